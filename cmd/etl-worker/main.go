@@ -5,24 +5,35 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	kmslib "kms/internal/kms"
+	"kms/internal/logging"
+	"kms/internal/tlsconfig"
+	"kms/internal/tracing"
 	kmsproto "kms/proto"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	_ "github.com/microsoft/go-mssqldb"
 	"github.com/xuri/excelize/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 )
 
@@ -30,6 +41,27 @@ import (
 const (
 	WorkerCount = 20
 	BatchSize   = 500
+
+	// RecordsPerRPC is the most records a worker encrypts per BatchEncrypt
+	// call (two items each, within the server's 1000-item limit).
+	RecordsPerRPC = 250
+
+	// KMSMaxAttempts bounds how often a batch RPC is sent while the KMS
+	// answers Unavailable or ResourceExhausted; the wait between attempts
+	// starts at KMSRetryBackoff and doubles. A rate-limited call waits the
+	// delay the server asks for instead, unless it exceeds KMSMaxRetryDelay
+	// (a spent daily quota), in which case the call fails.
+	KMSMaxAttempts   = 4
+	KMSRetryBackoff  = 250 * time.Millisecond
+	KMSMaxRetryDelay = 30 * time.Second
+)
+
+const (
+	// sourceTable is the table of the source database the ETL reads.
+	sourceTable = "cards_to_encrypt"
+	// maxSourceNameLen keeps idempotency keys within the server's 128
+	// characters.
+	maxSourceNameLen = 64
 )
 
 // --- Structs ---
@@ -65,6 +97,13 @@ type VerificationRecord struct {
 type AppConfig struct {
 	KMS struct {
 		Addr string `yaml:"addr"`
+		TLS  struct {
+			Enabled    bool   `yaml:"enabled"`
+			CAFile     string `yaml:"caFile"`
+			CertFile   string `yaml:"certFile"`
+			KeyFile    string `yaml:"keyFile"`
+			ServerName string `yaml:"serverName"`
+		} `yaml:"tls"`
 	} `yaml:"kms"`
 	Auth struct {
 		BearerToken string `yaml:"bearerToken"`
@@ -74,6 +113,10 @@ type AppConfig struct {
 	SourceDB struct {
 		Driver string `yaml:"driver"`
 		DSN    string `yaml:"dsn"`
+		// Name identifies the source database in idempotency keys. It
+		// defaults to the driver; set it when one KMS principal loads
+		// several source databases.
+		Name string `yaml:"name"`
 	} `yaml:"sourceDB"`
 	DestDB struct {
 		Driver string `yaml:"driver"`
@@ -84,6 +127,14 @@ type AppConfig struct {
 var (
 	processedCount atomic.Uint64
 	errorCount     atomic.Uint64
+
+	// streamMode selects streamWorker over worker (-stream flag).
+	streamMode bool
+
+	tracer = otel.Tracer("kms/cmd/etl-worker")
+
+	// sourceName is sourceDB.name, the source in idempotency keys.
+	sourceName string
 )
 
 // Using helper functions from kms package for combined encryption format
@@ -94,6 +145,7 @@ func main() {
 	verifyMode := flag.Bool("verify", false, "Run in SAFE verification mode (decrypt & mask)")
 	verifyExcelMode := flag.Bool("verify-excel", false, "Run ETL + verify all data + export to Excel")
 	maskData := flag.Bool("mask-data", false, "Mask sensitive data in Excel output (default: show actual decrypted values)")
+	flag.BoolVar(&streamMode, "stream", false, "Encrypt over one EncryptStream per worker instead of BatchEncrypt calls")
 	
 	// Default Excel output path: C:\Users\user\Desktop\work\KMS-golang-\verification_results_YYYYMMDD_HHMMSS.xlsx
 	timestamp := time.Now().Format("20060102_150405")
@@ -103,26 +155,63 @@ func main() {
 
 	cfg, err := loadConfig(*cfgPath)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		logging.Fatal("Failed to load config", "path", *cfgPath, "err", err)
+	}
+	sourceName = cfg.SourceDB.Name
+
+	// KMS_LOG_LEVEL / KMS_LOG_FORMAT / KMS_LOG_FILE; the credentials and DSNs
+	// are redacted from every log line.
+	logger, err := logging.Setup(logging.ConfigFromEnv(),
+		cfg.Auth.Password, cfg.Auth.BearerToken, os.Getenv("KMS_BEARER_TOKEN"),
+		cfg.SourceDB.DSN, cfg.DestDB.DSN)
+	if err != nil {
+		logging.Fatal("Failed to set up logging", "err", err)
 	}
+	defer logger.Close()
 
 	// 1. Connect DBs
 	srcDB, err := sql.Open(cfg.SourceDB.Driver, cfg.SourceDB.DSN)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Failed to open source database", "driver", cfg.SourceDB.Driver, "err", err)
 	}
 	defer srcDB.Close()
 
 	dstDB, err := sql.Open(cfg.DestDB.Driver, cfg.DestDB.DSN)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Failed to open destination database", "driver", cfg.DestDB.Driver, "err", err)
 	}
 	defer dstDB.Close()
 
 	// 2. Connect KMS
-	conn, err := grpc.Dial(cfg.KMS.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	kmsTLS := kmsTLSConfig(cfg)
+	creds, err := tlsconfig.DialOption(kmsTLS)
+	if err != nil {
+		logging.Fatal("Failed to configure KMS TLS", "err", err)
+	}
+	// KMS_TRACING_* exports the etl.* spans; the KMS calls join their trace.
+	tracingCfg, err := tracing.ConfigFromEnv()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Invalid tracing configuration", "err", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), "etl-worker", tracingCfg)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "err", err)
+		}
+	}()
+	dialOpts := []grpc.DialOption{creds}
+	if tracingCfg.Enabled() {
+		dialOpts = append(dialOpts, tracing.DialOption())
+		slog.Info("Tracing enabled", "exporter", tracingCfg.Exporter)
+	}
+	conn, err := grpc.Dial(cfg.KMS.Addr, dialOpts...)
+	if err != nil {
+		logging.Fatal("Failed to connect to KMS", "addr", cfg.KMS.Addr, "err", err)
 	}
 	defer conn.Close()
 
@@ -131,25 +220,27 @@ func main() {
 	// Auto Login
 	kmsToken := os.Getenv("KMS_BEARER_TOKEN")
 	if kmsToken != "" {
-		log.Printf("Using KMS_BEARER_TOKEN from environment")
+		slog.Info("Using KMS_BEARER_TOKEN from environment")
 	} else if cfg.Auth.BearerToken != "" {
-		log.Printf("Using bearer token from config file")
+		slog.Info("Using bearer token from config file")
 		kmsToken = cfg.Auth.BearerToken
 	} else if cfg.Auth.Username != "" {
-		log.Printf("Auto-login: Logging in as %s...", cfg.Auth.Username)
+		slog.Info("Auto-login: logging in", "principal", cfg.Auth.Username)
 		token, err := loginToKMS(conn, cfg.Auth.Username, cfg.Auth.Password)
 		if err != nil {
-			log.Fatalf("Auto-login failed: %v", err)
+			logging.Fatal("Auto-login failed", "principal", cfg.Auth.Username, "err", err)
 		}
 		kmsToken = token
 		if kmsToken != "" {
-			log.Printf("Auto-login successful! Token obtained (length: %d)", len(kmsToken))
+			slog.Info("Auto-login successful; token obtained", "principal", cfg.Auth.Username, "token_length", len(kmsToken))
 		} else {
-			log.Fatalf("Auto-login returned empty token!")
+			logging.Fatal("Auto-login returned empty token", "principal", cfg.Auth.Username)
 		}
+	} else if kmsTLS.CertFile != "" {
+		slog.Info("Authenticating to KMS with a client certificate", "cert", kmsTLS.CertFile)
 	} else {
-		log.Printf("WARNING: No authentication configured. JWT auth may be disabled on server.")
-		log.Printf("  Set KMS_BEARER_TOKEN env var, or configure auth in config.yaml")
+		slog.Warn("No authentication configured; JWT auth may be disabled on the server",
+			"hint", "set KMS_BEARER_TOKEN, or configure auth in config.yaml")
 	}
 
 	// --- 判斷模式 ---
@@ -177,7 +268,7 @@ func runSafeVerification(db *sql.DB, client kmsproto.KMSClient, token string, dr
 
 	rows, err := db.Query(query)
 	if err != nil {
-		log.Fatalf("Failed to query encrypted data: %v", err)
+		logging.Fatal("Failed to query encrypted data", "err", err)
 	}
 	defer rows.Close()
 
@@ -190,14 +281,14 @@ func runSafeVerification(db *sql.DB, client kmsproto.KMSClient, token string, dr
 		var id int64
 		var encryptedPAN string
 		if err := rows.Scan(&id, &encryptedPAN); err != nil {
-			log.Printf("Scan error: %v", err)
+			slog.Error("Scan error", "err", err)
 			continue
 		}
 
 		// Split the combined field back into nonce and ciphertext
 		nonce, ciphertext, err := kmslib.SplitNonceAndCiphertext(encryptedPAN, kmslib.AESGCMNonceSize)
 		if err != nil {
-			log.Printf("Failed to split encrypted data for ID %d: %v", id, err)
+			slog.Error("Failed to split encrypted data", "record", id, "err", err)
 			fmt.Printf("%d      | FAIL: %v | ERROR\n", id, err)
 			continue
 		}
@@ -237,31 +328,32 @@ func runSafeVerification(db *sql.DB, client kmsproto.KMSClient, token string, dr
 // === 標準 ETL 模式 (無 Log 檔案) ===
 func runETL(srcDB, dstDB *sql.DB, client kmsproto.KMSClient, token, driver string) {
 	startTotal := time.Now()
-	log.Printf("Starting Production Batch ETL (Batch Size: %d)...", BatchSize)
+	slog.Info("Starting production batch ETL", "batch_size", BatchSize, "source", sourceName, "stream", streamMode)
+
+	// One trace per run: etl.read, etl.encrypt and etl.write spans below it.
+	ctx, span := tracer.Start(context.Background(), "etl.run",
+		trace.WithAttributes(attribute.Bool("etl.stream", streamMode)))
+	defer span.End()
 
 	// Test KMS connection first
-	log.Printf("Testing KMS connection...")
-	testCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	slog.Info("Testing KMS connection")
+	testCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	if token != "" {
 		testCtx = metadata.AppendToOutgoingContext(testCtx, "authorization", "Bearer "+token)
-		log.Printf("Using authentication token (length: %d)", len(token))
+		slog.Debug("Using authentication token", "token_length", len(token))
 	} else {
-		log.Printf("WARNING: No authentication token - encryption may fail if JWT auth is enabled")
+		slog.Warn("No authentication token; encryption may fail if JWT auth is enabled")
 	}
 
 	// Try a test encryption
 	testResp, err := client.Encrypt(testCtx, &kmsproto.EncryptRequest{Plaintext: []byte("test")})
 	cancel()
 	if err != nil {
-		log.Fatalf("KMS connection test FAILED: %v", err)
-		log.Fatalf("  This means the KMS server is not accessible or authentication failed.")
-		log.Fatalf("  Check:")
-		log.Fatalf("  1. KMS server is running (check port 50051)")
-		log.Fatalf("  2. KMS_JWT_SECRET matches between client and server")
-		log.Fatalf("  3. Token is valid (not expired)")
-		return
+		logging.Fatal("KMS connection test failed: the KMS server is not accessible or authentication failed",
+			"code", status.Code(err).String(), "err", err,
+			"hint", "check that the KMS server is running, KMS_JWT_SECRET matches the server's and the token has not expired")
 	}
-	log.Printf("KMS connection test PASSED (encrypted %d bytes)", len(testResp.Ciphertext))
+	slog.Info("KMS connection test passed", "ciphertext_bytes", len(testResp.Ciphertext))
 
 	jobs := make(chan CardRecord, 100)
 	results := make(chan EncryptedRecord, 100)
@@ -270,14 +362,18 @@ func runETL(srcDB, dstDB *sql.DB, client kmsproto.KMSClient, token, driver strin
 
 	for w := 1; w <= WorkerCount; w++ {
 		wgWorkers.Add(1)
-		go worker(w, jobs, results, &wgWorkers, client, token)
+		if streamMode {
+			go streamWorker(ctx, w, jobs, results, &wgWorkers, client, token)
+		} else {
+			go worker(ctx, w, jobs, results, &wgWorkers, client, token)
+		}
 	}
 
 	wgWriter.Add(1)
-	go batchWriter(dstDB, results, &wgWriter, driver)
+	go batchWriter(ctx, dstDB, results, &wgWriter, driver)
 
 	go func() {
-		feedRecords(srcDB, jobs)
+		feedRecords(ctx, srcDB, jobs)
 		close(jobs)
 	}()
 
@@ -288,6 +384,10 @@ func runETL(srcDB, dstDB *sql.DB, client kmsproto.KMSClient, token, driver strin
 	duration := time.Since(startTotal)
 	total := processedCount.Load()
 	errors := errorCount.Load()
+	span.SetAttributes(attribute.Int64("etl.records", int64(total)), attribute.Int64("etl.errors", int64(errors)))
+	if errors > 0 {
+		span.SetStatus(otelcodes.Error, fmt.Sprintf("%d records failed", errors))
+	}
 
 	fmt.Println("\n=== ETL Completed ===")
 	fmt.Printf("Total Time:    %v\n", duration)
@@ -324,13 +424,12 @@ func runETLWithVerification(srcDB, dstDB *sql.DB, client kmsproto.KMSClient, tok
 	}
 	err := dstDB.QueryRow(countQuery).Scan(&encryptedCount)
 	if err != nil {
-		log.Printf("WARNING: Could not count encrypted records: %v", err)
+		slog.Warn("Could not count encrypted records", "err", err)
 	} else {
-		log.Printf("Found %d encrypted records in destination database", encryptedCount)
+		slog.Info("Found encrypted records in destination database", "records", encryptedCount)
 		if encryptedCount == 0 {
-			log.Printf("ERROR: No encrypted records found! Cannot verify.")
-			log.Printf("  This means Step 1 (ETL) failed to encrypt any records.")
-			log.Printf("  Check the error messages above to see why encryption failed.")
+			slog.Error("No encrypted records found; cannot verify",
+				"hint", "step 1 (ETL) failed to encrypt any records; see the errors above")
 			return
 		}
 	}
@@ -345,14 +444,14 @@ func runETLWithVerification(srcDB, dstDB *sql.DB, client kmsproto.KMSClient, tok
 	fmt.Println("\n=== Step 3: Exporting Results to Excel ===")
 	fmt.Printf("Excel output path: %s\n", excelPath)
 	if !maskData {
-		log.Printf("WARNING: Sensitive data (PAN/CVV) will be shown in plaintext in Excel file!")
-		log.Printf("  Use -mask-data flag to mask sensitive data for production use.")
+		slog.Warn("Sensitive data (PAN/CVV) will be shown in plaintext in the Excel file",
+			"hint", "use -mask-data to mask sensitive data for production use")
 	}
 	if len(verificationResults) == 0 {
-		log.Printf("WARNING: No verification results to export!")
+		slog.Warn("No verification results to export")
 	} else {
 		if err := exportToExcel(verificationResults, excelPath, maskData); err != nil {
-			log.Fatalf("Failed to export to Excel: %v", err)
+			logging.Fatal("Failed to export to Excel", "path", excelPath, "err", err)
 		}
 	}
 
@@ -367,7 +466,7 @@ func runETLWithVerification(srcDB, dstDB *sql.DB, client kmsproto.KMSClient, tok
 }
 
 func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client kmsproto.KMSClient, token, driver string) []VerificationRecord {
-	log.Printf("Starting verification process...")
+	slog.Info("Starting verification")
 	
 	// Read all encrypted records from destination
 	encryptedQuery := "SELECT source_id, encrypted_pan, encrypted_cvv, other_data FROM encrypted_cards ORDER BY source_id"
@@ -375,10 +474,10 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 		encryptedQuery = "SELECT source_id, encrypted_pan, encrypted_cvv, other_data FROM encrypted_cards ORDER BY source_id"
 	}
 
-	log.Printf("Reading encrypted records from destination database...")
+	slog.Info("Reading encrypted records from destination database")
 	encryptedRows, err := dstDB.Query(encryptedQuery)
 	if err != nil {
-		log.Fatalf("Failed to query encrypted data: %v", err)
+		logging.Fatal("Failed to query encrypted data", "err", err)
 	}
 	defer encryptedRows.Close()
 
@@ -388,26 +487,26 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 	for encryptedRows.Next() {
 		select {
 		case <-ctx.Done():
-			log.Printf("Verification cancelled: %v", ctx.Err())
+			slog.Warn("Verification cancelled", "err", ctx.Err())
 			return nil
 		default:
 		}
 		
 		var rec EncryptedRecord
 		if err := encryptedRows.Scan(&rec.SourceID, &rec.EncryptedPAN, &rec.EncryptedCVV, &rec.OtherData); err != nil {
-			log.Printf("Scan error: %v", err)
+			slog.Error("Scan error", "err", err)
 			continue
 		}
 		encryptedMap[rec.SourceID] = rec
 		encryptedCount++
 	}
-	log.Printf("Loaded %d encrypted records into memory", encryptedCount)
+	slog.Info("Loaded encrypted records into memory", "records", encryptedCount)
 
 	// Read all original records from source
-	log.Printf("Reading original records from source database...")
-	originalRows, err := srcDB.Query("SELECT id, card_no, cvv, other_data FROM cards_to_encrypt ORDER BY id")
+	slog.Info("Reading original records from source database", "source", sourceName, "table", sourceTable)
+	originalRows, err := srcDB.Query("SELECT id, card_no, cvv, other_data FROM " + sourceTable + " ORDER BY id")
 	if err != nil {
-		log.Fatalf("Failed to query original data: %v", err)
+		logging.Fatal("Failed to query original data", "source", sourceName, "table", sourceTable, "err", err)
 	}
 	defer originalRows.Close()
 
@@ -423,13 +522,13 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 	verifyWorkerCount := WorkerCount
 	if verifyWorkerCount > 10 {
 		verifyWorkerCount = 10 // Cap at 10 workers for HSM operations
-		log.Printf("Reduced worker count to %d for HSM verification (to avoid overwhelming HSM)", verifyWorkerCount)
+		slog.Info("Reduced worker count for HSM verification (to avoid overwhelming HSM)", "workers", verifyWorkerCount)
 	}
 	semaphore := make(chan struct{}, verifyWorkerCount)
 	totalProcessed := atomic.Uint64{}
 	timeoutCount := atomic.Uint64{}
 
-	log.Printf("Starting parallel decryption and verification (using %d workers)...", verifyWorkerCount)
+	slog.Info("Starting parallel decryption and verification", "workers", verifyWorkerCount)
 	startTime := time.Now()
 
 	// Read all original records first
@@ -437,19 +536,19 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 	for originalRows.Next() {
 		select {
 		case <-ctx.Done():
-			log.Printf("Verification cancelled while reading records: %v", ctx.Err())
+			slog.Warn("Verification cancelled while reading records", "err", ctx.Err())
 			return nil
 		default:
 		}
 		
 		var original CardRecord
 		if err := originalRows.Scan(&original.ID, &original.CardNo, &original.CVV, &original.OtherData); err != nil {
-			log.Printf("Scan error: %v", err)
+			slog.Error("Scan error", "err", err)
 			continue
 		}
 		originalRecords = append(originalRecords, original)
 	}
-	log.Printf("Loaded %d original records, starting verification...", len(originalRecords))
+	slog.Info("Loaded original records, starting verification", "records", len(originalRecords))
 
 	// Create results channel with large buffer (2x records to avoid blocking)
 	resultsChan = make(chan VerificationRecord, len(originalRecords)*2)
@@ -483,9 +582,8 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 					lastProgressTime = now
 					lastProgressCount = currentCount
 				} else if now.Sub(lastProgressTime) > stuckThreshold {
-					log.Printf("WARNING: No progress for %v seconds. Current: %d/%d records", 
-						stuckThreshold, currentCount, expectedCount)
-					log.Printf("  This may indicate HSM/decrypt issues. Continuing to wait...")
+					slog.Warn("No verification progress; this may indicate HSM/decrypt issues, continuing to wait",
+						"for", stuckThreshold, "records", currentCount, "expected", expectedCount)
 					lastProgressTime = now // Reset timer
 				}
 
@@ -500,15 +598,16 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 				
 				// Check if we've collected all expected results
 				if currentCount >= expectedCount {
-					log.Printf("\nAll expected results collected (%d/%d)", currentCount, expectedCount)
+					fmt.Println()
+					slog.Info("All expected results collected", "records", currentCount, "expected", expectedCount)
 					collectionDone <- true
 					return
 				}
 				
 			case <-time.After(maxWaitTime):
 				// Force timeout after max wait time
-				log.Printf("WARNING: Maximum wait time (%v) reached. Collected %d/%d results. Forcing completion...", 
-					maxWaitTime, len(results), expectedCount)
+				slog.Warn("Maximum wait time reached; forcing completion",
+					"max_wait", maxWaitTime, "records", len(results), "expected", expectedCount)
 				collectionDone <- true
 				return
 			}
@@ -519,7 +618,7 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 	for _, original := range originalRecords {
 		select {
 		case <-ctx.Done():
-			log.Printf("Verification cancelled: %v", ctx.Err())
+			slog.Warn("Verification cancelled", "err", ctx.Err())
 			// Wait for current goroutines to finish
 			wg.Wait()
 			close(resultsChan)
@@ -582,7 +681,7 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 				}
 				count := timeoutCount.Load()
 				if count <= 5 || count%10 == 0 {
-					log.Printf("WARNING: Record %d verification timed out (total timeouts: %d)", orig.ID, count)
+					slog.Warn("Record verification timed out", "record", orig.ID, "timeouts", count)
 				}
 			}
 
@@ -612,23 +711,24 @@ func verifyAllDataWithContext(ctx context.Context, srcDB, dstDB *sql.DB, client
 		select {
 		case <-done:
 			close(resultsChan)
-			log.Printf("\nAll verification goroutines completed")
+			fmt.Println()
+			slog.Info("All verification goroutines completed")
 		case <-time.After(15 * time.Minute):
-			log.Printf("WARNING: Some goroutines may still be running. Closing channel anyway...")
+			slog.Warn("Some verification goroutines may still be running; closing the channel anyway")
 			close(resultsChan)
 		}
 	}()
 
 	// Wait for collection to complete
-	log.Printf("Collecting verification results...")
+	slog.Info("Collecting verification results")
 	<-collectionDone
 
 	fmt.Printf("\n")
 	timeouts := timeoutCount.Load()
 	if timeouts > 0 {
-		log.Printf("WARNING: %d records timed out during verification (may indicate HSM issues)", timeouts)
+		slog.Warn("Records timed out during verification (may indicate HSM issues)", "records", timeouts)
 	}
-	log.Printf("Verification complete: %d records processed", len(results))
+	slog.Info("Verification complete", "records", len(results))
 	return results
 }
 
@@ -647,58 +747,70 @@ func verifyRecord(ctx context.Context, client kmsproto.KMSClient, token string,
 		OtherData:    original.OtherData,
 	}
 
-	// Decrypt PAN
-	panNonce, panCiphertext, err := kmslib.SplitNonceAndCiphertext(encrypted.EncryptedPAN, kmslib.AESGCMNonceSize)
-	if err != nil {
-		verification.PANError = fmt.Sprintf("Split error: %v", err)
-	} else {
-		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second) // Increased timeout
-		if token != "" {
-			reqCtx = metadata.AppendToOutgoingContext(reqCtx, "authorization", "Bearer "+token)
-		}
-		panResp, err := client.Decrypt(reqCtx, &kmsproto.DecryptRequest{
-			Ciphertext: panCiphertext,
-			Nonce:      panNonce,
-		})
-		cancel()
-		if err != nil {
-			verification.PANError = fmt.Sprintf("Decrypt error: %v", err)
-			// Log first few decrypt errors for debugging
-			count := decryptErrorCount.Add(1)
-			if count <= 3 {
-				log.Printf("Decrypt error (record %d, PAN): %v", original.ID, err)
-			}
-		} else {
-			verification.DecryptedPAN = string(panResp.Plaintext)
+	// Decrypt PAN and CVV with one BatchDecrypt call
+	type field struct {
+		name    string
+		encoded string
+		errOut  *string
+		apply   func(plain string)
+	}
+	fields := []field{
+		{"PAN", encrypted.EncryptedPAN, &verification.PANError, func(plain string) {
+			verification.DecryptedPAN = plain
 			verification.PANMatch = verification.DecryptedPAN == verification.OriginalPAN
-		}
+		}},
+		{"CVV", encrypted.EncryptedCVV, &verification.CVVError, func(plain string) {
+			verification.DecryptedCVV = plain
+			verification.CVVMatch = verification.DecryptedCVV == verification.OriginalCVV
+		}},
 	}
 
-	// Decrypt CVV
-	cvvNonce, cvvCiphertext, err := kmslib.SplitNonceAndCiphertext(encrypted.EncryptedCVV, kmslib.AESGCMNonceSize)
-	if err != nil {
-		verification.CVVError = fmt.Sprintf("Split error: %v", err)
-	} else {
-		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second) // Increased timeout
-		if token != "" {
-			reqCtx = metadata.AppendToOutgoingContext(reqCtx, "authorization", "Bearer "+token)
+	var items []*kmsproto.DecryptRequest
+	var pending []field
+	for _, f := range fields {
+		nonce, ciphertext, err := kmslib.SplitNonceAndCiphertext(f.encoded, kmslib.AESGCMNonceSize)
+		if err != nil {
+			*f.errOut = fmt.Sprintf("Split error: %v", err)
+			continue
 		}
-		cvvResp, err := client.Decrypt(reqCtx, &kmsproto.DecryptRequest{
-			Ciphertext: cvvCiphertext,
-			Nonce:      cvvNonce,
-		})
-		cancel()
+		items = append(items, &kmsproto.DecryptRequest{Ciphertext: ciphertext, Nonce: nonce})
+		pending = append(pending, f)
+	}
+	if len(items) == 0 {
+		return verification
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second) // Increased timeout
+	if token != "" {
+		reqCtx = metadata.AppendToOutgoingContext(reqCtx, "authorization", "Bearer "+token)
+	}
+	var resp *kmsproto.BatchDecryptResponse
+	err := retryKMS(reqCtx, func() (err error) {
+		resp, err = client.BatchDecrypt(reqCtx, &kmsproto.BatchDecryptRequest{Items: items})
+		return err
+	})
+	cancel()
+	if err == nil && len(resp.Results) != len(items) {
+		err = fmt.Errorf("BatchDecrypt returned %d results for %d items", len(resp.Results), len(items))
+	}
+
+	for i, f := range pending {
+		errMsg := ""
 		if err != nil {
-			verification.CVVError = fmt.Sprintf("Decrypt error: %v", err)
+			errMsg = err.Error()
+		} else if st := resp.Results[i].GetStatus(); st.GetCode() != 0 {
+			errMsg = itemError(st)
+		}
+		if errMsg != "" {
+			*f.errOut = fmt.Sprintf("Decrypt error: %v", errMsg)
 			// Log first few decrypt errors for debugging
 			count := decryptErrorCount.Add(1)
 			if count <= 3 {
-				log.Printf("Decrypt error (record %d, CVV): %v", original.ID, err)
+				slog.Error("Decrypt error", "record", original.ID, "field", f.name, "err", errMsg)
 			}
-		} else {
-			verification.DecryptedCVV = string(cvvResp.Plaintext)
-			verification.CVVMatch = verification.DecryptedCVV == verification.OriginalCVV
+			continue
 		}
+		f.apply(string(resp.Results[i].Plaintext))
 	}
 
 	return verification
@@ -728,7 +840,7 @@ func exportToExcel(results []VerificationRecord, filePath string, maskData bool)
 	f := excelize.NewFile()
 	defer func() {
 		if err := f.Close(); err != nil {
-			log.Printf("Error closing Excel file: %v", err)
+			slog.Error("Error closing Excel file", "err", err)
 		}
 	}()
 
@@ -867,7 +979,7 @@ func exportToExcel(results []VerificationRecord, filePath string, maskData bool)
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("failed to create directory '%s' for Excel file: %w", dir, err)
 		}
-		log.Printf("Ensured directory exists: %s", dir)
+		slog.Debug("Ensured directory exists", "dir", dir)
 	}
 
 	// Convert to absolute path for better error messages
@@ -877,12 +989,12 @@ func exportToExcel(results []VerificationRecord, filePath string, maskData bool)
 	}
 
 	// Save file
-	log.Printf("Saving Excel file to: %s", absPath)
+	slog.Info("Saving Excel file", "path", absPath)
 	if err := f.SaveAs(filePath); err != nil {
 		return fmt.Errorf("failed to save Excel file to '%s': %w", absPath, err)
 	}
 
-	log.Printf("✓ Excel file saved successfully: %s", absPath)
+	slog.Info("Excel file saved", "path", absPath)
 	return nil
 }
 
@@ -907,6 +1019,56 @@ func boolToYesNo(b bool) string {
 	return "No"
 }
 
+// retryKMS runs call until it succeeds, fails with a code other than
+// Unavailable or ResourceExhausted, KMSMaxAttempts is reached or ctx is done.
+func retryKMS(ctx context.Context, call func() error) error {
+	backoff := KMSRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		switch status.Code(err) {
+		case codes.Unavailable, codes.ResourceExhausted:
+		default:
+			return err
+		}
+		if attempt == KMSMaxAttempts {
+			return err
+		}
+		wait := backoff
+		if d, ok := retryDelay(err); ok {
+			if d > KMSMaxRetryDelay {
+				return err
+			}
+			wait = d
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// retryDelay returns the google.rpc.RetryInfo delay attached to err, if any.
+func retryDelay(err error) (time.Duration, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			return ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// itemError formats a failed batch or stream item, e.g.
+// "InvalidArgument/INVALID_CIPHERTEXT: invalid ciphertext: ...".
+func itemError(st *kmsproto.ItemStatus) string {
+	code := codes.Code(st.GetCode()).String()
+	if st.GetReason() != "" {
+		code += "/" + st.GetReason()
+	}
+	return code + ": " + st.GetMessage()
+}
+
 // --- Helper Functions (保持不變) ---
 func loginToKMS(conn *grpc.ClientConn, username, password string) (string, error) {
 	authClient := kmsproto.NewAuthClient(conn)
@@ -918,79 +1080,288 @@ func loginToKMS(conn *grpc.ClientConn, username, password string) (string, error
 	}
 	return resp.Token, nil
 }
-func worker(id int, jobs <-chan CardRecord, results chan<- EncryptedRecord, wg *sync.WaitGroup, client kmsproto.KMSClient, token string) {
+
+// idempotencyKey is the idempotency key of one field of a source row. It
+// names the row by source, table and primary key, so that a retried batch, or
+// a rerun of the same rows within the server's idempotency TTL, gets the
+// ciphertexts of the first attempt.
+func idempotencyKey(id int64, field string) string {
+	return fmt.Sprintf("etl:%s:%s:%d:%s", sourceName, sourceTable, id, field)
+}
+
+func worker(ctx context.Context, id int, jobs <-chan CardRecord, results chan<- EncryptedRecord, wg *sync.WaitGroup, client kmsproto.KMSClient, token string) {
 	defer wg.Done()
-	ctx := context.Background()
+	if token == "" {
+		slog.Warn("No token provided; encryption may fail if JWT auth is enabled", "worker", id)
+	}
+	logError := errorLogger(id)
+	for {
+		batch := nextRecords(jobs, RecordsPerRPC)
+		if len(batch) == 0 {
+			return
+		}
+		encryptBatch(ctx, id, batch, results, client, token, logError)
+	}
+}
+
+// errorLogger returns the encrypt error log of worker id: it logs the first
+// error and every 100th after it.
+func errorLogger(id int) func(recordID int64, field string, code codes.Code, errMsg string) {
 	errorCountLocal := 0
-	successCountLocal := 0
-	firstError := true
+	return func(recordID int64, field string, code codes.Code, errMsg string) {
+		errorCountLocal++
+		if errorCountLocal == 1 || errorCountLocal%100 == 0 {
+			args := []any{"worker", id, "record", recordID, "field", field, "code", code.String(), "err", errMsg}
+			switch code {
+			case codes.Unauthenticated:
+				args = append(args, "hint", "the token may be invalid or expired, or KMS_JWT_SECRET does not match the server's")
+			case codes.PermissionDenied:
+				args = append(args, "hint", "the principal is not allowed by KMS_AUTH_ALLOWED_PRINCIPALS or the key policy")
+			}
+			slog.Error("Encrypt failed", args...)
+		}
+	}
+}
 
-	for r := range jobs {
-		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second) // Increased timeout
-		if token != "" {
-			reqCtx = metadata.AppendToOutgoingContext(reqCtx, "authorization", "Bearer "+token)
-		} else {
-			if firstError {
-				log.Printf("WARNING (worker %d): No token provided, encryption may fail if JWT auth is enabled", id)
-				firstError = false
+// encryptBatch encrypts the PAN and CVV of up to RecordsPerRPC records in
+// one BatchEncrypt call and sends the encrypted records to results; records
+// that fail count as errors.
+func encryptBatch(ctx context.Context, id int, batch []CardRecord, results chan<- EncryptedRecord, client kmsproto.KMSClient, token string, logError func(int64, string, codes.Code, string)) {
+	// Items 2*i and 2*i+1 belong to batch[i].
+	items := make([]*kmsproto.EncryptRequest, 0, 2*len(batch))
+	for _, r := range batch {
+		items = append(items,
+			&kmsproto.EncryptRequest{Plaintext: []byte(r.CardNo), IdempotencyKey: idempotencyKey(r.ID, "pan")},
+			&kmsproto.EncryptRequest{Plaintext: []byte(r.CVV), IdempotencyKey: idempotencyKey(r.ID, "cvv")})
+	}
+
+	spanCtx, span := tracer.Start(ctx, "etl.encrypt", trace.WithAttributes(
+		attribute.Int("etl.worker", id), attribute.Int("etl.records", len(batch))))
+	reqCtx, cancel := context.WithTimeout(spanCtx, 30*time.Second)
+	if token != "" {
+		reqCtx = metadata.AppendToOutgoingContext(reqCtx, "authorization", "Bearer "+token)
+	}
+	var resp *kmsproto.BatchEncryptResponse
+	err := retryKMS(reqCtx, func() (err error) {
+		resp, err = client.BatchEncrypt(reqCtx, &kmsproto.BatchEncryptRequest{Items: items})
+		return err
+	})
+	cancel()
+	if err == nil && len(resp.Results) != len(items) {
+		err = fmt.Errorf("BatchEncrypt returned %d results for %d items", len(resp.Results), len(items))
+	}
+	if err != nil {
+		endSpan(span, err)
+		errorCount.Add(uint64(len(batch)))
+		logError(batch[0].ID, "batch", status.Code(err), err.Error())
+		return
+	}
+	span.End()
+
+	for i, r := range batch {
+		pan, cvv := resp.Results[2*i], resp.Results[2*i+1]
+		if st := pan.GetStatus(); st.GetCode() != 0 {
+			errorCount.Add(1)
+			logError(r.ID, "pan", codes.Code(st.GetCode()), itemError(st))
+			continue
+		}
+		if st := cvv.GetStatus(); st.GetCode() != 0 {
+			errorCount.Add(1)
+			logError(r.ID, "cvv", codes.Code(st.GetCode()), itemError(st))
+			continue
+		}
+
+		// Combine nonce + ciphertext into single base64 strings
+		results <- EncryptedRecord{
+			SourceID:     r.ID,
+			EncryptedPAN: kmslib.CombineNonceAndCiphertext(pan.Nonce, pan.Ciphertext),
+			EncryptedCVV: kmslib.CombineNonceAndCiphertext(cvv.Nonce, cvv.Ciphertext),
+			OtherData:    r.OtherData,
+		}
+	}
+}
+
+// streamWorker encrypts over one EncryptStream. If the stream cannot be
+// opened or fails, the worker encrypts the records it had sent without an
+// answer with BatchEncrypt and works like worker from then on, so a failed
+// stream neither loses records nor takes them from the other workers.
+func streamWorker(ctx context.Context, id int, jobs <-chan CardRecord, results chan<- EncryptedRecord, wg *sync.WaitGroup, client kmsproto.KMSClient, token string) {
+	defer wg.Done()
+	logError := errorLogger(id)
+	unanswered := encryptStream(ctx, id, jobs, results, client, token)
+	for len(unanswered) > 0 {
+		n := min(len(unanswered), RecordsPerRPC)
+		encryptBatch(ctx, id, unanswered[:n], results, client, token, logError)
+		unanswered = unanswered[n:]
+	}
+	for {
+		batch := nextRecords(jobs, RecordsPerRPC)
+		if len(batch) == 0 {
+			return
+		}
+		encryptBatch(ctx, id, batch, results, client, token, logError)
+	}
+}
+
+// encryptStream encrypts records from jobs over one EncryptStream until jobs
+// is closed or the stream fails. It returns the records it took but did not
+// get both ciphertexts for.
+func encryptStream(ctx context.Context, id int, jobs <-chan CardRecord, results chan<- EncryptedRecord, client kmsproto.KMSClient, token string) []CardRecord {
+	// The stream is one etl.encrypt span; it may carry many batches' worth.
+	ctx, span := tracer.Start(ctx, "etl.encrypt", trace.WithAttributes(attribute.Int("etl.worker", id)))
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	stream, err := client.EncryptStream(ctx)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Code(err).String())
+		slog.Error("Failed to open EncryptStream; using BatchEncrypt", "worker", id, "err", err)
+		return nil
+	}
+
+	type pendingRecord struct {
+		record   CardRecord
+		pan, cvv *kmsproto.EncryptStreamResponse
+	}
+	var mu sync.Mutex
+	pending := make(map[int64]*pendingRecord)
+
+	// The sender stops taking jobs once the stream has failed.
+	senderDone := make(chan struct{})
+	go func() {
+		defer close(senderDone)
+		for {
+			var r CardRecord
+			select {
+			case <-ctx.Done():
+				return
+			case rec, ok := <-jobs:
+				if !ok {
+					stream.CloseSend()
+					return
+				}
+				r = rec
+			}
+			mu.Lock()
+			pending[r.ID] = &pendingRecord{record: r}
+			mu.Unlock()
+			err := stream.Send(&kmsproto.EncryptStreamRequest{Id: fmt.Sprintf("%d/pan", r.ID), Plaintext: []byte(r.CardNo)})
+			if err == nil {
+				err = stream.Send(&kmsproto.EncryptStreamRequest{Id: fmt.Sprintf("%d/cvv", r.ID), Plaintext: []byte(r.CVV)})
+			}
+			if err != nil {
+				// The real error is reported by Recv.
+				return
 			}
 		}
+	}()
 
-		encPAN, err := client.Encrypt(reqCtx, &kmsproto.EncryptRequest{Plaintext: []byte(r.CardNo)})
+	errorCountLocal := 0
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			cancel()
+			span.SetStatus(otelcodes.Error, status.Code(err).String())
+			slog.Error("EncryptStream failed; using BatchEncrypt", "worker", id, "err", err)
+			break
+		}
+
+		recordIDText, field, _ := strings.Cut(resp.Id, "/")
+		recordID, convErr := strconv.ParseInt(recordIDText, 10, 64)
+		mu.Lock()
+		p := pending[recordID]
+		if convErr != nil || p == nil {
+			mu.Unlock()
+			slog.Warn("EncryptStream response with unknown id", "worker", id, "id", resp.Id)
+			continue
+		}
+		if field == "pan" {
+			p.pan = resp
+		} else {
+			p.cvv = resp
+		}
+		if p.pan == nil || p.cvv == nil {
+			mu.Unlock()
+			continue
+		}
+		delete(pending, recordID)
+		mu.Unlock()
+
+		if st := p.pan.GetStatus(); st.GetCode() != 0 {
 			errorCount.Add(1)
 			errorCountLocal++
-			if errorCountLocal <= 3 { // Log first 3 errors per worker
-				log.Printf("ERROR (worker %d, record %d, PAN): %v", id, r.ID, err)
-				// Check if it's an authentication error
-				if strings.Contains(err.Error(), "Unauthenticated") || strings.Contains(err.Error(), "unauthorized") {
-					log.Printf("  -> Authentication error! Token may be invalid or expired")
-				}
+			if errorCountLocal <= 3 {
+				slog.Error("Encrypt failed", "worker", id, "record", recordID, "field", "pan", "err", itemError(st))
 			}
 			continue
 		}
-
-		encCVV, err := client.Encrypt(reqCtx, &kmsproto.EncryptRequest{Plaintext: []byte(r.CVV)})
-		cancel() // Always cancel after both operations complete
-		if err != nil {
+		if st := p.cvv.GetStatus(); st.GetCode() != 0 {
 			errorCount.Add(1)
 			errorCountLocal++
-			// Always log first error, then log every 100th error
-			if errorCountLocal == 1 || errorCountLocal%100 == 0 {
-				errMsg := err.Error()
-				log.Printf("ERROR (worker %d, record %d, CVV): %v", id, r.ID, errMsg)
-				if strings.Contains(strings.ToLower(errMsg), "unauthenticated") ||
-					strings.Contains(strings.ToLower(errMsg), "unauthorized") ||
-					strings.Contains(strings.ToLower(errMsg), "permission denied") {
-					log.Printf("  -> AUTHENTICATION ERROR! Token may be invalid, expired, or KMS_JWT_SECRET mismatch")
-				}
+			if errorCountLocal <= 3 {
+				slog.Error("Encrypt failed", "worker", id, "record", recordID, "field", "cvv", "err", itemError(st))
 			}
 			continue
 		}
 
-		successCountLocal++
-		// Combine nonce + ciphertext into single base64 strings
-		// Combine nonce + ciphertext into single base64 strings
-		encryptedPAN := kmslib.CombineNonceAndCiphertext(encPAN.Nonce, encPAN.Ciphertext)
-		encryptedCVV := kmslib.CombineNonceAndCiphertext(encCVV.Nonce, encCVV.Ciphertext)
 		results <- EncryptedRecord{
-			SourceID:     r.ID,
-			EncryptedPAN: encryptedPAN,
-			EncryptedCVV: encryptedCVV,
-			OtherData:    r.OtherData,
+			SourceID:     recordID,
+			EncryptedPAN: kmslib.CombineNonceAndCiphertext(p.pan.Nonce, p.pan.Ciphertext),
+			EncryptedCVV: kmslib.CombineNonceAndCiphertext(p.cvv.Nonce, p.cvv.Ciphertext),
+			OtherData:    p.record.OtherData,
+		}
+	}
+
+	cancel()
+	<-senderDone
+	mu.Lock()
+	defer mu.Unlock()
+	unanswered := make([]CardRecord, 0, len(pending))
+	for _, p := range pending {
+		unanswered = append(unanswered, p.record)
+	}
+	return unanswered
+}
+
+// nextRecords blocks for one record, then takes whatever else is already
+// queued, up to max. It returns nil once jobs is closed and drained.
+func nextRecords(jobs <-chan CardRecord, max int) []CardRecord {
+	r, ok := <-jobs
+	if !ok {
+		return nil
+	}
+	batch := []CardRecord{r}
+	for len(batch) < max {
+		select {
+		case r, ok := <-jobs:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, r)
+		default:
+			return batch
 		}
 	}
+	return batch
 }
-func batchWriter(db *sql.DB, results <-chan EncryptedRecord, wg *sync.WaitGroup, driver string) {
+func batchWriter(ctx context.Context, db *sql.DB, results <-chan EncryptedRecord, wg *sync.WaitGroup, driver string) {
 	defer wg.Done()
 	batch := make([]EncryptedRecord, 0, BatchSize)
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
-		if err := insertBatch(db, batch, driver); err != nil {
-			log.Printf("Failed to insert batch of %d records: %v", len(batch), err)
+		_, span := tracer.Start(ctx, "etl.write", trace.WithAttributes(attribute.Int("etl.records", len(batch))))
+		err := insertBatch(db, batch, driver)
+		endSpan(span, err)
+		if err != nil {
+			slog.Error("Failed to insert batch", "records", len(batch), "err", err)
 			errorCount.Add(uint64(len(batch)))
 		} else {
 			processedCount.Add(uint64(len(batch)))
@@ -1036,32 +1407,36 @@ func insertBatch(db *sql.DB, batch []EncryptedRecord, driver string) error {
 	query := queryBuilder.String()
 	_, err := db.Exec(query, params...)
 	if err != nil {
-		// Log the actual SQL error with first record details for debugging
-		log.Printf("DATABASE INSERT ERROR (batch size: %d): %v", len(batch), err)
-		log.Printf("  SQL Query: %s", query)
+		// Log the SQL error with the first record's shape for debugging; the
+		// query and the ciphertexts themselves stay out of the log.
+		args := []any{"records", len(batch), "err", err}
 		if len(batch) > 0 {
 			first := batch[0]
-			log.Printf("  First record - SourceID: %d, PAN length: %d, CVV length: %d",
-				first.SourceID, len(first.EncryptedPAN), len(first.EncryptedCVV))
-			log.Printf("  First record PAN preview: %s...", first.EncryptedPAN[:min(50, len(first.EncryptedPAN))])
+			args = append(args, "first_record", first.SourceID,
+				"pan_length", len(first.EncryptedPAN), "cvv_length", len(first.EncryptedCVV))
 		}
 		// Check for common SQL Server errors
 		errStr := strings.ToLower(err.Error())
 		if strings.Contains(errStr, "invalid column name") {
-			log.Printf("  -> COLUMN NAME ERROR! Table schema may not match expected columns")
-			log.Printf("  -> Expected columns: source_id, encrypted_pan, encrypted_cvv, other_data")
+			args = append(args, "hint", "the table schema may not match the expected columns: source_id, encrypted_pan, encrypted_cvv, other_data")
 		} else if strings.Contains(errStr, "cannot insert null") {
-			log.Printf("  -> NULL VALUE ERROR! Some required fields are NULL")
+			args = append(args, "hint", "some required fields are NULL")
 		} else if strings.Contains(errStr, "string or binary data would be truncated") {
-			log.Printf("  -> DATA TRUNCATION ERROR! Encrypted data too long for column")
+			args = append(args, "hint", "encrypted data too long for the column")
 		}
+		slog.Error("Database insert failed", args...)
 	}
 	return err
 }
-func feedRecords(db *sql.DB, jobs chan<- CardRecord) {
-	rows, err := db.Query("SELECT id, card_no, cvv, other_data FROM cards_to_encrypt")
+func feedRecords(ctx context.Context, db *sql.DB, jobs chan<- CardRecord) {
+	// etl.read lasts until the last record is queued, so it includes the
+	// time spent waiting for the workers.
+	_, span := tracer.Start(ctx, "etl.read")
+	defer span.End()
+	rows, err := db.Query("SELECT id, card_no, cvv, other_data FROM " + sourceTable)
 	if err != nil {
-		log.Printf("ERROR: Failed to query source database: %v", err)
+		endSpan(span, err)
+		slog.Error("Failed to query source database", "source", sourceName, "table", sourceTable, "err", err)
 		return
 	}
 	defer rows.Close()
@@ -1070,27 +1445,50 @@ func feedRecords(db *sql.DB, jobs chan<- CardRecord) {
 	for rows.Next() {
 		var r CardRecord
 		if err := rows.Scan(&r.ID, &r.CardNo, &r.CVV, &r.OtherData); err != nil {
-			log.Printf("ERROR: Failed to scan record: %v", err)
+			slog.Error("Failed to scan record", "err", err)
 			continue
 		}
 		jobs <- r
 		count++
 	}
+	span.SetAttributes(attribute.Int("etl.records", count))
 	if count == 0 {
-		log.Printf("WARNING: No records found in source database table 'cards_to_encrypt'")
-		log.Printf("  Please check:")
-		log.Printf("  1. Source database connection is correct")
-		log.Printf("  2. Table 'cards_to_encrypt' exists")
-		log.Printf("  3. Table has data")
+		slog.Warn("No records found in source database", "source", sourceName, "table", sourceTable,
+			"hint", "check the source database connection, and that the table exists and has data")
 	} else {
-		log.Printf("Found %d records in source database", count)
+		slog.Info("Found records in source database", "source", sourceName, "table", sourceTable, "records", count)
+	}
+}
+
+// endSpan ends span, marking it failed when err is set. Only the gRPC code
+// is recorded: error messages may quote card data.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Code(err).String())
 	}
+	span.End()
 }
-func min(a, b int) int {
-	if a < b {
-		return a
+
+// kmsTLSConfig returns the KMS client TLS settings: the kms.tls section of
+// the config file, with the KMS_TLS_* environment variables filling in any
+// field it leaves empty.
+func kmsTLSConfig(cfg *AppConfig) tlsconfig.ClientConfig {
+	tlsCfg := tlsconfig.ClientConfigFromEnv()
+	t := cfg.KMS.TLS
+	tlsCfg.Enabled = tlsCfg.Enabled || t.Enabled
+	if t.CAFile != "" {
+		tlsCfg.CAFile = t.CAFile
+	}
+	if t.CertFile != "" {
+		tlsCfg.CertFile = t.CertFile
+	}
+	if t.KeyFile != "" {
+		tlsCfg.KeyFile = t.KeyFile
 	}
-	return b
+	if t.ServerName != "" {
+		tlsCfg.ServerName = t.ServerName
+	}
+	return tlsCfg
 }
 
 func loadConfig(path string) (*AppConfig, error) {
@@ -1102,5 +1500,11 @@ func loadConfig(path string) (*AppConfig, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if cfg.SourceDB.Name == "" {
+		cfg.SourceDB.Name = cfg.SourceDB.Driver
+	}
+	if len(cfg.SourceDB.Name) > maxSourceNameLen {
+		return nil, fmt.Errorf("sourceDB.name is longer than %d characters", maxSourceNameLen)
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeKMS encrypts by copying the plaintext. Its first failStreams streams
+// break after failAfter responses, or cannot be opened when failAfter < 0.
+type fakeKMS struct {
+	kmsproto.KMSClient
+	failStreams, failAfter int
+
+	mu         sync.Mutex
+	streams    int
+	batchItems int
+}
+
+func (f *fakeKMS) BatchEncrypt(ctx context.Context, req *kmsproto.BatchEncryptRequest, opts ...grpc.CallOption) (*kmsproto.BatchEncryptResponse, error) {
+	f.mu.Lock()
+	f.batchItems += len(req.Items)
+	f.mu.Unlock()
+	resp := &kmsproto.BatchEncryptResponse{}
+	for _, it := range req.Items {
+		resp.Results = append(resp.Results, &kmsproto.BatchEncryptResult{Ciphertext: it.Plaintext, Nonce: make([]byte, 12)})
+	}
+	return resp, nil
+}
+
+func (f *fakeKMS) EncryptStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[kmsproto.EncryptStreamRequest, kmsproto.EncryptStreamResponse], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.streams++
+	failAfter := -1
+	if f.streams <= f.failStreams {
+		if f.failAfter < 0 {
+			return nil, status.Error(codes.Unavailable, "stream refused")
+		}
+		failAfter = f.failAfter
+	}
+	return &fakeEncryptStream{ctx: ctx, reqs: make(chan *kmsproto.EncryptStreamRequest, 1024), failAfter: failAfter}, nil
+}
+
+type fakeEncryptStream struct {
+	grpc.ClientStream
+	ctx       context.Context
+	reqs      chan *kmsproto.EncryptStreamRequest
+	answered  int
+	failAfter int // responses before the stream breaks; < 0 for never
+}
+
+func (s *fakeEncryptStream) Context() context.Context { return s.ctx }
+
+func (s *fakeEncryptStream) Send(req *kmsproto.EncryptStreamRequest) error {
+	select {
+	case s.reqs <- req:
+		return nil
+	case <-s.ctx.Done():
+		return io.EOF
+	}
+}
+
+func (s *fakeEncryptStream) CloseSend() error {
+	close(s.reqs)
+	return nil
+}
+
+func (s *fakeEncryptStream) Recv() (*kmsproto.EncryptStreamResponse, error) {
+	if s.answered == s.failAfter {
+		return nil, status.Error(codes.Unavailable, "stream broken")
+	}
+	select {
+	case req, ok := <-s.reqs:
+		if !ok {
+			return nil, io.EOF
+		}
+		s.answered++
+		return &kmsproto.EncryptStreamResponse{Id: req.Id, Ciphertext: req.Plaintext, Nonce: make([]byte, 12)}, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func TestStreamWorkerFailure(t *testing.T) {
+	const records = 200
+	for _, tc := range []struct {
+		name                   string
+		workers                int
+		failStreams, failAfter int
+	}{
+		{name: "one of two streams breaks", workers: 2, failStreams: 1, failAfter: 3},
+		{name: "one of two streams cannot open", workers: 2, failStreams: 1, failAfter: -1},
+		{name: "the only stream breaks", workers: 1, failStreams: 1, failAfter: 3},
+		{name: "every stream breaks at once", workers: 3, failStreams: 3, failAfter: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errorCount.Store(0)
+			client := &fakeKMS{failStreams: tc.failStreams, failAfter: tc.failAfter}
+			jobs := make(chan CardRecord)
+			results := make(chan EncryptedRecord, records)
+			var wg sync.WaitGroup
+			for w := 1; w <= tc.workers; w++ {
+				wg.Add(1)
+				go streamWorker(context.Background(), w, jobs, results, &wg, client, "")
+			}
+			for i := 1; i <= records; i++ {
+				jobs <- CardRecord{ID: int64(i), CardNo: fmt.Sprintf("4111%012d", i), CVV: "123"}
+			}
+			close(jobs)
+			wg.Wait()
+			close(results)
+
+			seen := map[int64]int{}
+			for r := range results {
+				seen[r.SourceID]++
+			}
+			for i := int64(1); i <= records; i++ {
+				if seen[i] != 1 {
+					t.Errorf("record %d encrypted %d times, want once", i, seen[i])
+				}
+			}
+			if n := errorCount.Load(); n != 0 {
+				t.Errorf("%d records counted as errors", n)
+			}
+			if client.batchItems == 0 {
+				t.Error("no record was encrypted with BatchEncrypt after the stream failed")
+			}
+		})
+	}
+}
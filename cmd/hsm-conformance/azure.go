@@ -0,0 +1,30 @@
+//go:build azure
+// +build azure
+
+package main
+
+import (
+	"path/filepath"
+
+	kmslib "kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+func setupAzure(dir string) (kmstest.ProviderFactory, func(), error) {
+	vault := kmstest.NewAzureKeyVault()
+	if err := vault.CreateRSAKey("kms-master-key"); err != nil {
+		vault.Close()
+		return nil, nil, err
+	}
+	client, err := vault.NewClient(nil)
+	if err != nil {
+		vault.Close()
+		return nil, nil, err
+	}
+
+	dekPath := filepath.Join(dir, "azure-dek.json")
+	open := func() (kmslib.HSMProvider, error) {
+		return kmslib.NewAzureKeyVaultProviderWithClient(client, "kms-master-key", dekPath, true)
+	}
+	return open, vault.Close, nil
+}
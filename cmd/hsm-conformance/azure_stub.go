@@ -0,0 +1,10 @@
+//go:build !azure
+// +build !azure
+
+package main
+
+import "kms/internal/kms/kmstest"
+
+func setupAzure(dir string) (kmstest.ProviderFactory, func(), error) {
+	return nil, nil, skip("Azure Key Vault support not compiled (use build tag: azure)")
+}
@@ -0,0 +1,44 @@
+//go:build gcp
+// +build gcp
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	kmslib "kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+func setupGCP(dir string) (kmstest.ProviderFactory, func(), error) {
+	const keyName = "projects/conformance/locations/global/keyRings/kms/cryptoKeys/kms-master-key"
+
+	fake := kmstest.NewGCPKMS()
+	if err := fake.CreateKey(keyName); err != nil {
+		fake.Close()
+		return nil, nil, err
+	}
+	sa, err := fake.ServiceAccountJSON()
+	if err != nil {
+		fake.Close()
+		return nil, nil, err
+	}
+	saPath := filepath.Join(dir, "service-account.json")
+	if err := os.WriteFile(saPath, sa, 0600); err != nil {
+		fake.Close()
+		return nil, nil, err
+	}
+
+	cfg := kmslib.GCPConfig{
+		KeyName:         keyName,
+		DEKPath:         filepath.Join(dir, "gcp-dek.json"),
+		CreateDEK:       true,
+		CredentialsFile: saPath,
+		Endpoint:        fake.URL,
+	}
+	open := func() (kmslib.HSMProvider, error) {
+		return kmslib.NewGCPKMSProvider(cfg)
+	}
+	return open, fake.Close, nil
+}
@@ -0,0 +1,10 @@
+//go:build !gcp
+// +build !gcp
+
+package main
+
+import "kms/internal/kms/kmstest"
+
+func setupGCP(dir string) (kmstest.ProviderFactory, func(), error) {
+	return nil, nil, skip("Google Cloud KMS support not compiled (use build tag: gcp)")
+}
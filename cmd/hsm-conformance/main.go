@@ -0,0 +1,156 @@
+// Command hsm-conformance runs the kmstest HSMProvider conformance suite
+// against the in-memory fake, SoftHSM2 and the cloud stand-ins.
+//
+//	go run ./cmd/hsm-conformance
+//	go run -tags "pkcs11 azure gcp" ./cmd/hsm-conformance -backends softhsm,azure,gcp
+//
+// Backends that are not compiled in (build tags) or not installed are skipped.
+// The exit status is 1 if any backend fails. go test ./internal/kms runs the
+// same suite; this command is for hosts without a Go test setup.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kmslib "kms/internal/kms"
+	"kms/internal/kms/kmstest"
+	"kms/internal/logging"
+)
+
+// skipError marks a backend that cannot run in this build or environment.
+type skipError struct{ reason string }
+
+func (e skipError) Error() string { return e.reason }
+
+func skip(format string, args ...interface{}) error {
+	return skipError{fmt.Sprintf(format, args...)}
+}
+
+// setupFunc prepares a backend in dir and returns a factory for providers
+// sharing one key, plus a cleanup function.
+type setupFunc func(dir string) (kmstest.ProviderFactory, func(), error)
+
+var backends = map[string]setupFunc{
+	"fake":     setupFake,
+	"failover": setupFailover,
+	"softhsm":  setupSoftHSM,
+	"vault":    setupVault,
+	"gcp":      setupGCP,
+	"azure":    setupAzure,
+}
+
+var softHSMLib = flag.String("softhsm-lib", os.Getenv("SOFTHSM2_LIB"), "path to libsofthsm2 (default: search common install paths)")
+
+func main() {
+	list := flag.String("backends", "fake,failover,softhsm,vault,gcp,azure", "comma-separated backends to test")
+	goroutines := flag.Int("goroutines", 8, "goroutines in the concurrency check")
+	iterations := flag.Int("iterations", 25, "round trips per goroutine in the concurrency check")
+	flag.Parse()
+
+	if _, err := logging.Setup(logging.ConfigFromEnv()); err != nil {
+		logging.Fatal("invalid log settings", "err", err)
+	}
+
+	failed := false
+	for _, name := range strings.Split(*list, ",") {
+		name = strings.TrimSpace(name)
+		setup, ok := backends[name]
+		if !ok {
+			logging.Fatal("unknown backend", "backend", name)
+		}
+
+		dir, err := os.MkdirTemp("", "hsm-conformance-"+name+"-")
+		if err != nil {
+			logging.Fatal("failed to create temp dir", "backend", name, "err", err)
+		}
+		start := time.Now()
+		err = run(setup, dir, kmstest.ConformanceOptions{Goroutines: *goroutines, Iterations: *iterations})
+		os.RemoveAll(dir)
+
+		var se skipError
+		switch {
+		case errors.As(err, &se):
+			fmt.Printf("SKIP %-9s %s\n", name, se.reason)
+		case err != nil:
+			failed = true
+			fmt.Printf("FAIL %-9s (%s)\n", name, time.Since(start).Round(time.Millisecond))
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("     %s\n", line)
+			}
+		default:
+			fmt.Printf("PASS %-9s (%s)\n", name, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run(setup setupFunc, dir string, opts kmstest.ConformanceOptions) error {
+	open, cleanup, err := setup(dir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return kmstest.TestProvider(open, opts)
+}
+
+func setupFake(dir string) (kmstest.ProviderFactory, func(), error) {
+	key := make([]byte, 32)
+	copy(key, "hsm-conformance-fake-provider-key")
+	open := func() (kmslib.HSMProvider, error) {
+		return kmstest.NewProvider(key)
+	}
+	return open, func() {}, nil
+}
+
+// setupFailover tests a failover provider over two fakes with a cloned key,
+// the primary failing its first call so the secondary has to serve it.
+func setupFailover(dir string) (kmstest.ProviderFactory, func(), error) {
+	key := make([]byte, 32)
+	copy(key, "hsm-conformance-failover-key")
+	open := func() (kmslib.HSMProvider, error) {
+		primary, err := kmstest.NewProvider(key)
+		if err != nil {
+			return nil, err
+		}
+		secondary, err := kmstest.NewProvider(key)
+		if err != nil {
+			return nil, err
+		}
+		primary.FailNext(1, nil)
+		return kmslib.NewFailoverProvider([]kmslib.FailoverBackend{
+			{Name: "primary", Provider: primary},
+			{Name: "secondary", Provider: secondary},
+		}, kmslib.FailoverConfig{
+			HealthCheckInterval: -1,
+			OnServe:             func(kmslib.ServeEvent) {},
+		})
+	}
+	return open, func() {}, nil
+}
+
+func setupVault(dir string) (kmstest.ProviderFactory, func(), error) {
+	vault := kmstest.NewVaultTransit()
+	if err := vault.CreateKey("kms-master-key"); err != nil {
+		vault.Close()
+		return nil, nil, err
+	}
+	cfg := kmslib.VaultConfig{
+		Address:   vault.URL,
+		Token:     vault.RootToken,
+		KeyName:   "kms-master-key",
+		DEKPath:   filepath.Join(dir, "vault-dek.json"),
+		CreateDEK: true,
+	}
+	open := func() (kmslib.HSMProvider, error) {
+		return kmslib.NewVaultTransitProvider(cfg)
+	}
+	return open, vault.Close, nil
+}
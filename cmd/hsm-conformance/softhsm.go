@@ -0,0 +1,29 @@
+//go:build pkcs11
+// +build pkcs11
+
+package main
+
+import (
+	kmslib "kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+// setupSoftHSM provisions a throwaway SoftHSM2 token in dir (via SOFTHSM2_CONF)
+// holding a non-extractable AES-256 key, and opens PKCS11Providers on it.
+func setupSoftHSM(dir string) (kmstest.ProviderFactory, func(), error) {
+	lib := *softHSMLib
+	if lib == "" {
+		lib = kmstest.FindSoftHSM()
+	}
+	if lib == "" {
+		return nil, nil, skip("SoftHSM2 not found (set -softhsm-lib or SOFTHSM2_LIB)")
+	}
+	restore, err := kmstest.NewSoftHSMToken(lib, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	open := func() (kmslib.HSMProvider, error) {
+		return kmslib.NewPKCS11Provider(lib, 0, kmstest.SoftHSMPin, kmstest.SoftHSMKeyLabel)
+	}
+	return open, restore, nil
+}
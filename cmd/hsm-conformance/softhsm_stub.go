@@ -0,0 +1,10 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package main
+
+import "kms/internal/kms/kmstest"
+
+func setupSoftHSM(dir string) (kmstest.ProviderFactory, func(), error) {
+	return nil, nil, skip("PKCS#11 support not compiled (use build tag: pkcs11)")
+}
@@ -4,31 +4,50 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	kmslib "kms/internal/kms"
+	"kms/internal/logging"
+	"kms/internal/metrics"
+	"kms/internal/tlsconfig"
+	"kms/internal/tracing"
 	kmsproto "kms/proto"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // REST API request/response structures
 type EncryptRequest struct {
 	Plaintext string `json:"plaintext"`
 	KeyID     string `json:"key_id,omitempty"`
+	// IdempotencyKey makes a retry return the first attempt's result; for a
+	// single encrypt the Idempotency-Key header may be used instead.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 type EncryptResponse struct {
-	Ciphertext string `json:"ciphertext"` // base64 encoded
-	Nonce      string `json:"nonce"`      // base64 encoded
+	Ciphertext string `json:"ciphertext"`       // base64 encoded
+	Nonce      string `json:"nonce"`            // base64 encoded
+	Error      string `json:"error,omitempty"`  // batch only: why this item failed
+	Reason     string `json:"reason,omitempty"` // batch only: machine-readable cause, e.g. INVALID_CIPHERTEXT
 }
 
 type BatchEncryptRequest struct {
@@ -53,10 +72,27 @@ type DecryptRequest struct {
 
 type DecryptResponse struct {
 	Plaintext string `json:"plaintext"`
+	Error     string `json:"error,omitempty"`  // batch only: why this item failed
+	Reason    string `json:"reason,omitempty"` // batch only: machine-readable cause, e.g. INVALID_CIPHERTEXT
 }
 
+// Batch results are returned one per item, in request order.
+type BatchDecryptRequest struct {
+	Items []DecryptRequest `json:"items"`
+}
+
+type BatchDecryptResponse struct {
+	Results []DecryptResponse `json:"results"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// ErrorResponse is the body of every non-2xx response. Code and Reason are
+// set when the error came from the KMS server: the gRPC status code name
+// (e.g. "INVALID_ARGUMENT") and the google.rpc.ErrorInfo reason.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error  string `json:"error"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
 }
 
 // HTTP server that wraps gRPC KMS service
@@ -65,6 +101,7 @@ type HTTPServer struct {
 	grpcClient kmsproto.KMSClient
 	grpcConn   *grpc.ClientConn
 	token      string
+	draining   atomic.Bool // set on shutdown so /health fails first
 }
 
 func main() {
@@ -72,10 +109,37 @@ func main() {
 	httpAddr := getenvDefault("KMS_HTTP_ADDR", ":8080")
 	token := os.Getenv("KMS_BEARER_TOKEN")
 
+	// KMS_LOG_LEVEL / KMS_LOG_FORMAT / KMS_LOG_FILE; the bearer token is
+	// redacted from every log line.
+	logger, err := logging.Setup(logging.ConfigFromEnv(), token)
+	if err != nil {
+		logging.Fatal("invalid log settings", "err", err)
+	}
+	defer logger.Close()
+
 	// Connect to gRPC server
-	conn, err := grpc.NewClient(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := tlsconfig.DialOption(tlsconfig.ClientConfigFromEnv())
+	if err != nil {
+		logging.Fatal("failed to configure TLS for the gRPC connection", "err", err)
+	}
+	// KMS_TRACING_* exports a span per request; the trace continues into
+	// kms-server through the gRPC metadata.
+	tracingCfg, err := tracing.ConfigFromEnv()
+	if err != nil {
+		logging.Fatal("invalid tracing configuration", "err", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), "kms-http-server", tracingCfg)
 	if err != nil {
-		log.Fatalf("failed to connect to gRPC server at %s: %v", grpcAddr, err)
+		logging.Fatal("failed to set up tracing", "err", err)
+	}
+	dialOpts := []grpc.DialOption{creds}
+	if tracingCfg.Enabled() {
+		dialOpts = append(dialOpts, tracing.DialOption())
+		slog.Info("KMS HTTP server: tracing enabled", "exporter", tracingCfg.Exporter)
+	}
+	conn, err := grpc.NewClient(grpcAddr, dialOpts...)
+	if err != nil {
+		logging.Fatal("failed to connect to gRPC server", "grpc_addr", grpcAddr, "err", err)
 	}
 	defer conn.Close()
 
@@ -94,14 +158,86 @@ func main() {
 	r.HandleFunc("/api/v1/encrypt", server.encryptHandler).Methods("POST")
 	r.HandleFunc("/api/v1/encrypt/batch", server.batchEncryptHandler).Methods("POST")
 	r.HandleFunc("/api/v1/decrypt", server.decryptHandler).Methods("POST")
+	r.HandleFunc("/api/v1/decrypt/batch", server.batchDecryptHandler).Methods("POST")
+
+	// Prometheus scrapes /metrics on its own plain HTTP listener,
+	// KMS_HTTP_METRICS_ADDR, so that it is neither reachable through the
+	// public API port nor subject to its CORS policy. The routes record
+	// request counts and latency only when it is set.
+	var metricsServer *http.Server
+	if metricsAddr := os.Getenv("KMS_HTTP_METRICS_ADDR"); metricsAddr != "" {
+		reg := metrics.NewRegistry()
+		r.Use(metrics.NewGateway(reg).Middleware)
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler(reg))
+		metricsServer = &http.Server{Addr: metricsAddr, Handler: metricsMux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Fatal("metrics server failed", "addr", metricsAddr, "err", err)
+			}
+		}()
+		slog.Info("KMS HTTP server: serving metrics", "url", "http://"+metricsAddr+"/metrics")
+	}
 
 	// CORS middleware for SSIS
 	r.Use(corsMiddleware)
+	var handler http.Handler = r
+	if tracingCfg.Enabled() {
+		r.Use(spanNameMiddleware)
+		handler = otelhttp.NewHandler(r, "kms-http-server")
+	}
 
-	log.Printf("KMS HTTP server listening on %s (gRPC backend: %s)", httpAddr, grpcAddr)
-	if err := http.ListenAndServe(httpAddr, r); err != nil {
-		log.Fatalf("HTTP server failed: %v", err)
+	httpServer := &http.Server{Addr: httpAddr, Handler: handler}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- httpServer.ListenAndServe() }()
+	slog.Info("KMS HTTP server listening", "addr", httpAddr, "grpc_addr", grpcAddr)
+
+	// SIGINT/SIGTERM: fail /health, wait KMS_SHUTDOWN_DRAIN_DELAY for load
+	// balancers to notice, then let in-flight requests finish within
+	// KMS_SHUTDOWN_TIMEOUT before closing the gRPC connection.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		logging.Fatal("HTTP server failed", "addr", httpAddr, "err", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	server.draining.Store(true)
+	drainDelay := getenvDuration("KMS_SHUTDOWN_DRAIN_DELAY", 0)
+	timeout := getenvDuration("KMS_SHUTDOWN_TIMEOUT", 30*time.Second)
+	slog.Info("KMS HTTP server: shutting down", "drain_delay", drainDelay, "timeout", timeout)
+	time.Sleep(drainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("KMS HTTP server: requests still running; closing connections", "timeout", timeout, "err", err)
+		httpServer.Close()
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	tracingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("KMS HTTP server: flushing traces failed", "err", err)
 	}
+	slog.Info("KMS HTTP server: stopped")
+}
+
+// spanNameMiddleware names the request span after the route, e.g.
+// "POST /api/v1/encrypt", so that traces group by endpoint.
+func spanNameMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tmpl, err := cr.GetPathTemplate(); err == nil {
+				trace.SpanFromContext(r.Context()).SetName(r.Method + " " + tmpl)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
@@ -117,8 +253,35 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// healthHandler reports ok only when the KMS gRPC server says the KMS service
+// is SERVING, i.e. its key backend passes the self-test, and the gateway is not
+// shutting down. Otherwise it returns 503 so load balancers take the gateway
+// out of rotation.
 func (s *HTTPServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(s.grpcConn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: kmsproto.KMS_ServiceDesc.ServiceName,
+	})
 	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "kms": resp.GetStatus().String()})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
@@ -133,14 +296,19 @@ func (s *HTTPServer) encryptHandler(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "plaintext is required")
 		return
 	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
 
-	ctx := s.createContext(r)
+	ctx, cancel := s.createContext(r)
+	defer cancel()
 	resp, err := s.grpcClient.Encrypt(ctx, &kmsproto.EncryptRequest{
-		Plaintext: []byte(req.Plaintext),
-		KeyId:     req.KeyID,
+		Plaintext:      []byte(req.Plaintext),
+		KeyId:          req.KeyID,
+		IdempotencyKey: req.IdempotencyKey,
 	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondGRPCError(w, err)
 		return
 	}
 
@@ -163,95 +331,40 @@ func (s *HTTPServer) batchEncryptHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Limit batch size for performance
-	if len(req.Items) > 1000 {
-		respondError(w, http.StatusBadRequest, "batch size cannot exceed 1000 items")
+	// The KMS server enforces its batch size limit (limits.maxBatchItems)
+	// and an oversized batch comes back as InvalidArgument, answered as 400.
+	items := make([]*kmsproto.EncryptRequest, len(req.Items))
+	for i, item := range req.Items {
+		items[i] = &kmsproto.EncryptRequest{
+			Plaintext:      []byte(item.Plaintext),
+			KeyId:          item.KeyID,
+			IdempotencyKey: item.IdempotencyKey,
+		}
+	}
+
+	// One BatchEncrypt call; the KMS server returns results in request order.
+	ctx, cancel := s.createContext(r)
+	defer cancel()
+	resp, err := s.grpcClient.BatchEncrypt(ctx, &kmsproto.BatchEncryptRequest{Items: items})
+	if err != nil {
+		respondGRPCError(w, err)
 		return
 	}
 
-	ctx := s.createContext(r)
-	
-	// Pre-allocate results slice for better performance
-	results := make([]EncryptResponse, len(req.Items))
+	results := make([]EncryptResponse, len(resp.Results))
 	errors := make([]string, 0)
-	
-	// Use worker pool pattern for better performance
-	// Limit concurrent goroutines to avoid overwhelming gRPC connection
-	maxWorkers := 50
-	if len(req.Items) < maxWorkers {
-		maxWorkers = len(req.Items)
-	}
-	
-	type workItem struct {
-		index int
-		item  EncryptRequest
-	}
-	
-	type result struct {
-		index int
-		resp  *kmsproto.EncryptResponse
-		err   error
-	}
-	
-	workChan := make(chan workItem, len(req.Items))
-	resultChan := make(chan result, len(req.Items))
-	
-	// Start worker pool
-	var wg sync.WaitGroup
-	for w := 0; w < maxWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for work := range workChan {
-				resp, err := s.grpcClient.Encrypt(ctx, &kmsproto.EncryptRequest{
-					Plaintext: []byte(work.item.Plaintext),
-					KeyId:     work.item.KeyID,
-				})
-				resultChan <- result{index: work.index, resp: resp, err: err}
-			}
-		}()
-	}
-	
-	// Send work items
-	for i, item := range req.Items {
-		workChan <- workItem{index: i, item: item}
-	}
-	close(workChan)
-	
-	// Wait for all workers to finish
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-	
-	// Collect results (maintain order)
-	tempResults := make([]*kmsproto.EncryptResponse, len(req.Items))
-	for res := range resultChan {
-		if res.err != nil {
-			errors = append(errors, res.err.Error())
-		} else {
-			tempResults[res.index] = res.resp
-		}
-	}
-	
-	// Convert to response format (base64 encoding done here, not in goroutines)
-	for i, resp := range tempResults {
-		if resp != nil {
-			results[i] = EncryptResponse{
-				Ciphertext: base64.StdEncoding.EncodeToString(resp.Ciphertext),
-				Nonce:      base64.StdEncoding.EncodeToString(resp.Nonce),
-			}
+	for i, res := range resp.Results {
+		if st := res.GetStatus(); st.GetCode() != 0 {
+			results[i].Error = st.GetMessage()
+			results[i].Reason = st.GetReason()
+			errors = append(errors, fmt.Sprintf("item %d: %s", i, st.GetMessage()))
+			continue
 		}
-	}
-	
-	// Filter out empty results if needed
-	finalResults := make([]EncryptResponse, 0, len(results))
-	for _, r := range results {
-		if r.Ciphertext != "" {
-			finalResults = append(finalResults, r)
+		results[i] = EncryptResponse{
+			Ciphertext: base64.StdEncoding.EncodeToString(res.Ciphertext),
+			Nonce:      base64.StdEncoding.EncodeToString(res.Nonce),
 		}
 	}
-	results = finalResults
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(BatchEncryptResponse{
@@ -260,50 +373,89 @@ func (s *HTTPServer) batchEncryptHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-func (s *HTTPServer) decryptHandler(w http.ResponseWriter, r *http.Request) {
-	var req DecryptRequest
+func (s *HTTPServer) batchDecryptHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchDecryptRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	// Support both legacy (ciphertext + nonce) and new combined format
-	var (
-		ciphertext []byte
-		nonce      []byte
-		err        error
-	)
+	if len(req.Items) == 0 {
+		respondError(w, http.StatusBadRequest, "items array is required")
+		return
+	}
 
-	if req.Encrypted != "" {
-		// New combined format: base64(nonce+ciphertext)
-		nonce, ciphertext, err = kmslib.SplitNonceAndCiphertext(req.Encrypted, kmslib.AESGCMNonceSize)
-		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid encrypted format: "+err.Error())
-			return
-		}
-	} else {
-		// Legacy format: separate ciphertext and nonce fields
-		ciphertext, err = base64.StdEncoding.DecodeString(req.Ciphertext)
+	results := make([]DecryptResponse, len(req.Items))
+	errors := make([]string, 0)
+
+	// Items that fail to decode are answered here; the rest go to the KMS
+	// server in one call, remembering their position in the request.
+	var items []*kmsproto.DecryptRequest
+	var positions []int
+	for i, item := range req.Items {
+		ciphertext, nonce, err := decodeDecryptRequest(item)
 		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid ciphertext encoding")
-			return
+			results[i].Error = err.Error()
+			errors = append(errors, fmt.Sprintf("item %d: %s", i, err.Error()))
+			continue
 		}
+		items = append(items, &kmsproto.DecryptRequest{
+			Ciphertext: ciphertext,
+			Nonce:      nonce,
+			KeyId:      item.KeyID,
+		})
+		positions = append(positions, i)
+	}
 
-		nonce, err = base64.StdEncoding.DecodeString(req.Nonce)
+	if len(items) > 0 {
+		ctx, cancel := s.createContext(r)
+		defer cancel()
+		resp, err := s.grpcClient.BatchDecrypt(ctx, &kmsproto.BatchDecryptRequest{Items: items})
 		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid nonce encoding")
+			respondGRPCError(w, err)
 			return
 		}
+		for j, res := range resp.Results {
+			i := positions[j]
+			if st := res.GetStatus(); st.GetCode() != 0 {
+				results[i].Error = st.GetMessage()
+				results[i].Reason = st.GetReason()
+				errors = append(errors, fmt.Sprintf("item %d: %s", i, st.GetMessage()))
+				continue
+			}
+			results[i].Plaintext = string(res.Plaintext)
+		}
 	}
 
-	ctx := s.createContext(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchDecryptResponse{
+		Results: results,
+		Errors:  errors,
+	})
+}
+
+func (s *HTTPServer) decryptHandler(w http.ResponseWriter, r *http.Request) {
+	var req DecryptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	ciphertext, nonce, err := decodeDecryptRequest(req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.createContext(r)
+	defer cancel()
 	resp, err := s.grpcClient.Decrypt(ctx, &kmsproto.DecryptRequest{
 		Ciphertext: ciphertext,
 		Nonce:      nonce,
 		KeyId:      req.KeyID,
 	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondGRPCError(w, err)
 		return
 	}
 
@@ -313,7 +465,40 @@ func (s *HTTPServer) decryptHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *HTTPServer) createContext(r *http.Request) context.Context {
+// decodeDecryptRequest supports both the legacy (ciphertext + nonce) and the
+// new combined format.
+func decodeDecryptRequest(req DecryptRequest) (ciphertext, nonce []byte, err error) {
+	if req.Encrypted != "" {
+		// New combined format: base64(nonce+ciphertext)
+		nonce, ciphertext, err = kmslib.SplitNonceAndCiphertext(req.Encrypted, kmslib.AESGCMNonceSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid encrypted format: %w", err)
+		}
+		return ciphertext, nonce, nil
+	}
+
+	// Legacy format: separate ciphertext and nonce fields
+	ciphertext, err = base64.StdEncoding.DecodeString(req.Ciphertext)
+	if err != nil {
+		return nil, nil, errors.New("invalid ciphertext encoding")
+	}
+	nonce, err = base64.StdEncoding.DecodeString(req.Nonce)
+	if err != nil {
+		return nil, nil, errors.New("invalid nonce encoding")
+	}
+	return ciphertext, nonce, nil
+}
+
+// auditHeaders maps the HTTP headers forwarded to the KMS server to their
+// gRPC metadata keys.
+var auditHeaders = map[string]string{
+	"X-Request-ID":             "x-request-id",
+	"X-KMS-Encryption-Context": "x-kms-encryption-context",
+}
+
+// createContext forwards the caller's bearer token and bounds the call to 30
+// seconds. The caller must call cancel.
+func (s *HTTPServer) createContext(r *http.Request) (context.Context, context.CancelFunc) {
 	ctx := r.Context()
 	
 	// Use token from Authorization header or fallback to env var
@@ -324,10 +509,16 @@ func (s *HTTPServer) createContext(r *http.Request) context.Context {
 	} else if s.token != "" {
 		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+s.token)
 	}
+	// The KMS audit log records the request ID and a hash of the encryption
+	// context; pass the caller's on.
+	for header, key := range auditHeaders {
+		if v := r.Header.Get(header); v != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, v)
+		}
+	}
 	
 	// Add timeout
-	ctx, _ = context.WithTimeout(ctx, 30*time.Second)
-	return ctx
+	return context.WithTimeout(ctx, 30*time.Second)
 }
 
 func respondError(w http.ResponseWriter, code int, message string) {
@@ -336,6 +527,73 @@ func respondError(w http.ResponseWriter, code int, message string) {
 	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
 }
 
+// respondGRPCError writes an error returned by the KMS server with the HTTP
+// status matching its gRPC code.
+func respondGRPCError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	resp := ErrorResponse{Error: st.Message(), Code: codeName(st.Code())}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			resp.Reason = d.GetReason()
+		case *errdetails.RetryInfo:
+			// Rate limited: tell the client when to come back, rounded up.
+			secs := (d.GetRetryDelay().AsDuration() + time.Second - 1) / time.Second
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(secs), 10))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus(st.Code()))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// httpStatus maps a gRPC code to the HTTP status the gateway returns.
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition, codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// codeName returns the canonical upper-case name of c, e.g.
+// "INVALID_ARGUMENT" for InvalidArgument.
+func codeName(c codes.Code) string {
+	var b strings.Builder
+	for i, r := range c.String() {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
 func getenvDefault(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
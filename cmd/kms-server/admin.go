@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+
+	kmslib "kms/internal/kms"
+	"kms/internal/server"
+)
+
+// version is the kms-server release, set at build time with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// Seal releases the key material of the named keys and closes the key
+// backend. Key operations fail with KMS_SEALED until Unseal.
+func (r *reloader) Seal() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sealed {
+		return nil
+	}
+	if r.keys != nil {
+		r.keys.Seal()
+	}
+	r.mgr.Seal()
+	r.sealed = true
+	slog.Info("KMS admin: sealed; key material released", "backend", r.cfg.Key.Backend)
+	return nil
+}
+
+// Unseal builds the key backend from the configuration in effect, like a
+// reload, and resumes serving once it passes the self-test and unwraps the
+// key store.
+func (r *reloader) Unseal() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.sealed {
+		return nil
+	}
+	m, err := kmslib.NewManagerFromLookup(r.cfg.Lookup)
+	if err != nil {
+		return err
+	}
+	if err := r.checkBackend(m, nil); err != nil {
+		m.Close()
+		return err
+	}
+	r.mgr.Swap(m)
+	if r.keys != nil {
+		r.keys.Unseal()
+	}
+	r.sealed = false
+	slog.Info("KMS admin: unsealed", "backend", r.cfg.Key.Backend)
+	return nil
+}
+
+func (r *reloader) Info() server.ServerInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jwt := r.authn.Config()
+	return server.ServerInfo{
+		Version:     version,
+		KeyBackend:  r.cfg.Key.Backend,
+		ConfigFile:  r.path,
+		Sealed:      r.sealed,
+		TLSEnabled:  r.tls != nil,
+		AuthEnabled: jwt.Secret != "" || jwt.CertAuth,
+	}
+}
+
+func (r *reloader) hooks() server.AdminHooks {
+	return server.AdminHooks{Reload: r.Reload, Seal: r.Seal, Unseal: r.Unseal, Info: r.Info}
+}
@@ -1,69 +1,281 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 
+	"kms/internal/audit"
 	"kms/internal/auth"
+	"kms/internal/config"
+	"kms/internal/idempotency"
 	kmslib "kms/internal/kms"
+	"kms/internal/logging"
+	"kms/internal/metrics"
+	"kms/internal/ratelimit"
 	"kms/internal/server"
+	"kms/internal/tlsconfig"
+	"kms/internal/tracing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
 )
 
 func main() {
-	// Configuration via environment variables for simplicity.
-	addr := getenvDefault("KMS_GRPC_ADDR", ":50051")
-	masterKeyPath := getenvDefault("KMS_MASTER_KEY_PATH", "master.key")
-	jwtSecret := os.Getenv("KMS_JWT_SECRET")
-	jwtAud := os.Getenv("KMS_JWT_AUD")
-	jwtIss := os.Getenv("KMS_JWT_ISS")
-
-	// Use NewManager which supports both file and HSM backends
-	var mgr kmslib.Manager
-	var err error
-	hsmType := os.Getenv("KMS_HSM_TYPE")
-	if hsmType != "" {
-		log.Printf("KMS server: Using HSM backend (type=%s)", hsmType)
-		mgr, err = kmslib.NewManager()
-		if err != nil {
-			log.Fatalf("failed to initialize HSM manager: %v", err)
+	configPath := flag.String("config", os.Getenv("KMS_CONFIG_FILE"), "Path to a YAML config file; KMS_* environment variables override it")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration, print the effective settings (secrets redacted) and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logging.Fatal("invalid configuration", "err", err)
+	}
+	validateErr := cfg.Validate()
+	if *checkConfig {
+		cfg.Print(os.Stdout)
+		if validateErr != nil {
+			fmt.Fprintf(os.Stderr, "\nconfiguration is invalid:\n%v\n", validateErr)
+			os.Exit(1)
 		}
+		fmt.Fprintln(os.Stderr, "\nconfiguration OK")
+		return
+	}
+	if validateErr != nil {
+		logging.Fatal("invalid configuration", "err", validateErr)
+	}
+
+	// From here on the log is structured, and the configured secrets are
+	// redacted wherever they appear.
+	logger, err := logging.Setup(cfg.LoggingConfig(), cfg.Secrets()...)
+	if err != nil {
+		logging.Fatal("failed to set up logging", "err", err)
+	}
+	defer logger.Close()
+	if *configPath != "" {
+		slog.Info("KMS server: configuration loaded", "path", *configPath)
+	}
+
+	// The key backend reads its settings through cfg.Lookup, which serves
+	// the config file with the environment overrides applied.
+	if cfg.Key.Backend == "file" {
+		slog.Info("KMS server: using file-based key", "backend", cfg.Key.Backend, "path", cfg.Key.File.Path)
 	} else {
-		log.Printf("KMS server: Using file-based key from %s", masterKeyPath)
-		var fileMgr kmslib.Manager
-		fileMgr, err = kmslib.NewManagerFromFile(masterKeyPath)
+		slog.Info("KMS server: using HSM backend", "backend", cfg.Key.Backend)
+	}
+	initialMgr, err := kmslib.NewManagerFromLookup(cfg.Lookup)
+	if err != nil {
+		logging.Fatal("failed to initialize key backend", "backend", cfg.Key.Backend, "err", err)
+	}
+	// A reload swaps the key backend under the running server.
+	mgr := kmslib.NewReloadableManager(initialMgr)
+
+	// With metrics enabled, every call into the key backend is timed.
+	var (
+		reg     *prometheus.Registry
+		mtr     *metrics.Server
+		backend kmslib.Manager = mgr
+	)
+	if cfg.Metrics.Addr != "" {
+		reg = metrics.NewRegistry()
+		mtr = metrics.NewServer(reg)
+		backend = mtr.Manager(mgr)
+	}
+
+	// Named keys are wrapped by the key backend, whichever is current.
+	var keys *kmslib.Keyring
+	if cfg.Key.Store != "" {
+		keys, err = kmslib.OpenKeyring(cfg.Key.Store, backend)
 		if err != nil {
-			log.Fatalf("failed to load master key from %s: %v", masterKeyPath, err)
+			logging.Fatal("failed to open key store", "path", cfg.Key.Store, "err", err)
 		}
-		mgr = fileMgr
+		slog.Info("KMS server: key store opened", "path", cfg.Key.Store, "keys", keys.Len())
 	}
 
-	var interceptors []grpc.UnaryServerInterceptor
-	if jwtSecret != "" {
-		jwtCfg := auth.JWTConfig{
-			Secret:   jwtSecret,
-			Audience: jwtAud,
-			Issuer:   jwtIss,
+	serverOpts := []grpc.ServerOption{grpc.MaxRecvMsgSize(cfg.Limits.MaxRecvMsgBytes)}
+	if cfg.Limits.MaxConcurrentStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(uint32(cfg.Limits.MaxConcurrentStreams)))
+	}
+	tlsCfg := cfg.TLSConfig()
+	var tlsReloader *tlsconfig.Reloader
+	if tlsCfg.Enabled() {
+		var creds grpc.ServerOption
+		creds, tlsReloader, err = tlsconfig.ServerOption(tlsCfg)
+		if err != nil {
+			logging.Fatal("failed to configure TLS", "err", err)
 		}
-		interceptors = append(interceptors, auth.UnaryServerInterceptor(jwtCfg))
-		log.Printf("KMS server: JWT auth enabled (aud=%s, iss=%s)", jwtAud, jwtIss)
-		if err := server.Run(addr, mgr, jwtCfg, interceptors...); err != nil {
-			log.Fatalf("KMS server exited with error: %v", err)
+		serverOpts = append(serverOpts, creds)
+		slog.Info("KMS server: TLS enabled", "cert", tlsCfg.CertFile,
+			"expires", tlsReloader.NotAfter().Format(time.DateOnly), "client_auth", tlsCfg.ClientAuthMode())
+	} else {
+		slog.Warn("KMS server: TLS disabled (tls.certFile / KMS_TLS_CERT_FILE not set); traffic is plaintext")
+	}
+
+	jwtCfg := cfg.JWTConfig()
+	if jwtCfg.Secret != "" {
+		slog.Info("KMS server: JWT auth enabled", "audience", jwtCfg.Audience, "issuer", jwtCfg.Issuer)
+	} else {
+		slog.Warn("KMS server: JWT auth disabled (auth.jwtSecret / KMS_JWT_SECRET not set)")
+	}
+	if jwtCfg.CertAuth {
+		slog.Info("KMS server: client certificate auth enabled")
+	}
+	if len(jwtCfg.AllowedPrincipals) > 0 {
+		slog.Info("KMS server: allowed principals", "principals", jwtCfg.AllowedPrincipals)
+	}
+	// The interceptors are installed even with auth disabled: a reload may
+	// enable it, and KMSAdmin is refused without it.
+	authn := auth.NewAuthenticator(jwtCfg)
+	var (
+		interceptors       []grpc.UnaryServerInterceptor
+		streamInterceptors []grpc.StreamServerInterceptor
+	)
+	// Metrics come first, to count every call and time it in full.
+	if mtr != nil {
+		interceptors = append(interceptors, server.MetricsUnaryInterceptor(mtr))
+		streamInterceptors = append(streamInterceptors, server.MetricsStreamInterceptor(mtr))
+	}
+	// The audit log wraps everything else, so that refused calls are recorded
+	// too; the principal is filled in once auth has identified it.
+	var auditLog *audit.Log
+	if cfg.Audit.Dir != "" {
+		opts, err := cfg.AuditOptions()
+		if err != nil {
+			logging.Fatal("invalid configuration", "err", err)
 		}
+		auditLog, err = audit.Open(opts)
+		if err != nil {
+			logging.Fatal("failed to open audit log", "dir", cfg.Audit.Dir, "err", err)
+		}
+		seq, _ := auditLog.Head()
+		slog.Info("KMS server: audit log opened", "dir", cfg.Audit.Dir, "hmac", len(opts.HMACKey) > 0, "seq", seq)
+		for _, f := range opts.Forward {
+			slog.Info("KMS server: forwarding audit records", "sink", f.Sink.Name(), "fail_mode", cfg.Audit.FailMode)
+		}
+		interceptors = append(interceptors, server.AuditUnaryInterceptor(auditLog))
+		streamInterceptors = append(streamInterceptors, server.AuditStreamInterceptor(auditLog))
 	} else {
-		log.Print("KMS server: JWT auth disabled (KMS_JWT_SECRET not set)")
-		if err := server.Run(addr, mgr, auth.JWTConfig{}, interceptors...); err != nil {
-			log.Fatalf("KMS server exited with error: %v", err)
+		slog.Warn("KMS server: audit log disabled (audit.dir / KMS_AUDIT_DIR not set)")
+	}
+	interceptors = append(interceptors, authn.UnaryServerInterceptor())
+	streamInterceptors = append(streamInterceptors, authn.StreamServerInterceptor())
+	if auditLog != nil {
+		interceptors = append(interceptors, server.AuditPrincipalUnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, server.AuditPrincipalStreamInterceptor())
+	}
+	// The latency per key follows auth, which names the tenant of the key.
+	if mtr != nil {
+		interceptors = append(interceptors, server.MetricsKeyUnaryInterceptor(mtr, keys))
+	}
+
+	// The rate limiter runs after auth, which names the principal it charges.
+	// It is installed even without limits, so a reload can set them.
+	limiter := ratelimit.New(cfg.RateLimitConfig())
+	if cfg.RateLimitConfig().Enabled() {
+		slog.Info("KMS server: rate limits enabled")
+	}
+	interceptors = append(interceptors, server.RateLimitUnaryInterceptor(limiter))
+	streamInterceptors = append(streamInterceptors, server.RateLimitStreamInterceptor(limiter))
+
+	if len(cfg.Tenants) > 0 {
+		slog.Info("KMS server: tenants configured", "tenants", slices.Sorted(maps.Keys(cfg.Tenants)))
+	}
+
+	kmsServer := server.NewKMSServerWithKeyring(backend, keys)
+	kmsServer.SetMaxBatchItems(cfg.Limits.MaxBatchItems)
+	kmsServer.SetPolicy(cfg.Policy())
+	kmsServer.SetIdempotencyCache(idempotency.New(cfg.Idempotency.TTL, cfg.Idempotency.MaxEntries))
+	rl := &reloader{path: *configPath, cfg: cfg, log: logger, mgr: mgr, keys: keys, authn: authn, kms: kmsServer, limit: limiter, tls: tlsReloader}
+
+	// SIGHUP re-reads the configuration, like KMSAdmin/ReloadConfig. It does
+	// not exist on Windows; use the RPC there.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("KMS server: SIGHUP received, reloading configuration")
+			rl.Reload()
 		}
+	}()
+
+	// Prometheus scrapes /metrics on its own plain HTTP listener, so that it
+	// needs neither a token nor a client certificate.
+	var metricsServer *http.Server
+	if reg != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(reg))
+		metricsServer = &http.Server{Addr: cfg.Metrics.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Fatal("metrics server failed", "addr", cfg.Metrics.Addr, "err", err)
+			}
+		}()
+		slog.Info("KMS server: serving metrics", "url", "http://"+cfg.Metrics.Addr+"/metrics")
 	}
-}
 
-func getenvDefault(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+	// Traces continue from the caller's trace context in the gRPC metadata.
+	shutdownTracing, err := tracing.Setup(context.Background(), "kms-server", cfg.TracingConfig(),
+		attribute.String("kms.key_backend", cfg.Key.Backend))
+	if err != nil {
+		logging.Fatal("failed to set up tracing", "err", err)
 	}
-	return def
-}
+	if cfg.Tracing.Exporter != "" {
+		serverOpts = append(serverOpts, tracing.ServerOption())
+		slog.Info("KMS server: tracing enabled", "exporter", cfg.Tracing.Exporter, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+
+	// SIGINT (Ctrl+C) or SIGTERM (docker stop, Kubernetes) starts a graceful
+	// shutdown; a second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	serveErr := server.Serve(ctx, server.Options{
+		Addr:                cfg.Server.Addr,
+		Manager:             backend,
+		KMSServer:           kmsServer,
+		Authenticator:       authn,
+		Keys:                keys,
+		Admin:               rl.hooks(),
+		UnaryInterceptors:   interceptors,
+		StreamInterceptors:  streamInterceptors,
+		ServerOptions:       serverOpts,
+		DrainDelay:          cfg.Server.ShutdownDrainDelay,
+		ShutdownTimeout:     cfg.Server.ShutdownTimeout,
+		HealthCheckInterval: cfg.Server.HealthCheckInterval,
+		HealthCheckTimeout:  cfg.Server.HealthCheckTimeout,
+	})
+	stop()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	tracingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("KMS server: flushing traces failed", "err", err)
+	}
+	cancel()
 
+	// Zero the key / close the HSM session only after in-flight RPCs are done.
+	if err := mgr.Close(); err != nil {
+		slog.Error("KMS server: closing key manager failed", "backend", cfg.Key.Backend, "err", err)
+	}
+	if auditLog != nil {
+		seq, head := auditLog.Head()
+		if err := auditLog.Close(); err != nil {
+			slog.Error("KMS server: closing audit log failed", "err", err)
+		}
+		slog.Info("KMS server: audit log closed", "seq", seq, "head", head)
+	}
+	if serveErr != nil {
+		logging.Fatal("KMS server exited with error", "err", serveErr)
+	}
+	slog.Info("KMS server: stopped")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"kms/internal/auth"
+	"kms/internal/config"
+	kmslib "kms/internal/kms"
+	"kms/internal/logging"
+	"kms/internal/ratelimit"
+	"kms/internal/server"
+	"kms/internal/tlsconfig"
+)
+
+// liveSettings are the config keys (or key prefixes ending in ".") that a
+// reload applies to the running server, except for restartSettings. Any
+// other change waits for a restart.
+var (
+	liveSettings    = []string{"key.", "auth.", "limits.maxBatchItems", "limits.maxPlaintextBytes", "limits.maxCiphertextBytes", "limits.keys", "rateLimit.", "tenants", "logging.level"}
+	restartSettings = []string{"key.store"}
+)
+
+// reloader re-reads the configuration on SIGHUP or KMSAdmin/ReloadConfig and
+// applies it to the running server. It also seals and unseals the key
+// backend (see admin.go).
+type reloader struct {
+	mu     sync.Mutex
+	path   string
+	cfg    *config.Config // in effect
+	log    *logging.Logger
+	sealed bool
+
+	mgr   *kmslib.ReloadableManager
+	keys  *kmslib.Keyring // nil without a key store
+	authn *auth.Authenticator
+	kms   *server.KMSServer
+	limit *ratelimit.Limiter
+	tls   *tlsconfig.Reloader // nil without TLS
+}
+
+// Reload loads and validates the configuration, then builds and checks a new
+// key backend when its settings changed (the file backend always re-reads its
+// key file) and re-reads the TLS files. Only when all of that succeeds
+// are the new key backend, auth settings, tenants, limits, rate limits and log level switched in; otherwise
+// the running configuration is left untouched. While sealed, the key backend
+// is not built; Unseal builds it from the reloaded settings.
+func (r *reloader) Reload() (server.ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Load(r.path)
+	if err != nil {
+		return server.ReloadResult{}, r.rejected(err)
+	}
+	if err := next.Validate(); err != nil {
+		return server.ReloadResult{}, r.rejected(err)
+	}
+
+	var res server.ReloadResult
+	changed := r.cfg.Changed(next)
+	for _, key := range changed {
+		if !isLive(key) {
+			res.RestartRequired = append(res.RestartRequired, key)
+		}
+	}
+
+	var newMgr kmslib.Manager
+	if !r.sealed && (next.Key.Backend == "file" || hasPrefix(changed, "key.")) {
+		newMgr, err = kmslib.NewManagerFromLookup(next.Lookup)
+		if err != nil {
+			return server.ReloadResult{}, r.rejected(fmt.Errorf("key backend: %w", err))
+		}
+		if err := r.checkBackend(newMgr, r.mgr); err != nil {
+			newMgr.Close()
+			return server.ReloadResult{}, r.rejected(fmt.Errorf("key backend: %w", err))
+		}
+	}
+	if r.tls != nil {
+		if err := r.tls.Reload(); err != nil {
+			if newMgr != nil {
+				newMgr.Close()
+			}
+			return server.ReloadResult{}, r.rejected(fmt.Errorf("tls: %w", err))
+		}
+	}
+
+	// Nothing below can fail.
+	if newMgr != nil {
+		r.mgr.Swap(newMgr)
+		res.KeyReloaded = true
+	}
+	r.authn.SetConfig(next.JWTConfig())
+	r.kms.SetMaxBatchItems(next.Limits.MaxBatchItems)
+	r.kms.SetPolicy(next.Policy())
+	r.limit.SetConfig(next.RateLimitConfig())
+	level, _ := logging.ParseLevel(next.Logging.Level)
+	r.log.SetLevel(level)
+	// Secrets that need a restart are still in use; keep redacting both.
+	r.log.SetSecrets(append(r.cfg.Secrets(), next.Secrets()...)...)
+	r.cfg = next
+
+	slog.Info("KMS reload: applied", "changed", changed, "key_reloaded", res.KeyReloaded, "backend", next.Key.Backend)
+	if len(res.RestartRequired) > 0 {
+		slog.Warn("KMS reload: takes effect after a restart", "settings", res.RestartRequired)
+	}
+	return res, nil
+}
+
+func (r *reloader) rejected(err error) error {
+	slog.Error("KMS reload: rejected, keeping the running configuration", "err", err)
+	return err
+}
+
+var reloadProbe = []byte("reload-ping")
+
+// checkBackend vets next before it replaces the running key backend: it must
+// pass the self-test, decrypt a probe encrypted by cur, and unwrap the named
+// keys of the key store. Otherwise everything encrypted so far would become
+// unreadable on the swap. cur is nil while sealed; a cur that cannot encrypt
+// the probe is what the reload may be fixing, so the probe is then skipped
+// and the key store check alone vouches for next.
+func (r *reloader) checkBackend(next, cur kmslib.Manager) error {
+	if err := selfTest(next); err != nil {
+		return err
+	}
+	if cur != nil {
+		ct, nonce, err := cur.Encrypt(reloadProbe)
+		if err != nil {
+			slog.Warn("KMS reload: running key backend cannot encrypt the probe, skipping the cross-check", "backend", r.cfg.Key.Backend, "err", err)
+		} else if pt, err := next.Decrypt(ct, nonce); err != nil {
+			return fmt.Errorf("cannot decrypt what the running key backend encrypts: %w", err)
+		} else if !bytes.Equal(pt, reloadProbe) {
+			return errors.New("decrypting what the running key backend encrypts returned different plaintext")
+		}
+	}
+	if r.keys != nil {
+		if err := r.keys.CheckKEK(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selfTest round-trips a fixed value, as the startup and health checks do.
+func selfTest(m kmslib.Manager) error {
+	ct, nonce, err := m.Encrypt(reloadProbe)
+	if err != nil {
+		return fmt.Errorf("self-test encrypt: %w", err)
+	}
+	pt, err := m.Decrypt(ct, nonce)
+	if err != nil {
+		return fmt.Errorf("self-test decrypt: %w", err)
+	}
+	if !bytes.Equal(pt, reloadProbe) {
+		return errors.New("self-test round trip returned different plaintext")
+	}
+	return nil
+}
+
+func isLive(key string) bool {
+	if slices.Contains(restartSettings, key) {
+		return false
+	}
+	for _, l := range liveSettings {
+		if key == l || (strings.HasSuffix(l, ".") && strings.HasPrefix(key, l)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(keys []string, prefix string) bool {
+	for _, k := range keys {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
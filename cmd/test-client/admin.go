@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"kms/internal/logging"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// runAdmin runs a KMSAdmin command and reports whether cmd was one.
+func runAdmin(conn *grpc.ClientConn, cmd string) bool {
+	client := kmsproto.NewKMSAdminClient(conn)
+	ctx := adminContext()
+
+	var (
+		key *kmsproto.KeyMetadata
+		err error
+	)
+	switch cmd {
+	case "create-key":
+		var resp *kmsproto.CreateKeyResponse
+		resp, err = client.CreateKey(ctx, &kmsproto.CreateKeyRequest{KeyId: requireArg(2, "key_id"), Description: arg(3)})
+		key = resp.GetKey()
+	case "list-keys":
+		resp, err := client.ListKeys(ctx, &kmsproto.ListKeysRequest{})
+		if err != nil {
+			logging.Fatal(cmd+" failed", "err", err)
+		}
+		if len(resp.Keys) == 0 {
+			fmt.Println("No keys.")
+		}
+		for _, k := range resp.Keys {
+			printKey(k)
+		}
+		return true
+	case "describe-key":
+		var resp *kmsproto.DescribeKeyResponse
+		resp, err = client.DescribeKey(ctx, &kmsproto.DescribeKeyRequest{KeyId: requireArg(2, "key_id")})
+		key = resp.GetKey()
+	case "rotate-key":
+		var resp *kmsproto.RotateKeyResponse
+		resp, err = client.RotateKey(ctx, &kmsproto.RotateKeyRequest{KeyId: requireArg(2, "key_id")})
+		key = resp.GetKey()
+	case "enable-key":
+		var resp *kmsproto.EnableKeyResponse
+		resp, err = client.EnableKey(ctx, &kmsproto.EnableKeyRequest{KeyId: requireArg(2, "key_id")})
+		key = resp.GetKey()
+	case "disable-key":
+		var resp *kmsproto.DisableKeyResponse
+		resp, err = client.DisableKey(ctx, &kmsproto.DisableKeyRequest{KeyId: requireArg(2, "key_id")})
+		key = resp.GetKey()
+	case "delete-key":
+		days := 0
+		if a := arg(3); a != "" {
+			if days, err = strconv.Atoi(a); err != nil {
+				logging.Fatal("invalid days", "days", a)
+			}
+		}
+		var resp *kmsproto.ScheduleKeyDeletionResponse
+		resp, err = client.ScheduleKeyDeletion(ctx, &kmsproto.ScheduleKeyDeletionRequest{KeyId: requireArg(2, "key_id"), PendingWindowDays: int32(days)})
+		key = resp.GetKey()
+	case "server-info":
+		info, err := client.GetServerInfo(ctx, &kmsproto.GetServerInfoRequest{})
+		if err != nil {
+			logging.Fatal(cmd+" failed", "err", err)
+		}
+		fmt.Printf("Version:     %s (%s)\n", info.Version, info.GoVersion)
+		fmt.Printf("Started:     %s\n", formatTime(info.StartTime))
+		fmt.Printf("Key backend: %s (sealed: %t)\n", info.KeyBackend, info.Sealed)
+		fmt.Printf("Key store:   %t (%d keys)\n", info.KeyStoreEnabled, info.KeyCount)
+		fmt.Printf("TLS: %t, auth: %t, config file: %q\n", info.TlsEnabled, info.AuthEnabled, info.ConfigFile)
+		return true
+	case "seal":
+		_, err = client.Seal(ctx, &kmsproto.SealRequest{})
+	case "unseal":
+		_, err = client.Unseal(ctx, &kmsproto.UnsealRequest{})
+	default:
+		return false
+	}
+	if err != nil {
+		if keyID := arg(2); keyID != "" {
+			logging.Fatal(cmd+" failed", "key_id", keyID, "err", err)
+		}
+		logging.Fatal(cmd+" failed", "err", err)
+	}
+	if key != nil {
+		printKey(key)
+	} else {
+		fmt.Println("OK")
+	}
+	return true
+}
+
+// adminContext authenticates with KMS_BEARER_TOKEN if set; an admin may use
+// a client certificate instead.
+func adminContext() context.Context {
+	ctx := context.Background()
+	if token := os.Getenv("KMS_BEARER_TOKEN"); token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	return ctx
+}
+
+func printKey(k *kmsproto.KeyMetadata) {
+	state := strings.TrimPrefix(k.State.String(), "KEY_STATE_")
+	fmt.Printf("%s  %s  version %d  created %s", k.KeyId, state, k.PrimaryVersion, formatTime(k.CreateTime))
+	if k.DeletionTime != nil {
+		fmt.Printf("  deleted after %s", formatTime(k.DeletionTime))
+	}
+	fmt.Println()
+	if k.Description != "" {
+		fmt.Printf("    %s\n", k.Description)
+	}
+}
+
+func formatTime(t *timestamppb.Timestamp) string {
+	return t.AsTime().Local().Format(time.DateTime)
+}
+
+// arg returns the i-th command-line argument, or "" if absent.
+func arg(i int) string {
+	if i < len(os.Args) {
+		return os.Args[i]
+	}
+	return ""
+}
+
+func requireArg(i int, name string) string {
+	a := arg(i)
+	if a == "" {
+		logging.Fatal(os.Args[1] + " requires a " + name + " argument")
+	}
+	return a
+}
@@ -2,15 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"os"
+	"strings"
 
+	kmslib "kms/internal/kms"
+	"kms/internal/logging"
+	"kms/internal/tlsconfig"
 	kmsproto "kms/proto"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -18,17 +21,38 @@ func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("Usage:")
 		fmt.Println("  go run ./cmd/test-client login              # Login and get token")
-		fmt.Println("  go run ./cmd/test-client encrypt <text>     # Encrypt text (requires token)")
-		fmt.Println("  go run ./cmd/test-client decrypt <cipher> <nonce> # Decrypt (requires token)")
+		fmt.Println("  go run ./cmd/test-client encrypt <text> [key_id] [idempotency_key]   # Encrypt text (requires token)")
+		fmt.Println("  go run ./cmd/test-client decrypt <cipher> <nonce> [key_id] # Decrypt (requires token)")
+		fmt.Println("  go run ./cmd/test-client describe-ciphertext <cipher> [nonce] # Format and key, hex or base64 (no decryption)")
+		fmt.Println("  go run ./cmd/test-client describe-ciphertext -combined <base64>  # base64(nonce + ciphertext) single-field value")
+		fmt.Println("\nAdmin commands (admin token or client certificate):")
+		fmt.Println("  go run ./cmd/test-client reload-config      # KMSAdmin/ReloadConfig")
+		fmt.Println("  go run ./cmd/test-client create-key <key_id> [description]")
+		fmt.Println("  go run ./cmd/test-client list-keys")
+		fmt.Println("  go run ./cmd/test-client describe-key <key_id>")
+		fmt.Println("  go run ./cmd/test-client rotate-key <key_id>")
+		fmt.Println("  go run ./cmd/test-client enable-key <key_id>")
+		fmt.Println("  go run ./cmd/test-client disable-key <key_id>")
+		fmt.Println("  go run ./cmd/test-client delete-key <key_id> [days]   # ScheduleKeyDeletion")
+		fmt.Println("  go run ./cmd/test-client server-info")
+		fmt.Println("  go run ./cmd/test-client seal | unseal")
 		fmt.Println("\nSet KMS_GRPC_ADDR to change server address (default: 127.0.0.1:50051)")
 		fmt.Println("Set KMS_BEARER_TOKEN for encrypt/decrypt operations")
 		os.Exit(1)
 	}
 
+	if _, err := logging.Setup(logging.ConfigFromEnv(), os.Getenv("KMS_BEARER_TOKEN")); err != nil {
+		logging.Fatal("invalid log settings", "err", err)
+	}
+
 	addr := getenvDefault("KMS_GRPC_ADDR", "127.0.0.1:50051")
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := tlsconfig.DialOption(tlsconfig.ClientConfigFromEnv())
+	if err != nil {
+		logging.Fatal("failed to configure TLS", "err", err)
+	}
+	conn, err := grpc.Dial(addr, creds)
 	if err != nil {
-		log.Fatalf("failed to dial: %v", err)
+		logging.Fatal("failed to dial", "addr", addr, "err", err)
 	}
 	defer conn.Close()
 
@@ -38,16 +62,34 @@ func main() {
 		testLogin(conn)
 	case "encrypt":
 		if len(os.Args) < 3 {
-			log.Fatal("encrypt requires text argument")
+			logging.Fatal("encrypt requires text argument")
 		}
-		testEncrypt(conn, os.Args[2])
+		testEncrypt(conn, os.Args[2], arg(3), arg(4))
 	case "decrypt":
 		if len(os.Args) < 4 {
-			log.Fatal("decrypt requires cipher and nonce arguments (as hex)")
+			logging.Fatal("decrypt requires cipher and nonce arguments (as hex)")
 		}
-		testDecrypt(conn, os.Args[2], os.Args[3])
+		testDecrypt(conn, os.Args[2], os.Args[3], arg(4))
+	case "describe-ciphertext":
+		if arg(2) == "-combined" {
+			nonce, ct, err := kmslib.SplitNonceAndCiphertext(requireArg(3, "base64 value"), kmslib.AESGCMNonceSize)
+			if err != nil {
+				logging.Fatal("invalid combined value", "err", err)
+			}
+			describeCiphertext(conn, ct, nonce)
+			break
+		}
+		var nonce []byte
+		if n := arg(3); n != "" {
+			nonce = decodeBytes(n)
+		}
+		describeCiphertext(conn, decodeBytes(requireArg(2, "cipher")), nonce)
+	case "reload-config":
+		reloadConfig(conn)
 	default:
-		log.Fatalf("unknown command: %s", cmd)
+		if !runAdmin(conn, cmd) {
+			logging.Fatal("unknown command", "command", cmd)
+		}
 	}
 }
 
@@ -58,27 +100,29 @@ func testLogin(conn *grpc.ClientConn) {
 		Password: "demo123",
 	})
 	if err != nil {
-		log.Fatalf("login failed: %v", err)
+		logging.Fatal("login failed", "err", err)
 	}
 	fmt.Printf("Token: %s\n", resp.Token)
 	fmt.Printf("\nYou can use this token by setting:\n")
 	fmt.Printf("  $env:KMS_BEARER_TOKEN=\"%s\"\n", resp.Token)
 }
 
-func testEncrypt(conn *grpc.ClientConn, plaintext string) {
+func testEncrypt(conn *grpc.ClientConn, plaintext, keyID, idempotencyKey string) {
 	token := os.Getenv("KMS_BEARER_TOKEN")
 	if token == "" {
-		log.Fatal("KMS_BEARER_TOKEN not set. Please login first and set the token.")
+		logging.Fatal("KMS_BEARER_TOKEN not set. Please login first and set the token.")
 	}
 
 	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
 	kmsClient := kmsproto.NewKMSClient(conn)
 
 	resp, err := kmsClient.Encrypt(ctx, &kmsproto.EncryptRequest{
-		Plaintext: []byte(plaintext),
+		Plaintext:      []byte(plaintext),
+		KeyId:          keyID,
+		IdempotencyKey: idempotencyKey,
 	})
 	if err != nil {
-		log.Fatalf("encrypt failed: %v", err)
+		logging.Fatal("encrypt failed", "key_id", keyID, "err", err)
 	}
 
 	fmt.Printf("Plaintext: %s\n", plaintext)
@@ -86,10 +130,10 @@ func testEncrypt(conn *grpc.ClientConn, plaintext string) {
 	fmt.Printf("Nonce (hex): %x\n", resp.Nonce)
 }
 
-func testDecrypt(conn *grpc.ClientConn, cipherHex, nonceHex string) {
+func testDecrypt(conn *grpc.ClientConn, cipherHex, nonceHex, keyID string) {
 	token := os.Getenv("KMS_BEARER_TOKEN")
 	if token == "" {
-		log.Fatal("KMS_BEARER_TOKEN not set. Please login first and set the token.")
+		logging.Fatal("KMS_BEARER_TOKEN not set. Please login first and set the token.")
 	}
 
 	// Decode hex strings
@@ -102,22 +146,82 @@ func testDecrypt(conn *grpc.ClientConn, cipherHex, nonceHex string) {
 	resp, err := kmsClient.Decrypt(ctx, &kmsproto.DecryptRequest{
 		Ciphertext: ciphertext,
 		Nonce:      nonce,
+		KeyId:      keyID,
 	})
 	if err != nil {
-		log.Fatalf("decrypt failed: %v", err)
+		logging.Fatal("decrypt failed", "key_id", keyID, "err", err)
 	}
 
 	fmt.Printf("Decrypted: %s\n", string(resp.Plaintext))
 }
 
+// describeCiphertext prints what the server reads from a ciphertext's bytes.
+// It needs only an inspect principal, so like the admin commands it may
+// authenticate with a client certificate.
+func describeCiphertext(conn *grpc.ClientConn, ciphertext, nonce []byte) {
+	resp, err := kmsproto.NewKMSClient(conn).DescribeCiphertext(adminContext(), &kmsproto.DescribeCiphertextRequest{
+		Ciphertext: ciphertext,
+		Nonce:      nonce,
+	})
+	if err != nil {
+		logging.Fatal("describe failed", "err", err)
+	}
+	fmt.Printf("Format: %s", strings.TrimPrefix(resp.Format.String(), "CIPHERTEXT_FORMAT_"))
+	if resp.FormatVersion != 0 {
+		fmt.Printf(" (header version %d)", resp.FormatVersion)
+	}
+	fmt.Println()
+	switch {
+	case resp.OtherTenant:
+		fmt.Println("Key: another tenant's key")
+	case resp.KeyId != "":
+		fmt.Printf("Key: %s version %d\n", resp.KeyId, resp.KeyVersion)
+	default:
+		fmt.Println("Key: the key backend's key")
+	}
+	fmt.Printf("Algorithm: %s\n", resp.Algorithm)
+	fmt.Printf("AAD: %t\n", resp.Aad)
+	fmt.Printf("Plaintext length: %d bytes\n", resp.PlaintextLength)
+	if nonce != nil {
+		fmt.Printf("Nonce: %d bytes (expected %d)\n", resp.NonceLength, resp.ExpectedNonceLength)
+	}
+}
+
+// reloadConfig asks the server to re-read its configuration. An admin may
+// authenticate with a client certificate instead of KMS_BEARER_TOKEN.
+func reloadConfig(conn *grpc.ClientConn) {
+	resp, err := kmsproto.NewKMSAdminClient(conn).ReloadConfig(adminContext(), &kmsproto.ReloadConfigRequest{})
+	if err != nil {
+		logging.Fatal("reload failed", "err", err)
+	}
+	fmt.Printf("Configuration reloaded (key backend reloaded: %t)\n", resp.KeyReloaded)
+	for _, key := range resp.RestartRequired {
+		fmt.Printf("  %s changed; takes effect after a restart\n", key)
+	}
+}
+
 func hexDecode(s string) []byte {
 	result, err := hex.DecodeString(s)
 	if err != nil {
-		log.Fatalf("invalid hex string: %v", err)
+		logging.Fatal("invalid hex string", "err", err)
 	}
 	return result
 }
 
+// decodeBytes reads hex, as printed by encrypt or by SQL Server for
+// VARBINARY ("0x..."), or else standard base64, as returned by the HTTP
+// gateway.
+func decodeBytes(s string) []byte {
+	if b, err := hex.DecodeString(strings.TrimPrefix(s, "0x")); err == nil {
+		return b
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		logging.Fatal("not a hex or base64 string", "err", err)
+	}
+	return b
+}
+
 func getenvDefault(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
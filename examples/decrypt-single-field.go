@@ -9,12 +9,12 @@ import (
 	"os"
 
 	kmslib "kms/internal/kms"
+	"kms/internal/tlsconfig"
 	kmsproto "kms/proto"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/microsoft/go-mssqldb"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -44,7 +44,11 @@ func main() {
 	}
 
 	// 2. Connect to KMS
-	conn, err := grpc.Dial(kmsAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := tlsconfig.DialOption(tlsconfig.ClientConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to configure KMS TLS: %v", err)
+	}
+	conn, err := grpc.Dial(kmsAddr, creds)
 	if err != nil {
 		log.Fatalf("Failed to dial KMS: %v", err)
 	}
@@ -0,0 +1,377 @@
+// Package audit writes a tamper-evident record of every KMS call: who called
+// what, on which key, from where and with what outcome, never the data.
+//
+// Records are appended as JSON lines to files in a directory. Each record
+// carries the hash of the one before it, so editing, removing or reordering
+// records breaks the chain, and with an HMAC key each hash is also signed so
+// that the chain cannot be recomputed without the key. Verify checks a
+// directory. Copies of the records can be forwarded to sinks such as syslog
+// or a webhook.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of a call.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// Record is one audited call. Seq, Prev, Hash and MAC are set by Log.Append.
+type Record struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// RequestID is the caller's x-request-id, or one generated by the server
+	// and returned in the response headers.
+	RequestID  string `json:"request_id,omitempty"`
+	Principal  string `json:"principal,omitempty"`
+	AuthMethod string `json:"auth_method,omitempty"` // "jwt" or "mtls"
+	// Tenant is the caller's tenant, when tenants are configured.
+	Tenant     string `json:"tenant,omitempty"`
+	ClientAddr string `json:"client_addr,omitempty"`
+	// Op is the gRPC method without its package, e.g. "KMS/Decrypt".
+	Op   string   `json:"op"`
+	Keys []KeyUse `json:"keys,omitempty"`
+	// Items and Failed count the items of a batch or stream call.
+	Items  int `json:"items,omitempty"`
+	Failed int `json:"failed,omitempty"`
+	// Outcome is OutcomeOK or OutcomeError, with the gRPC code and the
+	// ErrorInfo reason of the error.
+	Outcome string `json:"outcome"`
+	Code    string `json:"code,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// ContextHash is the hex SHA-256 of the encryption context the caller
+	// sent, so that calls can be matched to a job without storing it.
+	ContextHash string `json:"context_hash,omitempty"`
+
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+// KeyUse is a key a call used: its key_id ("default" for the key backend's
+// key, "payments/pan" for a tenant's key), the versions seen in ciphertexts
+// and the number of items.
+type KeyUse struct {
+	ID       string   `json:"id"`
+	Versions []uint32 `json:"versions,omitempty"`
+	Items    int      `json:"items,omitempty"`
+}
+
+// DefaultMaxFileBytes is the size at which Log starts a new file unless
+// Options.MaxFileBytes says otherwise.
+const DefaultMaxFileBytes = 100 << 20
+
+// Options configure a Log.
+type Options struct {
+	// Dir holds the audit files; it must exist.
+	Dir string
+	// MaxFileBytes is the size after which a new file is started.
+	MaxFileBytes int64
+	// HMACKey, if set, signs each record's hash.
+	HMACKey []byte
+
+	// Forward copies every record, or a tenant's, to these sinks, each
+	// through a buffer of
+	// Buffer records (0 means DefaultBuffer).
+	Forward []Forward
+	Buffer  int
+	// FailClosed makes Append and Check fail with ErrUnavailable when a
+	// record cannot be written or a sink's buffer is full, so that the KMS
+	// refuses calls it cannot audit. Otherwise such records are dropped
+	// from the sink (never from the files) and logged.
+	FailClosed bool
+}
+
+// Log appends records to the audit files in a directory. It is safe for
+// concurrent use.
+type Log struct {
+	opts Options
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	seq  uint64 // of the last record written
+	head string // hash of the last record written
+	werr error  // of the last write to the files, until one succeeds
+	fwds []*forwarder
+}
+
+// Open continues the chain of the newest record in opts.Dir, or starts one,
+// in a new file, so that a file left incomplete by a crash is not appended
+// to. A file without records is reused.
+func Open(opts Options) (*Log, error) {
+	if opts.MaxFileBytes <= 0 {
+		opts.MaxFileBytes = DefaultMaxFileBytes
+	}
+	files, err := listFiles(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{opts: opts}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, err := lastRecord(files[i])
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.seq, l.head = last.Seq, last.Hash
+			break
+		}
+	}
+	if err := l.rotateLocked(); err != nil {
+		return nil, err
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+	for _, f := range opts.Forward {
+		l.fwds = append(l.fwds, newForwarder(f, opts.Buffer))
+	}
+	return l, nil
+}
+
+// Append completes r with its sequence number, the previous hash, its own
+// hash and MAC, and writes it. Records are written in the order of Append.
+func (l *Log) Append(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return errors.New("audit: log is closed")
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+	r.Seq = l.seq + 1
+	r.Prev = l.head
+	line, err := seal(&r, l.opts.HMACKey)
+	if err != nil {
+		return err
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.opts.MaxFileBytes {
+		if err := l.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.werr = fmt.Errorf("audit: write %s: %w", l.f.Name(), err)
+		return l.failure(l.werr)
+	}
+	l.seq, l.head, l.werr = r.Seq, r.Hash, nil
+
+	var full []string
+	for _, fw := range l.fwds {
+		if fw.Tenant != "" && fw.Tenant != r.Tenant {
+			continue
+		}
+		if !fw.offer(r) {
+			full = append(full, fw.Sink.Name())
+		}
+	}
+	if len(full) > 0 && l.opts.FailClosed {
+		return fmt.Errorf("%w: buffer full: %s", ErrUnavailable, strings.Join(full, ", "))
+	}
+	return nil
+}
+
+// Check returns, in fail-closed mode, why the next record could not be
+// written or forwarded: the last write to the files failed or a sink's
+// buffer is full. It lets callers refuse work before doing it.
+func (l *Log) Check() error {
+	if !l.opts.FailClosed {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.werr != nil {
+		return l.failure(l.werr)
+	}
+	for _, fw := range l.fwds {
+		if fw.full() {
+			return fmt.Errorf("%w: buffer full: %s", ErrUnavailable, fw.Sink.Name())
+		}
+	}
+	return nil
+}
+
+// FailClosed reports whether calls that cannot be audited must be refused.
+func (l *Log) FailClosed() bool { return l.opts.FailClosed }
+
+// failure wraps err in ErrUnavailable in fail-closed mode.
+func (l *Log) failure(err error) error {
+	if l.opts.FailClosed {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+// Head returns the sequence number and hash of the last record written.
+// Keeping it elsewhere lets Verify detect records cut from the end.
+func (l *Log) Head() (uint64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.head
+}
+
+// Close syncs and closes the current file, then gives the sinks a few
+// seconds to deliver what is queued.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := closeFile(l.f)
+	l.f = nil
+	return errors.Join(err, closeForwarders(l.fwds))
+}
+
+// rotateLocked closes the current file and starts one named after the next
+// sequence number.
+func (l *Log) rotateLocked() error {
+	if l.f != nil {
+		if err := closeFile(l.f); err != nil {
+			return err
+		}
+		l.f = nil
+	}
+	name := filepath.Join(l.opts.Dir, fileName(l.seq+1))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	l.f, l.size = f, 0
+	return nil
+}
+
+func closeFile(f *os.File) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("audit: sync %s: %w", f.Name(), err)
+	}
+	return f.Close()
+}
+
+// seal sets r.Hash and r.MAC and returns r as a JSON line.
+func seal(r *Record, key []byte) ([]byte, error) {
+	hash, err := recordHash(*r)
+	if err != nil {
+		return nil, err
+	}
+	r.Hash = hash
+	if len(key) > 0 {
+		r.MAC = recordMAC(key, hash)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return append(b, '\n'), nil
+}
+
+// recordHash is the hex SHA-256 of r's JSON without its Hash and MAC. Prev is
+// part of it, which chains the records.
+func recordHash(r Record) (string, error) {
+	r.Hash, r.MAC = "", ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("audit: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func recordMAC(key []byte, hash string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(hash))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Files are named after the sequence number of their first record, so that
+// they sort in chain order.
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+)
+
+func fileName(firstSeq uint64) string {
+	return fmt.Sprintf("%s%012d%s", filePrefix, firstSeq, fileSuffix)
+}
+
+// fileSeq returns the first sequence number encoded in an audit file name.
+func fileSeq(name string) (uint64, bool) {
+	s, ok := strings.CutPrefix(filepath.Base(name), filePrefix)
+	if !ok {
+		return 0, false
+	}
+	s, ok = strings.CutSuffix(s, fileSuffix)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+// listFiles returns the audit files in dir in chain order.
+func listFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if _, ok := fileSeq(e.Name()); ok && e.Type().IsRegular() {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	slices.SortFunc(files, func(a, b string) int {
+		sa, _ := fileSeq(a)
+		sb, _ := fileSeq(b)
+		return cmp.Compare(sa, sb)
+	})
+	return files, nil
+}
+
+// lastRecord returns the last complete record of an audit file, or nil if it
+// has none. An incomplete last line, left by a crash, is skipped; Verify
+// reports it.
+func lastRecord(path string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	var last *Record
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			continue
+		}
+		last = &r
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("audit: read %s: %w", path, err)
+	}
+	return last, nil
+}
@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnavailable is returned, in fail-closed mode, when a record cannot be
+// written to the audit files or queued for a sink.
+var ErrUnavailable = errors.New("audit log unavailable")
+
+// Sink receives copies of the audit records, e.g. for a SIEM. Delivery is at
+// least once: a batch that failed part way is sent again.
+type Sink interface {
+	// Name identifies the sink in logs, e.g. "syslog tcp://siem:6514".
+	Name() string
+	// Write delivers records in order. A failed batch is retried after a
+	// backoff unless the error is a PermanentError.
+	Write(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// PermanentError marks a Write failure that retrying cannot fix, such as a
+// webhook rejecting the request; the batch is dropped.
+type PermanentError struct{ Err error }
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Forward configures the delivery of records to a Sink.
+type Forward struct {
+	Sink Sink
+	// Tenant, if set, forwards only the records of that tenant's callers.
+	Tenant string
+	// BatchSize is the most records per Write; 0 means DefaultBatchSize.
+	BatchSize int
+	// FlushInterval is how long a batch may wait to fill up; 0 writes
+	// whatever is queued at once.
+	FlushInterval time.Duration
+}
+
+// Defaults of Options and Forward.
+const (
+	DefaultBuffer    = 10000
+	DefaultBatchSize = 100
+)
+
+// Retry backoff of a failing sink, and how long Close waits for the sinks to
+// catch up.
+const (
+	minRetryDelay = 500 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+	drainTimeout  = 5 * time.Second
+)
+
+// forwarder queues records for one sink in a bounded buffer and writes them
+// from its own goroutine, so a slow or unreachable sink never blocks a call.
+type forwarder struct {
+	Forward
+	queue   chan Record
+	dropped atomic.Int64
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newForwarder(f Forward, buffer int) *forwarder {
+	if f.BatchSize <= 0 {
+		f.BatchSize = DefaultBatchSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	fw := &forwarder{
+		Forward: f,
+		queue:   make(chan Record, buffer),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go fw.run()
+	return fw
+}
+
+// full reports whether the buffer has no room for another record.
+func (fw *forwarder) full() bool { return len(fw.queue) == cap(fw.queue) }
+
+// offer queues r, or counts it as dropped when the buffer is full. Only one
+// goroutine (Log.Append, under its lock) offers, so full() stays accurate.
+func (fw *forwarder) offer(r Record) bool {
+	select {
+	case fw.queue <- r:
+		return true
+	default:
+		if fw.dropped.Add(1) == 1 {
+			slog.Error("KMS audit: sink buffer full, dropping records", "sink", fw.Sink.Name(), "buffer", cap(fw.queue))
+		}
+		return false
+	}
+}
+
+func (fw *forwarder) run() {
+	defer close(fw.done)
+	batch := make([]Record, 0, fw.BatchSize)
+	for {
+		r, ok := <-fw.queue
+		if !ok {
+			return
+		}
+		if fw.ctx.Err() != nil {
+			slog.Error("KMS audit: sink stopped with queued records undelivered", "sink", fw.Sink.Name(), "records", 1+len(fw.queue))
+			return
+		}
+		batch = append(batch[:0], r)
+		batch, ok = fw.fill(batch)
+		fw.deliver(batch)
+		if !ok {
+			return
+		}
+	}
+}
+
+// fill adds queued records to batch, waiting up to FlushInterval for it to
+// fill up. It returns false once the queue is closed and empty.
+func (fw *forwarder) fill(batch []Record) ([]Record, bool) {
+	var timeout <-chan time.Time
+	if fw.FlushInterval > 0 {
+		t := time.NewTimer(fw.FlushInterval)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for len(batch) < fw.BatchSize {
+		if timeout == nil {
+			select {
+			case r, ok := <-fw.queue:
+				if !ok {
+					return batch, false
+				}
+				batch = append(batch, r)
+			default:
+				return batch, true
+			}
+			continue
+		}
+		select {
+		case r, ok := <-fw.queue:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, r)
+		case <-timeout:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// deliver writes batch, retrying with backoff until it succeeds, fails
+// permanently or the forwarder is stopped.
+func (fw *forwarder) deliver(batch []Record) {
+	delay := minRetryDelay
+	for {
+		err := fw.Sink.Write(fw.ctx, batch)
+		if err == nil {
+			if n := fw.dropped.Swap(0); n > 0 {
+				slog.Info("KMS audit: sink delivering again", "sink", fw.Sink.Name(), "dropped", n)
+			}
+			return
+		}
+		var perm *PermanentError
+		if errors.As(err, &perm) {
+			slog.Error("KMS audit: sink refused records, dropping them", "sink", fw.Sink.Name(), "first_seq", batch[0].Seq, "last_seq", batch[len(batch)-1].Seq, "err", err)
+			return
+		}
+		slog.Warn("KMS audit: sink write failed, retrying", "sink", fw.Sink.Name(), "err", err, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-fw.ctx.Done():
+			slog.Error("KMS audit: sink stopped with records undelivered", "sink", fw.Sink.Name(), "first_seq", batch[0].Seq, "last_seq", batch[len(batch)-1].Seq)
+			return
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// close stops accepting records and waits up to deadline for the queue to
+// drain, then abandons what is left and closes the sink.
+func (fw *forwarder) close(deadline time.Time) error {
+	close(fw.queue)
+	select {
+	case <-fw.done:
+	case <-time.After(time.Until(deadline)):
+		fw.cancel()
+		<-fw.done
+	}
+	fw.cancel()
+	return fw.Sink.Close()
+}
+
+// closeForwarders closes all forwarders concurrently with a shared deadline.
+func closeForwarders(fws []*forwarder) error {
+	deadline := time.Now().Add(drainTimeout)
+	errs := make([]error, len(fws))
+	var wg sync.WaitGroup
+	for i, fw := range fws {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fw.close(deadline)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,273 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyslogConfig configures a SyslogSink.
+type SyslogConfig struct {
+	// Addr is the collector's host:port.
+	Addr string
+	// TLS, if set, secures the connection (RFC 5425); nil means plain TCP.
+	TLS *tls.Config
+	// Facility is the syslog facility code, 1-23 (kern, 0, is reserved for
+	// the kernel); DefaultSyslogFacility if unset.
+	Facility int
+	// Hostname and AppName fill the HOSTNAME and APP-NAME fields; they
+	// default to the host name and "kms-server".
+	Hostname string
+	AppName  string
+	// DialTimeout and WriteTimeout bound connecting and sending a batch.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// DefaultSyslogFacility is authpriv (10), meant for security messages.
+const DefaultSyslogFacility = 10
+
+// Syslog severities used for successful and failed calls.
+const (
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// SyslogSink sends each record as an RFC 5424 message whose MSG is the
+// record's JSON, over TCP or TLS with octet-counting framing (RFC 6587). It
+// reconnects after a failure.
+type SyslogSink struct {
+	cfg  SyslogConfig
+	pid  string
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink returns a sink for cfg; it connects on the first Write.
+func NewSyslogSink(cfg SyslogConfig) *SyslogSink {
+	if cfg.Facility == 0 {
+		cfg.Facility = DefaultSyslogFacility
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "kms-server"
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 10 * time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	return &SyslogSink{cfg: cfg, pid: strconv.Itoa(os.Getpid())}
+}
+
+func (s *SyslogSink) Name() string {
+	if s.cfg.TLS != nil {
+		return "syslog tls://" + s.cfg.Addr
+	}
+	return "syslog tcp://" + s.cfg.Addr
+}
+
+func (s *SyslogSink) Write(ctx context.Context, records []Record) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		msg, err := s.format(r)
+		if err != nil {
+			return &PermanentError{err}
+		}
+		fmt.Fprintf(&buf, "%d ", len(msg))
+		buf.Write(msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := s.dial(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: s.cfg.DialTimeout}
+	if s.cfg.TLS == nil {
+		return d.DialContext(ctx, "tcp", s.cfg.Addr)
+	}
+	cfg := s.cfg.TLS.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(s.cfg.Addr)
+	}
+	return (&tls.Dialer{NetDialer: d, Config: cfg}).DialContext(ctx, "tcp", s.cfg.Addr)
+}
+
+// format returns r as an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG.
+func (s *SyslogSink) format(r Record) ([]byte, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	sev := severityInfo
+	if r.Outcome != OutcomeOK {
+		sev = severityWarning
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %s audit - ",
+		s.cfg.Facility*8+sev,
+		r.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(s.cfg.Hostname, 255),
+		syslogField(s.cfg.AppName, 48),
+		s.pid)
+	return append([]byte(header), body...), nil
+}
+
+// syslogField makes v a valid header field: printable ASCII without spaces,
+// at most limit characters, or "-" when empty.
+func syslogField(v string, limit int) string {
+	b := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(b) < limit; i++ {
+		if c := v[i]; c > ' ' && c < 127 {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// FileSink appends records as JSON lines to a file, e.g. one watched by a
+// log shipper. Unlike the audit files it is a single file that is not
+// rotated; rotate it with a tool that copies and truncates.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// NewFileSink opens path for appending, creating it with mode 0600.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return &FileSink{path: path, f: f}, nil
+}
+
+func (s *FileSink) Name() string { return "file " + s.path }
+
+func (s *FileSink) Write(ctx context.Context, records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return &PermanentError{err}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.f.Write(buf.Bytes())
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return closeFile(s.f)
+}
+
+// WebhookConfig configures a WebhookSink.
+type WebhookConfig struct {
+	URL string
+	// Token, if set, is sent as "Authorization: Bearer <token>".
+	Token string
+	// TLS overrides the client TLS settings, e.g. to trust a private CA.
+	TLS *tls.Config
+	// Timeout bounds each request; 10 seconds if unset.
+	Timeout time.Duration
+}
+
+// WebhookSink POSTs each batch as a JSON array of records. Network errors,
+// 429 and 5xx responses are retried; other responses drop the batch.
+type WebhookSink struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink for cfg.
+func NewWebhookSink(cfg WebhookConfig) *WebhookSink {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS != nil {
+		transport.TLSClientConfig = cfg.TLS
+	}
+	return &WebhookSink{cfg: cfg, client: &http.Client{Transport: transport, Timeout: cfg.Timeout}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook " + s.cfg.URL }
+
+func (s *WebhookSink) Write(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return &PermanentError{err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return &PermanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return &PermanentError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+}
+
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
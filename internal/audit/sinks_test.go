@@ -0,0 +1,377 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kms/internal/audit"
+)
+
+// records is how many records each delivery test appends.
+const records = 25
+
+// appendRecords opens an audit log in dir with opts, appends n records and
+// closes it, which waits for the sinks to catch up. The files must verify.
+func appendRecords(t *testing.T, dir string, opts audit.Options, n int) {
+	t.Helper()
+	opts.Dir = dir
+	l, err := audit.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Append(testRecord(i)); err != nil {
+			l.Close()
+			t.Fatalf("append %d: %v", i+1, err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	verifyRecords(t, dir, n)
+}
+
+// testRecord returns the i'th record to append; every fifth is an error.
+func testRecord(i int) audit.Record {
+	r := audit.Record{Principal: "audit-test", Op: "KMS/Encrypt", Outcome: audit.OutcomeOK}
+	if i%5 == 4 {
+		r.Op, r.Outcome, r.Code, r.Reason = "KMS/Decrypt", audit.OutcomeError, "InvalidArgument", "INVALID_CIPHERTEXT"
+	}
+	return r
+}
+
+func verifyRecords(t *testing.T, dir string, n int) {
+	t.Helper()
+	rep, err := audit.Verify(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() {
+		t.Fatalf("audit files do not verify: %s", rep.Problems[0])
+	}
+	if rep.Records != n {
+		t.Fatalf("audit files hold %d records, want %d", rep.Records, n)
+	}
+}
+
+// checkSeqs checks that got holds each of the n records once, in order.
+func checkSeqs(t *testing.T, got []audit.Record, n int) {
+	t.Helper()
+	if len(got) != n {
+		t.Fatalf("sink received %d records, want %d", len(got), n)
+	}
+	for i, r := range got {
+		if r.Seq != uint64(i+1) || r.Hash == "" {
+			t.Fatalf("record %d has seq %d, want %d", i+1, r.Seq, i+1)
+		}
+	}
+}
+
+// A sinkSetup starts a stand-in collector and returns the Forward to it and
+// a function returning what it received, called after the log is closed.
+type sinkSetup func(t *testing.T, dir string) (audit.Forward, func() []audit.Record)
+
+func TestSinkDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup sinkSetup
+	}{
+		{name: "syslog", setup: syslogTCP},
+		{name: "syslog TLS", setup: syslogTLS},
+		{name: "JSON Lines file", setup: jsonlFile},
+		{name: "webhook", setup: webhook},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fwd, received := tc.setup(t, t.TempDir())
+			appendRecords(t, dir, audit.Options{Forward: []audit.Forward{fwd}}, records)
+			checkSeqs(t, received(), records)
+		})
+	}
+}
+
+func syslogTCP(t *testing.T, dir string) (audit.Forward, func() []audit.Record) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return syslogCollector(t, ln, nil)
+}
+
+func syslogTLS(t *testing.T, dir string) (audit.Forward, func() []audit.Record) {
+	cert, pool := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return syslogCollector(t, ln, &tls.Config{RootCAs: pool})
+}
+
+// syslogCollector accepts the sink's connection on ln and checks the RFC
+// 5424 header and JSON body of each message.
+func syslogCollector(t *testing.T, ln net.Listener, tlsCfg *tls.Config) (audit.Forward, func() []audit.Record) {
+	t.Cleanup(func() { ln.Close() })
+	msgs := make(chan string, records)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			msg, err := readFrame(br)
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+
+	sink := audit.NewSyslogSink(audit.SyslogConfig{Addr: ln.Addr().String(), TLS: tlsCfg, Hostname: "kms-test"})
+	return audit.Forward{Sink: sink}, func() []audit.Record {
+		var got []audit.Record
+		timeout := time.After(5 * time.Second)
+		for len(got) < records {
+			select {
+			case msg := <-msgs:
+				r, err := parseSyslog(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, r)
+			case <-timeout:
+				return got
+			}
+		}
+		return got
+	}
+}
+
+// readFrame reads one octet-counted syslog frame: "LEN MSG".
+func readFrame(br *bufio.Reader) (string, error) {
+	n, err := br.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(n, " "))
+	if err != nil || size <= 0 {
+		return "", fmt.Errorf("bad frame length %q", n)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// parseSyslog checks an RFC 5424 message from the sink and returns its record.
+func parseSyslog(msg string) (audit.Record, error) {
+	var r audit.Record
+	fields := strings.SplitN(msg, " ", 8)
+	if len(fields) != 8 {
+		return r, fmt.Errorf("not an RFC 5424 message: %q", msg)
+	}
+	if err := json.Unmarshal([]byte(fields[7]), &r); err != nil {
+		return r, fmt.Errorf("message body is not a record: %v", err)
+	}
+	want := fmt.Sprintf("<%d>1", audit.DefaultSyslogFacility*8+6)
+	if r.Outcome != audit.OutcomeOK {
+		want = fmt.Sprintf("<%d>1", audit.DefaultSyslogFacility*8+4)
+	}
+	if fields[0] != want {
+		return r, fmt.Errorf("seq %d has priority %s, want %s", r.Seq, fields[0], want)
+	}
+	if fields[2] != "kms-test" || fields[3] != "kms-server" || fields[5] != "audit" || fields[6] != "-" {
+		return r, fmt.Errorf("unexpected header %q", strings.Join(fields[:7], " "))
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+		return r, fmt.Errorf("bad timestamp: %v", err)
+	}
+	return r, nil
+}
+
+func jsonlFile(t *testing.T, dir string) (audit.Forward, func() []audit.Record) {
+	path := filepath.Join(dir, "forward.jsonl")
+	sink, err := audit.NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return audit.Forward{Sink: sink}, func() []audit.Record {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var got []audit.Record
+		dec := json.NewDecoder(f)
+		for {
+			var r audit.Record
+			if err := dec.Decode(&r); errors.Is(err, io.EOF) {
+				return got
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r)
+		}
+	}
+}
+
+// webhook delivers batches to a webhook that answers 503 to its first two
+// requests, which the sink must retry.
+func webhook(t *testing.T, dir string) (audit.Forward, func() []audit.Record) {
+	const token = "audit-test-token"
+	var (
+		mu       sync.Mutex
+		got      []audit.Record
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			t.Error("webhook request without the bearer token")
+		}
+		if requests <= 2 {
+			http.Error(w, "not yet", http.StatusServiceUnavailable)
+			return
+		}
+		var batch []audit.Record
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, batch...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	sink := audit.NewWebhookSink(audit.WebhookConfig{URL: srv.URL, Token: token})
+	fwd := audit.Forward{Sink: sink, BatchSize: 10, FlushInterval: 100 * time.Millisecond}
+	return fwd, func() []audit.Record {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests < 3 {
+			t.Errorf("webhook received %d requests, want at least 3", requests)
+		}
+		return got
+	}
+}
+
+// downSink fails every write, like a collector that cannot be reached.
+type downSink struct{}
+
+func (downSink) Name() string { return "down" }
+
+func (downSink) Write(ctx context.Context, records []audit.Record) error {
+	return errors.New("connection refused")
+}
+
+func (downSink) Close() error { return nil }
+
+// A sink that is down never stops records from being written to the audit
+// files. Fail-open drops them from the sink; fail-closed refuses further
+// calls once the sink's buffer is full.
+func TestSinkDown(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		failClosed bool
+	}{
+		{name: "fail open"},
+		{name: "fail closed", failClosed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Close waits for the sink to give up, which takes seconds.
+			t.Parallel()
+			dir := t.TempDir()
+			l, err := audit.Open(audit.Options{
+				Dir:        dir,
+				Forward:    []audit.Forward{{Sink: downSink{}}},
+				Buffer:     2,
+				FailClosed: tc.failClosed,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			appended := 0
+			for ; appended < records; appended++ {
+				err = l.Append(testRecord(appended))
+				if err != nil {
+					appended++ // written to the files, refused by the sink
+					break
+				}
+			}
+			if !tc.failClosed {
+				if err != nil {
+					t.Fatalf("Append with a full buffer: %v", err)
+				}
+				if err := l.Check(); err != nil {
+					t.Fatalf("Check with a full buffer: %v", err)
+				}
+			} else {
+				if !errors.Is(err, audit.ErrUnavailable) {
+					t.Fatalf("Append with a full buffer returned %v, want ErrUnavailable", err)
+				}
+				if err := l.Check(); !errors.Is(err, audit.ErrUnavailable) {
+					t.Fatalf("Check with a full buffer returned %v, want ErrUnavailable", err)
+				}
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			verifyRecords(t, dir, appended)
+		})
+	}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "audit-test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
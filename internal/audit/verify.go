@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Report is the result of Verify.
+type Report struct {
+	Files   int
+	Records int
+	// LastSeq and Head identify the last record; compare them with a copy
+	// kept elsewhere (see Log.Head) to detect records cut from the end.
+	LastSeq uint64
+	Head    string
+	// Problems are signs of tampering or loss: the chain is not intact.
+	Problems []Finding
+	// Warnings do not break the chain, such as a record left incomplete at
+	// the end of a file by a crash.
+	Warnings []Finding
+}
+
+// Finding locates a problem; Line is 0 for a whole file.
+type Finding struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (f Finding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s", f.File, f.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", f.File, f.Line, f.Msg)
+}
+
+// OK reports whether no problems were found.
+func (r *Report) OK() bool { return len(r.Problems) == 0 }
+
+// Verify checks the audit files in dir: every record must parse, hash to its
+// Hash, name the previous record's hash as Prev and follow its sequence
+// number, starting from the first record ever written. With hmacKey, every
+// record must also carry a valid MAC. An error is returned only when dir
+// cannot be read.
+func Verify(dir string, hmacKey []byte) (*Report, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{Files: len(files)}
+	if len(files) == 0 {
+		rep.Warnings = append(rep.Warnings, Finding{File: dir, Msg: "no audit files"})
+		return rep, nil
+	}
+
+	var (
+		seq       uint64
+		head      string
+		macsSeen  bool
+		firstSeen bool
+	)
+	for _, path := range files {
+		name := filepath.Base(path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			rep.Problems = append(rep.Problems, Finding{File: name, Msg: err.Error()})
+			continue
+		}
+		problem := func(line int, format string, args ...any) {
+			rep.Problems = append(rep.Problems, Finding{name, line, fmt.Sprintf(format, args...)})
+		}
+
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(nil, 1<<20)
+		lineNo, inFile := 0, 0
+		for sc.Scan() {
+			lineNo++
+			var r Record
+			if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+				if lineNo == bytes.Count(data, []byte("\n"))+1 && !bytes.HasSuffix(data, []byte("\n")) {
+					rep.Warnings = append(rep.Warnings, Finding{name, lineNo, "incomplete last record (interrupted write)"})
+				} else {
+					problem(lineNo, "not a record: %v", err)
+				}
+				continue
+			}
+			if inFile == 0 {
+				if first, _ := fileSeq(name); first != r.Seq {
+					problem(lineNo, "file is named for seq %d but starts at seq %d", first, r.Seq)
+				}
+			}
+			inFile++
+			rep.Records++
+
+			switch {
+			case !firstSeen && (r.Seq != 1 || r.Prev != ""):
+				problem(lineNo, "chain starts at seq %d, not 1: earlier records are missing", r.Seq)
+			case firstSeen && r.Seq != seq+1:
+				problem(lineNo, "seq %d follows seq %d: %s", r.Seq, seq, gapKind(seq, r.Seq))
+			case firstSeen && r.Prev != head:
+				problem(lineNo, "seq %d does not chain to the record before it", r.Seq)
+			}
+			firstSeen = true
+
+			if want, err := recordHash(r); err != nil || want != r.Hash {
+				problem(lineNo, "seq %d has been modified (hash mismatch)", r.Seq)
+			}
+			switch {
+			case len(hmacKey) > 0 && r.MAC == "":
+				problem(lineNo, "seq %d has no MAC", r.Seq)
+			case len(hmacKey) > 0 && !hmac.Equal([]byte(r.MAC), []byte(recordMAC(hmacKey, r.Hash))):
+				problem(lineNo, "seq %d has an invalid MAC", r.Seq)
+			}
+			macsSeen = macsSeen || r.MAC != ""
+			seq, head = r.Seq, r.Hash
+		}
+		if err := sc.Err(); err != nil {
+			problem(0, "read: %v", err)
+		}
+	}
+	if macsSeen && len(hmacKey) == 0 {
+		rep.Warnings = append(rep.Warnings, Finding{File: dir, Msg: "records are signed but no HMAC key was given: MACs not checked"})
+	}
+	rep.LastSeq, rep.Head = seq, head
+	return rep, nil
+}
+
+func gapKind(prev, next uint64) string {
+	switch {
+	case next == prev+2:
+		return "1 record missing"
+	case next > prev+1:
+		return fmt.Sprintf("%d records missing", next-prev-1)
+	}
+	return "records reordered or duplicated"
+}
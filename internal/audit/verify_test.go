@@ -0,0 +1,255 @@
+package audit_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kms/internal/audit"
+)
+
+var testHMACKey = []byte("audit-test-hmac-key-0123456789ab")
+
+// writeChain appends n records in two sessions to a new log in a temporary
+// directory, with files small enough that the chain spans several of them.
+// It returns the directory and the head reported by the log.
+func writeChain(t *testing.T, n int) (string, uint64, string) {
+	t.Helper()
+	dir := t.TempDir()
+	var (
+		seq  uint64
+		head string
+	)
+	for _, count := range []int{n / 2, n - n/2} {
+		l, err := audit.Open(audit.Options{Dir: dir, HMACKey: testHMACKey, MaxFileBytes: 2000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			if err := l.Append(testRecord(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		seq, head = l.Head()
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, seq, head
+}
+
+// auditFiles returns the audit files in dir in chain order.
+func auditFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 3 {
+		t.Fatalf("chain spans %d files, want at least 3", len(files))
+	}
+	return files
+}
+
+// editLines rewrites the lines of an audit file with edit.
+func editLines(t *testing.T, path string, edit func(lines []string) []string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	lines = edit(lines)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	dir, seq, head := writeChain(t, records)
+	auditFiles(t, dir)
+
+	rep, err := audit.Verify(dir, testHMACKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || len(rep.Warnings) != 0 {
+		t.Fatalf("report %+v, want a clean chain", rep)
+	}
+	if rep.Records != records || rep.LastSeq != seq || rep.Head != head {
+		t.Errorf("report ends at %d records, seq %d, head %s; log wrote %d, seq %d, head %s",
+			rep.Records, rep.LastSeq, rep.Head, records, seq, head)
+	}
+
+	// Without the key the chain still verifies, but the MACs go unchecked.
+	rep, err = audit.Verify(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || len(rep.Warnings) != 1 || !strings.Contains(rep.Warnings[0].Msg, "MACs not checked") {
+		t.Errorf("report without key %+v, want one warning about unchecked MACs", rep)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tamper  func(t *testing.T, files []string)
+		key     []byte // default testHMACKey
+		problem string // substring of a reported problem; empty for none
+		warning string // substring of a reported warning
+	}{
+		{
+			name: "modified record",
+			tamper: func(t *testing.T, files []string) {
+				editLines(t, files[0], func(lines []string) []string {
+					lines[1] = strings.Replace(lines[1], `"principal":"audit-test"`, `"principal":"someone-else"`, 1)
+					return lines
+				})
+			},
+			problem: "seq 2 has been modified",
+		},
+		{
+			name: "deleted record",
+			tamper: func(t *testing.T, files []string) {
+				editLines(t, files[0], func(lines []string) []string {
+					return append(lines[:1], lines[2:]...)
+				})
+			},
+			problem: "seq 3 follows seq 1: 1 record missing",
+		},
+		{
+			name: "reordered records",
+			tamper: func(t *testing.T, files []string) {
+				editLines(t, files[0], func(lines []string) []string {
+					lines[1], lines[2] = lines[2], lines[1]
+					return lines
+				})
+			},
+			problem: "records reordered or duplicated",
+		},
+		{
+			name: "deleted file",
+			tamper: func(t *testing.T, files []string) {
+				if err := os.Remove(files[1]); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "records missing",
+		},
+		{
+			name: "first file deleted",
+			tamper: func(t *testing.T, files []string) {
+				if err := os.Remove(files[0]); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "earlier records are missing",
+		},
+		{
+			name: "renamed file",
+			tamper: func(t *testing.T, files []string) {
+				if err := os.Rename(files[1], filepath.Join(filepath.Dir(files[1]), "audit-000000999999.jsonl")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problem: "file is named for seq 999999",
+		},
+		{
+			name: "MAC removed",
+			tamper: func(t *testing.T, files []string) {
+				editLines(t, files[0], func(lines []string) []string {
+					i := strings.Index(lines[0], `,"mac":`)
+					lines[0] = lines[0][:i] + "}"
+					return lines
+				})
+			},
+			problem: "seq 1 has no MAC",
+		},
+		{
+			name:    "wrong HMAC key",
+			tamper:  func(t *testing.T, files []string) {},
+			key:     []byte("another-hmac-key"),
+			problem: "has an invalid MAC",
+		},
+		{
+			name: "not a record",
+			tamper: func(t *testing.T, files []string) {
+				editLines(t, files[0], func(lines []string) []string {
+					lines[0] = "garbage"
+					return lines
+				})
+			},
+			problem: "not a record",
+		},
+		{
+			// A crash mid-write leaves a partial line; the chain is intact.
+			name: "incomplete last record",
+			tamper: func(t *testing.T, files []string) {
+				last := files[len(files)-1]
+				data, err := os.ReadFile(last)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = append(data, bytes.TrimSuffix(data, []byte("\n"))[:40]...)
+				if err := os.WriteFile(last, data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			warning: "incomplete last record",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _, _ := writeChain(t, records)
+			tc.tamper(t, auditFiles(t, dir))
+			key := tc.key
+			if key == nil {
+				key = testHMACKey
+			}
+			rep, err := audit.Verify(dir, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.problem == "" && !rep.OK() {
+				t.Errorf("problems %v, want none", rep.Problems)
+			}
+			if tc.problem != "" && !hasFinding(rep.Problems, tc.problem) {
+				t.Errorf("problems %v, want one mentioning %q", rep.Problems, tc.problem)
+			}
+			if tc.warning != "" && !hasFinding(rep.Warnings, tc.warning) {
+				t.Errorf("warnings %v, want one mentioning %q", rep.Warnings, tc.warning)
+			}
+		})
+	}
+}
+
+func hasFinding(findings []audit.Finding, msg string) bool {
+	for _, f := range findings {
+		if strings.Contains(f.Msg, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records cut from the end leave a valid chain; only a head kept elsewhere
+// shows they are gone.
+func TestVerifyTruncatedEnd(t *testing.T) {
+	dir, seq, head := writeChain(t, records)
+	files := auditFiles(t, dir)
+	editLines(t, files[len(files)-1], func(lines []string) []string {
+		return lines[:len(lines)-1]
+	})
+	rep, err := audit.Verify(dir, testHMACKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() {
+		t.Fatalf("problems %v, want a valid shorter chain", rep.Problems)
+	}
+	if rep.LastSeq != seq-1 || rep.Head == head {
+		t.Errorf("report ends at seq %d, head %s; want seq %d and a head other than the log's", rep.LastSeq, rep.Head, seq-1)
+	}
+}
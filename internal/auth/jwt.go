@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"kms/internal/tenant"
+
 	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -13,18 +16,101 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// JWTConfig drives how we validate incoming bearer tokens.
+// JWTConfig drives how we validate incoming bearer tokens and, when CertAuth
+// is set, client certificates.
 type JWTConfig struct {
 	Secret   string // HMAC secret (HS256)
 	Audience string // optional
 	Issuer   string // optional
+
+	// CertAuth accepts a client certificate verified by the TLS layer (mTLS)
+	// in place of a bearer token; the principal is taken from the
+	// certificate, see CertPrincipal. A bearer token, when sent, is still
+	// validated and takes precedence.
+	CertAuth bool
+
+	// AllowedPrincipals, if set, limits access to these JWT subjects and
+	// certificate principals. A trailing "*" matches by prefix.
+	AllowedPrincipals []string
+
+	// AdminPrincipals are the only principals allowed to call the KMSAdmin
+	// service, matched like AllowedPrincipals. Empty, or auth disabled,
+	// means nobody may.
+	AdminPrincipals []string
+
+	// InspectPrincipals may call DescribeCiphertextMethod and nothing else,
+	// matched like AllowedPrincipals; administrators and the callers allowed
+	// by AllowedPrincipals may call it too.
+	InspectPrincipals []string
+
+	// Tenants, if set, puts every caller of the KMS service in a tenant:
+	// the one named by the token's "tenant" claim, which must list the
+	// caller among its principals, or else the tenant whose principals
+	// match. Callers in no tenant are refused; KMSAdmin is not scoped.
+	Tenants tenant.Set
+}
+
+// tokenClaims are the claims of the tokens we issue and accept.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// healthServicePrefix matches the grpc.health.v1.Health methods, which are
+// always open.
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// AdminServicePrefix matches the KMSAdmin methods.
+const AdminServicePrefix = "/kms.KMSAdmin/"
+
+// DescribeCiphertextMethod reads ciphertext headers only, so it is open to
+// the InspectPrincipals as well.
+const DescribeCiphertextMethod = "/kms.KMS/DescribeCiphertext"
+
+// Authenticator runs the auth interceptors from a JWTConfig that can be
+// replaced while the server runs, e.g. to rotate the secret on a config
+// reload. Each call is checked against the config current when it arrives.
+type Authenticator struct {
+	cfg atomic.Pointer[JWTConfig]
+}
+
+// NewAuthenticator returns an Authenticator using cfg.
+func NewAuthenticator(cfg JWTConfig) *Authenticator {
+	a := &Authenticator{}
+	a.SetConfig(cfg)
+	return a
+}
+
+// Config returns the config in use.
+func (a *Authenticator) Config() JWTConfig { return *a.cfg.Load() }
+
+// SetConfig replaces the config for calls that arrive from now on. Open
+// streams keep the principal they were authenticated with.
+func (a *Authenticator) SetConfig(cfg JWTConfig) { a.cfg.Store(&cfg) }
+
+// enabled reports whether any authentication is configured.
+func (cfg JWTConfig) enabled() bool {
+	return cfg.Secret != "" || cfg.CertAuth
 }
 
-// UnaryServerInterceptor validates Authorization: Bearer <token> if Secret is set.
-// If Secret is empty, the interceptor is a no-op (open).
+// UnaryServerInterceptor validates Authorization: Bearer <token> if Secret is set,
+// or the client certificate if CertAuth is set, and puts the caller in the
+// context (see PrincipalFromContext).
+// If neither is configured, the interceptor is a no-op (open), except that
+// KMSAdmin is refused.
 func UnaryServerInterceptor(cfg JWTConfig) grpc.UnaryServerInterceptor {
+	return NewAuthenticator(cfg).UnaryServerInterceptor()
+}
+
+// UnaryServerInterceptor is like the package-level function, reading the
+// current config on each call.
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if cfg.Secret == "" {
+		cfg := a.Config()
+		if !cfg.enabled() {
+			if strings.HasPrefix(info.FullMethod, AdminServicePrefix) {
+				return nil, errAdminWithoutAuth
+			}
 			// Auth disabled.
 			return handler(ctx, req)
 		}
@@ -34,72 +120,186 @@ func UnaryServerInterceptor(cfg JWTConfig) grpc.UnaryServerInterceptor {
 			return handler(ctx, req)
 		}
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.Unauthenticated, "missing metadata")
+		// Health checks come from orchestrators and load balancers without credentials.
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(ctx, req)
 		}
 
-		authHeader := ""
-		if vals := md.Get("authorization"); len(vals) > 0 {
-			authHeader = vals[0]
+		p, err := authenticate(ctx, cfg, info.FullMethod)
+		if err != nil {
+			return nil, err
 		}
-		if authHeader == "" {
-			return nil, status.Error(codes.Unauthenticated, "missing authorization header")
+		return handler(WithPrincipal(ctx, p), req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+// The token is validated once, when the stream opens; a token that expires
+// while the stream is open does not end it.
+func StreamServerInterceptor(cfg JWTConfig) grpc.StreamServerInterceptor {
+	return NewAuthenticator(cfg).StreamServerInterceptor()
+}
+
+// StreamServerInterceptor is like the package-level function, reading the
+// current config when each stream opens.
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		cfg := a.Config()
+		if !cfg.enabled() {
+			if strings.HasPrefix(info.FullMethod, AdminServicePrefix) {
+				return errAdminWithoutAuth
+			}
+			// Auth disabled.
+			return handler(srv, ss)
 		}
-		if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
-			return nil, status.Error(codes.Unauthenticated, "invalid authorization scheme")
+
+		// Server reflection is a stream too; keep it open as before so
+		// grpcurl can list services without a token. Likewise Health/Watch.
+		if strings.HasPrefix(info.FullMethod, "/grpc.reflection.") || strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(srv, ss)
 		}
-		raw := strings.TrimSpace(authHeader[7:])
-		if raw == "" {
-			return nil, status.Error(codes.Unauthenticated, "empty bearer token")
+
+		p, err := authenticate(ss.Context(), cfg, info.FullMethod)
+		if err != nil {
+			return err
 		}
+		return handler(srv, &principalStream{ServerStream: ss, ctx: WithPrincipal(ss.Context(), p)})
+	}
+}
 
-		claims := jwt.RegisteredClaims{}
-		token, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
-			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-				return nil, errors.New("unexpected signing method")
+var errAdminWithoutAuth = status.Error(codes.PermissionDenied, "the admin service requires authentication to be enabled")
+
+// authenticate identifies the caller from the bearer token in the incoming
+// metadata of ctx or, failing that and if enabled, from the client
+// certificate, and checks it against AllowedPrincipals, or AdminPrincipals
+// for a KMSAdmin method.
+func authenticate(ctx context.Context, cfg JWTConfig, method string) (Principal, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok && !cfg.CertAuth {
+		return Principal{}, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+
+	authHeader := ""
+	if vals := md.Get("authorization"); len(vals) > 0 {
+		authHeader = vals[0]
+	}
+	if authHeader == "" {
+		if cfg.CertAuth {
+			name, ok := peerCertPrincipal(ctx)
+			if !ok {
+				return Principal{}, status.Error(codes.Unauthenticated, "missing authorization header or client certificate")
 			}
-			return []byte(cfg.Secret), nil
-		})
-		if err != nil || !token.Valid {
-			return nil, status.Error(codes.Unauthenticated, "invalid token")
+			return authorize(Principal{Name: name, Method: "mtls"}, cfg, method)
 		}
+		return Principal{}, status.Error(codes.Unauthenticated, "missing authorization header")
+	}
+	if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
+		return Principal{}, status.Error(codes.Unauthenticated, "invalid authorization scheme")
+	}
+	raw := strings.TrimSpace(authHeader[7:])
+	if raw == "" {
+		return Principal{}, status.Error(codes.Unauthenticated, "empty bearer token")
+	}
+	if cfg.Secret == "" {
+		return Principal{}, status.Error(codes.Unauthenticated, "bearer tokens are not accepted; use a client certificate")
+	}
 
-		now := time.Now()
-		if claims.ExpiresAt != nil && now.After(claims.ExpiresAt.Time) {
-			return nil, status.Error(codes.Unauthenticated, "token expired")
+	claims := tokenClaims{}
+	token, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("unexpected signing method")
 		}
-		if claims.NotBefore != nil && now.Before(claims.NotBefore.Time) {
-			return nil, status.Error(codes.Unauthenticated, "token not yet valid")
+		return []byte(cfg.Secret), nil
+	})
+	if err != nil || !token.Valid {
+		return Principal{}, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt != nil && now.After(claims.ExpiresAt.Time) {
+		return Principal{}, status.Error(codes.Unauthenticated, "token expired")
+	}
+	if claims.NotBefore != nil && now.Before(claims.NotBefore.Time) {
+		return Principal{}, status.Error(codes.Unauthenticated, "token not yet valid")
+	}
+	if cfg.Audience != "" {
+		if len(claims.Audience) == 0 || claims.Audience[0] != cfg.Audience {
+			return Principal{}, status.Error(codes.Unauthenticated, "invalid audience")
 		}
-		if cfg.Audience != "" {
-			if len(claims.Audience) == 0 || claims.Audience[0] != cfg.Audience {
-				return nil, status.Error(codes.Unauthenticated, "invalid audience")
-			}
+	}
+	if cfg.Issuer != "" {
+		if claims.Issuer != cfg.Issuer {
+			return Principal{}, status.Error(codes.Unauthenticated, "invalid issuer")
 		}
-		if cfg.Issuer != "" {
-			if claims.Issuer != cfg.Issuer {
-				return nil, status.Error(codes.Unauthenticated, "invalid issuer")
-			}
+	}
+
+	return authorize(Principal{Name: claims.Subject, Method: "jwt", Tenant: claims.Tenant}, cfg, method)
+}
+
+// authorize applies AllowedPrincipals, or AdminPrincipals for a KMSAdmin
+// method, to an authenticated caller; DescribeCiphertextMethod also admits
+// the InspectPrincipals and administrators, tenant or not, and settles its tenant. p.Tenant is the
+// token's tenant claim, if any.
+func authorize(p Principal, cfg JWTConfig, method string) (Principal, error) {
+	p, err := assignTenant(p, cfg)
+	if err != nil {
+		return Principal{}, err
+	}
+	if strings.HasPrefix(method, AdminServicePrefix) {
+		if len(cfg.AdminPrincipals) == 0 || !principalAllowed(p.Name, cfg.AdminPrincipals) {
+			return Principal{}, status.Errorf(codes.PermissionDenied, "principal %q is not an administrator", p.Name)
 		}
+		return p, nil
+	}
+	if method == DescribeCiphertextMethod && (len(cfg.InspectPrincipals) > 0 && principalAllowed(p.Name, cfg.InspectPrincipals) ||
+		len(cfg.AdminPrincipals) > 0 && principalAllowed(p.Name, cfg.AdminPrincipals)) {
+		return p, nil
+	}
+	if !principalAllowed(p.Name, cfg.AllowedPrincipals) {
+		return Principal{}, status.Errorf(codes.PermissionDenied, "principal %q is not allowed", p.Name)
+	}
+	if len(cfg.Tenants) > 0 && p.Tenant == "" {
+		return Principal{}, status.Errorf(codes.PermissionDenied, "principal %q belongs to no tenant", p.Name)
+	}
+	return p, nil
+}
 
-		return handler(ctx, req)
+// assignTenant checks the tenant claimed in p.Tenant, or looks the tenant up
+// by principal. Without configured tenants the claim is ignored.
+func assignTenant(p Principal, cfg JWTConfig) (Principal, error) {
+	if len(cfg.Tenants) == 0 {
+		p.Tenant = ""
+		return p, nil
 	}
+	if p.Tenant == "" {
+		p.Tenant, _ = cfg.Tenants.Resolve(p.Name)
+		return p, nil
+	}
+	t, ok := cfg.Tenants[p.Tenant]
+	if !ok {
+		return Principal{}, status.Errorf(codes.PermissionDenied, "unknown tenant %q", p.Tenant)
+	}
+	if !t.Has(p.Name) {
+		return Principal{}, status.Errorf(codes.PermissionDenied, "principal %q is not a member of tenant %q", p.Name, p.Tenant)
+	}
+	return p, nil
 }
 
 // IssueToken creates a signed JWT string for the given subject (e.g. username).
-// This uses HS256 and the same cfg that the interceptor validates with.
+// This uses HS256 and the same cfg that the interceptor validates with. With
+// tenants configured, the subject's tenant goes in the tenant claim.
 func IssueToken(cfg JWTConfig, subject string, ttl time.Duration) (string, error) {
 	if cfg.Secret == "" {
 		return "", errors.New("JWT secret not configured")
 	}
 
 	now := time.Now()
-	claims := jwt.RegisteredClaims{
+	claims := tokenClaims{RegisteredClaims: jwt.RegisteredClaims{
 		Subject:   subject,
 		IssuedAt:  jwt.NewNumericDate(now),
 		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
-	}
+	}}
+	claims.Tenant, _ = cfg.Tenants.Resolve(subject)
 	if cfg.Audience != "" {
 		claims.Audience = []string{cfg.Audience}
 	}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Principal is the authenticated caller of an RPC.
+type Principal struct {
+	// Name is the JWT subject, or the identity taken from a client
+	// certificate (see CertPrincipal).
+	Name string
+	// Method is how the caller authenticated: "jwt" or "mtls".
+	Method string
+	// Tenant is the tenant the caller acts for, when tenants are
+	// configured: the token's tenant claim, else the tenant whose principals
+	// match Name.
+	Tenant string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller set by the auth interceptors. ok is
+// false when auth is disabled or the method is exempt.
+func PrincipalFromContext(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// CertPrincipal derives a principal name from a client certificate: its
+// SPIFFE ID (a spiffe:// URI SAN) if it has one, else its first DNS SAN, else
+// its first email SAN, else its subject common name.
+func CertPrincipal(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// peerCertPrincipal returns the principal of the verified client certificate
+// on the connection, if any. Certificates the TLS layer did not verify
+// against the client CA are ignored.
+func peerCertPrincipal(ctx context.Context) (string, bool) {
+	pr, ok := peer.FromContext(ctx)
+	if !ok || pr.AuthInfo == nil {
+		return "", false
+	}
+	info, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := CertPrincipal(info.State.VerifiedChains[0][0])
+	return name, name != ""
+}
+
+// principalAllowed reports whether name matches one of the patterns. A
+// pattern ending in "*" matches by prefix, e.g. "spiffe://corp.example/etl/*".
+// An empty list allows every authenticated principal.
+func principalAllowed(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// principalStream overrides the context of a server stream so handlers see
+// the authenticated principal.
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context { return s.ctx }
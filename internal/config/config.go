@@ -0,0 +1,849 @@
+// Package config loads the kms-server configuration: an optional YAML file
+// with environment variable overrides, validated strictly before use.
+//
+// Every setting has a file key and, for compatibility with the env-only
+// deployments, the environment variable it replaces (see the env tags). A set
+// variable wins over the file. Unknown file keys and values that do not parse
+// are errors, never silent fallbacks.
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"kms/internal/audit"
+	"kms/internal/auth"
+	"kms/internal/idempotency"
+	"kms/internal/logging"
+	"kms/internal/policy"
+	"kms/internal/ratelimit"
+	"kms/internal/tenant"
+	"kms/internal/tlsconfig"
+	"kms/internal/tracing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete kms-server configuration.
+type Config struct {
+	Server      Server      `yaml:"server"`
+	Key         Key         `yaml:"key"`
+	Auth        Auth        `yaml:"auth"`
+	TLS         TLS         `yaml:"tls"`
+	Limits      Limits      `yaml:"limits"`
+	RateLimit   RateLimit   `yaml:"rateLimit"`
+	Tenants     Tenants     `yaml:"tenants"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Audit       Audit       `yaml:"audit"`
+	Metrics     Metrics     `yaml:"metrics"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Logging     Logging     `yaml:"logging"`
+
+	// fromEnv records the settings overridden by an environment variable,
+	// by file key, with the variable's name.
+	fromEnv map[string]string
+}
+
+// Server holds the listener and lifecycle settings.
+type Server struct {
+	Addr                string        `yaml:"addr" env:"KMS_GRPC_ADDR"`
+	ShutdownDrainDelay  time.Duration `yaml:"shutdownDrainDelay" env:"KMS_SHUTDOWN_DRAIN_DELAY"`
+	ShutdownTimeout     time.Duration `yaml:"shutdownTimeout" env:"KMS_SHUTDOWN_TIMEOUT"`
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval" env:"KMS_HEALTH_CHECK_INTERVAL"`
+	HealthCheckTimeout  time.Duration `yaml:"healthCheckTimeout" env:"KMS_HEALTH_CHECK_TIMEOUT"`
+}
+
+// Key selects the key backend. Only the section of the selected backend (or
+// of the failover members) is used.
+type Key struct {
+	// Backend is file, pkcs11, aws, azure, vault, gcp or failover.
+	Backend string `yaml:"backend" env:"KMS_HSM_TYPE"`
+	// KeyID is the key ID passed to a PKCS#11 provider.
+	KeyID string `yaml:"keyID" env:"KMS_KEY_ID"`
+	// Store is the key store file holding the named keys managed through
+	// KMSAdmin, wrapped by the backend. Empty disables named keys.
+	Store string `yaml:"store" env:"KMS_KEY_STORE"`
+	// CreateDEK lets the azure, vault and gcp backends create their wrapped
+	// DEK when its dekPath does not exist. Set it for the first start only;
+	// otherwise a missing DEK file fails startup.
+	CreateDEK bool `yaml:"createDEK" env:"KMS_CREATE_DEK"`
+
+	File     FileKey  `yaml:"file"`
+	PKCS11   PKCS11   `yaml:"pkcs11"`
+	AWS      AWS      `yaml:"aws"`
+	Azure    Azure    `yaml:"azure"`
+	Vault    Vault    `yaml:"vault"`
+	GCP      GCP      `yaml:"gcp"`
+	Failover Failover `yaml:"failover"`
+}
+
+type FileKey struct {
+	Path string `yaml:"path" env:"KMS_MASTER_KEY_PATH"`
+}
+
+type PKCS11 struct {
+	Lib      string `yaml:"lib" env:"KMS_PKCS11_LIB"`
+	Slot     uint   `yaml:"slot" env:"KMS_PKCS11_SLOT"`
+	PIN      string `yaml:"pin" env:"KMS_PKCS11_PIN" secret:"true"`
+	KeyLabel string `yaml:"keyLabel" env:"KMS_PKCS11_KEY_LABEL"`
+}
+
+type AWS struct {
+	KeyID  string `yaml:"keyID" env:"KMS_AWS_KEY_ID"`
+	Region string `yaml:"region" env:"KMS_AWS_REGION"`
+}
+
+type Azure struct {
+	VaultURL string `yaml:"vaultURL" env:"KMS_AZURE_VAULT_URL"`
+	KeyName  string `yaml:"keyName" env:"KMS_AZURE_KEY_NAME"`
+	DEKPath  string `yaml:"dekPath" env:"KMS_AZURE_DEK_PATH"`
+}
+
+type Vault struct {
+	Addr          string `yaml:"addr" env:"KMS_VAULT_ADDR,VAULT_ADDR"`
+	Namespace     string `yaml:"namespace" env:"KMS_VAULT_NAMESPACE,VAULT_NAMESPACE"`
+	TransitMount  string `yaml:"transitMount" env:"KMS_VAULT_TRANSIT_MOUNT"`
+	KeyName       string `yaml:"keyName" env:"KMS_VAULT_KEY_NAME"`
+	DEKPath       string `yaml:"dekPath" env:"KMS_VAULT_DEK_PATH"`
+	Token         string `yaml:"token" env:"KMS_VAULT_TOKEN,VAULT_TOKEN" secret:"true"`
+	RoleID        string `yaml:"roleID" env:"KMS_VAULT_ROLE_ID"`
+	SecretID      string `yaml:"secretID" env:"KMS_VAULT_SECRET_ID" secret:"true"`
+	AppRoleMount  string `yaml:"appRoleMount" env:"KMS_VAULT_APPROLE_MOUNT"`
+	CACert        string `yaml:"caCert" env:"KMS_VAULT_CACERT,VAULT_CACERT"`
+	ClientCert    string `yaml:"clientCert" env:"KMS_VAULT_CLIENT_CERT"`
+	ClientKey     string `yaml:"clientKey" env:"KMS_VAULT_CLIENT_KEY"`
+	TLSServerName string `yaml:"tlsServerName" env:"KMS_VAULT_TLS_SERVER_NAME"`
+	SkipVerify    bool   `yaml:"skipVerify" env:"KMS_VAULT_SKIP_VERIFY"`
+}
+
+type GCP struct {
+	KeyName         string `yaml:"keyName" env:"KMS_GCP_KEY_NAME"`
+	DEKPath         string `yaml:"dekPath" env:"KMS_GCP_DEK_PATH"`
+	AccessToken     string `yaml:"accessToken" env:"KMS_GCP_ACCESS_TOKEN" secret:"true"`
+	CredentialsFile string `yaml:"credentialsFile" env:"KMS_GCP_CREDENTIALS,GOOGLE_APPLICATION_CREDENTIALS"`
+	Endpoint        string `yaml:"endpoint" env:"KMS_GCP_ENDPOINT"`
+}
+
+// Failover lists the member backends in priority order as "type" or
+// "type:SUFFIX". Every member of a type uses that type's section; a
+// "type:SUFFIX" member reads NAME_SUFFIX environment variables first
+// (KMS_PKCS11_LIB_B), as with KMS_FAILOVER_BACKENDS.
+type Failover struct {
+	Backends         []string      `yaml:"backends" env:"KMS_FAILOVER_BACKENDS"`
+	FailureThreshold uint          `yaml:"failureThreshold" env:"KMS_FAILOVER_FAILURE_THRESHOLD"`
+	OpenTimeout      time.Duration `yaml:"openTimeout" env:"KMS_FAILOVER_OPEN_TIMEOUT"`
+	HealthInterval   time.Duration `yaml:"healthInterval" env:"KMS_FAILOVER_HEALTH_INTERVAL"`
+}
+
+type Auth struct {
+	JWTSecret         string   `yaml:"jwtSecret" env:"KMS_JWT_SECRET" secret:"true"`
+	JWTAudience       string   `yaml:"jwtAudience" env:"KMS_JWT_AUD"`
+	JWTIssuer         string   `yaml:"jwtIssuer" env:"KMS_JWT_ISS"`
+	ClientCert        bool     `yaml:"clientCert" env:"KMS_AUTH_CLIENT_CERT"`
+	AllowedPrincipals []string `yaml:"allowedPrincipals" env:"KMS_AUTH_ALLOWED_PRINCIPALS"`
+	AdminPrincipals   []string `yaml:"adminPrincipals" env:"KMS_AUTH_ADMIN_PRINCIPALS"`
+	InspectPrincipals []string `yaml:"inspectPrincipals" env:"KMS_AUTH_INSPECT_PRINCIPALS"`
+}
+
+type TLS struct {
+	CertFile       string        `yaml:"certFile" env:"KMS_TLS_CERT_FILE"`
+	KeyFile        string        `yaml:"keyFile" env:"KMS_TLS_KEY_FILE"`
+	ClientCAFile   string        `yaml:"clientCAFile" env:"KMS_TLS_CLIENT_CA_FILE"`
+	ClientAuth     string        `yaml:"clientAuth" env:"KMS_TLS_CLIENT_AUTH"`
+	ReloadInterval time.Duration `yaml:"reloadInterval" env:"KMS_TLS_RELOAD_INTERVAL"`
+}
+
+type Limits struct {
+	// MaxBatchItems lowers the 1000-item BatchEncrypt/BatchDecrypt limit.
+	MaxBatchItems int `yaml:"maxBatchItems" env:"KMS_MAX_BATCH_ITEMS"`
+	// MaxRecvMsgBytes is the largest request message the server accepts.
+	MaxRecvMsgBytes int `yaml:"maxRecvMsgBytes" env:"KMS_MAX_RECV_MSG_BYTES"`
+	// MaxConcurrentStreams limits concurrent RPCs per connection; 0 means
+	// no limit.
+	MaxConcurrentStreams uint `yaml:"maxConcurrentStreams" env:"KMS_MAX_CONCURRENT_STREAMS"`
+	// MaxPlaintextBytes and MaxCiphertextBytes bound each item of every key
+	// unless Keys overrides them; 0 for the ciphertext means the plaintext
+	// limit plus the ciphertext format's overhead.
+	MaxPlaintextBytes  int `yaml:"maxPlaintextBytes" env:"KMS_MAX_PLAINTEXT_BYTES"`
+	MaxCiphertextBytes int `yaml:"maxCiphertextBytes" env:"KMS_MAX_CIPHERTEXT_BYTES"`
+	// Keys sets limits and a content validator per key_id; "default" is the
+	// key backend's key, used by requests without a key_id.
+	Keys map[string]KeyLimits `yaml:"keys"`
+}
+
+// KeyLimits override Limits for one key; zero values inherit.
+type KeyLimits struct {
+	MaxPlaintextBytes  int `yaml:"maxPlaintextBytes"`
+	MaxCiphertextBytes int `yaml:"maxCiphertextBytes"`
+	// Validator is pan (12-19 digits, Luhn), cvv (3-4 digits) or digits.
+	Validator string `yaml:"validator"`
+}
+
+// RateLimit throttles Encrypt and Decrypt per principal and per key. Rates
+// are items per second (a batch counts each item) and 0 means no limit; a
+// burst of 0 means one second of the rate, but at least 1000 items.
+type RateLimit struct {
+	// Principal limits each principal, encrypt and decrypt together.
+	PrincipalRate  float64 `yaml:"principalRate" env:"KMS_RATE_LIMIT_PRINCIPAL"`
+	PrincipalBurst int     `yaml:"principalBurst" env:"KMS_RATE_LIMIT_PRINCIPAL_BURST"`
+	EncryptRate    float64 `yaml:"encryptRate" env:"KMS_RATE_LIMIT_ENCRYPT"`
+	EncryptBurst   int     `yaml:"encryptBurst" env:"KMS_RATE_LIMIT_ENCRYPT_BURST"`
+	DecryptRate    float64 `yaml:"decryptRate" env:"KMS_RATE_LIMIT_DECRYPT"`
+	DecryptBurst   int     `yaml:"decryptBurst" env:"KMS_RATE_LIMIT_DECRYPT_BURST"`
+	// Key limits each key across all principals.
+	KeyRate  float64 `yaml:"keyRate" env:"KMS_RATE_LIMIT_KEY"`
+	KeyBurst int     `yaml:"keyBurst" env:"KMS_RATE_LIMIT_KEY_BURST"`
+	// DailyDecryptQuota caps the items each principal decrypts per UTC day.
+	// The counts are kept in memory and start over when the server restarts.
+	DailyDecryptQuota int `yaml:"dailyDecryptQuota" env:"KMS_DAILY_DECRYPT_QUOTA"`
+	// Principals overrides the limits by principal name, or by a name prefix
+	// ending in "*"; the longest matching prefix wins.
+	Principals map[string]PrincipalRateLimit `yaml:"principals"`
+	// Keys overrides keyRate and keyBurst by key_id; "default" is the key
+	// backend's key.
+	Keys map[string]KeyRateLimit `yaml:"keys"`
+}
+
+// PrincipalRateLimit overrides RateLimit for matching principals; zero values
+// inherit.
+type PrincipalRateLimit struct {
+	Rate              float64 `yaml:"rate"`
+	Burst             int     `yaml:"burst"`
+	EncryptRate       float64 `yaml:"encryptRate"`
+	EncryptBurst      int     `yaml:"encryptBurst"`
+	DecryptRate       float64 `yaml:"decryptRate"`
+	DecryptBurst      int     `yaml:"decryptBurst"`
+	DailyDecryptQuota int     `yaml:"dailyDecryptQuota"`
+}
+
+// KeyRateLimit overrides keyRate and keyBurst for one key.
+type KeyRateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Tenants maps tenant names to their members, limits and rate limits. When
+// set, every caller of the KMS service belongs to a tenant and uses only its
+// tenant's named keys: the key_id "pan" of a payments caller is the named key
+// "payments/pan", created through KMSAdmin, and requests without a key_id use
+// "payments/default".
+type Tenants map[string]Tenant
+
+// Tenant is one tenant.
+type Tenant struct {
+	// Principals are the JWT subjects and certificate principals of the
+	// tenant; a trailing "*" matches by prefix. A token's tenant claim must
+	// name a tenant listing its subject.
+	Principals []string `yaml:"principals"`
+	// Limits sets limits and a content validator per key_id of the tenant,
+	// as limits.keys does; "default" is the tenant's default key.
+	Limits map[string]KeyLimits `yaml:"limits"`
+	// RateLimit limits the tenant's principals together.
+	RateLimit TenantRateLimit `yaml:"rateLimit"`
+}
+
+// TenantRateLimit limits one tenant; rates are items per second and 0 means
+// no limit.
+type TenantRateLimit struct {
+	Rate              float64 `yaml:"rate"`
+	Burst             int     `yaml:"burst"`
+	DailyDecryptQuota int     `yaml:"dailyDecryptQuota"`
+	// Keys overrides rateLimit.keyRate and keyBurst per key_id of the tenant.
+	Keys map[string]KeyRateLimit `yaml:"keys"`
+}
+
+// Idempotency bounds the results kept for Encrypt and BatchEncrypt items
+// sent with an idempotency key. Only ciphertexts and nonces are kept, in
+// memory.
+type Idempotency struct {
+	// TTL is how long a result is returned for a repeated key.
+	TTL time.Duration `yaml:"ttl" env:"KMS_IDEMPOTENCY_TTL"`
+	// MaxEntries caps the results kept; the oldest go first.
+	MaxEntries int `yaml:"maxEntries" env:"KMS_IDEMPOTENCY_MAX_ENTRIES"`
+}
+
+// Audit configures the audit log of KMS calls.
+type Audit struct {
+	// Dir receives the hash-chained audit files; empty disables auditing.
+	Dir string `yaml:"dir" env:"KMS_AUDIT_DIR"`
+	// MaxFileBytes is the size at which a new audit file is started.
+	MaxFileBytes int `yaml:"maxFileBytes" env:"KMS_AUDIT_MAX_FILE_BYTES"`
+	// HMACKey, hex-encoded and at least 32 bytes, signs every record so that
+	// the chain cannot be rewritten without it.
+	HMACKey string `yaml:"hmacKey" env:"KMS_AUDIT_HMAC_KEY" secret:"true"`
+
+	// FailMode is "open" (the default) to keep serving and drop records a
+	// sink cannot take, or "closed" to refuse calls that cannot be audited.
+	FailMode string `yaml:"failMode" env:"KMS_AUDIT_FAIL_MODE"`
+	// Buffer is how many records each sink may fall behind.
+	Buffer  int          `yaml:"buffer" env:"KMS_AUDIT_BUFFER"`
+	Syslog  AuditSyslog  `yaml:"syslog"`
+	JSONL   AuditJSONL   `yaml:"jsonl"`
+	Webhook AuditWebhook `yaml:"webhook"`
+}
+
+// Audit fail modes.
+const (
+	AuditFailOpen   = "open"
+	AuditFailClosed = "closed"
+)
+
+// AuditSyslog forwards audit records to a syslog collector as RFC 5424
+// messages over TCP, or TLS when TLS or CAFile is set.
+type AuditSyslog struct {
+	Addr     string `yaml:"addr" env:"KMS_AUDIT_SYSLOG_ADDR"`
+	TLS      bool   `yaml:"tls" env:"KMS_AUDIT_SYSLOG_TLS"`
+	CAFile   string `yaml:"caFile" env:"KMS_AUDIT_SYSLOG_CA_FILE"`
+	Facility int    `yaml:"facility" env:"KMS_AUDIT_SYSLOG_FACILITY"`
+	AppName  string `yaml:"appName"`
+}
+
+// AuditJSONL appends audit records as JSON lines to a file for a log shipper.
+type AuditJSONL struct {
+	Path string `yaml:"path" env:"KMS_AUDIT_JSONL_FILE"`
+	// TenantPath, containing "{tenant}", gives each tenant its own file of
+	// its callers' records, e.g. "/var/log/kms/audit-{tenant}.jsonl".
+	TenantPath string `yaml:"tenantPath" env:"KMS_AUDIT_JSONL_TENANT_FILE"`
+}
+
+// TenantPlaceholder is replaced by the tenant name in audit.jsonl.tenantPath.
+const TenantPlaceholder = "{tenant}"
+
+// AuditWebhook POSTs batches of audit records to an HTTP endpoint.
+type AuditWebhook struct {
+	URL           string        `yaml:"url" env:"KMS_AUDIT_WEBHOOK_URL"`
+	Token         string        `yaml:"token" env:"KMS_AUDIT_WEBHOOK_TOKEN" secret:"true"`
+	CAFile        string        `yaml:"caFile" env:"KMS_AUDIT_WEBHOOK_CA_FILE"`
+	BatchSize     int           `yaml:"batchSize" env:"KMS_AUDIT_WEBHOOK_BATCH_SIZE"`
+	FlushInterval time.Duration `yaml:"flushInterval" env:"KMS_AUDIT_WEBHOOK_FLUSH_INTERVAL"`
+	Timeout       time.Duration `yaml:"timeout" env:"KMS_AUDIT_WEBHOOK_TIMEOUT"`
+}
+
+func (a Audit) sinksEnabled() bool {
+	return a.Syslog.Addr != "" || a.JSONL.Path != "" || a.JSONL.TenantPath != "" || a.Webhook.URL != ""
+}
+
+// Metrics configures the Prometheus endpoint.
+type Metrics struct {
+	// Addr, e.g. ":9090", serves /metrics over plain HTTP; empty disables it.
+	Addr string `yaml:"addr" env:"KMS_METRICS_ADDR"`
+}
+
+// Tracing configures OpenTelemetry tracing; see package tracing.
+type Tracing struct {
+	// Exporter is "otlp", "stdout" or "file"; empty disables tracing.
+	Exporter string `yaml:"exporter" env:"KMS_TRACING_EXPORTER"`
+	// Endpoint is the OTLP/gRPC collector, e.g. "otel-collector:4317".
+	Endpoint string `yaml:"endpoint" env:"KMS_TRACING_ENDPOINT"`
+	Insecure bool   `yaml:"insecure" env:"KMS_TRACING_INSECURE"`
+	// File receives the spans of the file exporter as JSON lines.
+	File        string  `yaml:"file" env:"KMS_TRACING_FILE"`
+	SampleRatio float64 `yaml:"sampleRatio" env:"KMS_TRACING_SAMPLE_RATIO"`
+}
+
+// Logging configures the structured log; see package logging.
+type Logging struct {
+	// File, if set, receives the log as well as stderr.
+	File string `yaml:"file" env:"KMS_LOG_FILE"`
+	// Level is "debug", "info", "warn" or "error".
+	Level string `yaml:"level" env:"KMS_LOG_LEVEL"`
+	// Format is "text" or "json".
+	Format string `yaml:"format" env:"KMS_LOG_FORMAT"`
+}
+
+// MaxBatchItems mirrors server.MaxBatchItems, the most Limits.MaxBatchItems
+// may be set to.
+const MaxBatchItems = 1000
+
+// Backends accepted in key.backend.
+var Backends = []string{"file", "pkcs11", "aws", "azure", "vault", "gcp", "failover"}
+
+// Default returns the settings used when neither the file nor the
+// environment sets them. They match the defaults of the env-only setup.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:                ":50051",
+			ShutdownTimeout:     30 * time.Second,
+			HealthCheckInterval: 15 * time.Second,
+			HealthCheckTimeout:  5 * time.Second,
+		},
+		Key: Key{
+			Backend: "file",
+			File:    FileKey{Path: "master.key"},
+			PKCS11:  PKCS11{KeyLabel: "kms-master-key"},
+			AWS:     AWS{Region: "us-east-1"},
+			Vault: Vault{
+				TransitMount: "transit",
+				AppRoleMount: "approle",
+			},
+			Failover: Failover{
+				FailureThreshold: 3,
+				OpenTimeout:      30 * time.Second,
+				HealthInterval:   10 * time.Second,
+			},
+		},
+		TLS:     TLS{ReloadInterval: tlsconfig.DefaultReloadInterval},
+		Tracing: Tracing{SampleRatio: 1},
+		Idempotency: Idempotency{
+			TTL:        idempotency.DefaultTTL,
+			MaxEntries: idempotency.DefaultMaxEntries,
+		},
+		Logging: Logging{Level: "info", Format: logging.FormatText},
+		Audit: Audit{
+			MaxFileBytes: audit.DefaultMaxFileBytes,
+			FailMode:     AuditFailOpen,
+			Buffer:       audit.DefaultBuffer,
+			Syslog:       AuditSyslog{Facility: audit.DefaultSyslogFacility},
+			Webhook: AuditWebhook{
+				BatchSize:     audit.DefaultBatchSize,
+				FlushInterval: time.Second,
+				Timeout:       10 * time.Second,
+			},
+		},
+		Limits: Limits{
+			MaxBatchItems:     MaxBatchItems,
+			MaxRecvMsgBytes:   4 << 20, // gRPC's default
+			MaxPlaintextBytes: policy.DefaultMaxPlaintextBytes,
+		},
+	}
+}
+
+// Load reads the YAML file at path over the defaults, then applies the
+// environment overrides. An empty path means environment only. Load does not
+// validate; call Validate.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setting is one leaf of the Config tree.
+type setting struct {
+	path   string   // file key, e.g. "tls.certFile"
+	env    []string // variables that override it, first set wins
+	secret bool
+	value  reflect.Value
+}
+
+// settings walks the Config in declaration order.
+func (c *Config) settings() []setting {
+	var out []setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if !f.IsExported() || name == "" {
+				continue
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			fv := v.Field(i)
+			if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(name, fv)
+				continue
+			}
+			s := setting{path: name, secret: f.Tag.Get("secret") == "true", value: fv}
+			if env := f.Tag.Get("env"); env != "" {
+				s.env = strings.Split(env, ",")
+			}
+			out = append(out, s)
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return out
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	c.fromEnv = map[string]string{}
+	var errs []error
+	for _, s := range c.settings() {
+		for _, name := range s.env {
+			v, ok := lookup(name)
+			if !ok || v == "" {
+				continue
+			}
+			if err := setValue(s.value, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			c.fromEnv[s.path] = name
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Uint:
+		n, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		v.SetUint(n)
+	case v.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes":
+		return true, nil
+	case "0", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	case v.Kind() == reflect.Map:
+		var entries []string
+		for _, k := range v.MapKeys() {
+			entries = append(entries, fmt.Sprintf("%s=%+v", k, v.MapIndex(k)))
+		}
+		slices.Sort(entries)
+		return strings.Join(entries, " ")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// Lookup returns the value of a setting by its environment variable name,
+// formatted as that variable would be. Names without a setting, such as the
+// suffixed KMS_PKCS11_LIB_B of a failover member, are read from the
+// environment. It is meant for kms.NewManagerFromLookup.
+func (c *Config) Lookup(name string) string {
+	for _, s := range c.settings() {
+		for _, env := range s.env {
+			if env == name {
+				return formatValue(s.value)
+			}
+		}
+	}
+	return os.Getenv(name)
+}
+
+// Changed returns the file keys of the settings whose values differ between
+// c and other.
+func (c *Config) Changed(other *Config) []string {
+	var changed []string
+	mine, theirs := c.settings(), other.settings()
+	for i, s := range mine {
+		a, b := s.value, theirs[i].value
+		if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			changed = append(changed, s.path)
+		}
+	}
+	return changed
+}
+
+// JWTConfig returns the auth interceptor configuration.
+func (c *Config) JWTConfig() auth.JWTConfig {
+	return auth.JWTConfig{
+		Secret:            c.Auth.JWTSecret,
+		Audience:          c.Auth.JWTAudience,
+		Issuer:            c.Auth.JWTIssuer,
+		CertAuth:          c.Auth.ClientCert,
+		AllowedPrincipals: c.Auth.AllowedPrincipals,
+		AdminPrincipals:   c.Auth.AdminPrincipals,
+		InspectPrincipals: c.Auth.InspectPrincipals,
+		Tenants:           c.TenantSet(),
+	}
+}
+
+// TenantSet returns the tenants and their principals, nil if none are
+// configured.
+func (c *Config) TenantSet() tenant.Set {
+	if len(c.Tenants) == 0 {
+		return nil
+	}
+	set := tenant.Set{}
+	for name, t := range c.Tenants {
+		set[name] = tenant.Tenant{Principals: t.Principals}
+	}
+	return set
+}
+
+// Policy returns the size limits and content validators of the KMS service.
+func (c *Config) Policy() *policy.Policy {
+	p := &policy.Policy{
+		Default: policy.Limits{
+			MaxPlaintextBytes:  c.Limits.MaxPlaintextBytes,
+			MaxCiphertextBytes: c.Limits.MaxCiphertextBytes,
+		},
+		Keys: map[string]policy.Limits{},
+	}
+	for id, l := range c.Limits.Keys {
+		p.Keys[id] = policy.Limits(l)
+	}
+	for name, t := range c.Tenants {
+		for id, l := range t.Limits {
+			p.Keys[tenant.KeyID(name, id)] = policy.Limits(l)
+		}
+	}
+	return p
+}
+
+// RateLimitConfig returns the rate limits and quotas of the KMS service.
+func (c *Config) RateLimitConfig() ratelimit.Config {
+	rl := c.RateLimit
+	cfg := ratelimit.Config{
+		Principal:         ratelimit.Rule{Rate: rl.PrincipalRate, Burst: rl.PrincipalBurst},
+		Encrypt:           ratelimit.Rule{Rate: rl.EncryptRate, Burst: rl.EncryptBurst},
+		Decrypt:           ratelimit.Rule{Rate: rl.DecryptRate, Burst: rl.DecryptBurst},
+		Key:               ratelimit.Rule{Rate: rl.KeyRate, Burst: rl.KeyBurst},
+		DailyDecryptQuota: rl.DailyDecryptQuota,
+		Principals:        map[string]ratelimit.PrincipalConfig{},
+		Keys:              map[string]ratelimit.Rule{},
+	}
+	for name, p := range rl.Principals {
+		cfg.Principals[name] = ratelimit.PrincipalConfig{
+			Principal:         ratelimit.Rule{Rate: p.Rate, Burst: p.Burst},
+			Encrypt:           ratelimit.Rule{Rate: p.EncryptRate, Burst: p.EncryptBurst},
+			Decrypt:           ratelimit.Rule{Rate: p.DecryptRate, Burst: p.DecryptBurst},
+			DailyDecryptQuota: p.DailyDecryptQuota,
+		}
+	}
+	for id, k := range rl.Keys {
+		cfg.Keys[id] = ratelimit.Rule(k)
+	}
+	if len(c.Tenants) > 0 {
+		cfg.Tenants = map[string]ratelimit.TenantConfig{}
+	}
+	for name, t := range c.Tenants {
+		cfg.Tenants[name] = ratelimit.TenantConfig{
+			Rate:              ratelimit.Rule{Rate: t.RateLimit.Rate, Burst: t.RateLimit.Burst},
+			DailyDecryptQuota: t.RateLimit.DailyDecryptQuota,
+		}
+		for id, k := range t.RateLimit.Keys {
+			cfg.Keys[tenant.KeyID(name, id)] = ratelimit.Rule(k)
+		}
+	}
+	return cfg
+}
+
+// AuditOptions returns the audit log settings, with the configured sinks.
+// It fails on an invalid HMAC key, an unreadable CA bundle or a JSON Lines
+// file that cannot be opened; the caller closes the sinks through the Log.
+func (c *Config) AuditOptions() (audit.Options, error) {
+	a := c.Audit
+	key, err := auditKey(a.HMACKey)
+	if err != nil {
+		return audit.Options{}, err
+	}
+	opts := audit.Options{
+		Dir:          a.Dir,
+		MaxFileBytes: int64(a.MaxFileBytes),
+		HMACKey:      key,
+		Buffer:       a.Buffer,
+		FailClosed:   a.FailMode == AuditFailClosed,
+	}
+	if a.Syslog.Addr != "" {
+		sc := audit.SyslogConfig{Addr: a.Syslog.Addr, Facility: a.Syslog.Facility, AppName: a.Syslog.AppName}
+		if a.Syslog.TLS || a.Syslog.CAFile != "" {
+			if sc.TLS, err = tlsconfig.NewClientTLS(tlsconfig.ClientConfig{Enabled: true, CAFile: a.Syslog.CAFile}); err != nil {
+				return audit.Options{}, fmt.Errorf("audit.syslog: %w", err)
+			}
+		}
+		opts.Forward = append(opts.Forward, audit.Forward{Sink: audit.NewSyslogSink(sc)})
+	}
+	if a.JSONL.Path != "" {
+		sink, err := audit.NewFileSink(a.JSONL.Path)
+		if err != nil {
+			return audit.Options{}, fmt.Errorf("audit.jsonl: %w", err)
+		}
+		opts.Forward = append(opts.Forward, audit.Forward{Sink: sink})
+	}
+	if a.JSONL.TenantPath != "" {
+		for _, name := range slices.Sorted(maps.Keys(c.Tenants)) {
+			sink, err := audit.NewFileSink(strings.ReplaceAll(a.JSONL.TenantPath, TenantPlaceholder, name))
+			if err != nil {
+				return audit.Options{}, fmt.Errorf("audit.jsonl.tenantPath: %w", err)
+			}
+			opts.Forward = append(opts.Forward, audit.Forward{Sink: sink, Tenant: name})
+		}
+	}
+	if a.Webhook.URL != "" {
+		wc := audit.WebhookConfig{URL: a.Webhook.URL, Token: a.Webhook.Token, Timeout: a.Webhook.Timeout}
+		if a.Webhook.CAFile != "" {
+			if wc.TLS, err = tlsconfig.NewClientTLS(tlsconfig.ClientConfig{Enabled: true, CAFile: a.Webhook.CAFile}); err != nil {
+				return audit.Options{}, fmt.Errorf("audit.webhook: %w", err)
+			}
+		}
+		opts.Forward = append(opts.Forward, audit.Forward{
+			Sink:          audit.NewWebhookSink(wc),
+			BatchSize:     a.Webhook.BatchSize,
+			FlushInterval: a.Webhook.FlushInterval,
+		})
+	}
+	return opts, nil
+}
+
+// MinAuditKeyBytes is the shortest audit.hmacKey accepted.
+const MinAuditKeyBytes = 32
+
+func auditKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("audit.hmacKey: not hex-encoded")
+	}
+	if len(key) < MinAuditKeyBytes {
+		return nil, fmt.Errorf("audit.hmacKey: %d bytes, need at least %d", len(key), MinAuditKeyBytes)
+	}
+	return key, nil
+}
+
+// LoggingConfig returns the log settings.
+func (c *Config) LoggingConfig() logging.Config {
+	return logging.Config{Level: c.Logging.Level, Format: c.Logging.Format, File: c.Logging.File}
+}
+
+// Secrets returns the values of the secret settings that are set (PINs,
+// tokens, the JWT secret, the audit HMAC key), for the log to redact.
+func (c *Config) Secrets() []string {
+	var out []string
+	for _, s := range c.settings() {
+		if s.secret && s.value.Kind() == reflect.String && s.value.String() != "" {
+			out = append(out, s.value.String())
+		}
+	}
+	return out
+}
+
+// TracingConfig returns the tracing settings.
+func (c *Config) TracingConfig() tracing.Config {
+	t := c.Tracing
+	return tracing.Config{
+		Exporter:    t.Exporter,
+		Endpoint:    t.Endpoint,
+		Insecure:    t.Insecure,
+		File:        t.File,
+		SampleRatio: t.SampleRatio,
+	}
+}
+
+// TLSConfig returns the server TLS configuration.
+func (c *Config) TLSConfig() tlsconfig.ServerConfig {
+	return tlsconfig.ServerConfig{
+		CertFile:       c.TLS.CertFile,
+		KeyFile:        c.TLS.KeyFile,
+		ClientCAFile:   c.TLS.ClientCAFile,
+		ClientAuth:     strings.ToLower(c.TLS.ClientAuth),
+		ReloadInterval: c.TLS.ReloadInterval,
+	}
+}
+
+// usesBackend reports whether the section of backend name is in use.
+func (c *Config) usesBackend(name string) bool {
+	if c.Key.Backend == name {
+		return true
+	}
+	if c.Key.Backend != "failover" {
+		return false
+	}
+	for _, b := range c.Key.Failover.Backends {
+		if t, _, _ := strings.Cut(b, ":"); t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the effective settings, one "key = value" line each, with
+// secrets redacted and the overriding environment variable noted. Sections of
+// unused key backends are left out.
+func (c *Config) Print(w io.Writer) {
+	for _, s := range c.settings() {
+		if section, ok := strings.CutPrefix(s.path, "key."); ok {
+			backend, _, nested := strings.Cut(section, ".")
+			if nested && !c.usesBackend(backend) {
+				continue
+			}
+		}
+		value := strconv.Quote(formatValue(s.value))
+		if s.secret {
+			value = "(not set)"
+			if !s.value.IsZero() {
+				value = "<redacted>"
+			}
+		}
+		line := fmt.Sprintf("%s = %s", s.path, value)
+		if env, ok := c.fromEnv[s.path]; ok {
+			line = fmt.Sprintf("%-48s # from %s", line, env)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"kms/internal/logging"
+	"kms/internal/policy"
+	"kms/internal/tenant"
+	"kms/internal/tlsconfig"
+	"kms/internal/tracing"
+)
+
+// Validate checks the configuration as a whole and returns every problem
+// found, joined, or nil. It also checks that the files it names exist, so a
+// config that passes can start the server on this host.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		v.addf("server.addr", "%q is not a host:port address", c.Server.Addr)
+	}
+	if c.Metrics.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			v.addf("metrics.addr", "%q is not a host:port address", c.Metrics.Addr)
+		}
+	}
+	switch t := c.Tracing; t.Exporter {
+	case "", tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		v.required("tracing.file", t.File)
+		if t.File != "" {
+			// The file is created on start; its directory must exist.
+			v.fileExists("tracing.file", filepath.Dir(t.File))
+		}
+	default:
+		v.addf("tracing.exporter", "%q is not one of %s", t.Exporter, strings.Join(tracing.Exporters, ", "))
+	}
+	if r := c.Tracing.SampleRatio; !(r >= 0 && r <= 1) {
+		v.addf("tracing.sampleRatio", "must be between 0 and 1")
+	}
+	if c.Tracing.Endpoint != "" {
+		if _, _, err := net.SplitHostPort(c.Tracing.Endpoint); err != nil {
+			v.addf("tracing.endpoint", "%q is not a host:port address", c.Tracing.Endpoint)
+		}
+	}
+	if _, err := logging.ParseLevel(c.Logging.Level); err != nil {
+		v.addf("logging.level", "%q is not debug, info, warn or error", c.Logging.Level)
+	}
+	if f := c.Logging.Format; f != logging.FormatText && f != logging.FormatJSON {
+		v.addf("logging.format", "%q is not %q or %q", f, logging.FormatText, logging.FormatJSON)
+	}
+	if c.Logging.File != "" {
+		v.fileExists("logging.file", filepath.Dir(c.Logging.File))
+	}
+	v.nonNegative("server.shutdownDrainDelay", int64(c.Server.ShutdownDrainDelay))
+	v.positive("server.shutdownTimeout", int64(c.Server.ShutdownTimeout))
+	v.positive("server.healthCheckInterval", int64(c.Server.HealthCheckInterval))
+	v.positive("server.healthCheckTimeout", int64(c.Server.HealthCheckTimeout))
+
+	c.validateKey(v)
+
+	if c.Auth.ClientCert && c.TLS.ClientCAFile == "" {
+		v.addf("auth.clientCert", "requires mTLS: set tls.clientCAFile")
+	}
+	v.principals("auth.allowedPrincipals", c.Auth.AllowedPrincipals)
+	v.principals("auth.adminPrincipals", c.Auth.AdminPrincipals)
+	v.principals("auth.inspectPrincipals", c.Auth.InspectPrincipals)
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		v.addf("tls", "certFile and keyFile must be set together")
+	}
+	v.fileExists("tls.certFile", c.TLS.CertFile)
+	v.fileExists("tls.keyFile", c.TLS.KeyFile)
+	v.fileExists("tls.clientCAFile", c.TLS.ClientCAFile)
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		v.addf("tls.clientCAFile", "requires tls.certFile and tls.keyFile")
+	}
+	switch mode := strings.ToLower(c.TLS.ClientAuth); mode {
+	case "", tlsconfig.ClientAuthNone:
+	case tlsconfig.ClientAuthOptional, tlsconfig.ClientAuthRequire:
+		if c.TLS.ClientCAFile == "" {
+			v.addf("tls.clientAuth", "%q requires tls.clientCAFile", mode)
+		}
+	default:
+		v.addf("tls.clientAuth", "%q is not one of none, optional, require", c.TLS.ClientAuth)
+	}
+	v.positive("tls.reloadInterval", int64(c.TLS.ReloadInterval))
+
+	if c.Limits.MaxBatchItems < 1 || c.Limits.MaxBatchItems > MaxBatchItems {
+		v.addf("limits.maxBatchItems", "must be between 1 and %d", MaxBatchItems)
+	}
+	if c.Limits.MaxRecvMsgBytes < 1024 {
+		v.addf("limits.maxRecvMsgBytes", "must be at least 1024")
+	}
+	v.nonNegative("limits.maxPlaintextBytes", int64(c.Limits.MaxPlaintextBytes))
+	v.nonNegative("limits.maxCiphertextBytes", int64(c.Limits.MaxCiphertextBytes))
+	for _, id := range slices.Sorted(maps.Keys(c.Limits.Keys)) {
+		if id == "" {
+			v.addf("limits.keys", "empty key ID; use %q for the key backend's key", policy.DefaultKeyID)
+		}
+		v.keyLimits("limits.keys."+id, c.Limits.Keys[id])
+	}
+
+	c.validateRateLimit(v)
+	c.validateTenants(v)
+	v.positive("idempotency.ttl", int64(c.Idempotency.TTL))
+	v.positive("idempotency.maxEntries", int64(c.Idempotency.MaxEntries))
+
+	if c.Audit.Dir != "" {
+		v.fileExists("audit.dir", c.Audit.Dir)
+	}
+	if c.Audit.MaxFileBytes < 4096 {
+		v.addf("audit.maxFileBytes", "must be at least 4096")
+	}
+	if _, err := auditKey(c.Audit.HMACKey); err != nil {
+		v.errs = append(v.errs, err)
+	}
+	c.validateAuditSinks(v)
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateAuditSinks(v *validator) {
+	a := c.Audit
+	if a.FailMode != AuditFailOpen && a.FailMode != AuditFailClosed {
+		v.addf("audit.failMode", "%q is not %q or %q", a.FailMode, AuditFailOpen, AuditFailClosed)
+	}
+	if a.sinksEnabled() && a.Dir == "" {
+		v.addf("audit.dir", "required when audit records are forwarded")
+	}
+	v.positive("audit.buffer", int64(a.Buffer))
+	if a.Syslog.Addr != "" {
+		if _, _, err := net.SplitHostPort(a.Syslog.Addr); err != nil {
+			v.addf("audit.syslog.addr", "%v", err)
+		}
+		if a.Syslog.Facility < 1 || a.Syslog.Facility > 23 {
+			v.addf("audit.syslog.facility", "must be between 1 and 23")
+		}
+		v.fileExists("audit.syslog.caFile", a.Syslog.CAFile)
+	}
+	if a.JSONL.Path != "" {
+		v.fileExists("audit.jsonl.path", filepath.Dir(a.JSONL.Path))
+	}
+	if a.JSONL.TenantPath != "" {
+		if !strings.Contains(a.JSONL.TenantPath, TenantPlaceholder) {
+			v.addf("audit.jsonl.tenantPath", "must contain %s", TenantPlaceholder)
+		}
+		if len(c.Tenants) == 0 {
+			v.addf("audit.jsonl.tenantPath", "requires tenants")
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.Tenants)) {
+			v.fileExists("audit.jsonl.tenantPath", filepath.Dir(strings.ReplaceAll(a.JSONL.TenantPath, TenantPlaceholder, name)))
+		}
+	}
+	if a.Webhook.URL != "" {
+		if u, err := url.Parse(a.Webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.addf("audit.webhook.url", "must be an http or https URL")
+		}
+		v.positive("audit.webhook.batchSize", int64(a.Webhook.BatchSize))
+		v.nonNegative("audit.webhook.flushInterval", int64(a.Webhook.FlushInterval))
+		v.positive("audit.webhook.timeout", int64(a.Webhook.Timeout))
+		v.fileExists("audit.webhook.caFile", a.Webhook.CAFile)
+	}
+}
+
+func (c *Config) validateRateLimit(v *validator) {
+	rl := c.RateLimit
+	v.rate("rateLimit.principalRate", "rateLimit.principalBurst", rl.PrincipalRate, rl.PrincipalBurst)
+	v.rate("rateLimit.encryptRate", "rateLimit.encryptBurst", rl.EncryptRate, rl.EncryptBurst)
+	v.rate("rateLimit.decryptRate", "rateLimit.decryptBurst", rl.DecryptRate, rl.DecryptBurst)
+	v.rate("rateLimit.keyRate", "rateLimit.keyBurst", rl.KeyRate, rl.KeyBurst)
+	v.nonNegative("rateLimit.dailyDecryptQuota", int64(rl.DailyDecryptQuota))
+	for _, name := range slices.Sorted(maps.Keys(rl.Principals)) {
+		p := rl.Principals[name]
+		path := "rateLimit.principals." + name
+		v.principals("rateLimit.principals", []string{name})
+		v.rate(path+".rate", path+".burst", p.Rate, p.Burst)
+		v.rate(path+".encryptRate", path+".encryptBurst", p.EncryptRate, p.EncryptBurst)
+		v.rate(path+".decryptRate", path+".decryptBurst", p.DecryptRate, p.DecryptBurst)
+		v.nonNegative(path+".dailyDecryptQuota", int64(p.DailyDecryptQuota))
+	}
+	for _, id := range slices.Sorted(maps.Keys(rl.Keys)) {
+		if id == "" {
+			v.addf("rateLimit.keys", "empty key ID; use %q for the key backend's key", policy.DefaultKeyID)
+		}
+		v.rate("rateLimit.keys."+id+".rate", "rateLimit.keys."+id+".burst", rl.Keys[id].Rate, rl.Keys[id].Burst)
+	}
+}
+
+func (c *Config) validateTenants(v *validator) {
+	if len(c.Tenants) == 0 {
+		return
+	}
+	if c.Auth.JWTSecret == "" && !c.Auth.ClientCert {
+		v.addf("tenants", "require authentication: set auth.jwtSecret or auth.clientCert")
+	}
+	if c.Key.Store == "" {
+		v.addf("tenants", "require named keys: set key.store")
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Tenants)) {
+		t := c.Tenants[name]
+		path := "tenants." + name
+		if err := tenant.CheckName(name); err != nil {
+			v.addf("tenants", "%v", err)
+		}
+		if len(t.Principals) == 0 {
+			v.addf(path+".principals", "required")
+		}
+		v.principals(path+".principals", t.Principals)
+		for _, id := range slices.Sorted(maps.Keys(t.Limits)) {
+			if id == "" || strings.Contains(id, tenant.Separator) {
+				v.addf(path+".limits", "%q: name keys without the tenant; %q is the tenant's default key", id, tenant.DefaultKeyID)
+			}
+			v.keyLimits(path+".limits."+id, t.Limits[id])
+		}
+		rl := t.RateLimit
+		v.rate(path+".rateLimit.rate", path+".rateLimit.burst", rl.Rate, rl.Burst)
+		v.nonNegative(path+".rateLimit.dailyDecryptQuota", int64(rl.DailyDecryptQuota))
+		for _, id := range slices.Sorted(maps.Keys(rl.Keys)) {
+			if id == "" || strings.Contains(id, tenant.Separator) {
+				v.addf(path+".rateLimit.keys", "%q: name keys without the tenant; %q is the tenant's default key", id, tenant.DefaultKeyID)
+			}
+			v.rate(path+".rateLimit.keys."+id+".rate", path+".rateLimit.keys."+id+".burst", rl.Keys[id].Rate, rl.Keys[id].Burst)
+		}
+	}
+}
+
+func (c *Config) validateKey(v *validator) {
+	if !slices.Contains(Backends, c.Key.Backend) {
+		v.addf("key.backend", "%q is not one of %s", c.Key.Backend, strings.Join(Backends, ", "))
+		return
+	}
+	if c.Key.Backend == "failover" {
+		if len(c.Key.Failover.Backends) == 0 {
+			v.addf("key.failover.backends", "required when key.backend is failover")
+		}
+		seen := map[string]bool{}
+		for _, b := range c.Key.Failover.Backends {
+			t, _, _ := strings.Cut(b, ":")
+			switch {
+			case t == "file" || t == "failover" || !slices.Contains(Backends, t):
+				v.addf("key.failover.backends", "%q is not an HSM backend", b)
+			case seen[b]:
+				v.addf("key.failover.backends", "%q is listed twice", b)
+			}
+			seen[b] = true
+		}
+		if c.Key.Failover.FailureThreshold < 1 {
+			v.addf("key.failover.failureThreshold", "must be at least 1")
+		}
+		v.positive("key.failover.openTimeout", int64(c.Key.Failover.OpenTimeout))
+		v.nonNegative("key.failover.healthInterval", int64(c.Key.Failover.HealthInterval))
+	}
+
+	if c.Key.Store != "" {
+		// The store itself is created by the first CreateKey.
+		v.fileExists("key.store", filepath.Dir(c.Key.Store))
+	}
+	if c.usesBackend("file") {
+		v.required("key.file.path", c.Key.File.Path)
+		v.fileExists("key.file.path", c.Key.File.Path)
+	}
+	if c.usesBackend("pkcs11") {
+		v.required("key.pkcs11.lib", c.Key.PKCS11.Lib)
+		if strings.ContainsAny(c.Key.PKCS11.Lib, `/\`) {
+			// A bare library name is resolved by the dynamic loader.
+			v.fileExists("key.pkcs11.lib", c.Key.PKCS11.Lib)
+		}
+		v.required("key.pkcs11.keyLabel", c.Key.PKCS11.KeyLabel)
+	}
+	if c.usesBackend("aws") {
+		v.required("key.aws.keyID", c.Key.AWS.KeyID)
+		v.required("key.aws.region", c.Key.AWS.Region)
+	}
+	if c.usesBackend("azure") {
+		v.required("key.azure.vaultURL", c.Key.Azure.VaultURL)
+		v.required("key.azure.keyName", c.Key.Azure.KeyName)
+		v.required("key.azure.dekPath", c.Key.Azure.DEKPath)
+	}
+	if c.usesBackend("vault") {
+		vc := c.Key.Vault
+		v.required("key.vault.addr", vc.Addr)
+		v.required("key.vault.keyName", vc.KeyName)
+		v.required("key.vault.dekPath", vc.DEKPath)
+		if vc.Token == "" && (vc.RoleID == "" || vc.SecretID == "") {
+			v.addf("key.vault", "set token, or roleID and secretID for AppRole login")
+		}
+		if (vc.ClientCert == "") != (vc.ClientKey == "") {
+			v.addf("key.vault", "clientCert and clientKey must be set together")
+		}
+		v.fileExists("key.vault.caCert", vc.CACert)
+	}
+	if c.usesBackend("gcp") {
+		v.required("key.gcp.keyName", c.Key.GCP.KeyName)
+		v.required("key.gcp.dekPath", c.Key.GCP.DEKPath)
+		v.fileExists("key.gcp.credentialsFile", c.Key.GCP.CredentialsFile)
+	}
+}
+
+// validator collects errors, each prefixed with the file key it is about.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) addf(path, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) required(path, value string) {
+	if value == "" {
+		v.addf(path, "required")
+	}
+}
+
+func (v *validator) positive(path string, n int64) {
+	if n <= 0 {
+		v.addf(path, "must be positive")
+	}
+}
+
+func (v *validator) nonNegative(path string, n int64) {
+	if n < 0 {
+		v.addf(path, "must not be negative")
+	}
+}
+
+// rate checks a token bucket's rate and burst settings.
+func (v *validator) rate(ratePath, burstPath string, rate float64, burst int) {
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		v.addf(ratePath, "must be a non-negative number")
+	}
+	v.nonNegative(burstPath, int64(burst))
+}
+
+func (v *validator) principals(path string, patterns []string) {
+	for _, p := range patterns {
+		if strings.TrimSpace(p) == "" || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+			v.addf(path, "%q is not a name or a name prefix ending in *", p)
+		}
+	}
+}
+
+// keyLimits checks the limits of one key.
+func (v *validator) keyLimits(path string, l KeyLimits) {
+	v.nonNegative(path+".maxPlaintextBytes", int64(l.MaxPlaintextBytes))
+	v.nonNegative(path+".maxCiphertextBytes", int64(l.MaxCiphertextBytes))
+	if _, ok := policy.Validators[l.Validator]; l.Validator != "" && !ok {
+		v.addf(path+".validator", "%q is not one of %s", l.Validator, strings.Join(policy.ValidatorNames(), ", "))
+	}
+}
+
+// fileExists checks a path that is set; empty paths are left to required.
+func (v *validator) fileExists(path, file string) {
+	if file == "" {
+		return
+	}
+	if _, err := os.Stat(file); err != nil {
+		v.addf(path, "%v", err)
+	}
+}
@@ -0,0 +1,8 @@
+package idempotency
+
+import "time"
+
+// SetClock replaces the clock c expires its entries by.
+func SetClock(c *Cache, now func() time.Time) {
+	c.now = now
+}
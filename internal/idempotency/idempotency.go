@@ -0,0 +1,175 @@
+// Package idempotency remembers the results of encrypt requests by the
+// caller's idempotency key, so that a retried request gets the ciphertext of
+// the first attempt instead of a second, different one. The cache is bounded
+// and in memory, and it never holds plaintext: a request is recognised by an
+// HMAC of its key_id and plaintext under a random key that lives only in the
+// process.
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors returned by Do.
+var (
+	ErrInvalidKey = errors.New("invalid idempotency key")
+	ErrKeyReused  = errors.New("idempotency key reused for a different request")
+)
+
+// Limits and defaults.
+const (
+	MaxKeyLen         = 128
+	DefaultTTL        = 10 * time.Minute
+	DefaultMaxEntries = 100000
+)
+
+// Result is what a repeated request gets back.
+type Result struct {
+	Ciphertext []byte
+	Nonce      []byte
+}
+
+// Cache holds results for TTL after they were made, evicting the oldest
+// beyond its maximum number of entries. It is safe for concurrent use.
+type Cache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+	mac []byte // HMAC key of the request fingerprints
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *entry
+	order   *list.List               // oldest first
+}
+
+type entry struct {
+	key         string
+	fingerprint []byte
+	expires     time.Time
+	done        chan struct{} // closed when res and err are set
+	res         Result
+	err         error
+}
+
+// New returns a Cache keeping results for ttl, at most maxEntries of them;
+// zero values mean DefaultTTL and DefaultMaxEntries.
+func New(ttl time.Duration, maxEntries int) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	mac := make([]byte, 32)
+	if _, err := rand.Read(mac); err != nil {
+		panic(fmt.Sprintf("idempotency: %v", err))
+	}
+	return &Cache{
+		ttl:     ttl,
+		max:     maxEntries,
+		now:     time.Now,
+		mac:     mac,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// CheckKey reports whether key can be used as an idempotency key.
+func CheckKey(key string) error {
+	if len(key) > MaxKeyLen {
+		return fmt.Errorf("%w: %d characters, at most %d", ErrInvalidKey, len(key), MaxKeyLen)
+	}
+	return nil
+}
+
+// Do returns the result stored for key within scope (the caller), or runs
+// encrypt and stores its result. replayed is true for a stored result. A
+// request with the same key but another keyID or plaintext fails with
+// ErrKeyReused. Failures are not stored, so the next attempt runs again; a
+// request arriving while the first is still running waits for it.
+func (c *Cache) Do(ctx context.Context, scope, key, keyID string, plaintext []byte, encrypt func() (Result, error)) (res Result, replayed bool, err error) {
+	if err := CheckKey(key); err != nil {
+		return Result{}, false, err
+	}
+	id := scope + "\x00" + key
+	fp := c.fingerprint(keyID, plaintext)
+	for {
+		c.mu.Lock()
+		c.expireLocked()
+		if el, ok := c.entries[id]; ok {
+			e := el.Value.(*entry)
+			c.mu.Unlock()
+			if !hmac.Equal(e.fingerprint, fp) {
+				return Result{}, false, fmt.Errorf("%w: %q", ErrKeyReused, key)
+			}
+			select {
+			case <-e.done:
+			case <-ctx.Done():
+				return Result{}, false, ctx.Err()
+			}
+			if e.err != nil {
+				continue // the first attempt failed and was dropped; try again
+			}
+			return e.res, true, nil
+		}
+		e := &entry{key: id, fingerprint: fp, expires: c.now().Add(c.ttl), done: make(chan struct{})}
+		c.entries[id] = c.order.PushBack(e)
+		for c.order.Len() > c.max {
+			c.removeLocked(c.order.Front())
+		}
+		c.mu.Unlock()
+
+		e.res, e.err = encrypt()
+		if e.err != nil {
+			c.mu.Lock()
+			if el, ok := c.entries[id]; ok && el.Value == e {
+				c.removeLocked(el)
+			}
+			c.mu.Unlock()
+		}
+		close(e.done)
+		return e.res, false, e.err
+	}
+}
+
+// Len returns the number of stored results, including those being made.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+	return c.order.Len()
+}
+
+// fingerprint identifies a request without keeping its plaintext.
+func (c *Cache) fingerprint(keyID string, plaintext []byte) []byte {
+	h := hmac.New(sha256.New, c.mac)
+	h.Write([]byte(keyID))
+	h.Write([]byte{0})
+	h.Write(plaintext)
+	return h.Sum(nil)
+}
+
+// expireLocked drops the expired entries; all share one TTL, so they are the
+// oldest.
+func (c *Cache) expireLocked() {
+	now := c.now()
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		if now.Before(el.Value.(*entry).expires) {
+			return
+		}
+		c.removeLocked(el)
+	}
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,223 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kms/internal/idempotency"
+)
+
+// counter is an encrypt function returning a new result on every run.
+type counter struct{ runs atomic.Int32 }
+
+func (c *counter) encrypt() (idempotency.Result, error) {
+	n := c.runs.Add(1)
+	return idempotency.Result{Ciphertext: []byte(fmt.Sprintf("ct-%d", n)), Nonce: []byte(fmt.Sprintf("nonce-%d", n))}, nil
+}
+
+func TestReplay(t *testing.T) {
+	c := idempotency.New(0, 0)
+	var enc counter
+	first, replayed, err := c.Do(context.Background(), "payments/pay-api", "row-1:pan", "pan", []byte("4111111111111111"), enc.encrypt)
+	if err != nil || replayed {
+		t.Fatalf("first Do = %v, replayed %v", err, replayed)
+	}
+	again, replayed, err := c.Do(context.Background(), "payments/pay-api", "row-1:pan", "pan", []byte("4111111111111111"), enc.encrypt)
+	if err != nil || !replayed {
+		t.Fatalf("repeated Do = %v, replayed %v; want the stored result", err, replayed)
+	}
+	if string(again.Ciphertext) != string(first.Ciphertext) || string(again.Nonce) != string(first.Nonce) {
+		t.Errorf("replay %q/%q, want the first %q/%q", again.Ciphertext, again.Nonce, first.Ciphertext, first.Nonce)
+	}
+	if n := enc.runs.Load(); n != 1 {
+		t.Errorf("encrypt ran %d times, want once", n)
+	}
+
+	// Another caller's key of the same name is its own.
+	if _, replayed, _ := c.Do(context.Background(), "cards/cards-etl", "row-1:pan", "pan", []byte("4111111111111111"), enc.encrypt); replayed {
+		t.Error("another scope got the stored result")
+	}
+}
+
+func TestKeyReused(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		keyID     string
+		plaintext string
+		want      error
+	}{
+		{name: "same request", keyID: "pan", plaintext: "4111111111111111"},
+		{name: "other plaintext", keyID: "pan", plaintext: "5500000000000004", want: idempotency.ErrKeyReused},
+		{name: "other key_id", keyID: "cvv", plaintext: "4111111111111111", want: idempotency.ErrKeyReused},
+		{name: "default key", keyID: "", plaintext: "4111111111111111", want: idempotency.ErrKeyReused},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := idempotency.New(0, 0)
+			var enc counter
+			if _, _, err := c.Do(context.Background(), "s", "k", "pan", []byte("4111111111111111"), enc.encrypt); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := c.Do(context.Background(), "s", "k", tc.keyID, []byte(tc.plaintext), enc.encrypt)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Do = %v, want %v", err, tc.want)
+			}
+			if err != nil && strings.Contains(err.Error(), tc.plaintext) {
+				t.Errorf("error %q quotes the plaintext", err)
+			}
+			if n := enc.runs.Load(); n != 1 {
+				t.Errorf("encrypt ran %d times, want once", n)
+			}
+		})
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	c := idempotency.New(0, 0)
+	var enc counter
+	_, _, err := c.Do(context.Background(), "s", strings.Repeat("k", idempotency.MaxKeyLen+1), "pan", []byte("x"), enc.encrypt)
+	if !errors.Is(err, idempotency.ErrInvalidKey) {
+		t.Errorf("Do with a %d-character key = %v, want ErrInvalidKey", idempotency.MaxKeyLen+1, err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	c := idempotency.New(time.Minute, 0)
+	idempotency.SetClock(c, func() time.Time { return now })
+	var enc counter
+	do := func(plaintext string) (bool, error) {
+		_, replayed, err := c.Do(context.Background(), "s", "k", "pan", []byte(plaintext), enc.encrypt)
+		return replayed, err
+	}
+	if _, err := do("4111111111111111"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute - time.Nanosecond)
+	if replayed, err := do("4111111111111111"); err != nil || !replayed {
+		t.Errorf("Do within the TTL = %v, replayed %v; want the stored result", err, replayed)
+	}
+	now = now.Add(time.Nanosecond)
+	if n := c.Len(); n != 0 {
+		t.Errorf("%d entries after the TTL, want 0", n)
+	}
+	// After the TTL the key is free, even for other data.
+	if replayed, err := do("5500000000000004"); err != nil || replayed {
+		t.Errorf("Do after the TTL = %v, replayed %v; want a new result", err, replayed)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c := idempotency.New(0, 3)
+	var enc counter
+	for i := 1; i <= 4; i++ {
+		if _, _, err := c.Do(context.Background(), "s", fmt.Sprint("k", i), "pan", []byte("x"), enc.encrypt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.Len(); n != 3 {
+		t.Errorf("%d entries, want the maximum of 3", n)
+	}
+	for _, tc := range []struct {
+		key      string
+		replayed bool
+	}{
+		{key: "k4", replayed: true},
+		{key: "k2", replayed: true},
+		{key: "k1", replayed: false}, // the oldest was evicted
+	} {
+		if _, replayed, err := c.Do(context.Background(), "s", tc.key, "pan", []byte("x"), enc.encrypt); err != nil || replayed != tc.replayed {
+			t.Errorf("Do %s = %v, replayed %v; want replayed %v", tc.key, err, replayed, tc.replayed)
+		}
+	}
+}
+
+// Concurrent requests with one key run encrypt once; the others wait for it
+// and get its result.
+func TestConcurrentDo(t *testing.T) {
+	c := idempotency.New(0, 0)
+	var runs atomic.Int32
+	release := make(chan struct{})
+	encrypt := func() (idempotency.Result, error) {
+		runs.Add(1)
+		<-release
+		return idempotency.Result{Ciphertext: []byte("ct"), Nonce: []byte("nonce")}, nil
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	results := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, _, err := c.Do(context.Background(), "s", "k", "pan", []byte("x"), encrypt)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- string(res.Ciphertext)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond) // let the callers queue up behind the first
+	close(release)
+	wg.Wait()
+	close(results)
+	for ct := range results {
+		if ct != "ct" {
+			t.Errorf("caller got %q, want the one result", ct)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("encrypt ran %d times, want once", n)
+	}
+}
+
+// A failed attempt is not stored: a waiting request runs encrypt again, and a
+// waiting request whose context ends gives up.
+func TestConcurrentDoFailure(t *testing.T) {
+	c := idempotency.New(0, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	failing := func() (idempotency.Result, error) {
+		close(started)
+		<-release
+		return idempotency.Result{}, errors.New("backend unavailable")
+	}
+	first := make(chan error)
+	go func() {
+		_, _, err := c.Do(context.Background(), "s", "k", "pan", []byte("x"), failing)
+		first <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var enc counter
+	if _, _, err := c.Do(ctx, "s", "k", "pan", []byte("x"), enc.encrypt); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting Do whose context ends = %v, want DeadlineExceeded", err)
+	}
+
+	second := make(chan error)
+	go func() {
+		_, replayed, err := c.Do(context.Background(), "s", "k", "pan", []byte("x"), enc.encrypt)
+		if replayed {
+			err = errors.New("replayed a failed attempt")
+		}
+		second <- err
+	}()
+	close(release)
+	if err := <-first; err == nil {
+		t.Error("first Do succeeded, want the backend error")
+	}
+	if err := <-second; err != nil {
+		t.Errorf("Do waiting on the failed attempt = %v, want it to run encrypt itself", err)
+	}
+	if n := enc.runs.Load(); n != 1 {
+		t.Errorf("encrypt ran %d times after the failure, want once", n)
+	}
+}
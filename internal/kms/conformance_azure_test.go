@@ -0,0 +1,30 @@
+//go:build azure
+// +build azure
+
+package kms_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+func init() { taggedSetups["azure"] = setupAzure }
+
+func setupAzure(t *testing.T) kmstest.ProviderFactory {
+	vault := kmstest.NewAzureKeyVault()
+	t.Cleanup(vault.Close)
+	if err := vault.CreateRSAKey("kms-master-key"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := vault.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dekPath := filepath.Join(t.TempDir(), "azure-dek.json")
+	return func() (kms.HSMProvider, error) {
+		return kms.NewAzureKeyVaultProviderWithClient(client, "kms-master-key", dekPath, true)
+	}
+}
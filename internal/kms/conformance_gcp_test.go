@@ -0,0 +1,49 @@
+//go:build gcp
+// +build gcp
+
+package kms_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+func init() { taggedSetups["gcp"] = setupGCP }
+
+const gcpKeyName = "projects/conformance/locations/global/keyRings/kms/cryptoKeys/kms-master-key"
+
+func setupGCP(t *testing.T) kmstest.ProviderFactory {
+	fake := kmstest.NewGCPKMS()
+	t.Cleanup(fake.Close)
+	if err := fake.CreateKey(gcpKeyName); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg := kms.GCPConfig{
+		KeyName:         gcpKeyName,
+		DEKPath:         filepath.Join(dir, "gcp-dek.json"),
+		CreateDEK:       true,
+		CredentialsFile: writeServiceAccount(t, fake, dir),
+		Endpoint:        fake.URL,
+	}
+	return func() (kms.HSMProvider, error) {
+		return kms.NewGCPKMSProvider(cfg)
+	}
+}
+
+// writeServiceAccount writes a service account key file for fake to dir.
+func writeServiceAccount(t *testing.T, fake *kmstest.GCPKMS, dir string) string {
+	sa, err := fake.ServiceAccountJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "service-account.json")
+	if err := os.WriteFile(path, sa, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,30 @@
+//go:build pkcs11
+// +build pkcs11
+
+package kms_test
+
+import (
+	"testing"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+func init() { taggedSetups["pkcs11"] = setupSoftHSM }
+
+// setupSoftHSM provisions a throwaway SoftHSM2 token holding a
+// non-extractable AES-256 key. It skips when SoftHSM2 is not installed.
+func setupSoftHSM(t *testing.T) kmstest.ProviderFactory {
+	lib := kmstest.FindSoftHSM()
+	if lib == "" {
+		t.Skip("SoftHSM2 not found (set SOFTHSM2_LIB)")
+	}
+	restore, err := kmstest.NewSoftHSMToken(lib, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(restore)
+	return func() (kms.HSMProvider, error) {
+		return kms.NewPKCS11Provider(lib, 0, kmstest.SoftHSMPin, kmstest.SoftHSMKeyLabel)
+	}
+}
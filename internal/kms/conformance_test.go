@@ -0,0 +1,158 @@
+package kms_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+// conformanceSetup prepares a backend for one test and returns a factory
+// for providers sharing its key. It calls t.Skip when the backend cannot run
+// here and registers its cleanup with t.Cleanup.
+type conformanceSetup func(t *testing.T) kmstest.ProviderFactory
+
+// taggedSetups holds the stand-in setups of the backends that need a build
+// tag, by tag; the test file of each tag registers its own, so an entry also
+// tells that the tag's backend is compiled in.
+var taggedSetups = map[string]conformanceSetup{}
+
+// conformanceOptions keeps the concurrency check short under -short.
+func conformanceOptions() kmstest.ConformanceOptions {
+	if testing.Short() {
+		return kmstest.ConformanceOptions{Goroutines: 4, Iterations: 5}
+	}
+	return kmstest.ConformanceOptions{}
+}
+
+func TestConformance(t *testing.T) {
+	backends := []struct {
+		name  string
+		tag   string // build tag needed, if any
+		setup conformanceSetup
+	}{
+		{name: "fake", setup: setupFake},
+		{name: "failover", setup: setupFailover},
+		{name: "vault", setup: setupVault},
+		{name: "softhsm", tag: "pkcs11"},
+		{name: "azure", tag: "azure"},
+		{name: "gcp", tag: "gcp"},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			setup := b.setup
+			if setup == nil {
+				if setup = taggedSetups[b.tag]; setup == nil {
+					t.Skipf("not compiled (use build tag: %s)", b.tag)
+				}
+			}
+			if err := kmstest.TestProvider(setup(t), conformanceOptions()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestConformanceLive runs the suite against real key backends configured
+// by the same KMS_* variables as kms-server, e.g. KMS_VAULT_ADDR and
+// KMS_VAULT_KEY_NAME for Vault. Backends whose variables are unset are
+// skipped. The wrapped DEK is created in a temporary directory unless its
+// *_DEK_PATH variable is set.
+func TestConformanceLive(t *testing.T) {
+	backends := []struct {
+		hsmType  string
+		tag      string
+		required []string
+	}{
+		{hsmType: "pkcs11", tag: "pkcs11", required: []string{"KMS_PKCS11_LIB"}},
+		{hsmType: "vault", required: []string{"KMS_VAULT_ADDR", "KMS_VAULT_KEY_NAME"}},
+		{hsmType: "azure", tag: "azure", required: []string{"KMS_AZURE_VAULT_URL", "KMS_AZURE_KEY_NAME"}},
+		{hsmType: "gcp", tag: "gcp", required: []string{"KMS_GCP_KEY_NAME"}},
+		{hsmType: "aws", tag: "aws", required: []string{"KMS_AWS_KEY_ID"}},
+	}
+	for _, b := range backends {
+		t.Run(b.hsmType, func(t *testing.T) {
+			for _, name := range b.required {
+				if os.Getenv(name) == "" {
+					t.Skipf("%s not set", name)
+				}
+			}
+			if b.tag != "" && taggedSetups[b.tag] == nil {
+				t.Skipf("not compiled (use build tag: %s)", b.tag)
+			}
+			dir := t.TempDir()
+			env := func(name string) string {
+				v := os.Getenv(name)
+				switch {
+				case v != "":
+					return v
+				case strings.HasSuffix(name, "_DEK_PATH"):
+					return filepath.Join(dir, "dek.json")
+				case name == "KMS_CREATE_DEK":
+					return "true"
+				}
+				return v
+			}
+			open := func() (kms.HSMProvider, error) {
+				return kms.ProviderFromEnv(b.hsmType, env)
+			}
+			if err := kmstest.TestProvider(open, conformanceOptions()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func setupFake(t *testing.T) kmstest.ProviderFactory {
+	key := make([]byte, 32)
+	copy(key, "conformance-fake-provider-key")
+	return func() (kms.HSMProvider, error) {
+		return kmstest.NewProvider(key)
+	}
+}
+
+// setupFailover tests a failover provider over two fakes with a cloned key,
+// the primary failing its first call so the secondary has to serve it.
+func setupFailover(t *testing.T) kmstest.ProviderFactory {
+	key := make([]byte, 32)
+	copy(key, "conformance-failover-key")
+	return func() (kms.HSMProvider, error) {
+		primary, err := kmstest.NewProvider(key)
+		if err != nil {
+			return nil, err
+		}
+		secondary, err := kmstest.NewProvider(key)
+		if err != nil {
+			return nil, err
+		}
+		primary.FailNext(1, nil)
+		return kms.NewFailoverProvider([]kms.FailoverBackend{
+			{Name: "primary", Provider: primary},
+			{Name: "secondary", Provider: secondary},
+		}, kms.FailoverConfig{
+			HealthCheckInterval: -1,
+			OnServe:             func(kms.ServeEvent) {},
+		})
+	}
+}
+
+func setupVault(t *testing.T) kmstest.ProviderFactory {
+	vault := kmstest.NewVaultTransit()
+	t.Cleanup(vault.Close)
+	if err := vault.CreateKey("kms-master-key"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := kms.VaultConfig{
+		Address:   vault.URL,
+		Token:     vault.RootToken,
+		KeyName:   "kms-master-key",
+		DEKPath:   filepath.Join(t.TempDir(), "vault-dek.json"),
+		CreateDEK: true,
+	}
+	return func() (kms.HSMProvider, error) {
+		return kms.NewVaultTransitProvider(cfg)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sync"
@@ -60,7 +61,7 @@ func (m *FileManager) Encrypt(plaintext []byte) (ciphertext, nonce []byte, err e
 	defer m.mu.RUnlock()
 
 	if m.aead == nil {
-		return nil, nil, errors.New("kms manager not initialized")
+		return nil, nil, ErrSealed
 	}
 
 	nonce = make([]byte, m.aead.NonceSize())
@@ -78,18 +79,28 @@ func (m *FileManager) Decrypt(ciphertext, nonce []byte) ([]byte, error) {
 	defer m.mu.RUnlock()
 
 	if m.aead == nil {
-		return nil, errors.New("kms manager not initialized")
+		return nil, ErrSealed
+	}
+
+	// aead.Open panics on a nonce of the wrong size.
+	if len(nonce) != m.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidNonce, m.aead.NonceSize(), len(nonce))
 	}
 
 	plaintext, err := m.aead.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
 	}
 	return plaintext, nil
 }
 
-// Close releases resources (no-op for file-based manager).
+// Close clears the key from memory. Later calls fail, so a closed manager
+// reads as sealed to the health check.
 func (m *FileManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aead = nil
+
 	// Clear master key from memory
 	if m.masterKey != nil {
 		for i := range m.masterKey {
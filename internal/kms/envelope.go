@@ -0,0 +1,219 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dekSize is the size of locally generated data encryption keys (AES-256).
+const dekSize = 32
+
+// WrappedDEK is a data encryption key (DEK) wrapped by a remote key-encryption
+// key (KEK). This is the form in which envelope-mode providers persist their
+// DEK on disk: the plaintext DEK only ever lives in process memory.
+type WrappedDEK struct {
+	// KeyID identifies the KEK (including its version) that wrapped the DEK,
+	// so the DEK can still be unwrapped after the KEK has been rotated.
+	KeyID string `json:"key_id"`
+
+	// Algorithm is the provider-specific wrapping algorithm, e.g. "RSA-OAEP-256".
+	Algorithm string `json:"algorithm"`
+
+	// Wrapped is the wrapped DEK as returned by the provider.
+	Wrapped []byte `json:"wrapped"`
+}
+
+// dekWrapper is implemented by providers that protect a local DEK with a
+// remote KEK (Azure Key Vault, Vault Transit, Cloud KMS, ...).
+type dekWrapper interface {
+	WrapDEK(dek []byte) (*WrappedDEK, error)
+	UnwrapDEK(w *WrappedDEK) ([]byte, error)
+}
+
+// dekRewrapper is implemented by providers that can re-encrypt a wrapped DEK
+// under the newest KEK version without exposing the DEK. It reports whether
+// the wrapped DEK changed.
+type dekRewrapper interface {
+	RewrapDEK(w *WrappedDEK) (*WrappedDEK, bool, error)
+}
+
+// dekGenerator is implemented by providers whose backend can generate a data
+// key itself and return it both in plaintext and wrapped form.
+type dekGenerator interface {
+	GenerateDEK() ([]byte, *WrappedDEK, error)
+}
+
+// loadOrCreateDEK returns the DEK persisted at path, unwrapping it with w.
+// If no file exists yet and create is set, a fresh DEK is generated (by the
+// backend if w implements dekGenerator, locally otherwise), wrapped and
+// persisted. Without create a missing file is an error: a mistyped path must
+// not silently start the service on a new DEK that cannot read existing data.
+// If w also implements dekRewrapper, a DEK wrapped by an older KEK version is
+// rewrapped and persisted again after a successful unwrap.
+func loadOrCreateDEK(path string, create bool, w dekWrapper) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("wrapped DEK path is required for envelope mode")
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var wrapped WrappedDEK
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("failed to parse wrapped DEK %s: %w", path, err)
+		}
+		dek, err := w.UnwrapDEK(&wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap DEK %s: %w", path, err)
+		}
+		if len(dek) != dekSize {
+			return nil, fmt.Errorf("unwrapped DEK must be %d bytes, got %d", dekSize, len(dek))
+		}
+		if rw, ok := w.(dekRewrapper); ok {
+			rewrapped, changed, err := rw.RewrapDEK(&wrapped)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewrap DEK %s: %w", path, err)
+			}
+			if changed {
+				if err := saveWrappedDEK(path, rewrapped); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return dek, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !create {
+		return nil, fmt.Errorf("wrapped DEK %s does not exist; set KMS_CREATE_DEK=true (key.createDEK) to create a new DEK on first start", path)
+	}
+
+	var (
+		dek     []byte
+		wrapped *WrappedDEK
+	)
+	if g, ok := w.(dekGenerator); ok {
+		dek, wrapped, err = g.GenerateDEK()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate new DEK: %w", err)
+		}
+		if len(dek) != dekSize {
+			return nil, fmt.Errorf("generated DEK must be %d bytes, got %d", dekSize, len(dek))
+		}
+	} else {
+		dek = make([]byte, dekSize)
+		if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+			return nil, err
+		}
+		wrapped, err = w.WrapDEK(dek)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap new DEK: %w", err)
+		}
+	}
+	if err := saveWrappedDEK(path, wrapped); err != nil {
+		return nil, err
+	}
+	return dek, nil
+}
+
+// saveWrappedDEK writes the wrapped DEK atomically with owner-only permissions.
+func saveWrappedDEK(path string, w *WrappedDEK) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data, readable by the owner only. A
+// crash leaves either the old or the new file, never a partial one.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// envelopeCipher performs AES-GCM locally with an unwrapped DEK.
+type envelopeCipher struct {
+	mu   sync.RWMutex
+	dek  []byte
+	aead cipher.AEAD
+}
+
+func newEnvelopeCipher(dek []byte) (*envelopeCipher, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &envelopeCipher{dek: dek, aead: aead}, nil
+}
+
+func (e *envelopeCipher) seal(plaintext []byte) (ciphertext, nonce []byte, err error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.aead == nil {
+		return nil, nil, fmt.Errorf("%w: envelope DEK has been released", ErrSealed)
+	}
+
+	nonce = make([]byte, e.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return e.aead.Seal(nil, nonce, plaintext, nil), nonce, nil
+}
+
+func (e *envelopeCipher) open(ciphertext, nonce []byte) ([]byte, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.aead == nil {
+		return nil, fmt.Errorf("%w: envelope DEK has been released", ErrSealed)
+	}
+	if len(nonce) != e.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidNonce, e.aead.NonceSize(), len(nonce))
+	}
+	pt, err := e.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	return pt, nil
+}
+
+// wipe zeroes the DEK and drops the AEAD so no further operations succeed.
+func (e *envelopeCipher) wipe() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.dek {
+		e.dek[i] = 0
+	}
+	e.dek = nil
+	e.aead = nil
+}
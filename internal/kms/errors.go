@@ -0,0 +1,40 @@
+package kms
+
+import "errors"
+
+// Sentinel errors classify Manager and HSMProvider failures. Providers wrap
+// them (fmt.Errorf with %w) around the backend's own error, so callers test
+// with errors.Is and still see the backend detail in the message. The gRPC
+// server maps each class to a status code.
+var (
+	// ErrInvalidNonce: the nonce has the wrong size for the cipher.
+	ErrInvalidNonce = errors.New("invalid nonce")
+
+	// ErrInvalidCiphertext: the ciphertext is malformed, truncated or fails
+	// authentication (wrong key, nonce or tampered data).
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+	// ErrKeyNotFound: the key or key version does not exist in the backend.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrKeyExists: a key with the requested ID already exists.
+	ErrKeyExists = errors.New("key already exists")
+
+	// ErrInvalidKeyID: the key ID is not a valid named key ID.
+	ErrInvalidKeyID = errors.New("invalid key ID")
+
+	// ErrKeyDisabled: the key exists but is disabled, destroyed or not
+	// allowed for this version.
+	ErrKeyDisabled = errors.New("key is disabled")
+
+	// ErrPermissionDenied: the backend's policy refuses the operation.
+	ErrPermissionDenied = errors.New("operation denied by key policy")
+
+	// ErrUnavailable: the HSM or cloud KMS cannot be reached or failed
+	// transiently; the call may succeed if retried.
+	ErrUnavailable = errors.New("key backend unavailable")
+
+	// ErrSealed: the manager or provider has been closed and its key
+	// material released.
+	ErrSealed = errors.New("key manager is sealed")
+)
@@ -0,0 +1,22 @@
+package kms
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProviderFromEnv opens the hsmType backend from env as NewManagerFromLookup
+// does, for the tests of package kms_test.
+func ProviderFromEnv(hsmType string, env func(string) string) (HSMProvider, error) {
+	factory, ok := providerFactories[hsmType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported HSM type: %s", hsmType)
+	}
+	p, _, err := factory(env)
+	return p, err
+}
+
+// SetFailoverClock replaces the clock p uses for circuit timeouts.
+func SetFailoverClock(p *FailoverProvider, now func() time.Time) {
+	p.now = now
+}
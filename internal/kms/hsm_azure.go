@@ -5,35 +5,37 @@ package kms
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"io"
+	"net/http"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
 )
 
-// AzureKeyVaultProvider implements HSMProvider using Azure Key Vault.
+// AzureKeyVaultProvider implements HSMProvider using Azure Key Vault in envelope mode.
+//
+// A local 256-bit DEK is generated once, wrapped with the Key Vault key
+// (RSA-OAEP-256 for RSA keys, A256KW for AES keys) and persisted in wrapped
+// form. On startup the persisted DEK is unwrapped by Key Vault; the key
+// material of the Key Vault key itself never leaves the vault.
 type AzureKeyVaultProvider struct {
 	client   *azkeys.Client
 	keyName  string
-	key      []byte
-	aead     cipher.AEAD
-	ctx      context.Context
+	alg      azkeys.EncryptionAlgorithm
+	envelope *envelopeCipher
 }
 
 // NewAzureKeyVaultProvider creates a new Azure Key Vault provider.
 //
 // Parameters:
 //   - vaultURL: Azure Key Vault URL (e.g., "https://myvault.vault.azure.net/")
-//   - keyName: Name of the key in Key Vault
-func NewAzureKeyVaultProvider(vaultURL, keyName string) (*AzureKeyVaultProvider, error) {
-	ctx := context.Background()
-
+//   - keyName: Name of the RSA or AES key in Key Vault used to wrap the DEK
+//   - dekPath: File where the wrapped DEK is persisted
+//   - createDEK: Create the DEK when dekPath does not exist yet (first start)
+func NewAzureKeyVaultProvider(vaultURL, keyName, dekPath string, createDEK bool) (*AzureKeyVaultProvider, error) {
 	// Use DefaultAzureCredential (supports multiple auth methods)
 	cred, err := azidentity.NewDefaultAzureCredential(nil)
 	if err != nil {
@@ -45,65 +47,131 @@ func NewAzureKeyVaultProvider(vaultURL, keyName string) (*AzureKeyVaultProvider,
 		return nil, fmt.Errorf("failed to create Azure Key Vault client: %w", err)
 	}
 
-	// Get the key from Key Vault
-	keyResp, err := client.GetKey(ctx, keyName, "", nil)
+	return NewAzureKeyVaultProviderWithClient(client, keyName, dekPath, createDEK)
+}
+
+// NewAzureKeyVaultProviderWithClient creates a provider from an existing
+// azkeys client, e.g. one pointed at a Key Vault stand-in.
+func NewAzureKeyVaultProviderWithClient(client *azkeys.Client, keyName, dekPath string, createDEK bool) (*AzureKeyVaultProvider, error) {
+	keyResp, err := client.GetKey(context.Background(), keyName, "", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get key from Azure Key Vault: %w", err)
+		return nil, fmt.Errorf("failed to get key from Azure Key Vault: %w", classifyAzure(err))
+	}
+	if keyResp.Key == nil || keyResp.Key.Kty == nil {
+		return nil, errors.New("Azure Key Vault returned a key without a key type")
+	}
+
+	var alg azkeys.EncryptionAlgorithm
+	switch *keyResp.Key.Kty {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		alg = azkeys.EncryptionAlgorithmRSAOAEP256
+	case azkeys.KeyTypeOct, azkeys.KeyTypeOctHSM:
+		alg = azkeys.EncryptionAlgorithmA256KW
+	default:
+		return nil, fmt.Errorf("unsupported Key Vault key type %q (use an RSA or AES key)", *keyResp.Key.Kty)
+	}
+
+	p := &AzureKeyVaultProvider{
+		client:  client,
+		keyName: keyName,
+		alg:     alg,
 	}
 
-	// Extract key material (if available)
-	var key []byte
-	if keyResp.Key != nil && keyResp.Key.N != nil {
-		// RSA key - not suitable for AES, would need to use RSA encryption
-		return nil, errors.New("RSA keys not supported, use AES keys")
+	dek, err := loadOrCreateDEK(dekPath, createDEK, p)
+	if err != nil {
+		return nil, err
+	}
+	p.envelope, err = newEnvelopeCipher(dek)
+	if err != nil {
+		return nil, err
 	}
+	return p, nil
+}
 
-	// For AES keys, Azure Key Vault doesn't export key material directly
-	// We'll use Azure Key Vault's encrypt/decrypt operations
-	// For now, return error to indicate envelope encryption should be used
-	return nil, errors.New("Azure Key Vault requires using encrypt/decrypt operations directly")
+// WrapDEK wraps the DEK with the current version of the Key Vault key.
+func (a *AzureKeyVaultProvider) WrapDEK(dek []byte) (*WrappedDEK, error) {
+	resp, err := a.client.WrapKey(context.Background(), a.keyName, "", azkeys.KeyOperationParameters{
+		Algorithm: &a.alg,
+		Value:     dek,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Key Vault wrapKey failed: %w", classifyAzure(err))
+	}
+	if resp.KID == nil {
+		return nil, errors.New("Key Vault wrapKey returned no key identifier")
+	}
+	return &WrappedDEK{
+		KeyID:     string(*resp.KID),
+		Algorithm: string(a.alg),
+		Wrapped:   resp.Result,
+	}, nil
+}
 
-	// Note: Full implementation would use client.Encrypt() and client.Decrypt()
-	// but those require different key types and operations
+// UnwrapDEK unwraps the DEK with the Key Vault key version that wrapped it.
+func (a *AzureKeyVaultProvider) UnwrapDEK(w *WrappedDEK) ([]byte, error) {
+	kid := azkeys.ID(w.KeyID)
+	if kid.Name() != a.keyName {
+		return nil, fmt.Errorf("wrapped DEK belongs to key %q, not %q", kid.Name(), a.keyName)
+	}
+	alg := azkeys.EncryptionAlgorithm(w.Algorithm)
+	resp, err := a.client.UnwrapKey(context.Background(), a.keyName, kid.Version(), azkeys.KeyOperationParameters{
+		Algorithm: &alg,
+		Value:     w.Wrapped,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Key Vault unwrapKey failed: %w", classifyAzure(err))
+	}
+	return resp.Result, nil
 }
 
+// GetKey is disabled: the DEK is only ever persisted wrapped by Key Vault.
 func (a *AzureKeyVaultProvider) GetKey(keyID string) ([]byte, error) {
-	return a.key, nil
+	return nil, errors.New("security violation: cannot export the Key Vault protected DEK")
 }
 
 func (a *AzureKeyVaultProvider) Encrypt(keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
-	if a.aead == nil {
+	if a.envelope == nil {
 		return nil, nil, errors.New("Azure Key Vault provider not properly initialized")
 	}
-
-	nonce = make([]byte, a.aead.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, nil, err
-	}
-
-	ciphertext = a.aead.Seal(nil, nonce, plaintext, nil)
-	return ciphertext, nonce, nil
+	return a.envelope.seal(plaintext)
 }
 
 func (a *AzureKeyVaultProvider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte, error) {
-	if a.aead == nil {
+	if a.envelope == nil {
 		return nil, errors.New("Azure Key Vault provider not properly initialized")
 	}
-
-	plaintext, err := a.aead.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, err
-	}
-	return plaintext, nil
+	return a.envelope.open(ciphertext, nonce)
 }
 
 func (a *AzureKeyVaultProvider) Close() error {
-	if a.key != nil {
-		for i := range a.key {
-			a.key[i] = 0
-		}
-		a.key = nil
+	if a.envelope != nil {
+		a.envelope.wipe()
 	}
 	return nil
 }
 
+// classifyAzure wraps a Key Vault error with the matching sentinel error.
+// Errors without an HTTP response (network, credential) count as
+// unavailable.
+func classifyAzure(err error) error {
+	var re *azcore.ResponseError
+	if !errors.As(err, &re) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	var class error
+	switch {
+	case re.StatusCode == http.StatusNotFound:
+		class = ErrKeyNotFound
+	case re.StatusCode == http.StatusForbidden && strings.Contains(strings.ToLower(err.Error()), "disabled"):
+		class = ErrKeyDisabled
+	case re.StatusCode == http.StatusForbidden || re.StatusCode == http.StatusUnauthorized:
+		class = ErrPermissionDenied
+	case re.StatusCode == http.StatusBadRequest:
+		class = ErrInvalidCiphertext
+	case re.StatusCode == http.StatusTooManyRequests || re.StatusCode >= 500:
+		class = ErrUnavailable
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", class, err)
+}
@@ -6,7 +6,7 @@ package kms
 import "errors"
 
 // Stub when azure build tag is not set.
-func NewAzureKeyVaultProvider(vaultURL, keyName string) (HSMProvider, error) {
+func NewAzureKeyVaultProvider(vaultURL, keyName, dekPath string, createDEK bool) (HSMProvider, error) {
 	return nil, errors.New("Azure Key Vault support not compiled (use build tag: azure)")
 }
 
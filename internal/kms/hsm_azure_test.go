@@ -0,0 +1,226 @@
+//go:build azure
+// +build azure
+
+package kms_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+const azureKeyName = "kms-master-key"
+
+// newAzureVault starts a Key Vault stand-in holding one key, RSA or AES.
+func newAzureVault(t *testing.T, aes bool) *kmstest.AzureKeyVault {
+	t.Helper()
+	vault := kmstest.NewAzureKeyVault()
+	t.Cleanup(vault.Close)
+	create := vault.CreateRSAKey
+	if aes {
+		create = vault.CreateAESKey
+	}
+	if err := create(azureKeyName); err != nil {
+		t.Fatal(err)
+	}
+	return vault
+}
+
+func openAzure(t *testing.T, vault *kmstest.AzureKeyVault, cred *kmstest.StaticCredential, dekPath string) (*kms.AzureKeyVaultProvider, error) {
+	t.Helper()
+	if cred == nil {
+		cred = &kmstest.StaticCredential{}
+	}
+	client, err := vault.NewClient(cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kms.NewAzureKeyVaultProviderWithClient(client, azureKeyName, dekPath, true)
+}
+
+func TestAzureWrapAlgorithms(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		aes  bool
+		alg  string
+	}{
+		{name: "RSA", alg: "RSA-OAEP-256"},
+		{name: "AES", aes: true, alg: "A256KW"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vault := newAzureVault(t, tc.aes)
+			dekPath := filepath.Join(t.TempDir(), "dek.json")
+			p, err := openAzure(t, vault, nil, dekPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ct, nonce, err := p.Encrypt(azureKeyName, []byte("4111111111111111"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := readWrappedDEK(t, dekPath)
+			if w.Algorithm != tc.alg {
+				t.Errorf("wrapped with %q, want %q", w.Algorithm, tc.alg)
+			}
+			if !strings.HasPrefix(w.KeyID, vault.URL+"/keys/"+azureKeyName+"/") {
+				t.Errorf("key ID %q does not name a version of %s", w.KeyID, azureKeyName)
+			}
+			if len(w.Wrapped) == 0 || bytes.Contains(w.Wrapped, ct) {
+				t.Errorf("wrapped DEK is empty or malformed")
+			}
+
+			// A second provider unwraps the persisted DEK through Key Vault.
+			p2, err := openAzure(t, vault, nil, dekPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pt, err := p2.Decrypt(azureKeyName, ct, nonce)
+			if err != nil || string(pt) != "4111111111111111" {
+				t.Fatalf("Decrypt = %q, %v", pt, err)
+			}
+		})
+	}
+}
+
+// After a rotation the latest version is a different key, so the DEK only
+// unwraps if the provider asks for the version recorded in the envelope.
+func TestAzureUnwrapAfterRotation(t *testing.T) {
+	for _, aes := range []bool{false, true} {
+		vault := newAzureVault(t, aes)
+		dekPath := filepath.Join(t.TempDir(), "dek.json")
+		p, err := openAzure(t, vault, nil, dekPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, nonce, err := p.Encrypt(azureKeyName, []byte("before rotation"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := readWrappedDEK(t, dekPath)
+
+		if err := vault.RotateKey(azureKeyName); err != nil {
+			t.Fatal(err)
+		}
+		p2, err := openAzure(t, vault, nil, dekPath)
+		if err != nil {
+			t.Fatalf("aes=%t: open after rotation: %v", aes, err)
+		}
+		pt, err := p2.Decrypt(azureKeyName, ct, nonce)
+		if err != nil || string(pt) != "before rotation" {
+			t.Fatalf("aes=%t: Decrypt after rotation = %q, %v", aes, pt, err)
+		}
+		if after := readWrappedDEK(t, dekPath); after.KeyID != before.KeyID {
+			t.Errorf("aes=%t: envelope key ID changed from %q to %q", aes, before.KeyID, after.KeyID)
+		}
+	}
+}
+
+func TestAzureTokenRefresh(t *testing.T) {
+	vault := newAzureVault(t, false)
+	dekPath := filepath.Join(t.TempDir(), "dek.json")
+
+	// A long-lived token is fetched once and reused.
+	cached := &kmstest.StaticCredential{}
+	client, err := vault.NewClient(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := kms.NewAzureKeyVaultProviderWithClient(client, azureKeyName, dekPath, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cached.Calls(); n != 1 {
+		t.Errorf("long-lived token requested %d times, want 1", n)
+	}
+
+	// An expired token is replaced before the next request.
+	expiring := &kmstest.StaticCredential{Lifetime: -time.Second}
+	client, err = vault.NewClient(expiring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := kms.NewAzureKeyVaultProviderWithClient(client, azureKeyName, dekPath, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := expiring.Calls(); n < 3 {
+		t.Errorf("expiring token requested %d times, want a new one per provider", n)
+	}
+}
+
+func TestAzureErrors(t *testing.T) {
+	vault := newAzureVault(t, false)
+	dir := t.TempDir()
+	dekPath := filepath.Join(dir, "dek.json")
+	if _, err := openAzure(t, vault, nil, dekPath); err != nil {
+		t.Fatal(err)
+	}
+	good := readWrappedDEK(t, dekPath)
+
+	writeDEK := func(name string, w kms.WrappedDEK) string {
+		path := filepath.Join(dir, name+".json")
+		data, _ := json.Marshal(w)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tampered := good
+	tampered.Wrapped = append([]byte(nil), good.Wrapped...)
+	tampered.Wrapped[0] ^= 0xff
+	unknownVersion := good
+	unknownVersion.KeyID = good.KeyID[:strings.LastIndex(good.KeyID, "/")+1] + "0000"
+	otherKey := good
+	otherKey.KeyID = strings.Replace(good.KeyID, "/keys/"+azureKeyName+"/", "/keys/other/", 1)
+
+	for _, tc := range []struct {
+		name    string
+		keyName string
+		cred    *kmstest.StaticCredential
+		dekPath string
+		want    error  // sentinel, if any
+		message string // substring, if no sentinel
+	}{
+		{name: "missing key", keyName: "no-such-key", dekPath: dekPath, want: kms.ErrKeyNotFound},
+		{name: "credential failure", cred: &kmstest.StaticCredential{Err: errors.New("no managed identity")}, dekPath: dekPath, want: kms.ErrUnavailable},
+		{name: "tampered DEK", dekPath: writeDEK("tampered", tampered), want: kms.ErrInvalidCiphertext},
+		{name: "unknown key version", dekPath: writeDEK("unknown-version", unknownVersion), want: kms.ErrKeyNotFound},
+		{name: "DEK of another key", dekPath: writeDEK("other-key", otherKey), message: `belongs to key "other"`},
+		{name: "missing DEK", dekPath: filepath.Join(dir, "missing.json"), message: "missing.json does not exist"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keyName := tc.keyName
+			if keyName == "" {
+				keyName = azureKeyName
+			}
+			cred := tc.cred
+			if cred == nil {
+				cred = &kmstest.StaticCredential{}
+			}
+			client, err := vault.NewClient(cred)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = kms.NewAzureKeyVaultProviderWithClient(client, keyName, tc.dekPath, false)
+			switch {
+			case err == nil:
+				t.Fatal("provider opened, want an error")
+			case tc.want != nil && !errors.Is(err, tc.want):
+				t.Errorf("error %v, want %v", err, tc.want)
+			case tc.message != "" && !strings.Contains(err.Error(), tc.message):
+				t.Errorf("error %v, want it to mention %s", err, tc.message)
+			}
+		})
+	}
+}
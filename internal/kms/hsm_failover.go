@@ -0,0 +1,427 @@
+package kms
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailoverBackend is one member of a FailoverProvider.
+type FailoverBackend struct {
+	// Name identifies the backend in logs, ServeEvents and Status.
+	Name string
+
+	// Provider is the underlying HSM provider.
+	Provider HSMProvider
+
+	// KeyID is passed to Provider; each backend may name its key differently.
+	KeyID string
+}
+
+// FailoverConfig tunes health checking and circuit breaking.
+type FailoverConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens a
+	// backend's circuit (default 3).
+	FailureThreshold int
+
+	// OpenTimeout is how long an open circuit rejects calls before a single
+	// trial call is let through (default 30s).
+	OpenTimeout time.Duration
+
+	// HealthCheckInterval is the period of the background health check
+	// (default 10s). A negative value disables it.
+	HealthCheckInterval time.Duration
+
+	// OnServe, if set, is called after every Encrypt/Decrypt with the backend
+	// that served it. When nil, calls served by a non-primary backend are logged.
+	OnServe func(ServeEvent)
+}
+
+// ServeEvent reports which backend served a call.
+type ServeEvent struct {
+	Op       string // "encrypt" or "decrypt"
+	Backend  string // empty if no backend succeeded
+	Attempts int    // number of backends tried
+	Err      error
+	Duration time.Duration
+}
+
+// HealthChecker may be implemented by providers that have a cheaper or more
+// precise liveness check than an encrypt/decrypt round trip.
+type HealthChecker interface {
+	HealthCheck() error
+}
+
+// BackendStatus is a snapshot of one backend's circuit.
+type BackendStatus struct {
+	Name                string
+	State               string // "closed", "open" or "half-open"
+	ConsecutiveFailures int
+	LastError           string
+	LastCheck           time.Time
+	Served              uint64
+	Failed              uint64
+}
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var circuitStateNames = [...]string{"closed", "open", "half-open"}
+
+type failoverMember struct {
+	FailoverBackend
+
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openedAt  time.Time
+	trial     bool // a half-open trial call is in flight
+	lastErr   error
+	lastCheck time.Time
+	served    uint64
+	failed    uint64
+}
+
+// FailoverProvider is an HSMProvider that spreads calls over a primary and one
+// or more secondary providers.
+//
+// Encrypt goes to the first backend (in configuration order) whose circuit is
+// not open; failures open the circuit after FailureThreshold consecutive errors
+// and the next backend is tried. Decrypt tries every available backend in
+// order until one succeeds. Decrypt errors do not count against a backend,
+// since a wrong key and a broken backend cannot be told apart there; the
+// health check settles that.
+//
+// All backends must be able to decrypt each other's ciphertexts, e.g. PKCS#11
+// HSMs holding the same cloned key. Backends with independent key material
+// (HSM plus cloud KMS) still work for availability, but a ciphertext is then
+// only readable by the backend that produced it.
+type FailoverProvider struct {
+	members []*failoverMember
+	cfg     FailoverConfig
+	now     func() time.Time
+
+	stop     chan struct{}
+	done     chan struct{}
+	closeMu  sync.Mutex
+	isClosed bool
+}
+
+// NewFailoverProvider builds a FailoverProvider. The first backend is the
+// primary. The health check starts immediately unless disabled.
+func NewFailoverProvider(backends []FailoverBackend, cfg FailoverConfig) (*FailoverProvider, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("failover provider requires at least one backend")
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HealthCheckInterval == 0 {
+		cfg.HealthCheckInterval = 10 * time.Second
+	}
+
+	p := &FailoverProvider{
+		cfg:  cfg,
+		now:  time.Now,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	seen := make(map[string]bool)
+	for i, b := range backends {
+		if b.Provider == nil {
+			return nil, fmt.Errorf("failover backend %d has no provider", i)
+		}
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("backend-%d", i)
+		}
+		if seen[b.Name] {
+			return nil, fmt.Errorf("duplicate failover backend name %q", b.Name)
+		}
+		seen[b.Name] = true
+		p.members = append(p.members, &failoverMember{FailoverBackend: b})
+	}
+
+	if cfg.HealthCheckInterval > 0 {
+		go p.healthLoop()
+	} else {
+		close(p.done)
+	}
+	return p, nil
+}
+
+// GetKey is not supported; the member providers keep their keys.
+func (p *FailoverProvider) GetKey(keyID string) ([]byte, error) {
+	return nil, errors.New("security violation: failover provider does not export keys")
+}
+
+// Encrypt encrypts with the first healthy backend. keyID is ignored in favour
+// of each backend's configured KeyID.
+func (p *FailoverProvider) Encrypt(keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	start := p.now()
+	var errs []error
+	attempts := 0
+	for _, m := range p.members {
+		if !m.allow(p.now(), p.cfg.OpenTimeout) {
+			continue
+		}
+		attempts++
+		ct, n, e := m.Provider.Encrypt(m.KeyID, plaintext)
+		if e == nil {
+			m.success(true)
+			p.served("encrypt", m.Name, attempts, nil, start)
+			return ct, n, nil
+		}
+		p.recordFailure(m, e)
+		errs = append(errs, fmt.Errorf("%s: %w", m.Name, e))
+	}
+	err = p.exhausted(errs)
+	p.served("encrypt", "", attempts, err, start)
+	return nil, nil, err
+}
+
+// Decrypt tries each available backend in order until one succeeds.
+func (p *FailoverProvider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte, error) {
+	start := p.now()
+	var errs []error
+	attempts := 0
+	for _, m := range p.members {
+		if !m.allow(p.now(), p.cfg.OpenTimeout) {
+			continue
+		}
+		attempts++
+		pt, e := m.Provider.Decrypt(m.KeyID, ciphertext, nonce)
+		if e == nil {
+			m.success(false)
+			p.served("decrypt", m.Name, attempts, nil, start)
+			return pt, nil
+		}
+		m.release()
+		errs = append(errs, fmt.Errorf("%s: %w", m.Name, e))
+	}
+	err := p.exhausted(errs)
+	p.served("decrypt", "", attempts, err, start)
+	return nil, err
+}
+
+// Close stops the health check and closes every backend.
+func (p *FailoverProvider) Close() error {
+	p.closeMu.Lock()
+	if p.isClosed {
+		p.closeMu.Unlock()
+		return nil
+	}
+	p.isClosed = true
+	p.closeMu.Unlock()
+
+	if p.cfg.HealthCheckInterval > 0 {
+		close(p.stop)
+	}
+	<-p.done
+
+	var errs []error
+	for _, m := range p.members {
+		if err := m.Provider.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns a snapshot of every backend's circuit, primary first.
+func (p *FailoverProvider) Status() []BackendStatus {
+	out := make([]BackendStatus, 0, len(p.members))
+	for _, m := range p.members {
+		m.mu.Lock()
+		st := BackendStatus{
+			Name:                m.Name,
+			State:               circuitStateNames[m.state],
+			ConsecutiveFailures: m.failures,
+			LastCheck:           m.lastCheck,
+			Served:              m.served,
+			Failed:              m.failed,
+		}
+		if m.lastErr != nil {
+			st.LastError = m.lastErr.Error()
+		}
+		m.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+// CheckHealth runs one health check pass over all backends. It is called
+// periodically in the background and may be called directly.
+func (p *FailoverProvider) CheckHealth() {
+	for _, m := range p.members {
+		err := probeProvider(m.Provider, m.KeyID)
+		m.mu.Lock()
+		m.lastCheck = p.now()
+		m.mu.Unlock()
+		if err != nil {
+			p.recordFailure(m, err)
+			continue
+		}
+		if m.recover() {
+			slog.Info("KMS failover: backend recovered", "backend", m.Name)
+		}
+	}
+}
+
+func (p *FailoverProvider) healthLoop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.CheckHealth()
+		}
+	}
+}
+
+// probeProvider checks a backend with its HealthCheck method, or else with an
+// encrypt/decrypt round trip of a fixed probe value.
+func probeProvider(provider HSMProvider, keyID string) error {
+	if hc, ok := provider.(HealthChecker); ok {
+		return hc.HealthCheck()
+	}
+	probe := []byte("kms-health-probe")
+	ct, nonce, err := provider.Encrypt(keyID, probe)
+	if err != nil {
+		return fmt.Errorf("probe encrypt: %w", err)
+	}
+	pt, err := provider.Decrypt(keyID, ct, nonce)
+	if err != nil {
+		return fmt.Errorf("probe decrypt: %w", err)
+	}
+	if string(pt) != string(probe) {
+		return errors.New("probe round trip returned wrong plaintext")
+	}
+	return nil
+}
+
+func (p *FailoverProvider) recordFailure(m *failoverMember, err error) {
+	if m.failure(err, p.now(), p.cfg.FailureThreshold) {
+		slog.Warn("KMS failover: circuit opened", "backend", m.Name, "err", err)
+	}
+}
+
+func (p *FailoverProvider) served(op, backend string, attempts int, err error, start time.Time) {
+	ev := ServeEvent{Op: op, Backend: backend, Attempts: attempts, Err: err, Duration: p.now().Sub(start)}
+	if p.cfg.OnServe != nil {
+		p.cfg.OnServe(ev)
+		return
+	}
+	if backend != "" && backend != p.members[0].Name {
+		slog.Warn("KMS failover: served by secondary backend", "op", op, "backend", backend)
+	}
+}
+
+func (p *FailoverProvider) exhausted(errs []error) error {
+	if len(errs) == 0 {
+		return fmt.Errorf("%w: all failover backends are unavailable (circuits open)", ErrUnavailable)
+	}
+	return &failoverError{errs: errs}
+}
+
+// failoverError collects the error of every backend tried. errors.Is matches
+// any of them, so a ciphertext that every backend rejects still reads as
+// ErrInvalidCiphertext.
+type failoverError struct {
+	errs []error
+}
+
+func (e *failoverError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return "all failover backends failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *failoverError) Unwrap() []error { return e.errs }
+
+// allow reports whether a call may go to this backend, moving an open circuit
+// to half-open once OpenTimeout has passed. Only one trial runs at a time.
+func (m *failoverMember) allow(now time.Time, openTimeout time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.state {
+	case circuitOpen:
+		if now.Sub(m.openedAt) < openTimeout {
+			return false
+		}
+		m.state = circuitHalfOpen
+		m.trial = true
+		return true
+	case circuitHalfOpen:
+		if m.trial {
+			return false
+		}
+		m.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// success closes the circuit. Decrypt successes only count toward Served.
+func (m *failoverMember) success(closeCircuit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.served++
+	m.trial = false
+	if closeCircuit || m.state == circuitHalfOpen {
+		m.state = circuitClosed
+		m.failures = 0
+	}
+}
+
+// release ends a half-open trial without judging the backend.
+func (m *failoverMember) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trial = false
+}
+
+// failure records an error and reports whether the circuit just opened.
+func (m *failoverMember) failure(err error, now time.Time, threshold int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+	m.failures++
+	m.lastErr = err
+	m.trial = false
+	if m.state == circuitHalfOpen || (m.state == circuitClosed && m.failures >= threshold) {
+		m.state = circuitOpen
+		m.openedAt = now
+		return true
+	}
+	if m.state == circuitOpen {
+		m.openedAt = now
+	}
+	return false
+}
+
+// recover closes the circuit after a passing health check and reports whether
+// it was open before.
+func (m *failoverMember) recover() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wasOpen := m.state != circuitClosed
+	m.state = circuitClosed
+	m.failures = 0
+	m.lastErr = nil
+	return wasOpen
+}
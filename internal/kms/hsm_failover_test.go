@@ -0,0 +1,261 @@
+package kms_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+// failoverFixture is a FailoverProvider over two kmstest providers with a
+// cloned key, a manual clock and a record of its ServeEvents.
+type failoverFixture struct {
+	*kms.FailoverProvider
+	primary, secondary *kmstest.Provider
+
+	mu     sync.Mutex
+	now    time.Time
+	events []kms.ServeEvent
+}
+
+const (
+	failoverThreshold = 3
+	failoverTimeout   = 30 * time.Second
+)
+
+func newFailover(t *testing.T) *failoverFixture {
+	t.Helper()
+	key := make([]byte, 32)
+	copy(key, "failover-test-key")
+	f := &failoverFixture{now: time.Unix(1700000000, 0)}
+	var err error
+	if f.primary, err = kmstest.NewProvider(key); err != nil {
+		t.Fatal(err)
+	}
+	if f.secondary, err = kmstest.NewProvider(key); err != nil {
+		t.Fatal(err)
+	}
+	f.FailoverProvider, err = kms.NewFailoverProvider([]kms.FailoverBackend{
+		{Name: "primary", Provider: f.primary},
+		{Name: "secondary", Provider: f.secondary},
+	}, kms.FailoverConfig{
+		FailureThreshold:    failoverThreshold,
+		OpenTimeout:         failoverTimeout,
+		HealthCheckInterval: -1,
+		OnServe: func(ev kms.ServeEvent) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.events = append(f.events, ev)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	kms.SetFailoverClock(f.FailoverProvider, func() time.Time {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.now
+	})
+	return f
+}
+
+func (f *failoverFixture) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// lastEvent returns the ServeEvent of the latest call.
+func (f *failoverFixture) lastEvent(t *testing.T) kms.ServeEvent {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.events) == 0 {
+		t.Fatal("no ServeEvent reported")
+	}
+	return f.events[len(f.events)-1]
+}
+
+// encrypt encrypts through the failover provider and checks which backend
+// OnServe reports and after how many attempts.
+func (f *failoverFixture) encrypt(t *testing.T, backend string, attempts int) {
+	t.Helper()
+	if _, _, err := f.Encrypt("", []byte("4111111111111111")); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	ev := f.lastEvent(t)
+	if ev.Op != "encrypt" || ev.Backend != backend || ev.Attempts != attempts || ev.Err != nil {
+		t.Errorf("ServeEvent %+v, want encrypt served by %s after %d attempts", ev, backend, attempts)
+	}
+}
+
+func (f *failoverFixture) state(t *testing.T, backend string) kms.BackendStatus {
+	t.Helper()
+	for _, st := range f.Status() {
+		if st.Name == backend {
+			return st
+		}
+	}
+	t.Fatalf("no status for backend %s", backend)
+	return kms.BackendStatus{}
+}
+
+// openPrimary fails the primary until its circuit opens.
+func (f *failoverFixture) openPrimary(t *testing.T) {
+	t.Helper()
+	f.primary.FailNext(failoverThreshold, nil)
+	for i := 0; i < failoverThreshold; i++ {
+		f.encrypt(t, "secondary", 2)
+	}
+	if st := f.state(t, "primary"); st.State != "open" {
+		t.Fatalf("primary circuit %s after %d failures, want open", st.State, failoverThreshold)
+	}
+}
+
+func TestFailoverBreakerOpens(t *testing.T) {
+	f := newFailover(t)
+	f.encrypt(t, "primary", 1)
+
+	// Failures below the threshold leave the circuit closed.
+	f.primary.FailNext(failoverThreshold-1, nil)
+	for i := 0; i < failoverThreshold-1; i++ {
+		f.encrypt(t, "secondary", 2)
+	}
+	if st := f.state(t, "primary"); st.State != "closed" || st.ConsecutiveFailures != failoverThreshold-1 {
+		t.Fatalf("primary %+v, want closed with %d failures", st, failoverThreshold-1)
+	}
+	// A success resets the count, so the next failures start from zero.
+	f.encrypt(t, "primary", 1)
+	if st := f.state(t, "primary"); st.ConsecutiveFailures != 0 {
+		t.Fatalf("primary has %d consecutive failures after a success", st.ConsecutiveFailures)
+	}
+
+	f.openPrimary(t)
+	st := f.state(t, "primary")
+	if st.LastError == "" || st.Failed != 2*failoverThreshold-1 {
+		t.Errorf("primary %+v, want the injected error and %d failures recorded", st, 2*failoverThreshold-1)
+	}
+
+	// While open the primary is skipped without being called.
+	calls := f.primary.Calls()
+	f.encrypt(t, "secondary", 1)
+	if n := f.primary.Calls() - calls; n != 0 {
+		t.Errorf("open primary called %d times", n)
+	}
+}
+
+func TestFailoverHalfOpen(t *testing.T) {
+	f := newFailover(t)
+	f.openPrimary(t)
+
+	// Before the timeout the circuit stays open.
+	f.advance(failoverTimeout - time.Second)
+	f.encrypt(t, "secondary", 1)
+
+	// After it one trial call goes to the primary; a failed trial opens the
+	// circuit again for another full timeout.
+	f.advance(time.Second)
+	f.primary.FailNext(1, nil)
+	calls := f.primary.Calls()
+	f.encrypt(t, "secondary", 2)
+	if n := f.primary.Calls() - calls; n != 1 {
+		t.Errorf("primary got %d trial calls, want 1", n)
+	}
+	if st := f.state(t, "primary"); st.State != "open" {
+		t.Fatalf("primary circuit %s after a failed trial, want open", st.State)
+	}
+	f.advance(failoverTimeout - time.Second)
+	f.encrypt(t, "secondary", 1)
+
+	// A successful trial closes the circuit and the primary serves again.
+	f.advance(time.Second)
+	f.encrypt(t, "primary", 1)
+	if st := f.state(t, "primary"); st.State != "closed" || st.ConsecutiveFailures != 0 {
+		t.Errorf("primary %+v after a successful trial, want closed", st)
+	}
+	f.encrypt(t, "primary", 1)
+}
+
+// A passing health check closes an open circuit without waiting for the
+// timeout; a failing one keeps it open.
+func TestFailoverHealthCheckRecovery(t *testing.T) {
+	f := newFailover(t)
+	f.openPrimary(t)
+
+	f.primary.SetDown(nil)
+	f.CheckHealth()
+	if st := f.state(t, "primary"); st.State != "open" {
+		t.Fatalf("primary circuit %s after a failed health check, want open", st.State)
+	}
+
+	f.primary.Recover()
+	f.CheckHealth()
+	st := f.state(t, "primary")
+	if st.State != "closed" || st.LastError != "" || st.LastCheck.IsZero() {
+		t.Fatalf("primary %+v after a passing health check, want closed", st)
+	}
+	f.encrypt(t, "primary", 1)
+}
+
+func TestFailoverDecrypt(t *testing.T) {
+	f := newFailover(t)
+	ct, nonce, err := f.Encrypt("", []byte("4111111111111111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The secondary decrypts what the primary encrypted. Decrypt failures
+	// do not count against the primary.
+	f.primary.FailNext(failoverThreshold+1, nil)
+	for i := 0; i <= failoverThreshold; i++ {
+		pt, err := f.Decrypt("", ct, nonce)
+		if err != nil || string(pt) != "4111111111111111" {
+			t.Fatalf("Decrypt = %q, %v", pt, err)
+		}
+		if ev := f.lastEvent(t); ev.Op != "decrypt" || ev.Backend != "secondary" || ev.Attempts != 2 {
+			t.Errorf("ServeEvent %+v, want decrypt served by secondary after 2 attempts", ev)
+		}
+	}
+	if st := f.state(t, "primary"); st.State != "closed" || st.ConsecutiveFailures != 0 {
+		t.Errorf("primary %+v after decrypt failures, want closed", st)
+	}
+
+	// A ciphertext no backend accepts reports every backend's error.
+	bad := append([]byte(nil), ct...)
+	bad[0] ^= 0xff
+	if _, err := f.Decrypt("", bad, nonce); !errors.Is(err, kms.ErrInvalidCiphertext) {
+		t.Errorf("Decrypt of a corrupt ciphertext = %v, want ErrInvalidCiphertext", err)
+	}
+	if ev := f.lastEvent(t); ev.Backend != "" || ev.Attempts != 2 || ev.Err == nil {
+		t.Errorf("ServeEvent %+v, want a failure after 2 attempts", ev)
+	}
+}
+
+func TestFailoverAllOpen(t *testing.T) {
+	f := newFailover(t)
+	f.primary.SetDown(nil)
+	f.secondary.SetDown(nil)
+	for i := 0; i < failoverThreshold; i++ {
+		if _, _, err := f.Encrypt("", []byte("x")); !errors.Is(err, kms.ErrUnavailable) {
+			t.Fatalf("Encrypt with both backends down = %v, want ErrUnavailable", err)
+		}
+	}
+
+	// With both circuits open nothing is tried.
+	_, _, err := f.Encrypt("", []byte("x"))
+	if !errors.Is(err, kms.ErrUnavailable) {
+		t.Fatalf("Encrypt with both circuits open = %v, want ErrUnavailable", err)
+	}
+	if ev := f.lastEvent(t); ev.Backend != "" || ev.Attempts != 0 || ev.Err == nil {
+		t.Errorf("ServeEvent %+v, want a failure with no attempts", ev)
+	}
+
+	// The secondary's trial succeeds first once it is back.
+	f.secondary.Recover()
+	f.advance(failoverTimeout)
+	f.encrypt(t, "secondary", 2)
+}
@@ -0,0 +1,430 @@
+//go:build gcp
+// +build gcp
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	gcpDefaultEndpoint = "https://cloudkms.googleapis.com"
+	gcpKMSScope        = "https://www.googleapis.com/auth/cloudkms"
+	gcpMetadataHost    = "metadata.google.internal"
+	gcpMetadataToken   = "/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// GCPKMSProvider implements HSMProvider using Google Cloud KMS in envelope mode.
+//
+// A local 256-bit DEK is encrypted with a symmetric Cloud KMS CryptoKey and
+// persisted in wrapped form together with the CryptoKeyVersion that wrapped
+// it. On startup the DEK is decrypted by Cloud KMS; if the key's primary
+// version has been rotated since, the DEK is re-encrypted under the new
+// primary version. All calls use the REST API with CRC32C integrity checks.
+//
+// The provider talks REST directly instead of using cloud.google.com/go/kms:
+// the SDK would pull google.golang.org/api, gax and genproto (gRPC and
+// protobuf stubs for every Google API) into the server for the three calls
+// made here, cryptoKeys get, encrypt and decrypt, once per start. Tokens come
+// from a static access token, a service account key (JWT bearer grant) or
+// the metadata server, which covers GCE, Cloud Run and GKE Workload
+// Identity. Other credential types, such as external_account for workload
+// identity federation, are not supported; get a token with gcloud or the
+// federation tooling and pass it as AccessToken instead.
+type GCPKMSProvider struct {
+	cfg      GCPConfig
+	client   *http.Client
+	tokens   *gcpTokenSource
+	envelope *envelopeCipher
+}
+
+// NewGCPKMSProvider creates a new Google Cloud KMS provider.
+func NewGCPKMSProvider(cfg GCPConfig) (*GCPKMSProvider, error) {
+	if cfg.KeyName == "" {
+		return nil, errors.New("Cloud KMS key name is required")
+	}
+	if strings.Contains(cfg.KeyName, "/cryptoKeyVersions/") {
+		return nil, errors.New("Cloud KMS key name must be a CryptoKey, not a CryptoKeyVersion")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = gcpDefaultEndpoint
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	tokens, err := newGCPTokenSource(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &GCPKMSProvider{
+		cfg:    cfg,
+		client: client,
+		tokens: tokens,
+	}
+
+	dek, err := loadOrCreateDEK(cfg.DEKPath, cfg.CreateDEK, p)
+	if err != nil {
+		return nil, err
+	}
+	p.envelope, err = newEnvelopeCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// gcpError is returned for non-2xx responses from Cloud KMS.
+type gcpError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *gcpError) Error() string {
+	return fmt.Sprintf("Cloud KMS returned HTTP %d %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
+// Unwrap classifies the response by its google.rpc status so errors.Is
+// matches the kms sentinels.
+func (e *gcpError) Unwrap() error {
+	switch e.Status {
+	case "NOT_FOUND":
+		return ErrKeyNotFound
+	case "FAILED_PRECONDITION":
+		// Disabled, destroyed or scheduled-for-destruction key versions.
+		return ErrKeyDisabled
+	case "PERMISSION_DENIED":
+		return ErrPermissionDenied
+	case "INVALID_ARGUMENT":
+		return ErrInvalidCiphertext
+	case "UNAVAILABLE", "INTERNAL", "DEADLINE_EXCEEDED", "RESOURCE_EXHAUSTED":
+		return ErrUnavailable
+	}
+	if e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func (p *GCPKMSProvider) call(method, resource string, body, out interface{}) error {
+	token, err := p.tokens.Token()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.cfg.Endpoint+"/v1/"+resource, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errBody struct {
+			Error struct {
+				Message string `json:"message"`
+				Status  string `json:"status"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errBody)
+		return &gcpError{StatusCode: resp.StatusCode, Status: errBody.Error.Status, Message: errBody.Error.Message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// gcpCRC returns the CRC32C of data in the decimal string form used by the
+// Cloud KMS JSON API (int64 fields are encoded as strings).
+func gcpCRC(data []byte) string {
+	return strconv.FormatUint(uint64(crc32.Checksum(data, crc32c)), 10)
+}
+
+// WrapDEK encrypts the DEK with the primary version of the CryptoKey.
+func (p *GCPKMSProvider) WrapDEK(dek []byte) (*WrappedDEK, error) {
+	var resp struct {
+		Name                    string `json:"name"`
+		Ciphertext              []byte `json:"ciphertext"`
+		CiphertextCrc32c        string `json:"ciphertextCrc32c"`
+		VerifiedPlaintextCrc32c bool   `json:"verifiedPlaintextCrc32c"`
+	}
+	body := map[string]interface{}{
+		"plaintext":       dek,
+		"plaintextCrc32c": gcpCRC(dek),
+	}
+	if err := p.call(http.MethodPost, p.cfg.KeyName+":encrypt", body, &resp); err != nil {
+		return nil, fmt.Errorf("Cloud KMS encrypt failed: %w", err)
+	}
+	if !resp.VerifiedPlaintextCrc32c {
+		return nil, errors.New("Cloud KMS encrypt: plaintext CRC32C not verified by server")
+	}
+	if resp.CiphertextCrc32c != gcpCRC(resp.Ciphertext) {
+		return nil, errors.New("Cloud KMS encrypt: ciphertext corrupted in transit (CRC32C mismatch)")
+	}
+	return &WrappedDEK{
+		KeyID:     resp.Name,
+		Algorithm: "GOOGLE_SYMMETRIC_ENCRYPTION",
+		Wrapped:   resp.Ciphertext,
+	}, nil
+}
+
+// UnwrapDEK decrypts the DEK. Cloud KMS selects the key version from the
+// ciphertext, so DEKs wrapped by non-primary versions still decrypt.
+func (p *GCPKMSProvider) UnwrapDEK(w *WrappedDEK) ([]byte, error) {
+	if w.KeyID != "" && !strings.HasPrefix(w.KeyID, p.cfg.KeyName+"/") {
+		return nil, fmt.Errorf("wrapped DEK belongs to %q, not %q", w.KeyID, p.cfg.KeyName)
+	}
+
+	var resp struct {
+		Plaintext       []byte `json:"plaintext"`
+		PlaintextCrc32c string `json:"plaintextCrc32c"`
+	}
+	body := map[string]interface{}{
+		"ciphertext":       w.Wrapped,
+		"ciphertextCrc32c": gcpCRC(w.Wrapped),
+	}
+	if err := p.call(http.MethodPost, p.cfg.KeyName+":decrypt", body, &resp); err != nil {
+		return nil, fmt.Errorf("Cloud KMS decrypt failed: %w", err)
+	}
+	if resp.PlaintextCrc32c != gcpCRC(resp.Plaintext) {
+		return nil, errors.New("Cloud KMS decrypt: plaintext corrupted in transit (CRC32C mismatch)")
+	}
+	return resp.Plaintext, nil
+}
+
+// RewrapDEK re-encrypts the DEK when the CryptoKey's primary version is no
+// longer the version that wrapped it (i.e. after a rotation).
+func (p *GCPKMSProvider) RewrapDEK(w *WrappedDEK) (*WrappedDEK, bool, error) {
+	var key struct {
+		Primary struct {
+			Name string `json:"name"`
+		} `json:"primary"`
+	}
+	if err := p.call(http.MethodGet, p.cfg.KeyName, nil, &key); err != nil {
+		return nil, false, fmt.Errorf("Cloud KMS key lookup failed: %w", err)
+	}
+	if key.Primary.Name == "" || key.Primary.Name == w.KeyID {
+		return w, false, nil
+	}
+
+	dek, err := p.UnwrapDEK(w)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		for i := range dek {
+			dek[i] = 0
+		}
+	}()
+	rewrapped, err := p.WrapDEK(dek)
+	if err != nil {
+		return nil, false, err
+	}
+	return rewrapped, true, nil
+}
+
+// GetKey is disabled: the DEK is only ever persisted wrapped by Cloud KMS.
+func (p *GCPKMSProvider) GetKey(keyID string) ([]byte, error) {
+	return nil, errors.New("security violation: cannot export the Cloud KMS protected DEK")
+}
+
+func (p *GCPKMSProvider) Encrypt(keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	if p.envelope == nil {
+		return nil, nil, errors.New("Cloud KMS provider not properly initialized")
+	}
+	return p.envelope.seal(plaintext)
+}
+
+func (p *GCPKMSProvider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte, error) {
+	if p.envelope == nil {
+		return nil, errors.New("Cloud KMS provider not properly initialized")
+	}
+	return p.envelope.open(ciphertext, nonce)
+}
+
+func (p *GCPKMSProvider) Close() error {
+	if p.envelope != nil {
+		p.envelope.wipe()
+	}
+	return nil
+}
+
+// gcpTokenSource hands out OAuth2 access tokens for Cloud KMS and caches them
+// until shortly before they expire.
+type gcpTokenSource struct {
+	client *http.Client
+	static string
+
+	// Metadata server token URL, used without a static token or key file.
+	metadata string
+
+	// Service account key (JWT bearer grant).
+	email    string
+	tokenURI string
+	key      interface{}
+	keyID    string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCPTokenSource(cfg GCPConfig, client *http.Client) (*gcpTokenSource, error) {
+	// GCE_METADATA_HOST overrides the metadata server address, as in the
+	// Google client libraries.
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = gcpMetadataHost
+	}
+	ts := &gcpTokenSource{
+		client:   client,
+		static:   cfg.AccessToken,
+		metadata: "http://" + host + gcpMetadataToken,
+	}
+	if ts.static != "" || cfg.CredentialsFile == "" {
+		return ts, nil
+	}
+
+	data, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCP credentials: %w", err)
+	}
+	var sa struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("failed to parse GCP credentials: %w", err)
+	}
+	if sa.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported GCP credentials type %q (use a service account key or an access token)", sa.Type)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sa.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account private key: %w", err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	ts.email = sa.ClientEmail
+	ts.tokenURI = sa.TokenURI
+	ts.key = key
+	ts.keyID = sa.PrivateKeyID
+	return ts, nil
+}
+
+// Token returns a valid access token, refreshing it if needed.
+func (ts *gcpTokenSource) Token() (string, error) {
+	if ts.static != "" {
+		return ts.static, nil
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.expires) {
+		return ts.token, nil
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+	if ts.key != nil {
+		now := time.Now()
+		assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   ts.email,
+			"scope": gcpKMSScope,
+			"aud":   ts.tokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		})
+		if ts.keyID != "" {
+			assertion.Header["kid"] = ts.keyID
+		}
+		signed, err := assertion.SignedString(ts.key)
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {signed},
+		}
+		req, err = http.NewRequest(http.MethodPost, ts.tokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequest(http.MethodGet, ts.metadata, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain GCP access token: %w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		class := ErrPermissionDenied // rejected credentials
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			class = ErrUnavailable
+		}
+		return "", fmt.Errorf("failed to obtain GCP access token: %w: HTTP %d", class, resp.StatusCode)
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("GCP token endpoint returned no access token")
+	}
+
+	ts.token = tok.AccessToken
+	ts.expires = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return ts.token, nil
+}
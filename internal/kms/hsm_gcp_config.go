@@ -0,0 +1,29 @@
+package kms
+
+import "net/http"
+
+// GCPConfig configures the Google Cloud KMS provider (build tag: gcp).
+type GCPConfig struct {
+	// KeyName is the CryptoKey resource name, e.g.
+	// "projects/p/locations/global/keyRings/kms/cryptoKeys/kms-master-key".
+	KeyName string
+
+	// DEKPath is the file where the wrapped DEK is persisted.
+	DEKPath string
+
+	// CreateDEK creates the DEK when DEKPath does not exist yet. Without it
+	// a missing file is an error.
+	CreateDEK bool
+
+	// Credentials, in order of precedence: a static OAuth2 access token,
+	// a service account JSON key file, or the GCE/GKE metadata server.
+	AccessToken     string
+	CredentialsFile string
+
+	// Endpoint overrides the Cloud KMS REST endpoint
+	// (default "https://cloudkms.googleapis.com"), e.g. for a local fake.
+	Endpoint string
+
+	// HTTPClient overrides the default HTTP client.
+	HTTPClient *http.Client
+}
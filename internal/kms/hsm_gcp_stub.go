@@ -0,0 +1,11 @@
+//go:build !gcp
+// +build !gcp
+
+package kms
+
+import "errors"
+
+// Stub when gcp build tag is not set.
+func NewGCPKMSProvider(cfg GCPConfig) (HSMProvider, error) {
+	return nil, errors.New("Google Cloud KMS support not compiled (use build tag: gcp)")
+}
@@ -0,0 +1,356 @@
+//go:build gcp
+// +build gcp
+
+package kms_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+func newGCPKMS(t *testing.T) *kmstest.GCPKMS {
+	t.Helper()
+	fake := kmstest.NewGCPKMS()
+	t.Cleanup(fake.Close)
+	if err := fake.CreateKey(gcpKeyName); err != nil {
+		t.Fatal(err)
+	}
+	return fake
+}
+
+// gcpConfig returns a config for fake authenticating with its static token.
+func gcpConfig(t *testing.T, fake *kmstest.GCPKMS) kms.GCPConfig {
+	return kms.GCPConfig{
+		KeyName:     gcpKeyName,
+		DEKPath:     filepath.Join(t.TempDir(), "dek.json"),
+		CreateDEK:   true,
+		AccessToken: fake.AccessToken,
+		Endpoint:    fake.URL,
+	}
+}
+
+func TestGCPTokenSources(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		lifetime int // token expires_in, seconds
+		setup    func(t *testing.T, fake *kmstest.GCPKMS, cfg *kms.GCPConfig)
+		requests int
+	}{
+		{
+			name:  "static token",
+			setup: func(t *testing.T, fake *kmstest.GCPKMS, cfg *kms.GCPConfig) {},
+		},
+		{
+			name: "service account",
+			setup: func(t *testing.T, fake *kmstest.GCPKMS, cfg *kms.GCPConfig) {
+				cfg.AccessToken, cfg.CredentialsFile = "", writeServiceAccount(t, fake, t.TempDir())
+			},
+			requests: 1,
+		},
+		{
+			name: "metadata server",
+			setup: func(t *testing.T, fake *kmstest.GCPKMS, cfg *kms.GCPConfig) {
+				cfg.AccessToken = ""
+				t.Setenv("GCE_METADATA_HOST", fake.MetadataHost())
+			},
+			requests: 1,
+		},
+		{
+			// Tokens are refreshed a minute before they expire, so a token
+			// good for a minute is fetched again for every call.
+			name:     "short-lived token",
+			lifetime: 60,
+			setup: func(t *testing.T, fake *kmstest.GCPKMS, cfg *kms.GCPConfig) {
+				cfg.AccessToken, cfg.CredentialsFile = "", writeServiceAccount(t, fake, t.TempDir())
+			},
+			requests: 3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newGCPKMS(t)
+			fake.TokenLifetime = tc.lifetime
+			cfg := gcpConfig(t, fake)
+			tc.setup(t, fake, &cfg)
+
+			// Wrapping the new DEK, then unwrapping and checking the
+			// primary version make three Cloud KMS calls.
+			p, err := kms.NewGCPKMSProvider(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			w := readWrappedDEK(t, cfg.DEKPath)
+			if _, err := p.UnwrapDEK(&w); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := p.RewrapDEK(&w); err != nil {
+				t.Fatal(err)
+			}
+			if n := fake.TokenRequests(); n != tc.requests {
+				t.Errorf("%d token requests, want %d", n, tc.requests)
+			}
+		})
+	}
+}
+
+func TestGCPTokenErrors(t *testing.T) {
+	fake := newGCPKMS(t)
+	dir := t.TempDir()
+	writeCredentials := func(name string, data []byte) string {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	other := kmstest.NewGCPKMS()
+	other.Close()
+	otherSA, err := other.ServiceAccountJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A key the fake did not issue, posted to the fake's token endpoint.
+	var foreign map[string]string
+	json.Unmarshal(otherSA, &foreign)
+	foreign["token_uri"] = fake.URL + "/token"
+	foreignSA, _ := json.Marshal(foreign)
+
+	for _, tc := range []struct {
+		name        string
+		credentials string
+		metadata    string // GCE_METADATA_HOST
+		want        error  // sentinel, if any
+		message     string // substring, if no sentinel
+	}{
+		{name: "unknown service account", credentials: writeCredentials("foreign", foreignSA), want: kms.ErrPermissionDenied},
+		{name: "federated credentials", credentials: writeCredentials("federated", []byte(`{"type":"external_account"}`)), message: `unsupported GCP credentials type "external_account"`},
+		{name: "metadata server unreachable", metadata: other.MetadataHost(), want: kms.ErrUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.metadata != "" {
+				t.Setenv("GCE_METADATA_HOST", tc.metadata)
+			}
+			cfg := gcpConfig(t, fake)
+			cfg.AccessToken, cfg.CredentialsFile = "", tc.credentials
+			p, err := kms.NewGCPKMSProvider(cfg)
+			switch {
+			case err == nil:
+				p.Close()
+				t.Fatal("provider opened, want an error")
+			case tc.want != nil && !errors.Is(err, tc.want):
+				t.Errorf("error %v, want %v", err, tc.want)
+			case tc.message != "" && !strings.Contains(err.Error(), tc.message):
+				t.Errorf("error %v, want it to mention %s", err, tc.message)
+			}
+		})
+	}
+}
+
+// gcpTamper rewrites one field of the JSON request or response body of the
+// Cloud KMS method it is set for, as a corrupting proxy would.
+type gcpTamper struct {
+	method  string // e.g. "encrypt"
+	request bool   // rewrite the request rather than the response
+	field   string
+	value   interface{}
+}
+
+func (g *gcpTamper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, ":"+g.method) {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	if g.request {
+		body, err := g.rewrite(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body, req.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := g.rewrite(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body, resp.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	return resp, nil
+}
+
+func (g *gcpTamper) rewrite(r io.ReadCloser) ([]byte, error) {
+	defer r.Close()
+	var body map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+	body[g.field] = g.value
+	return json.Marshal(body)
+}
+
+func TestGCPCRC32C(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tamper  gcpTamper
+		want    error  // sentinel, if any
+		message string // substring, if no sentinel
+	}{
+		{
+			name:    "encrypt response ciphertext",
+			tamper:  gcpTamper{method: "encrypt", field: "ciphertextCrc32c", value: "1"},
+			message: "ciphertext corrupted in transit",
+		},
+		{
+			name:    "encrypt response not verified",
+			tamper:  gcpTamper{method: "encrypt", field: "verifiedPlaintextCrc32c", value: false},
+			message: "plaintext CRC32C not verified",
+		},
+		{
+			name:   "encrypt request plaintext",
+			tamper: gcpTamper{method: "encrypt", request: true, field: "plaintextCrc32c", value: "1"},
+			want:   kms.ErrInvalidCiphertext,
+		},
+		{
+			name:    "decrypt response plaintext",
+			tamper:  gcpTamper{method: "decrypt", field: "plaintextCrc32c", value: "1"},
+			message: "plaintext corrupted in transit",
+		},
+		{
+			name:   "decrypt request ciphertext",
+			tamper: gcpTamper{method: "decrypt", request: true, field: "ciphertextCrc32c", value: "1"},
+			want:   kms.ErrInvalidCiphertext,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newGCPKMS(t)
+			cfg := gcpConfig(t, fake)
+			if tc.tamper.method == "decrypt" {
+				// Decrypt is only called for a DEK that already exists.
+				p, err := kms.NewGCPKMSProvider(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Close()
+			}
+			tamper := tc.tamper
+			cfg.HTTPClient = &http.Client{Transport: &tamper}
+			p, err := kms.NewGCPKMSProvider(cfg)
+			switch {
+			case err == nil:
+				p.Close()
+				t.Fatal("provider opened, want an error")
+			case tc.want != nil && !errors.Is(err, tc.want):
+				t.Errorf("error %v, want %v", err, tc.want)
+			case tc.message != "" && !strings.Contains(err.Error(), tc.message):
+				t.Errorf("error %v, want it to mention %s", err, tc.message)
+			}
+		})
+	}
+}
+
+// After a rotation the DEK is rewrapped under the new primary version the
+// next time the provider starts; data encrypted before keeps decrypting.
+func TestGCPKeyVersions(t *testing.T) {
+	fake := newGCPKMS(t)
+	cfg := gcpConfig(t, fake)
+	p, err := kms.NewGCPKMSProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, nonce, err := p.Encrypt(gcpKeyName, []byte("4111111111111111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if w := readWrappedDEK(t, cfg.DEKPath); w.KeyID != gcpKeyName+"/cryptoKeyVersions/1" {
+		t.Fatalf("DEK wrapped by %q, want version 1", w.KeyID)
+	}
+
+	if err := fake.RotateKey(gcpKeyName); err != nil {
+		t.Fatal(err)
+	}
+	p, err = kms.NewGCPKMSProvider(cfg)
+	if err != nil {
+		t.Fatalf("open after rotation: %v", err)
+	}
+	pt, err := p.Decrypt(gcpKeyName, ct, nonce)
+	if err != nil || string(pt) != "4111111111111111" {
+		t.Fatalf("Decrypt after rotation = %q, %v", pt, err)
+	}
+	p.Close()
+	rewrapped := readWrappedDEK(t, cfg.DEKPath)
+	if rewrapped.KeyID != gcpKeyName+"/cryptoKeyVersions/2" {
+		t.Fatalf("DEK wrapped by %q after rotation, want version 2", rewrapped.KeyID)
+	}
+
+	// Without a rotation the DEK file is left as is.
+	p, err = kms.NewGCPKMSProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if w := readWrappedDEK(t, cfg.DEKPath); !bytes.Equal(w.Wrapped, rewrapped.Wrapped) {
+		t.Error("DEK rewrapped again without a rotation")
+	}
+}
+
+func TestGCPKeyVersionErrors(t *testing.T) {
+	t.Run("disabled version", func(t *testing.T) {
+		// The DEK was wrapped by version 1, which was disabled after the
+		// rotation before the provider had a chance to rewrap it.
+		fake := newGCPKMS(t)
+		cfg := gcpConfig(t, fake)
+		p, err := kms.NewGCPKMSProvider(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Close()
+		if err := fake.RotateKey(gcpKeyName); err != nil {
+			t.Fatal(err)
+		}
+		fake.DisableVersion(gcpKeyName, 1)
+		if _, err := kms.NewGCPKMSProvider(cfg); !errors.Is(err, kms.ErrKeyDisabled) {
+			t.Errorf("open = %v, want ErrKeyDisabled", err)
+		}
+	})
+
+	t.Run("DEK of another key", func(t *testing.T) {
+		fake := newGCPKMS(t)
+		cfg := gcpConfig(t, fake)
+		p, err := kms.NewGCPKMSProvider(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Close()
+		w := readWrappedDEK(t, cfg.DEKPath)
+		w.KeyID = strings.Replace(w.KeyID, "/cryptoKeys/kms-master-key/", "/cryptoKeys/other/", 1)
+		data, _ := json.Marshal(w)
+		if err := os.WriteFile(cfg.DEKPath, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kms.NewGCPKMSProvider(cfg); err == nil || !strings.Contains(err.Error(), "cryptoKeys/other") {
+			t.Errorf("open = %v, want the DEK rejected as another key's", err)
+		}
+	})
+
+	t.Run("key version configured", func(t *testing.T) {
+		fake := newGCPKMS(t)
+		cfg := gcpConfig(t, fake)
+		cfg.KeyName += "/cryptoKeyVersions/1"
+		if _, err := kms.NewGCPKMSProvider(cfg); err == nil || !strings.Contains(err.Error(), "not a CryptoKeyVersion") {
+			t.Errorf("open = %v, want a CryptoKeyVersion name rejected", err)
+		}
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"strings"
 	"sync"
 
 	"github.com/miekg/pkcs11"
@@ -21,6 +23,37 @@ type PKCS11Provider struct {
 	pin      string
 	keyLabel string
 	mu       sync.Mutex // PKCS#11 sessions are not goroutine-safe
+	libPath  string
+}
+
+// pkcs11Libs counts providers per library. C_Initialize and C_Finalize act on
+// the whole module, so several providers on one library (e.g. two slots of the
+// same HSM behind a failover provider) share one initialization.
+var pkcs11Libs = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: make(map[string]int)}
+
+func acquirePKCS11(ctx *pkcs11.Ctx, libPath string) error {
+	pkcs11Libs.Lock()
+	defer pkcs11Libs.Unlock()
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return err
+	}
+	pkcs11Libs.refs[libPath]++
+	return nil
+}
+
+// releasePKCS11 drops a reference and finalizes the library with the last one.
+func releasePKCS11(ctx *pkcs11.Ctx, libPath string) {
+	pkcs11Libs.Lock()
+	defer pkcs11Libs.Unlock()
+	pkcs11Libs.refs[libPath]--
+	if pkcs11Libs.refs[libPath] > 0 {
+		return
+	}
+	delete(pkcs11Libs.refs, libPath)
+	ctx.Finalize()
 }
 
 // NewPKCS11Provider creates a new PKCS#11 HSM provider.
@@ -30,7 +63,7 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 		return nil, errors.New("failed to load PKCS#11 library")
 	}
 
-	err := ctx.Initialize()
+	err := acquirePKCS11(ctx, libPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize PKCS#11: %w", err)
 	}
@@ -41,14 +74,14 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 
 	slots, err := ctx.GetSlotList(true) // Get slots with tokens present
 	if err != nil {
-		ctx.Finalize()
+		releasePKCS11(ctx, libPath)
 		return nil, fmt.Errorf("failed to get slot list: %w", err)
 	}
 
 	var targetSlot uint
 	var found bool
 
-	fmt.Printf("DEBUG: Found %d slots with tokens:\n", len(slots))
+	slog.Debug("KMS pkcs11: slots with tokens", "count", len(slots))
 
 	// Iterate over all available slots to find the right one
 	for _, slot := range slots {
@@ -56,7 +89,7 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 		if err != nil {
 			continue
 		}
-		fmt.Printf("  - Checking Slot ID %d (Label: %s)\n", slot, tokenInfo.Label)
+		slog.Debug("KMS pkcs11: checking slot", "slot", slot, "token_label", strings.TrimSpace(tokenInfo.Label))
 
 		// Check if this token matches our expectations.
 		// Usually, SoftHSM labels are padded with spaces, so checking connection is key.
@@ -66,7 +99,7 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 		if uint(slot) == slotID {
 			targetSlot = slot
 			found = true
-			fmt.Println("    -> MATCHED user-provided Slot ID")
+			slog.Debug("KMS pkcs11: slot matches KMS_PKCS11_SLOT", "slot", slot)
 			break
 		}
 	}
@@ -75,11 +108,11 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 	// OR if you want to be specific, check the token Label "KMS Token"
 	if !found {
 		if len(slots) > 0 {
-			fmt.Printf("DEBUG: Slot ID %d not found in list. Using first available slot: %d\n", slotID, slots[0])
+			slog.Debug("KMS pkcs11: configured slot not found, using the first slot", "configured", slotID, "slot", slots[0])
 			targetSlot = slots[0]
 			found = true
 		} else {
-			ctx.Finalize()
+			releasePKCS11(ctx, libPath)
 			return nil, errors.New("no slots with tokens found")
 		}
 	}
@@ -89,15 +122,17 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 	// Open Session using the CONFIRMED slot ID
 	session, err := ctx.OpenSession(targetSlot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
 	if err != nil {
-		ctx.Finalize()
+		releasePKCS11(ctx, libPath)
 		return nil, fmt.Errorf("failed to open PKCS#11 session on slot %d: %w", targetSlot, err)
 	}
 
 	// Login
+	// Login state is shared by all sessions on a token, so another provider
+	// on the same token may already have logged in.
 	err = ctx.Login(session, pkcs11.CKU_USER, pin)
-	if err != nil {
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
 		ctx.CloseSession(session)
-		ctx.Finalize()
+		releasePKCS11(ctx, libPath)
 		return nil, fmt.Errorf("failed to login to PKCS#11: %w", err)
 	}
 
@@ -107,28 +142,33 @@ func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (*PKCS
 		slotID:   targetSlot, // Use the real one
 		pin:      pin,
 		keyLabel: keyLabel,
+		libPath:  libPath,
 	}, nil
 }
 
 // findKeyHandle looks up the object handle for the key inside the HSM.
 // It does NOT extract the key data.
 func (p *PKCS11Provider) findKeyHandle() (pkcs11.ObjectHandle, error) {
+	if p.ctx == nil {
+		return 0, fmt.Errorf("%w: PKCS#11 provider is closed", ErrSealed)
+	}
+
 	template := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
 		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.keyLabel),
 	}
 
 	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
-		return 0, err
+		return 0, classifyPKCS11(err)
 	}
 	defer p.ctx.FindObjectsFinal(p.session)
 
 	objs, _, err := p.ctx.FindObjects(p.session, 1)
 	if err != nil {
-		return 0, err
+		return 0, classifyPKCS11(err)
 	}
 	if len(objs) == 0 {
-		return 0, errors.New("key not found in HSM")
+		return 0, fmt.Errorf("%w: no secret key labelled %q in HSM", ErrKeyNotFound, p.keyLabel)
 	}
 
 	return objs[0], nil
@@ -162,13 +202,13 @@ func (p *PKCS11Provider) Encrypt(keyID string, plaintext []byte) ([]byte, []byte
 
 	// 3. Initialize Encryption
 	if err := p.ctx.EncryptInit(p.session, mech, keyHandle); err != nil {
-		return nil, nil, fmt.Errorf("encrypt init failed: %w", err)
+		return nil, nil, fmt.Errorf("encrypt init failed: %w", classifyPKCS11(err))
 	}
 
 	// 4. Perform Encryption
 	ciphertext, err := p.ctx.Encrypt(p.session, plaintext)
 	if err != nil {
-		return nil, nil, fmt.Errorf("encrypt execution failed: %w", err)
+		return nil, nil, fmt.Errorf("encrypt execution failed: %w", classifyPKCS11(err))
 	}
 
 	return ciphertext, nonce, nil
@@ -183,6 +223,9 @@ func (p *PKCS11Provider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte
 	if err != nil {
 		return nil, err
 	}
+	if len(nonce) != 12 {
+		return nil, fmt.Errorf("%w: expected 12 bytes, got %d", ErrInvalidNonce, len(nonce))
+	}
 
 	// 1. Configure AES-GCM with the nonce received during encryption
 	gcmParams := pkcs11.NewGCMParams(nonce, nil, 128)
@@ -190,29 +233,62 @@ func (p *PKCS11Provider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte
 
 	// 2. Initialize Decryption
 	if err := p.ctx.DecryptInit(p.session, mech, keyHandle); err != nil {
-		return nil, fmt.Errorf("decrypt init failed: %w", err)
+		return nil, fmt.Errorf("decrypt init failed: %w", classifyPKCS11(err))
 	}
 
 	// 3. Perform Decryption
 	plaintext, err := p.ctx.Decrypt(p.session, ciphertext)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt execution failed: %w", err)
+		return nil, fmt.Errorf("decrypt execution failed: %w", classifyPKCS11(err))
 	}
 
 	return plaintext, nil
 }
 
-// Close cleans up the session.
+// Close cleans up the session. It is safe to call more than once; later
+// Encrypt/Decrypt calls fail.
 func (p *PKCS11Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.ctx == nil {
 		return nil
 	}
 
+	// Closing a session ends the login once it is the token's last session;
+	// an explicit Logout would also log out other providers on the token.
 	if p.session != 0 {
-		p.ctx.Logout(p.session)
 		p.ctx.CloseSession(p.session)
+		p.session = 0
 	}
 
-	p.ctx.Finalize()
+	releasePKCS11(p.ctx, p.libPath)
+	p.ctx.Destroy()
+	p.ctx = nil
 	return nil
 }
+
+// classifyPKCS11 wraps a PKCS#11 return value with the matching sentinel
+// error. Unknown codes are returned unchanged.
+func classifyPKCS11(err error) error {
+	var rv pkcs11.Error
+	if !errors.As(err, &rv) {
+		return err
+	}
+	switch rv {
+	case pkcs11.CKR_ENCRYPTED_DATA_INVALID, pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE:
+		return fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	case pkcs11.CKR_MECHANISM_PARAM_INVALID:
+		return fmt.Errorf("%w: %w", ErrInvalidNonce, err)
+	case pkcs11.CKR_KEY_HANDLE_INVALID:
+		return fmt.Errorf("%w: %w", ErrKeyNotFound, err)
+	case pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED:
+		return fmt.Errorf("%w: %w", ErrPermissionDenied, err)
+	case pkcs11.CKR_DEVICE_ERROR, pkcs11.CKR_DEVICE_MEMORY, pkcs11.CKR_DEVICE_REMOVED,
+		pkcs11.CKR_SESSION_CLOSED, pkcs11.CKR_SESSION_HANDLE_INVALID,
+		pkcs11.CKR_TOKEN_NOT_PRESENT, pkcs11.CKR_USER_NOT_LOGGED_IN,
+		pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
@@ -1,11 +1,11 @@
-//go:build !pkcs11 && !aws && !azure
-// +build !pkcs11,!aws,!azure
+//go:build !pkcs11
+// +build !pkcs11
 
 package kms
 
 import "errors"
 
-// Stub implementations for PKCS#11 when no HSM build tags are set.
+// Stub implementation for PKCS#11 when the pkcs11 build tag is not set.
 // AWS/Azure stubs are provided in their own files with !aws / !azure tags.
 
 func NewPKCS11Provider(libPath string, slotID uint, pin, keyLabel string) (HSMProvider, error) {
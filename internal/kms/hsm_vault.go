@@ -0,0 +1,397 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultConfig configures the HashiCorp Vault Transit provider.
+type VaultConfig struct {
+	Address      string // e.g. "https://vault.example.com:8200"
+	Namespace    string // optional Vault Enterprise namespace
+	TransitMount string // mount path of the Transit engine, default "transit"
+	KeyName      string // Transit key used to wrap the DEK
+	DEKPath      string // file where the wrapped DEK is persisted
+	CreateDEK    bool   // create the DEK when DEKPath does not exist yet
+
+	// Token auth. Used as-is when RoleID is empty.
+	Token string
+
+	// AppRole auth. When RoleID is set the provider logs in with
+	// RoleID/SecretID and logs in again whenever Vault rejects the token.
+	RoleID       string
+	SecretID     string
+	AppRoleMount string // default "approle"
+
+	// TLS settings for the connection to Vault.
+	CACert             string // PEM bundle used to verify the Vault server
+	ClientCert         string // optional client certificate (PEM) for TLS auth
+	ClientKey          string
+	TLSServerName      string
+	InsecureSkipVerify bool
+
+	// HTTPClient overrides the client built from the TLS settings, e.g. to
+	// talk to a Transit stand-in.
+	HTTPClient *http.Client
+}
+
+// VaultTransitProvider implements HSMProvider using Vault's Transit engine in
+// envelope mode.
+//
+// A 256-bit DEK is generated by Transit (datakey/plaintext), persisted in its
+// wrapped "vault:vN:..." form and unwrapped with transit decrypt on startup.
+// When the Transit key has been rotated since, the persisted DEK is rewrapped
+// under the latest key version. Card data is encrypted locally with the DEK.
+type VaultTransitProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+
+	mu    sync.Mutex // guards token
+	token string
+
+	envelope *envelopeCipher
+}
+
+// NewVaultTransitProvider authenticates to Vault and loads the DEK, creating
+// it first when cfg.CreateDEK is set and DEKPath does not exist.
+func NewVaultTransitProvider(cfg VaultConfig) (*VaultTransitProvider, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("Vault address is required")
+	}
+	if cfg.KeyName == "" {
+		return nil, errors.New("Vault Transit key name is required")
+	}
+	if cfg.Token == "" && cfg.RoleID == "" {
+		return nil, errors.New("Vault token or AppRole role_id is required")
+	}
+	if cfg.TransitMount == "" {
+		cfg.TransitMount = "transit"
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = "approle"
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+
+	client := cfg.HTTPClient
+	if client == nil {
+		tlsCfg, err := vaultTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+		}
+	}
+
+	p := &VaultTransitProvider{
+		cfg:    cfg,
+		client: client,
+		token:  cfg.Token,
+	}
+	if cfg.RoleID != "" {
+		if err := p.login(); err != nil {
+			return nil, err
+		}
+	}
+
+	dek, err := loadOrCreateDEK(cfg.DEKPath, cfg.CreateDEK, p)
+	if err != nil {
+		return nil, err
+	}
+	p.envelope, err = newEnvelopeCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func vaultTLSConfig(cfg VaultConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Vault CA bundle %s", cfg.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Vault client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// vaultError is returned for non-2xx responses from Vault.
+type vaultError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *vaultError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("Vault returned HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Vault returned HTTP %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Unwrap classifies the response so errors.Is matches the kms sentinels.
+func (e *vaultError) Unwrap() error {
+	msg := strings.ToLower(strings.Join(e.Errors, " "))
+	switch {
+	case e.StatusCode == http.StatusNotFound || strings.Contains(msg, "not found"):
+		return ErrKeyNotFound
+	case e.StatusCode == http.StatusForbidden:
+		return ErrPermissionDenied
+	case e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500:
+		// 503 also covers a sealed Vault.
+		return ErrUnavailable
+	case strings.Contains(msg, "disallowed by policy") || strings.Contains(msg, "disabled"):
+		return ErrKeyDisabled
+	case strings.Contains(msg, "authentication failed") || strings.Contains(msg, "invalid ciphertext"):
+		return ErrInvalidCiphertext
+	}
+	return nil
+}
+
+// login exchanges the AppRole credentials for a client token.
+func (p *VaultTransitProvider) login() error {
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role_id": p.cfg.RoleID, "secret_id": p.cfg.SecretID}
+	if err := p.send(http.MethodPost, "auth/"+p.cfg.AppRoleMount+"/login", "", body, &resp); err != nil {
+		return fmt.Errorf("Vault AppRole login failed: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return errors.New("Vault AppRole login returned no client token")
+	}
+
+	p.mu.Lock()
+	p.token = resp.Auth.ClientToken
+	p.mu.Unlock()
+	return nil
+}
+
+// call performs an authenticated request. With AppRole auth an expired or
+// revoked token (HTTP 403) triggers one re-login and retry.
+func (p *VaultTransitProvider) call(method, path string, body, out interface{}) error {
+	p.mu.Lock()
+	token := p.token
+	p.mu.Unlock()
+
+	err := p.send(method, path, token, body, out)
+	var vErr *vaultError
+	if p.cfg.RoleID != "" && errors.As(err, &vErr) && vErr.StatusCode == http.StatusForbidden {
+		if err := p.login(); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		token = p.token
+		p.mu.Unlock()
+		err = p.send(method, path, token, body, out)
+	}
+	return err
+}
+
+func (p *VaultTransitProvider) send(method, path, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, p.cfg.Address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		vErr := &vaultError{StatusCode: resp.StatusCode}
+		var errBody struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errBody) == nil {
+			vErr.Errors = errBody.Errors
+		}
+		return vErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *VaultTransitProvider) transitPath(op string) string {
+	return p.cfg.TransitMount + "/" + op + "/" + p.cfg.KeyName
+}
+
+// vaultCiphertextVersion extracts N from a "vault:vN:..." ciphertext.
+func vaultCiphertextVersion(ciphertext string) (int, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, errors.New("not a Vault Transit ciphertext")
+	}
+	return strconv.Atoi(parts[1][1:])
+}
+
+// WrapDEK wraps an existing DEK with transit encrypt.
+func (p *VaultTransitProvider) WrapDEK(dek []byte) (*WrappedDEK, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dek)}
+	if err := p.call(http.MethodPost, p.transitPath("encrypt"), body, &resp); err != nil {
+		return nil, fmt.Errorf("Vault transit encrypt failed: %w", err)
+	}
+	return p.wrapped(resp.Data.Ciphertext), nil
+}
+
+// UnwrapDEK unwraps the persisted DEK with transit decrypt.
+func (p *VaultTransitProvider) UnwrapDEK(w *WrappedDEK) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"ciphertext": string(w.Wrapped)}
+	if err := p.call(http.MethodPost, p.transitPath("decrypt"), body, &resp); err != nil {
+		return nil, fmt.Errorf("Vault transit decrypt failed: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+// RewrapDEK rewraps the persisted DEK under the latest Transit key version
+// if it was wrapped by an older one. The rewrap is best-effort: the DEK has
+// already been decrypted, so a token without read access to the key (403),
+// or any other failed lookup or rewrap, is logged and the current wrapped
+// DEK is kept.
+func (p *VaultTransitProvider) RewrapDEK(w *WrappedDEK) (*WrappedDEK, bool, error) {
+	current, err := vaultCiphertextVersion(string(w.Wrapped))
+	if err != nil {
+		return nil, false, err
+	}
+
+	var key struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+		} `json:"data"`
+	}
+	if err := p.call(http.MethodGet, p.transitPath("keys"), nil, &key); err != nil {
+		slog.Warn("KMS vault: cannot read the transit key, keeping the wrapped DEK", "key_name", p.cfg.KeyName, "version", current, "err", err)
+		return w, false, nil
+	}
+	if current >= key.Data.LatestVersion {
+		return w, false, nil
+	}
+
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"ciphertext": string(w.Wrapped)}
+	if err := p.call(http.MethodPost, p.transitPath("rewrap"), body, &resp); err != nil {
+		slog.Warn("KMS vault: transit rewrap failed, keeping the wrapped DEK", "key_name", p.cfg.KeyName, "version", current, "latest_version", key.Data.LatestVersion, "err", err)
+		return w, false, nil
+	}
+	return p.wrapped(resp.Data.Ciphertext), true, nil
+}
+
+// GenerateDEK asks Transit for a fresh data key (datakey/plaintext), so the
+// DEK is generated by Vault rather than locally.
+func (p *VaultTransitProvider) GenerateDEK() ([]byte, *WrappedDEK, error) {
+	var resp struct {
+		Data struct {
+			Plaintext  string `json:"plaintext"`
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]int{"bits": dekSize * 8}
+	if err := p.call(http.MethodPost, p.transitPath("datakey/plaintext"), body, &resp); err != nil {
+		return nil, nil, fmt.Errorf("Vault transit datakey failed: %w", err)
+	}
+	dek, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, p.wrapped(resp.Data.Ciphertext), nil
+}
+
+func (p *VaultTransitProvider) wrapped(ciphertext string) *WrappedDEK {
+	return &WrappedDEK{
+		KeyID:     p.cfg.TransitMount + "/" + p.cfg.KeyName,
+		Algorithm: "vault-transit",
+		Wrapped:   []byte(ciphertext),
+	}
+}
+
+// GetKey is disabled: the DEK is only ever persisted wrapped by Vault.
+func (p *VaultTransitProvider) GetKey(keyID string) ([]byte, error) {
+	return nil, errors.New("security violation: cannot export the Vault protected DEK")
+}
+
+func (p *VaultTransitProvider) Encrypt(keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	if p.envelope == nil {
+		return nil, nil, errors.New("Vault Transit provider not properly initialized")
+	}
+	return p.envelope.seal(plaintext)
+}
+
+func (p *VaultTransitProvider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte, error) {
+	if p.envelope == nil {
+		return nil, errors.New("Vault Transit provider not properly initialized")
+	}
+	return p.envelope.open(ciphertext, nonce)
+}
+
+func (p *VaultTransitProvider) Close() error {
+	if p.envelope != nil {
+		p.envelope.wipe()
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package kms_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"kms/internal/kms"
+	"kms/internal/kms/kmstest"
+)
+
+const vaultKeyName = "kms-master-key"
+
+// vaultRecorder records the requests a provider sends to Vault. Requests
+// listed in deny, as "METHOD path", are answered with that status instead,
+// as Vault does for a token whose policy lacks the path.
+type vaultRecorder struct {
+	mu         sync.Mutex
+	paths      []string
+	namespaces []string
+	deny       map[string]int
+}
+
+func (r *vaultRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.Method + " " + strings.TrimPrefix(req.URL.Path, "/v1/")
+	r.mu.Lock()
+	r.paths = append(r.paths, path)
+	r.namespaces = append(r.namespaces, req.Header.Get("X-Vault-Namespace"))
+	code := r.deny[path]
+	r.mu.Unlock()
+	if code != 0 {
+		return &http.Response{
+			StatusCode: code,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"errors":["permission denied"]}`)),
+			Request:    req,
+		}, nil
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// count returns how many recorded requests went to path.
+func (r *vaultRecorder) count(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, p := range r.paths {
+		if strings.HasSuffix(p, " "+path) {
+			n++
+		}
+	}
+	return n
+}
+
+func newVault(t *testing.T) *kmstest.VaultTransit {
+	t.Helper()
+	vault := kmstest.NewVaultTransit()
+	t.Cleanup(vault.Close)
+	if err := vault.CreateKey(vaultKeyName); err != nil {
+		t.Fatal(err)
+	}
+	return vault
+}
+
+// vaultConfig returns a token-auth config for vault whose requests go
+// through a new recorder.
+func vaultConfig(t *testing.T, vault *kmstest.VaultTransit) (kms.VaultConfig, *vaultRecorder) {
+	rec := &vaultRecorder{}
+	return kms.VaultConfig{
+		Address:    vault.URL,
+		Token:      vault.RootToken,
+		KeyName:    vaultKeyName,
+		DEKPath:    filepath.Join(t.TempDir(), "dek.json"),
+		CreateDEK:  true,
+		HTTPClient: &http.Client{Transport: rec},
+	}, rec
+}
+
+func readWrappedDEK(t *testing.T, path string) kms.WrappedDEK {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w kms.WrappedDEK
+	if err := json.Unmarshal(data, &w); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestVaultAuth(t *testing.T) {
+	vault := newVault(t)
+	vault.AddAppRole("kms-role", "kms-secret")
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		roleID   string
+		secretID string
+		logins   int
+		want     error  // sentinel, if any
+		message  string // substring, if no sentinel
+	}{
+		{name: "token", token: "root"},
+		{name: "AppRole", roleID: "kms-role", secretID: "kms-secret", logins: 1},
+		{name: "AppRole preferred over token", token: "root", roleID: "kms-role", secretID: "kms-secret", logins: 1},
+		{name: "rejected token", token: "hvs.revoked", want: kms.ErrPermissionDenied},
+		{name: "wrong secret ID", roleID: "kms-role", secretID: "guess", logins: 1, message: "AppRole login failed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, rec := vaultConfig(t, vault)
+			cfg.Token, cfg.RoleID, cfg.SecretID = tc.token, tc.roleID, tc.secretID
+			p, err := kms.NewVaultTransitProvider(cfg)
+			switch {
+			case err == nil:
+				p.Close()
+				if tc.want != nil || tc.message != "" {
+					t.Fatal("provider opened, want an error")
+				}
+			case tc.want == nil && tc.message == "":
+				t.Fatal(err)
+			case tc.want != nil && !errors.Is(err, tc.want):
+				t.Errorf("error %v, want %v", err, tc.want)
+			case tc.message != "" && !strings.Contains(err.Error(), tc.message):
+				t.Errorf("error %v, want it to mention %s", err, tc.message)
+			}
+			if n := rec.count("auth/approle/login"); n != tc.logins {
+				t.Errorf("%d AppRole logins, want %d", n, tc.logins)
+			}
+		})
+	}
+}
+
+// A missing DEK file is only replaced by a new DEK when asked to: otherwise
+// a mistyped path would start the service on a key that reads no existing
+// data.
+func TestVaultDEKCreation(t *testing.T) {
+	vault := newVault(t)
+	cfg, rec := vaultConfig(t, vault)
+	cfg.CreateDEK = false
+	_, err := kms.NewVaultTransitProvider(cfg)
+	if err == nil || !strings.Contains(err.Error(), cfg.DEKPath) {
+		t.Fatalf("open without a DEK file = %v, want an error naming %s", err, cfg.DEKPath)
+	}
+	if _, err := os.Stat(cfg.DEKPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("DEK file created without CreateDEK (stat: %v)", err)
+	}
+	if n := rec.count("transit/datakey/plaintext/" + vaultKeyName); n != 0 {
+		t.Errorf("%d datakey requests without CreateDEK, want 0", n)
+	}
+
+	cfg.CreateDEK = true
+	p, err := kms.NewVaultTransitProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	// Once the file exists it is loaded without CreateDEK.
+	cfg.CreateDEK = false
+	p, err = kms.NewVaultTransitProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if n := rec.count("transit/datakey/plaintext/" + vaultKeyName); n != 1 {
+		t.Errorf("%d datakey requests, want 1", n)
+	}
+
+	// The factory behind kms-server requires the path.
+	env := map[string]string{"KMS_VAULT_ADDR": vault.URL, "KMS_VAULT_TOKEN": vault.RootToken, "KMS_VAULT_KEY_NAME": vaultKeyName}
+	if _, err := kms.ProviderFromEnv("vault", func(k string) string { return env[k] }); err == nil || !strings.Contains(err.Error(), "KMS_VAULT_DEK_PATH") {
+		t.Errorf("open without KMS_VAULT_DEK_PATH = %v, want it to be required", err)
+	}
+}
+
+// An AppRole token that Vault no longer accepts is replaced by logging in
+// again; a static token is not, and the 403 is returned as is.
+func TestVaultReloginAfterForbidden(t *testing.T) {
+	vault := newVault(t)
+	vault.AddAppRole("kms-role", "kms-secret")
+
+	cfg, rec := vaultConfig(t, vault)
+	cfg.Token, cfg.RoleID, cfg.SecretID = "", "kms-role", "kms-secret"
+	p, err := kms.NewVaultTransitProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	w := readWrappedDEK(t, cfg.DEKPath)
+
+	vault.RevokeTokens()
+	if _, err := p.UnwrapDEK(&w); err != nil {
+		t.Fatalf("UnwrapDEK after token revocation: %v", err)
+	}
+	if n := rec.count("auth/approle/login"); n != 2 {
+		t.Errorf("%d AppRole logins, want 2", n)
+	}
+	if n := rec.count("transit/decrypt/" + vaultKeyName); n != 2 {
+		t.Errorf("%d decrypt requests, want the rejected one and its retry", n)
+	}
+
+	// With token auth a 403 is final. The token here is one an operator
+	// got from the same AppRole, and it expires like the provider's did.
+	tokenCfg, tokenRec := vaultConfig(t, vault)
+	tokenCfg.Token = appRoleToken(t, vault, "kms-role", "kms-secret")
+	tokenCfg.DEKPath = cfg.DEKPath
+	tp, err := kms.NewVaultTransitProvider(tokenCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close()
+	vault.RevokeTokens()
+	if _, err := tp.UnwrapDEK(&w); !errors.Is(err, kms.ErrPermissionDenied) {
+		t.Errorf("UnwrapDEK with a rejected token = %v, want ErrPermissionDenied", err)
+	}
+	if n := tokenRec.count("auth/approle/login"); n != 0 {
+		t.Errorf("%d AppRole logins with token auth, want 0", n)
+	}
+}
+
+// appRoleToken logs in to vault the way an operator would.
+func appRoleToken(t *testing.T, vault *kmstest.VaultTransit, roleID, secretID string) string {
+	t.Helper()
+	body := strings.NewReader(`{"role_id":"` + roleID + `","secret_id":"` + secretID + `"}`)
+	resp, err := http.Post(vault.URL+"/v1/auth/approle/login", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil || login.Auth.ClientToken == "" {
+		t.Fatalf("AppRole login: HTTP %d, %v", resp.StatusCode, err)
+	}
+	return login.Auth.ClientToken
+}
+
+func TestVaultNamespace(t *testing.T) {
+	vault := newVault(t)
+	vault.Namespace = "payments"
+	vault.AddAppRole("kms-role", "kms-secret")
+
+	cfg, rec := vaultConfig(t, vault)
+	cfg.Token, cfg.RoleID, cfg.SecretID = "", "kms-role", "kms-secret"
+	cfg.Namespace = "payments"
+	p, err := kms.NewVaultTransitProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	for i, ns := range rec.namespaces {
+		if ns != "payments" {
+			t.Errorf("request %s sent namespace %q, want payments", rec.paths[i], ns)
+		}
+	}
+
+	// Without the header Vault resolves the paths in the root namespace,
+	// where the key does not exist.
+	cfg, _ = vaultConfig(t, vault)
+	if _, err := kms.NewVaultTransitProvider(cfg); !errors.Is(err, kms.ErrKeyNotFound) {
+		t.Errorf("open without namespace = %v, want ErrKeyNotFound", err)
+	}
+}
+
+// Rotating the Transit key leaves data encrypted before the rotation
+// readable: the DEK wrapped by the old version still unwraps, and the next
+// start rewraps it under the new version.
+func TestVaultKeyRotation(t *testing.T) {
+	vault := newVault(t)
+	cfg, rec := vaultConfig(t, vault)
+	p, err := kms.NewVaultTransitProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, nonce, err := p.Encrypt(vaultKeyName, []byte("4111111111111111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	v1 := readWrappedDEK(t, cfg.DEKPath)
+	if !strings.HasPrefix(string(v1.Wrapped), "vault:v1:") {
+		t.Fatalf("wrapped DEK %q, want a v1 ciphertext", v1.Wrapped)
+	}
+
+	for version := 2; version <= 3; version++ {
+		if err := vault.RotateKey(vaultKeyName); err != nil {
+			t.Fatal(err)
+		}
+		p, err := kms.NewVaultTransitProvider(cfg)
+		if err != nil {
+			t.Fatalf("open after rotation to v%d: %v", version, err)
+		}
+		pt, err := p.Decrypt(vaultKeyName, ct, nonce)
+		if err != nil || string(pt) != "4111111111111111" {
+			t.Fatalf("Decrypt after rotation to v%d = %q, %v", version, pt, err)
+		}
+		if w := readWrappedDEK(t, cfg.DEKPath); !strings.HasPrefix(string(w.Wrapped), fmt.Sprintf("vault:v%d:", version)) {
+			t.Errorf("wrapped DEK %q not rewrapped under v%d", w.Wrapped, version)
+		}
+
+		// The original v1 envelope still unwraps to the same DEK.
+		dek, err := p.UnwrapDEK(&v1)
+		if err != nil {
+			t.Fatalf("UnwrapDEK of the v1 envelope: %v", err)
+		}
+		if len(dek) != 32 {
+			t.Errorf("v1 DEK is %d bytes", len(dek))
+		}
+		p.Close()
+	}
+	if n := rec.count("transit/rewrap/" + vaultKeyName); n != 2 {
+		t.Errorf("%d rewrap requests, want one per rotation", n)
+	}
+
+	// Opening again without a rotation does not rewrap.
+	p, err = kms.NewVaultTransitProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if n := rec.count("transit/rewrap/" + vaultKeyName); n != 2 {
+		t.Errorf("%d rewrap requests after reopening, want still 2", n)
+	}
+}
+
+// A least-privilege token may lack read on transit/keys or update on
+// transit/rewrap. The rewrap check is best-effort: the provider opens with
+// the DEK it decrypted and keeps the file as is. Only a DEK that cannot be
+// decrypted fails startup.
+func TestVaultRewrapDenied(t *testing.T) {
+	keys := "GET transit/keys/" + vaultKeyName
+	for _, tc := range []struct {
+		name   string
+		deny   map[string]int
+		rotate bool
+		want   error // sentinel, if opening fails
+	}{
+		{name: "key read forbidden", deny: map[string]int{keys: http.StatusForbidden}},
+		{name: "key read not found", deny: map[string]int{keys: http.StatusNotFound}},
+		{name: "key read forbidden after rotation", deny: map[string]int{keys: http.StatusForbidden}, rotate: true},
+		{name: "rewrap forbidden", deny: map[string]int{"POST transit/rewrap/" + vaultKeyName: http.StatusForbidden}, rotate: true},
+		{name: "decrypt forbidden", deny: map[string]int{"POST transit/decrypt/" + vaultKeyName: http.StatusForbidden}, want: kms.ErrPermissionDenied},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vault := newVault(t)
+			cfg, rec := vaultConfig(t, vault)
+			p, err := kms.NewVaultTransitProvider(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ct, nonce, err := p.Encrypt(vaultKeyName, []byte("4111111111111111"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Close()
+			before := readWrappedDEK(t, cfg.DEKPath)
+			if tc.rotate {
+				if err := vault.RotateKey(vaultKeyName); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec.mu.Lock()
+			rec.deny = tc.deny
+			rec.mu.Unlock()
+			p, err = kms.NewVaultTransitProvider(cfg)
+			if tc.want != nil {
+				if !errors.Is(err, tc.want) {
+					t.Fatalf("open = %v, want %v", err, tc.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open = %v, want the rewrap check skipped", err)
+			}
+			defer p.Close()
+			if pt, err := p.Decrypt(vaultKeyName, ct, nonce); err != nil || string(pt) != "4111111111111111" {
+				t.Errorf("Decrypt = %q, %v", pt, err)
+			}
+			if after := readWrappedDEK(t, cfg.DEKPath); string(after.Wrapped) != string(before.Wrapped) {
+				t.Errorf("wrapped DEK changed to %q, want it kept", after.Wrapped)
+			}
+		})
+	}
+}
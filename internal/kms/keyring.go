@@ -0,0 +1,692 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"kms/internal/tenant"
+)
+
+// KeyState is the lifecycle state of a named key.
+type KeyState string
+
+const (
+	KeyEnabled         KeyState = "ENABLED"
+	KeyDisabled        KeyState = "DISABLED"
+	KeyPendingDeletion KeyState = "PENDING_DELETION"
+)
+
+// Bounds of the window between ScheduleKeyDeletion and the deletion itself.
+const (
+	MinDeletionWindow     = 7 * 24 * time.Hour
+	MaxDeletionWindow     = 30 * 24 * time.Hour
+	DefaultDeletionWindow = MaxDeletionWindow
+)
+
+// keyIDPattern allows one tenant prefix, "payments/pan"; see package tenant.
+var keyIDPattern = regexp.MustCompile(`^(?:[A-Za-z0-9][A-Za-z0-9._-]{0,63}/)?[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// KeyMetadata describes a named key without its key material.
+type KeyMetadata struct {
+	ID          string
+	Description string
+	State       KeyState
+	CreateTime  time.Time
+	// DeletionTime is set while the key is pending deletion.
+	DeletionTime time.Time
+	// Versions are oldest first; the last one is primary.
+	Versions []KeyVersionMetadata
+}
+
+type KeyVersionMetadata struct {
+	Version    uint32
+	CreateTime time.Time
+}
+
+// PrimaryVersion returns the version new ciphertexts are encrypted with.
+func (m KeyMetadata) PrimaryVersion() uint32 {
+	if len(m.Versions) == 0 {
+		return 0
+	}
+	return m.Versions[len(m.Versions)-1].Version
+}
+
+// Keyring holds the named keys of the key store: AES-256 keys with versions,
+// created and rotated through KMSAdmin. The store is a JSON file in which
+// every key version is wrapped by a key-encryption Manager (the configured
+// key backend), so it is useless without the backend. Unwrapped versions are
+// cached in memory until Seal.
+//
+// Ciphertexts of a named key start with a header naming the key and version:
+//
+//	"KMS" | 0x01 | len(key ID) | key ID | version (uint32, big endian)
+//
+// followed by the AES-GCM output. The header is authenticated as additional
+// data, so it cannot be altered to point at another key. Ciphertexts of the
+// key backend's own key carry no header.
+type Keyring struct {
+	path string
+	kek  Manager
+	now  func() time.Time
+
+	mu     sync.RWMutex
+	keys   map[string]*storedKey
+	cache  map[keyVersion]*unwrappedKey
+	sealed bool
+}
+
+type keyVersion struct {
+	id      string
+	version uint32
+}
+
+type unwrappedKey struct {
+	material []byte
+	aead     cipher.AEAD
+}
+
+// keyStoreFile is the JSON form of the key store.
+type keyStoreFile struct {
+	Keys []*storedKey `json:"keys"`
+}
+
+type storedKey struct {
+	ID           string          `json:"id"`
+	Description  string          `json:"description,omitempty"`
+	State        KeyState        `json:"state"`
+	CreateTime   time.Time       `json:"create_time"`
+	DeletionTime time.Time       `json:"deletion_time,omitzero"`
+	Versions     []storedVersion `json:"versions"`
+}
+
+// storedVersion holds one version's key material as returned by the KEK's
+// Encrypt.
+type storedVersion struct {
+	Version    uint32    `json:"version"`
+	CreateTime time.Time `json:"create_time"`
+	Wrapped    []byte    `json:"wrapped"`
+	Nonce      []byte    `json:"nonce"`
+}
+
+// OpenKeyring loads the key store at path, which need not exist yet, and
+// wraps new key versions with kek. Keys whose deletion time has passed are
+// removed.
+func OpenKeyring(path string, kek Manager) (*Keyring, error) {
+	if path == "" {
+		return nil, errors.New("key store path is required")
+	}
+	r := &Keyring{
+		path:  path,
+		kek:   kek,
+		now:   time.Now,
+		keys:  map[string]*storedKey{},
+		cache: map[keyVersion]*unwrappedKey{},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var f keyStoreFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse key store %s: %w", path, err)
+		}
+		for _, k := range f.Keys {
+			if !keyIDPattern.MatchString(k.ID) || len(k.Versions) == 0 || r.keys[k.ID] != nil {
+				return nil, fmt.Errorf("key store %s: invalid or duplicate key %q", path, k.ID)
+			}
+			r.keys[k.ID] = k
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.purgeLocked(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CreateKey creates an enabled key with a new version 1.
+func (r *Keyring) CreateKey(id, description string) (KeyMetadata, error) {
+	if err := checkKeyID(id); err != nil {
+		return KeyMetadata{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.purgeLocked(); err != nil {
+		return KeyMetadata{}, err
+	}
+	if r.keys[id] != nil {
+		return KeyMetadata{}, fmt.Errorf("%w: %q", ErrKeyExists, id)
+	}
+
+	now := r.now().UTC()
+	k := &storedKey{ID: id, Description: description, State: KeyEnabled, CreateTime: now}
+	if err := r.addVersionLocked(k, now); err != nil {
+		return KeyMetadata{}, err
+	}
+	r.keys[id] = k
+	if err := r.saveLocked(); err != nil {
+		delete(r.keys, id)
+		r.forgetLocked(id)
+		return KeyMetadata{}, err
+	}
+	slog.Info("KMS keys: created key", "key_id", id)
+	return k.metadata(), nil
+}
+
+// ListKeys returns every key, sorted by ID.
+func (r *Keyring) ListKeys() ([]KeyMetadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.purgeLocked(); err != nil {
+		return nil, err
+	}
+	keys := make([]KeyMetadata, 0, len(r.keys))
+	for _, k := range r.keys {
+		keys = append(keys, k.metadata())
+	}
+	slices.SortFunc(keys, func(a, b KeyMetadata) int { return strings.Compare(a.ID, b.ID) })
+	return keys, nil
+}
+
+// DescribeKey returns the metadata of key id.
+func (r *Keyring) DescribeKey(id string) (KeyMetadata, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k, err := r.lookupLocked(id)
+	if err != nil {
+		return KeyMetadata{}, err
+	}
+	return k.metadata(), nil
+}
+
+// RotateKey adds a new primary version to an enabled key.
+func (r *Keyring) RotateKey(id string) (KeyMetadata, error) {
+	return r.update(id, "rotated", func(k *storedKey) error {
+		if k.State != KeyEnabled {
+			return fmt.Errorf("%w: %q is %s", ErrKeyDisabled, id, k.State)
+		}
+		return r.addVersionLocked(k, r.now().UTC())
+	})
+}
+
+// EnableKey enables a disabled key or cancels its scheduled deletion.
+func (r *Keyring) EnableKey(id string) (KeyMetadata, error) {
+	return r.update(id, "enabled", func(k *storedKey) error {
+		k.State = KeyEnabled
+		k.DeletionTime = time.Time{}
+		return nil
+	})
+}
+
+// DisableKey disables an enabled key. A key pending deletion stays so.
+func (r *Keyring) DisableKey(id string) (KeyMetadata, error) {
+	return r.update(id, "disabled", func(k *storedKey) error {
+		if k.State == KeyPendingDeletion {
+			return fmt.Errorf("%w: %q is pending deletion", ErrKeyDisabled, id)
+		}
+		k.State = KeyDisabled
+		return nil
+	})
+}
+
+// ScheduleKeyDeletion disables key id and deletes it after window, which
+// must lie between MinDeletionWindow and MaxDeletionWindow.
+func (r *Keyring) ScheduleKeyDeletion(id string, window time.Duration) (KeyMetadata, error) {
+	if window < MinDeletionWindow || window > MaxDeletionWindow {
+		return KeyMetadata{}, fmt.Errorf("deletion window %s is outside %s..%s", window, MinDeletionWindow, MaxDeletionWindow)
+	}
+	return r.update(id, "scheduled for deletion", func(k *storedKey) error {
+		if k.State == KeyPendingDeletion {
+			return fmt.Errorf("%w: %q is already pending deletion", ErrKeyDisabled, id)
+		}
+		k.State = KeyPendingDeletion
+		k.DeletionTime = r.now().UTC().Add(window)
+		return nil
+	})
+}
+
+// Encrypt encrypts plaintext with the primary version of key id.
+func (r *Keyring) Encrypt(id string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	k, err := r.usable(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	version := k.Versions[len(k.Versions)-1].Version
+	uk, err := r.unwrap(k, version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, uk.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	header := appendKeyHeader(nil, id, version)
+	return uk.aead.Seal(header, nonce, plaintext, header), nonce, nil
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt with key id, using the
+// version named in its header.
+func (r *Keyring) Decrypt(id string, ciphertext, nonce []byte) ([]byte, error) {
+	headerID, version, sealed, err := parseKeyHeader(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if headerID != id {
+		// Do not tell a tenant the names of another tenant's keys.
+		if ht, _ := tenant.Split(headerID); ht != "" {
+			if t, _ := tenant.Split(id); t != ht {
+				return nil, fmt.Errorf("%w: encrypted with another tenant's key, not %q", ErrInvalidCiphertext, id)
+			}
+		}
+		return nil, fmt.Errorf("%w: encrypted with key %q, not %q", ErrInvalidCiphertext, headerID, id)
+	}
+	k, err := r.usable(id)
+	if err != nil {
+		return nil, err
+	}
+	uk, err := r.unwrap(k, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != uk.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidNonce, uk.aead.NonceSize(), len(nonce))
+	}
+	header := ciphertext[:len(ciphertext)-len(sealed)]
+	plaintext, err := uk.aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+// Seal zeroes the cached key material and fails every key operation with
+// ErrSealed until Unseal.
+func (r *Keyring) Seal() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for kv := range r.cache {
+		r.dropLocked(kv)
+	}
+	r.sealed = true
+}
+
+// Unseal allows key operations again; versions are unwrapped on first use.
+func (r *Keyring) Unseal() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sealed = false
+}
+
+// Len returns the number of keys in the store.
+func (r *Keyring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.keys)
+}
+
+// update applies fn to a copy of key id and saves it, leaving the key
+// unchanged if fn or the save fails.
+func (r *Keyring) update(id, action string, fn func(k *storedKey) error) (KeyMetadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.purgeLocked(); err != nil {
+		return KeyMetadata{}, err
+	}
+	old, err := r.lookupLocked(id)
+	if err != nil {
+		return KeyMetadata{}, err
+	}
+	k := *old
+	k.Versions = slices.Clone(old.Versions)
+	if err := fn(&k); err != nil {
+		return KeyMetadata{}, err
+	}
+	r.keys[id] = &k
+	if err := r.saveLocked(); err != nil {
+		r.keys[id] = old
+		return KeyMetadata{}, err
+	}
+	slog.Info("KMS keys: key "+action, "key_id", id)
+	return k.metadata(), nil
+}
+
+// usable returns key id if it may be used for encryption and decryption.
+func (r *Keyring) usable(id string) (*storedKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.sealed {
+		return nil, ErrSealed
+	}
+	k, err := r.lookupLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	if k.State != KeyEnabled {
+		return nil, fmt.Errorf("%w: %q is %s", ErrKeyDisabled, id, k.State)
+	}
+	return k, nil
+}
+
+// lookupLocked returns key id; a key past its deletion time no longer
+// exists even before purgeLocked removes it.
+func (r *Keyring) lookupLocked(id string) (*storedKey, error) {
+	if err := checkKeyID(id); err != nil {
+		return nil, err
+	}
+	k := r.keys[id]
+	if k == nil || k.expired(r.now()) {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return k, nil
+}
+
+// unwrap returns the material of a version of k, unwrapping it with the KEK
+// on first use.
+func (r *Keyring) unwrap(k *storedKey, version uint32) (*unwrappedKey, error) {
+	kv := keyVersion{k.ID, version}
+	r.mu.RLock()
+	uk := r.cache[kv]
+	r.mu.RUnlock()
+	if uk != nil {
+		return uk, nil
+	}
+
+	i := slices.IndexFunc(k.Versions, func(v storedVersion) bool { return v.Version == version })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %q has no version %d", ErrKeyNotFound, k.ID, version)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sealed {
+		return nil, ErrSealed
+	}
+	if uk := r.cache[kv]; uk != nil {
+		return uk, nil
+	}
+	uk, err := unwrapVersion(r.kek, k.ID, k.Versions[i])
+	if err != nil {
+		return nil, err
+	}
+	r.cache[kv] = uk
+	return uk, nil
+}
+
+func unwrapVersion(kek Manager, id string, v storedVersion) (*unwrappedKey, error) {
+	material, err := kek.Decrypt(v.Wrapped, v.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key %q version %d: %w", id, v.Version, err)
+	}
+	uk, err := newUnwrappedKey(material)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key %q version %d: %w", id, v.Version, err)
+	}
+	return uk, nil
+}
+
+// CheckKEK tells whether kek can unwrap the key store, before it replaces
+// the KEK in use: it must unwrap the newest version of at least one key. The
+// cache is left alone. An empty key store passes.
+func (r *Keyring) CheckKEK(kek Manager) error {
+	r.mu.RLock()
+	keys := make([]*storedKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		keys = append(keys, k)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(keys, func(a, b *storedKey) int { return strings.Compare(a.ID, b.ID) })
+
+	var errs []error
+	for _, k := range keys {
+		uk, err := unwrapVersion(kek, k.ID, k.Versions[len(k.Versions)-1])
+		if err == nil {
+			for i := range uk.material {
+				uk.material[i] = 0
+			}
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cannot unwrap any key in the key store: %w", errors.Join(errs...))
+}
+
+// addVersionLocked generates, wraps and appends a new version to k and
+// caches its material.
+func (r *Keyring) addVersionLocked(k *storedKey, now time.Time) error {
+	if r.sealed {
+		return ErrSealed
+	}
+	material := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		return err
+	}
+	wrapped, nonce, err := r.kek.Encrypt(material)
+	if err != nil {
+		return fmt.Errorf("wrap new key version: %w", err)
+	}
+	uk, err := newUnwrappedKey(material)
+	if err != nil {
+		return err
+	}
+
+	var version uint32 = 1
+	if n := len(k.Versions); n > 0 {
+		version = k.Versions[n-1].Version + 1
+	}
+	k.Versions = append(k.Versions, storedVersion{Version: version, CreateTime: now, Wrapped: wrapped, Nonce: nonce})
+	r.cache[keyVersion{k.ID, version}] = uk
+	return nil
+}
+
+// purgeLocked deletes the keys whose deletion time has passed.
+func (r *Keyring) purgeLocked() error {
+	now := r.now()
+	var purged []string
+	for id, k := range r.keys {
+		if k.expired(now) {
+			purged = append(purged, id)
+		}
+	}
+	if len(purged) == 0 {
+		return nil
+	}
+	for _, id := range purged {
+		delete(r.keys, id)
+		r.forgetLocked(id)
+	}
+	if err := r.saveLocked(); err != nil {
+		return err
+	}
+	slices.Sort(purged)
+	slog.Info("KMS keys: deleted keys", "key_ids", purged)
+	return nil
+}
+
+// forgetLocked zeroes and drops the cached versions of key id.
+func (r *Keyring) forgetLocked(id string) {
+	for kv := range r.cache {
+		if kv.id == id {
+			r.dropLocked(kv)
+		}
+	}
+}
+
+func (r *Keyring) dropLocked(kv keyVersion) {
+	uk := r.cache[kv]
+	for i := range uk.material {
+		uk.material[i] = 0
+	}
+	delete(r.cache, kv)
+}
+
+func (r *Keyring) saveLocked() error {
+	f := keyStoreFile{Keys: make([]*storedKey, 0, len(r.keys))}
+	for _, k := range r.keys {
+		f.Keys = append(f.Keys, k)
+	}
+	slices.SortFunc(f.Keys, func(a, b *storedKey) int { return strings.Compare(a.ID, b.ID) })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.path, data); err != nil {
+		return fmt.Errorf("save key store: %w", err)
+	}
+	return nil
+}
+
+func (k *storedKey) expired(now time.Time) bool {
+	return k.State == KeyPendingDeletion && !now.Before(k.DeletionTime)
+}
+
+func (k *storedKey) metadata() KeyMetadata {
+	m := KeyMetadata{
+		ID:           k.ID,
+		Description:  k.Description,
+		State:        k.State,
+		CreateTime:   k.CreateTime,
+		DeletionTime: k.DeletionTime,
+	}
+	for _, v := range k.Versions {
+		m.Versions = append(m.Versions, KeyVersionMetadata{Version: v.Version, CreateTime: v.CreateTime})
+	}
+	return m
+}
+
+func newUnwrappedKey(material []byte) (*unwrappedKey, error) {
+	if len(material) != dekSize {
+		return nil, fmt.Errorf("key material must be %d bytes, got %d", dekSize, len(material))
+	}
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &unwrappedKey{material: material, aead: aead}, nil
+}
+
+// ReservedKeyID stands for the key backend's own key in configuration, so
+// no named key may use it. A tenant's "tenant/default" is an ordinary key.
+const ReservedKeyID = "default"
+
+func checkKeyID(id string) error {
+	if !keyIDPattern.MatchString(id) {
+		return fmt.Errorf("%w: %q (use 1-64 letters, digits, '.', '_' or '-', after an optional \"tenant/\")", ErrInvalidKeyID, id)
+	}
+	if id == ReservedKeyID {
+		return fmt.Errorf("%w: %q is reserved for the key backend's key", ErrInvalidKeyID, id)
+	}
+	return nil
+}
+
+const (
+	keyHeaderMagic   = "KMS"
+	keyHeaderVersion = 1
+)
+
+func appendKeyHeader(b []byte, id string, version uint32) []byte {
+	b = append(b, keyHeaderMagic...)
+	b = append(b, keyHeaderVersion, byte(len(id)))
+	b = append(b, id...)
+	return binary.BigEndian.AppendUint32(b, version)
+}
+
+// parseKeyHeader splits a named-key ciphertext into its key ID, version and
+// the AES-GCM output that follows the header.
+func parseKeyHeader(ciphertext []byte) (id string, version uint32, rest []byte, err error) {
+	n := len(keyHeaderMagic)
+	if len(ciphertext) < n+2 || string(ciphertext[:n]) != keyHeaderMagic {
+		return "", 0, nil, fmt.Errorf("%w: no named key header", ErrInvalidCiphertext)
+	}
+	if ciphertext[n] != keyHeaderVersion {
+		return "", 0, nil, fmt.Errorf("%w: unknown header format %d", ErrInvalidCiphertext, ciphertext[n])
+	}
+	idLen := int(ciphertext[n+1])
+	b := ciphertext[n+2:]
+	if len(b) < idLen+4 {
+		return "", 0, nil, fmt.Errorf("%w: truncated header", ErrInvalidCiphertext)
+	}
+	return string(b[:idLen]), binary.BigEndian.Uint32(b[idLen:]), b[idLen+4:], nil
+}
+
+// CiphertextKey returns the key ID and version recorded in the header of a
+// named-key ciphertext; ok is false for other ciphertexts, such as those of
+// the key backend's key.
+func CiphertextKey(ciphertext []byte) (id string, version uint32, ok bool) {
+	id, version, _, err := parseKeyHeader(ciphertext)
+	return id, version, err == nil
+}
+
+// CiphertextInfo describes a ciphertext as far as its bytes tell, without a
+// key.
+type CiphertextInfo struct {
+	// Named is true for a named-key ciphertext, false for the legacy format:
+	// bare AES-GCM output of the key backend's key.
+	Named bool
+	// HeaderVersion is the version of the named-key header, 0 when legacy.
+	HeaderVersion int
+	KeyID         string
+	KeyVersion    uint32
+	Algorithm     string
+	// AAD is set when the AES-GCM output authenticates additional data: the
+	// header of a named-key ciphertext.
+	AAD            bool
+	PlaintextBytes int
+}
+
+// gcmTagSize is the AES-GCM tag at the end of every ciphertext.
+const gcmTagSize = 16
+
+// DescribeCiphertext parses the header of a ciphertext, if any, and checks
+// that enough bytes follow it for an AES-GCM tag. A ciphertext starting with
+// the header magic but malformed is an error, not a legacy ciphertext.
+func DescribeCiphertext(ciphertext []byte) (CiphertextInfo, error) {
+	info := CiphertextInfo{Algorithm: "AES-GCM"}
+	sealed := ciphertext
+	if strings.HasPrefix(string(ciphertext), keyHeaderMagic) {
+		id, version, rest, err := parseKeyHeader(ciphertext)
+		if err != nil {
+			return CiphertextInfo{}, err
+		}
+		info = CiphertextInfo{
+			Named:         true,
+			HeaderVersion: keyHeaderVersion,
+			KeyID:         id,
+			KeyVersion:    version,
+			Algorithm:     "AES-256-GCM",
+			AAD:           true,
+		}
+		sealed = rest
+	}
+	if len(sealed) < gcmTagSize {
+		return CiphertextInfo{}, fmt.Errorf("%w: %d bytes is shorter than the %d-byte tag", ErrInvalidCiphertext, len(sealed), gcmTagSize)
+	}
+	info.PlaintextBytes = len(sealed) - gcmTagSize
+	return info, nil
+}
@@ -0,0 +1,307 @@
+// Package kmstest provides in-process stand-ins for the key backends used by
+// internal/kms, so providers can be exercised without HSMs or cloud accounts.
+package kmstest
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// AzureKeyVault is an httptest stand-in for the Azure Key Vault keys REST API.
+//
+// It implements the subset used by the azure provider: get key, wrapkey and
+// unwrapkey for RSA keys (RSA-OAEP-256) and AES keys (A256KW), plus the
+// challenge-based bearer authentication the azkeys client performs. Clients
+// must use the server's TLS client and set DisableChallengeResourceVerification.
+type AzureKeyVault struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	keys map[string][]*azureKeyVersion
+}
+
+type azureKeyVersion struct {
+	version string
+	rsa     *rsa.PrivateKey
+	oct     []byte
+}
+
+// NewAzureKeyVault starts a Key Vault stand-in with no keys.
+func NewAzureKeyVault() *AzureKeyVault {
+	v := &AzureKeyVault{keys: make(map[string][]*azureKeyVersion)}
+	v.Server = httptest.NewTLSServer(http.HandlerFunc(v.serveHTTP))
+	return v
+}
+
+// CreateRSAKey creates (or adds a new version of) an RSA-2048 key.
+func (v *AzureKeyVault) CreateRSAKey(name string) error {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	return v.addVersion(name, &azureKeyVersion{rsa: priv})
+}
+
+// CreateAESKey creates (or adds a new version of) a 256-bit AES key.
+func (v *AzureKeyVault) CreateAESKey(name string) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	return v.addVersion(name, &azureKeyVersion{oct: key})
+}
+
+// RotateKey adds a new version of an existing key with the same key type.
+// Older versions stay usable for unwrapkey, as in Key Vault.
+func (v *AzureKeyVault) RotateKey(name string) error {
+	v.mu.Lock()
+	versions := v.keys[name]
+	v.mu.Unlock()
+	if len(versions) == 0 {
+		return fmt.Errorf("key %q not found", name)
+	}
+	if versions[len(versions)-1].rsa != nil {
+		return v.CreateRSAKey(name)
+	}
+	return v.CreateAESKey(name)
+}
+
+func (v *AzureKeyVault) addVersion(name string, kv *azureKeyVersion) error {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return err
+	}
+	kv.version = hex.EncodeToString(id)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[name] = append(v.keys[name], kv)
+	return nil
+}
+
+func (v *AzureKeyVault) lookup(name, version string) *azureKeyVersion {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	versions := v.keys[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	if version == "" {
+		return versions[len(versions)-1]
+	}
+	for _, kv := range versions {
+		if kv.version == version {
+			return kv
+		}
+	}
+	return nil
+}
+
+func (v *AzureKeyVault) kid(name, version string) string {
+	return v.URL + "/keys/" + name + "/" + version
+}
+
+func (v *AzureKeyVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Challenge-based auth: the first request arrives without a token.
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		w.Header().Set("WWW-Authenticate",
+			`Bearer authorization="https://login.microsoftonline.com/00000000-0000-0000-0000-000000000000", resource="https://vault.azure.net"`)
+		azureError(w, http.StatusUnauthorized, "Unauthorized", "missing bearer token")
+		return
+	}
+
+	// Paths: /keys/{name}[/{version}][/{wrapkey|unwrapkey}]. The client drops
+	// the version segment entirely when it asks for the latest version.
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] != "keys" {
+		azureError(w, http.StatusNotFound, "NotFound", "unknown path "+r.URL.Path)
+		return
+	}
+	op := ""
+	if last := parts[len(parts)-1]; last == "wrapkey" || last == "unwrapkey" {
+		op = last
+		parts = parts[:len(parts)-1]
+	}
+	name := parts[1]
+	version := ""
+	if len(parts) > 2 {
+		version = parts[2]
+	}
+
+	kv := v.lookup(name, version)
+	if kv == nil {
+		azureError(w, http.StatusNotFound, "KeyNotFound", fmt.Sprintf("key %s/%s not found", name, version))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && op == "":
+		v.getKey(w, name, kv)
+	case r.Method == http.MethodPost && op == "wrapkey":
+		v.keyOperation(w, r, name, kv, true)
+	case r.Method == http.MethodPost && op == "unwrapkey":
+		v.keyOperation(w, r, name, kv, false)
+	default:
+		azureError(w, http.StatusBadRequest, "BadParameter", "unsupported operation "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (v *AzureKeyVault) getKey(w http.ResponseWriter, name string, kv *azureKeyVersion) {
+	key := map[string]interface{}{
+		"kid":     v.kid(name, kv.version),
+		"key_ops": []string{"wrapKey", "unwrapKey"},
+	}
+	if kv.rsa != nil {
+		key["kty"] = "RSA"
+		key["n"] = b64url(kv.rsa.N.Bytes())
+		key["e"] = b64url(big.NewInt(int64(kv.rsa.E)).Bytes())
+	} else {
+		key["kty"] = "oct"
+	}
+	azureJSON(w, map[string]interface{}{
+		"key":        key,
+		"attributes": map[string]interface{}{"enabled": true},
+	})
+}
+
+func (v *AzureKeyVault) keyOperation(w http.ResponseWriter, r *http.Request, name string, kv *azureKeyVersion, wrap bool) {
+	var req struct {
+		Alg   string `json:"alg"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		azureError(w, http.StatusBadRequest, "BadParameter", "invalid request body")
+		return
+	}
+	value, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Value, "="))
+	if err != nil {
+		azureError(w, http.StatusBadRequest, "BadParameter", "value is not base64url")
+		return
+	}
+
+	var result []byte
+	switch {
+	case kv.rsa != nil && req.Alg == "RSA-OAEP-256":
+		if wrap {
+			result, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, &kv.rsa.PublicKey, value, nil)
+		} else {
+			result, err = rsa.DecryptOAEP(sha256.New(), nil, kv.rsa, value, nil)
+		}
+	case kv.oct != nil && req.Alg == "A256KW":
+		if wrap {
+			result, err = aesKeyWrap(kv.oct, value)
+		} else {
+			result, err = aesKeyUnwrap(kv.oct, value)
+		}
+	default:
+		azureError(w, http.StatusBadRequest, "BadParameter", "algorithm "+req.Alg+" not supported for this key")
+		return
+	}
+	if err != nil {
+		azureError(w, http.StatusBadRequest, "BadParameter", err.Error())
+		return
+	}
+
+	azureJSON(w, map[string]string{
+		"kid":   v.kid(name, kv.version),
+		"value": b64url(result),
+	})
+}
+
+func azureJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func azureError(w http.ResponseWriter, code int, errCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": errCode, "message": message},
+	})
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// aesKeyWrap implements RFC 3394 AES key wrap with the default IV.
+func aesKeyWrap(kek, plaintext []byte) ([]byte, error) {
+	if len(plaintext)%8 != 0 || len(plaintext) < 16 {
+		return nil, fmt.Errorf("key wrap input must be a multiple of 8 bytes and at least 16 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(plaintext) / 8
+	a := []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+	r := make([]byte, len(plaintext))
+	copy(r, plaintext)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Encrypt(buf, buf)
+			t := uint64(n*j + i + 1)
+			for k := 0; k < 8; k++ {
+				buf[7-k] ^= byte(t >> (8 * k))
+			}
+			copy(a, buf[:8])
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// aesKeyUnwrap reverses aesKeyWrap and verifies the integrity check value.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, fmt.Errorf("wrapped key must be a multiple of 8 bytes and at least 24 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			copy(buf, a)
+			for k := 0; k < 8; k++ {
+				buf[7-k] ^= byte(t >> (8 * k))
+			}
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	for _, b := range a {
+		if b != 0xA6 {
+			return nil, fmt.Errorf("key unwrap integrity check failed")
+		}
+	}
+	return r, nil
+}
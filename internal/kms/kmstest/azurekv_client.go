@@ -0,0 +1,60 @@
+//go:build azure
+// +build azure
+
+package kmstest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// StaticCredential satisfies azcore.TokenCredential for the stand-in, which
+// accepts any bearer token. Each GetToken call is counted.
+type StaticCredential struct {
+	// Err, if set, is returned instead of a token.
+	Err error
+	// Lifetime of the tokens, default an hour. azcore fetches a new token
+	// once one has expired, so a negative lifetime gets one per request.
+	Lifetime time.Duration
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *StaticCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.Err != nil {
+		return azcore.AccessToken{}, c.Err
+	}
+	lifetime := c.Lifetime
+	if lifetime == 0 {
+		lifetime = time.Hour
+	}
+	return azcore.AccessToken{Token: "kmstest", ExpiresOn: time.Now().Add(lifetime)}, nil
+}
+
+// Calls returns the number of tokens requested.
+func (c *StaticCredential) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// NewClient returns an azkeys client for the stand-in, authenticating with
+// cred (a fresh StaticCredential if nil).
+func (v *AzureKeyVault) NewClient(cred azcore.TokenCredential) (*azkeys.Client, error) {
+	if cred == nil {
+		cred = &StaticCredential{}
+	}
+	return azkeys.NewClient(v.URL, cred, &azkeys.ClientOptions{
+		ClientOptions:                        azcore.ClientOptions{Transport: v.Client()},
+		DisableChallengeResourceVerification: true,
+	})
+}
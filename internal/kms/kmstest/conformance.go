@@ -0,0 +1,268 @@
+package kmstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"kms/internal/kms"
+)
+
+// ProviderFactory opens a fresh provider for one conformance check. Every
+// provider it returns must use the same key, so ciphertexts stay decryptable
+// across providers from the same factory.
+type ProviderFactory func() (kms.HSMProvider, error)
+
+// ConformanceOptions tunes TestProvider.
+type ConformanceOptions struct {
+	// KeyID is passed to the provider (default "default").
+	KeyID string
+
+	// Goroutines and Iterations size the concurrency check (defaults 8 and 25).
+	Goroutines int
+	Iterations int
+}
+
+// TestProvider checks that providers from open behave as internal/kms
+// expects of an HSMProvider:
+//
+//   - round trip of empty, short and large plaintexts, with a fresh nonce
+//     per encryption
+//   - decryption with a modified or wrongly sized nonce fails
+//   - decryption of tampered, truncated or empty ciphertext fails
+//   - concurrent use from many goroutines
+//   - Close is idempotent, and calls after Close fail without panicking
+//   - GetKey does not export key material
+//
+// Like testing/fstest.TestFS it returns an error describing every failed
+// check, or nil, so it can be called from a test or from a command such as
+// cmd/hsm-conformance. Panics inside the provider are reported as failures.
+func TestProvider(open ProviderFactory, opts ConformanceOptions) error {
+	if opts.KeyID == "" {
+		opts.KeyID = "default"
+	}
+	if opts.Goroutines <= 0 {
+		opts.Goroutines = 8
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = 25
+	}
+
+	c := &conformance{open: open, opts: opts}
+	c.run("round trip", c.roundTrip)
+	c.run("wrong nonce", c.wrongNonce)
+	c.run("tampered ciphertext", c.tamperedCiphertext)
+	c.run("concurrency", c.concurrency)
+	c.run("close", c.close)
+	c.run("GetKey policy", c.getKeyPolicy)
+	return errors.Join(c.errs...)
+}
+
+type conformance struct {
+	open ProviderFactory
+	opts ConformanceOptions
+	errs []error
+}
+
+func (c *conformance) run(name string, check func(p kms.HSMProvider) error) {
+	p, err := c.open()
+	if err != nil {
+		c.errs = append(c.errs, fmt.Errorf("%s: open provider: %w", name, err))
+		return
+	}
+	if err := protect(func() error { return check(p) }); err != nil {
+		c.errs = append(c.errs, fmt.Errorf("%s: %w", name, err))
+	}
+	protect(p.Close)
+}
+
+// protect runs fn and turns a panic into an error.
+func protect(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{r}
+		}
+	}()
+	return fn()
+}
+
+func (c *conformance) roundTrip(p kms.HSMProvider) error {
+	large := bytes.Repeat([]byte("4111111111111111"), 4096)
+	for _, pt := range [][]byte{{}, []byte("4111111111111111"), large} {
+		ct, nonce, err := p.Encrypt(c.opts.KeyID, pt)
+		if err != nil {
+			return fmt.Errorf("encrypt %d bytes: %w", len(pt), err)
+		}
+		if len(pt) > 0 && bytes.Contains(ct, pt) {
+			return fmt.Errorf("ciphertext of %d bytes contains the plaintext", len(pt))
+		}
+		got, err := p.Decrypt(c.opts.KeyID, ct, nonce)
+		if err != nil {
+			return fmt.Errorf("decrypt %d bytes: %w", len(pt), err)
+		}
+		if !bytes.Equal(got, pt) {
+			return fmt.Errorf("round trip of %d bytes returned different plaintext", len(pt))
+		}
+	}
+
+	pt := []byte("4111111111111111")
+	ct1, n1, err := p.Encrypt(c.opts.KeyID, pt)
+	if err != nil {
+		return err
+	}
+	ct2, n2, err := p.Encrypt(c.opts.KeyID, pt)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(n1, n2) || bytes.Equal(ct1, ct2) {
+		return errors.New("two encryptions of the same plaintext share a nonce or ciphertext")
+	}
+
+	// A second provider with the same key must read the first one's output.
+	other, err := c.open()
+	if err != nil {
+		return fmt.Errorf("open second provider: %w", err)
+	}
+	defer other.Close()
+	got, err := other.Decrypt(c.opts.KeyID, ct1, n1)
+	if err != nil {
+		return fmt.Errorf("decrypt with a second provider: %w", err)
+	}
+	if !bytes.Equal(got, pt) {
+		return errors.New("second provider returned different plaintext")
+	}
+	return nil
+}
+
+func (c *conformance) wrongNonce(p kms.HSMProvider) error {
+	ct, nonce, err := p.Encrypt(c.opts.KeyID, []byte("4111111111111111"))
+	if err != nil {
+		return err
+	}
+
+	flipped := append([]byte(nil), nonce...)
+	flipped[0] ^= 0x01
+	if _, err := p.Decrypt(c.opts.KeyID, ct, flipped); err == nil {
+		return errors.New("decrypt succeeded with a modified nonce")
+	}
+
+	for _, bad := range [][]byte{nil, nonce[:len(nonce)-1], append(append([]byte(nil), nonce...), 0)} {
+		if _, err := p.Decrypt(c.opts.KeyID, ct, bad); err == nil {
+			return fmt.Errorf("decrypt succeeded with a %d-byte nonce", len(bad))
+		}
+	}
+	return nil
+}
+
+func (c *conformance) tamperedCiphertext(p kms.HSMProvider) error {
+	ct, nonce, err := p.Encrypt(c.opts.KeyID, []byte("4111111111111111"))
+	if err != nil {
+		return err
+	}
+
+	for _, i := range []int{0, len(ct) / 2, len(ct) - 1} {
+		tampered := append([]byte(nil), ct...)
+		tampered[i] ^= 0x80
+		if _, err := p.Decrypt(c.opts.KeyID, tampered, nonce); err == nil {
+			return fmt.Errorf("decrypt succeeded with byte %d of the ciphertext flipped", i)
+		}
+	}
+	if _, err := p.Decrypt(c.opts.KeyID, ct[:len(ct)-1], nonce); err == nil {
+		return errors.New("decrypt succeeded with truncated ciphertext")
+	}
+	if _, err := p.Decrypt(c.opts.KeyID, nil, nonce); err == nil {
+		return errors.New("decrypt succeeded with empty ciphertext")
+	}
+
+	// The original must still decrypt: failures must not poison the provider.
+	if _, err := p.Decrypt(c.opts.KeyID, ct, nonce); err != nil {
+		return fmt.Errorf("decrypt of the original after tampering attempts: %w", err)
+	}
+	return nil
+}
+
+func (c *conformance) concurrency(p kms.HSMProvider) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, c.opts.Goroutines)
+	for g := 0; g < c.opts.Goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			err := protect(func() error {
+				for i := 0; i < c.opts.Iterations; i++ {
+					pt := []byte(fmt.Sprintf("goroutine %d iteration %d", g, i))
+					ct, nonce, err := p.Encrypt(c.opts.KeyID, pt)
+					if err != nil {
+						return fmt.Errorf("encrypt: %w", err)
+					}
+					got, err := p.Decrypt(c.opts.KeyID, ct, nonce)
+					if err != nil {
+						return fmt.Errorf("decrypt: %w", err)
+					}
+					if !bytes.Equal(got, pt) {
+						return errors.New("round trip returned another goroutine's plaintext")
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				errs <- fmt.Errorf("goroutine %d: %w", g, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (c *conformance) close(p kms.HSMProvider) error {
+	ct, nonce, err := p.Encrypt(c.opts.KeyID, []byte("4111111111111111"))
+	if err != nil {
+		return err
+	}
+	if err := p.Close(); err != nil {
+		return fmt.Errorf("first Close: %w", err)
+	}
+	if err := protect(p.Close); err != nil {
+		return fmt.Errorf("second Close: %w", err)
+	}
+	err = protect(func() error {
+		_, _, err := p.Encrypt(c.opts.KeyID, []byte("x"))
+		return err
+	})
+	if err == nil {
+		return errors.New("encrypt succeeded after Close")
+	}
+	if isPanic(err) {
+		return fmt.Errorf("encrypt after Close: %w", err)
+	}
+	err = protect(func() error {
+		_, err := p.Decrypt(c.opts.KeyID, ct, nonce)
+		return err
+	})
+	if err == nil {
+		return errors.New("decrypt succeeded after Close")
+	}
+	if isPanic(err) {
+		return fmt.Errorf("decrypt after Close: %w", err)
+	}
+	return nil
+}
+
+func (c *conformance) getKeyPolicy(p kms.HSMProvider) error {
+	key, err := p.GetKey(c.opts.KeyID)
+	if err == nil || len(key) > 0 {
+		return errors.New("GetKey exported key material; keys must stay inside the backend")
+	}
+	return nil
+}
+
+type panicError struct{ value interface{} }
+
+func (e panicError) Error() string { return fmt.Sprintf("panic: %v", e.value) }
+
+func isPanic(err error) bool {
+	var pe panicError
+	return errors.As(err, &pe)
+}
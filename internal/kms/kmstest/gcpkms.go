@@ -0,0 +1,381 @@
+package kmstest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var gcpCRC32C = crc32.MakeTable(crc32.Castagnoli)
+
+// GCPKMS is a local fake of the Cloud KMS REST API (v1) for symmetric keys.
+//
+// It implements cryptoKeys get, :encrypt and :decrypt with CRC32C checks,
+// key versions with a primary version, a service account token endpoint at
+// /token that verifies JWT bearer assertions, and the metadata server's
+// token endpoint for clients pointed at MetadataHost. Ciphertexts carry the
+// version number in their first 4 bytes, so older versions keep decrypting
+// after a rotation, as in Cloud KMS.
+type GCPKMS struct {
+	*httptest.Server
+
+	// AccessToken is accepted as a static bearer token.
+	AccessToken string
+
+	// TokenLifetime is the expires_in, in seconds, of issued tokens
+	// (default 3600).
+	TokenLifetime int
+
+	mu            sync.Mutex
+	keys          map[string]*gcpKey // CryptoKey resource name -> key
+	saKey         *rsa.PrivateKey
+	saTokens      map[string]bool
+	tokenRequests int
+}
+
+type gcpKey struct {
+	versions [][]byte // index 0 = cryptoKeyVersions/1
+	disabled map[int]bool
+	primary  int
+}
+
+// NewGCPKMS starts a Cloud KMS fake with no keys.
+func NewGCPKMS() *GCPKMS {
+	f := &GCPKMS{
+		AccessToken: "test-access-token",
+		keys:        make(map[string]*gcpKey),
+		saTokens:    make(map[string]bool),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// CreateKey creates a symmetric CryptoKey with one primary version.
+// name is the full resource name "projects/.../cryptoKeys/<key>".
+func (f *GCPKMS) CreateKey(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.keys[name]; ok {
+		return fmt.Errorf("key %q already exists", name)
+	}
+	f.keys[name] = &gcpKey{disabled: make(map[int]bool)}
+	return f.rotateLocked(name)
+}
+
+// RotateKey adds a new version and makes it primary.
+func (f *GCPKMS) RotateKey(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.keys[name]; !ok {
+		return fmt.Errorf("key %q not found", name)
+	}
+	return f.rotateLocked(name)
+}
+
+// DisableVersion disables a key version (1-based), so it no longer decrypts.
+func (f *GCPKMS) DisableVersion(name string, version int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if k, ok := f.keys[name]; ok {
+		k.disabled[version] = true
+	}
+}
+
+func (f *GCPKMS) rotateLocked(name string) error {
+	material := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		return err
+	}
+	k := f.keys[name]
+	k.versions = append(k.versions, material)
+	k.primary = len(k.versions)
+	return nil
+}
+
+// ServiceAccountJSON returns a service account key file whose token_uri
+// points at this fake. Tokens minted from it are accepted by the fake.
+func (f *GCPKMS) ServiceAccountJSON() ([]byte, error) {
+	f.mu.Lock()
+	if f.saKey == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			f.mu.Unlock()
+			return nil, err
+		}
+		f.saKey = key
+	}
+	key := f.saKey
+	f.mu.Unlock()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "kms@test-project.iam.gserviceaccount.com",
+		"private_key_id": "test-key",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      f.URL + "/token",
+	})
+}
+
+// MetadataHost returns the host:port to set as GCE_METADATA_HOST so that a
+// client without credentials gets its tokens from this fake.
+func (f *GCPKMS) MetadataHost() string {
+	return strings.TrimPrefix(f.URL, "http://")
+}
+
+// TokenRequests returns the number of tokens issued so far, by either
+// token endpoint.
+func (f *GCPKMS) TokenRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokenRequests
+}
+
+func (f *GCPKMS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" && r.Method == http.MethodPost {
+		f.issueToken(w, r)
+		return
+	}
+	if r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token" && r.Method == http.MethodGet {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "Missing Metadata-Flavor:Google header.", http.StatusForbidden)
+			return
+		}
+		f.respondToken(w)
+		return
+	}
+	if !f.authorized(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		gcpError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "request had invalid authentication credentials")
+		return
+	}
+
+	resource := strings.TrimPrefix(r.URL.Path, "/v1/")
+	method := ""
+	if i := strings.LastIndex(resource, ":"); i >= 0 {
+		resource, method = resource[:i], resource[i+1:]
+	}
+
+	f.mu.Lock()
+	key, ok := f.keys[resource]
+	f.mu.Unlock()
+	if !ok {
+		gcpError(w, http.StatusNotFound, "NOT_FOUND", "CryptoKey "+resource+" not found.")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && method == "":
+		f.mu.Lock()
+		primary := key.primary
+		f.mu.Unlock()
+		gcpJSON(w, map[string]interface{}{
+			"name":    resource,
+			"purpose": "ENCRYPT_DECRYPT",
+			"primary": map[string]string{
+				"name":  resource + "/cryptoKeyVersions/" + strconv.Itoa(primary),
+				"state": "ENABLED",
+			},
+		})
+	case r.Method == http.MethodPost && method == "encrypt":
+		f.encrypt(w, r, resource, key)
+	case r.Method == http.MethodPost && method == "decrypt":
+		f.decrypt(w, r, resource, key)
+	default:
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "unsupported method "+method)
+	}
+}
+
+func (f *GCPKMS) authorized(token string) bool {
+	if token == "" {
+		return false
+	}
+	if token == f.AccessToken {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.saTokens[token]
+}
+
+func (f *GCPKMS) issueToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	key := f.saKey
+	f.mu.Unlock()
+	if key == nil {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	_, err := jwt.Parse(r.Form.Get("assertion"), func(t *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(f.URL+"/token"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	f.respondToken(w)
+}
+
+// respondToken issues a new access token.
+func (f *GCPKMS) respondToken(w http.ResponseWriter) {
+	raw := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := "ya29." + hex.EncodeToString(raw)
+	f.mu.Lock()
+	f.saTokens[token] = true
+	f.tokenRequests++
+	f.mu.Unlock()
+
+	lifetime := f.TokenLifetime
+	if lifetime == 0 {
+		lifetime = 3600
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"expires_in":   lifetime,
+		"token_type":   "Bearer",
+	})
+}
+
+func (f *GCPKMS) encrypt(w http.ResponseWriter, r *http.Request, name string, key *gcpKey) {
+	var req struct {
+		Plaintext       []byte `json:"plaintext"`
+		PlaintextCrc32c string `json:"plaintextCrc32c"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "invalid request body")
+		return
+	}
+	if req.PlaintextCrc32c != "" && req.PlaintextCrc32c != crcString(req.Plaintext) {
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "The checksum in field plaintext_crc32c did not match the data in field plaintext.")
+		return
+	}
+
+	f.mu.Lock()
+	version := key.primary
+	material := key.versions[version-1]
+	f.mu.Unlock()
+
+	aead, err := gcpAEAD(material)
+	if err != nil {
+		gcpError(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	out := make([]byte, 4, 4+aead.NonceSize())
+	binary.BigEndian.PutUint32(out, uint32(version))
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		gcpError(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, req.Plaintext, []byte(name))
+
+	gcpJSON(w, map[string]interface{}{
+		"name":                    name + "/cryptoKeyVersions/" + strconv.Itoa(version),
+		"ciphertext":              out,
+		"ciphertextCrc32c":        crcString(out),
+		"verifiedPlaintextCrc32c": req.PlaintextCrc32c != "",
+	})
+}
+
+func (f *GCPKMS) decrypt(w http.ResponseWriter, r *http.Request, name string, key *gcpKey) {
+	var req struct {
+		Ciphertext       []byte `json:"ciphertext"`
+		CiphertextCrc32c string `json:"ciphertextCrc32c"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "invalid request body")
+		return
+	}
+	if req.CiphertextCrc32c != "" && req.CiphertextCrc32c != crcString(req.Ciphertext) {
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "The checksum in field ciphertext_crc32c did not match the data in field ciphertext.")
+		return
+	}
+	if len(req.Ciphertext) < 4+12 {
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Decryption failed: the ciphertext is invalid.")
+		return
+	}
+
+	version := int(binary.BigEndian.Uint32(req.Ciphertext[:4]))
+	f.mu.Lock()
+	var material []byte
+	if version >= 1 && version <= len(key.versions) && !key.disabled[version] {
+		material = key.versions[version-1]
+	}
+	primary := key.primary
+	f.mu.Unlock()
+	if material == nil {
+		gcpError(w, http.StatusBadRequest, "FAILED_PRECONDITION", "The CryptoKeyVersion used for this ciphertext is not enabled.")
+		return
+	}
+
+	aead, err := gcpAEAD(material)
+	if err != nil {
+		gcpError(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+	nonce := req.Ciphertext[4 : 4+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, req.Ciphertext[4+aead.NonceSize():], []byte(name))
+	if err != nil {
+		gcpError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Decryption failed: the ciphertext is invalid.")
+		return
+	}
+
+	gcpJSON(w, map[string]interface{}{
+		"plaintext":       plaintext,
+		"plaintextCrc32c": crcString(plaintext),
+		"usedPrimary":     version == primary,
+	})
+}
+
+func gcpAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func crcString(data []byte) string {
+	return strconv.FormatUint(uint64(crc32.Checksum(data, gcpCRC32C)), 10)
+}
+
+func gcpJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func gcpError(w http.ResponseWriter, code int, status, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message, "status": status},
+	})
+}
@@ -0,0 +1,147 @@
+package kmstest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"kms/internal/kms"
+)
+
+// ErrInjected is the default error returned by an injected fault. It wraps
+// kms.ErrUnavailable, like an HSM outage.
+var ErrInjected = fmt.Errorf("kmstest: injected fault: %w", kms.ErrUnavailable)
+
+// Provider is an in-memory kms.HSMProvider doing AES-256-GCM with a key held
+// in process, with fault injection for failover and error-path testing.
+//
+// Providers created from the same key behave like HSMs holding a cloned key:
+// each can decrypt the other's ciphertexts.
+type Provider struct {
+	mu       sync.Mutex
+	aead     cipher.AEAD
+	down     error // when set, every call fails with it
+	failNext int   // number of upcoming calls that fail
+	failErr  error
+	closed   bool
+	calls    int
+}
+
+// NewProvider returns a Provider for key (32 bytes). A nil key generates one.
+func NewProvider(key []byte) (*Provider, error) {
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("kmstest: key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{aead: aead}, nil
+}
+
+// SetDown makes every call fail with err until Recover. A nil err uses ErrInjected.
+func (p *Provider) SetDown(err error) {
+	if err == nil {
+		err = ErrInjected
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = err
+}
+
+// FailNext makes the next n calls fail with err (ErrInjected if nil).
+func (p *Provider) FailNext(n int, err error) {
+	if err == nil {
+		err = ErrInjected
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failNext = n
+	p.failErr = err
+}
+
+// Recover clears all injected faults.
+func (p *Provider) Recover() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = nil
+	p.failNext = 0
+}
+
+// Calls returns the number of Encrypt/Decrypt calls received, failed or not.
+func (p *Provider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func (p *Provider) fault() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.closed {
+		return fmt.Errorf("%w: kmstest provider is closed", kms.ErrSealed)
+	}
+	if p.down != nil {
+		return p.down
+	}
+	if p.failNext > 0 {
+		p.failNext--
+		return p.failErr
+	}
+	return nil
+}
+
+// GetKey refuses to export the key, like a real HSM.
+func (p *Provider) GetKey(keyID string) ([]byte, error) {
+	return nil, errors.New("security violation: cannot extract key from kmstest provider")
+}
+
+// Encrypt seals plaintext with a random 12-byte nonce.
+func (p *Provider) Encrypt(keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	if err := p.fault(); err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, p.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return p.aead.Seal(nil, nonce, plaintext, nil), nonce, nil
+}
+
+// Decrypt opens ciphertext produced by any Provider sharing the key.
+func (p *Provider) Decrypt(keyID string, ciphertext, nonce []byte) ([]byte, error) {
+	if err := p.fault(); err != nil {
+		return nil, err
+	}
+	if len(nonce) != p.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", kms.ErrInvalidNonce, p.aead.NonceSize(), len(nonce))
+	}
+	pt, err := p.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", kms.ErrInvalidCiphertext, err)
+	}
+	return pt, nil
+}
+
+// Close marks the provider closed; later calls fail.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
@@ -0,0 +1,138 @@
+//go:build pkcs11
+// +build pkcs11
+
+package kmstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/miekg/pkcs11"
+)
+
+// SoftHSM2 token settings used by NewSoftHSMToken.
+const (
+	SoftHSMPin      = "1234"
+	SoftHSMKeyLabel = "kms-master-key"
+	softHSMSOPin    = "12345678"
+)
+
+var softHSMPaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+	`C:\SoftHSM2\lib\softhsm2-x64.dll`,
+}
+
+// FindSoftHSM returns the SoftHSM2 library: SOFTHSM2_LIB if set, else the
+// first common install path that exists, else "".
+func FindSoftHSM() string {
+	if lib := os.Getenv("SOFTHSM2_LIB"); lib != "" {
+		return lib
+	}
+	for _, p := range softHSMPaths {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// NewSoftHSMToken provisions a throwaway SoftHSM2 token in dir, selected
+// through SOFTHSM2_CONF, holding a non-extractable AES-256 key labelled
+// SoftHSMKeyLabel with user PIN SoftHSMPin. restore puts SOFTHSM2_CONF back.
+func NewSoftHSMToken(lib, dir string) (restore func(), err error) {
+	tokenDir := filepath.Join(dir, "tokens")
+	if err := os.MkdirAll(tokenDir, 0700); err != nil {
+		return nil, err
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokenDir+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		return nil, err
+	}
+	prevConf, hadConf := os.LookupEnv("SOFTHSM2_CONF")
+	os.Setenv("SOFTHSM2_CONF", conf)
+	restore = func() {
+		if hadConf {
+			os.Setenv("SOFTHSM2_CONF", prevConf)
+		} else {
+			os.Unsetenv("SOFTHSM2_CONF")
+		}
+	}
+
+	if err := provisionSoftHSM(lib); err != nil {
+		restore()
+		return nil, fmt.Errorf("provision SoftHSM2 token: %w", err)
+	}
+	return restore, nil
+}
+
+func provisionSoftHSM(lib string) error {
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		return fmt.Errorf("failed to load %s", lib)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(false)
+	if err != nil {
+		return err
+	}
+	if len(slots) == 0 {
+		return fmt.Errorf("no slots")
+	}
+	if err := ctx.InitToken(slots[0], softHSMSOPin, "KMS Conformance"); err != nil {
+		return fmt.Errorf("init token: %w", err)
+	}
+
+	// SoftHSM moves an initialized token to a new slot.
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	if len(slots) == 0 {
+		return fmt.Errorf("initialized token not found")
+	}
+	session, err := ctx.OpenSession(slots[0], pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return err
+	}
+	defer ctx.CloseSession(session)
+
+	if err := ctx.Login(session, pkcs11.CKU_SO, softHSMSOPin); err != nil {
+		return fmt.Errorf("SO login: %w", err)
+	}
+	if err := ctx.InitPIN(session, SoftHSMPin); err != nil {
+		return fmt.Errorf("init PIN: %w", err)
+	}
+	ctx.Logout(session)
+	if err := ctx.Login(session, pkcs11.CKU_USER, SoftHSMPin); err != nil {
+		return fmt.Errorf("user login: %w", err)
+	}
+	defer ctx.Logout(session)
+
+	_, err = ctx.GenerateKey(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, SoftHSMKeyLabel),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		})
+	if err != nil {
+		return fmt.Errorf("generate AES key: %w", err)
+	}
+	return nil
+}
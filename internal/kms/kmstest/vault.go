@@ -0,0 +1,346 @@
+package kmstest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VaultTransit is an httptest stand-in for HashiCorp Vault's Transit engine.
+//
+// It serves the endpoints used by the vault provider under /v1/transit:
+// encrypt, decrypt, rewrap, datakey, keys (read, create, rotate), plus
+// AppRole login under /v1/auth/approle. Ciphertexts use the real
+// "vault:vN:<base64>" format with AES-256-GCM per key version.
+type VaultTransit struct {
+	*httptest.Server
+
+	// RootToken is always accepted in X-Vault-Token.
+	RootToken string
+
+	// Namespace, when set, must be sent in X-Vault-Namespace.
+	Namespace string
+
+	mu       sync.Mutex
+	keys     map[string][][]byte // key name -> versions (index 0 = v1)
+	approles map[string]string   // role_id -> secret_id
+	tokens   map[string]bool     // tokens issued by AppRole login
+}
+
+// NewVaultTransit starts a plain HTTP Transit stand-in.
+func NewVaultTransit() *VaultTransit {
+	v := newVaultTransit()
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serveHTTP))
+	return v
+}
+
+// NewVaultTransitTLS starts a Transit stand-in behind TLS. Use its Client()
+// or Certificate() to trust it.
+func NewVaultTransitTLS() *VaultTransit {
+	v := newVaultTransit()
+	v.Server = httptest.NewTLSServer(http.HandlerFunc(v.serveHTTP))
+	return v
+}
+
+func newVaultTransit() *VaultTransit {
+	return &VaultTransit{
+		RootToken: "root",
+		keys:      make(map[string][][]byte),
+		approles:  make(map[string]string),
+		tokens:    make(map[string]bool),
+	}
+}
+
+// CreateKey creates an aes256-gcm96 Transit key (no-op if it exists).
+func (v *VaultTransit) CreateKey(name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.keys[name]; ok {
+		return nil
+	}
+	return v.rotateLocked(name)
+}
+
+// RotateKey adds a new version to a Transit key.
+func (v *VaultTransit) RotateKey(name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.keys[name]; !ok {
+		return fmt.Errorf("key %q not found", name)
+	}
+	return v.rotateLocked(name)
+}
+
+func (v *VaultTransit) rotateLocked(name string) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	v.keys[name] = append(v.keys[name], key)
+	return nil
+}
+
+// AddAppRole registers an AppRole role_id/secret_id pair.
+func (v *VaultTransit) AddAppRole(roleID, secretID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.approles[roleID] = secretID
+}
+
+// RevokeTokens revokes every token issued by AppRole login, as if they expired.
+func (v *VaultTransit) RevokeTokens() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens = make(map[string]bool)
+}
+
+func (v *VaultTransit) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if v.Namespace != "" && r.Header.Get("X-Vault-Namespace") != v.Namespace {
+		vaultError(w, http.StatusNotFound, "no handler for route "+r.URL.Path)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if path == "auth/approle/login" && r.Method == http.MethodPost {
+		v.login(w, r)
+		return
+	}
+	if !v.authorized(r.Header.Get("X-Vault-Token")) {
+		vaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	// transit/{op}/{name} or transit/keys/{name}[/rotate] or transit/datakey/{type}/{name}
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[0] != "transit" {
+		vaultError(w, http.StatusNotFound, "no handler for route "+r.URL.Path)
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch {
+	case parts[1] == "keys" && len(parts) == 3 && r.Method == http.MethodGet:
+		v.readKey(w, parts[2])
+	case parts[1] == "keys" && len(parts) == 3 && r.Method == http.MethodPost:
+		if err := v.CreateKey(parts[2]); err != nil {
+			vaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case parts[1] == "keys" && len(parts) == 4 && parts[3] == "rotate" && r.Method == http.MethodPost:
+		if err := v.RotateKey(parts[2]); err != nil {
+			vaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case parts[1] == "encrypt" && len(parts) == 3:
+		plaintext, err := base64.StdEncoding.DecodeString(stringField(body, "plaintext"))
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, "plaintext must be base64")
+			return
+		}
+		v.respondCiphertext(w, parts[2], plaintext, nil)
+	case parts[1] == "decrypt" && len(parts) == 3:
+		plaintext, err := v.decrypt(parts[2], stringField(body, "ciphertext"))
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		vaultJSON(w, map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)})
+	case parts[1] == "rewrap" && len(parts) == 3:
+		plaintext, err := v.decrypt(parts[2], stringField(body, "ciphertext"))
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v.respondCiphertext(w, parts[2], plaintext, nil)
+	case parts[1] == "datakey" && len(parts) == 4 && (parts[2] == "plaintext" || parts[2] == "wrapped"):
+		bits := 256
+		if b, ok := body["bits"].(float64); ok {
+			bits = int(b)
+		}
+		if bits != 128 && bits != 256 && bits != 512 {
+			vaultError(w, http.StatusBadRequest, "invalid bits")
+			return
+		}
+		dataKey := make([]byte, bits/8)
+		if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+			vaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		extra := map[string]string{}
+		if parts[2] == "plaintext" {
+			extra["plaintext"] = base64.StdEncoding.EncodeToString(dataKey)
+		}
+		v.respondCiphertext(w, parts[3], dataKey, extra)
+	default:
+		vaultError(w, http.StatusNotFound, "no handler for route "+r.URL.Path)
+	}
+}
+
+func (v *VaultTransit) authorized(token string) bool {
+	if token == "" {
+		return false
+	}
+	if token == v.RootToken {
+		return true
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.tokens[token]
+}
+
+func (v *VaultTransit) login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	v.mu.Lock()
+	secret, ok := v.approles[req.RoleID]
+	v.mu.Unlock()
+	if !ok || secret != req.SecretID {
+		vaultError(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+
+	raw := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		vaultError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	token := "hvs." + hex.EncodeToString(raw)
+	v.mu.Lock()
+	v.tokens[token] = true
+	v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"lease_duration": 3600,
+			"renewable":      true,
+		},
+	})
+}
+
+func (v *VaultTransit) readKey(w http.ResponseWriter, name string) {
+	v.mu.Lock()
+	versions := len(v.keys[name])
+	v.mu.Unlock()
+	if versions == 0 {
+		vaultError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	vaultJSON(w, map[string]interface{}{
+		"name":                   name,
+		"type":                   "aes256-gcm96",
+		"latest_version":         versions,
+		"min_decryption_version": 1,
+	})
+}
+
+func (v *VaultTransit) aead(name string, version int) (cipher.AEAD, error) {
+	v.mu.Lock()
+	versions := v.keys[name]
+	v.mu.Unlock()
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("encryption key not found")
+	}
+	if version == 0 {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return nil, fmt.Errorf("invalid key version %d", version)
+	}
+	block, err := aes.NewCipher(versions[version-1])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (v *VaultTransit) respondCiphertext(w http.ResponseWriter, name string, plaintext []byte, extra map[string]string) {
+	aead, err := v.aead(name, 0)
+	if err != nil {
+		vaultError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	v.mu.Lock()
+	version := len(v.keys[name])
+	v.mu.Unlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		vaultError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+
+	data := map[string]interface{}{
+		"ciphertext":  fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(sealed)),
+		"key_version": version,
+	}
+	for k, val := range extra {
+		data[k] = val
+	}
+	vaultJSON(w, data)
+}
+
+func (v *VaultTransit) decrypt(name, ciphertext string) ([]byte, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return nil, fmt.Errorf("invalid ciphertext: no prefix")
+	}
+	version, err := strconv.Atoi(parts[1][1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: bad version")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: bad base64")
+	}
+	aead, err := v.aead(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid ciphertext: too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: message authentication failed")
+	}
+	return plaintext, nil
+}
+
+func stringField(body map[string]interface{}, name string) string {
+	s, _ := body[name].(string)
+	return s
+}
+
+func vaultJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func vaultError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{message}})
+}
@@ -3,7 +3,11 @@ package kms
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Manager interface defines the encryption/decryption operations.
@@ -16,21 +20,32 @@ type Manager interface {
 // NewManager creates a Manager based on configuration.
 // It supports:
 //   - File-based keys (default)
-//   - HSM providers (PKCS#11, AWS KMS, Azure Key Vault)
+//   - HSM providers (PKCS#11, AWS KMS, Azure Key Vault, Vault Transit, Google Cloud KMS)
+//   - Failover across several HSM backends
 func NewManager() (Manager, error) {
-	// Check for HSM configuration
-	hsmType := os.Getenv("KMS_HSM_TYPE")
-	if hsmType != "" {
-		return NewHSMManagerFromEnv()
-	}
+	return NewManagerFromLookup(os.Getenv)
+}
 
-	// Default: file-based key
-	masterKeyPath := getenvDefault("KMS_MASTER_KEY_PATH", "master.key")
-	fileMgr, err := NewManagerFromFile(masterKeyPath)
-	if err != nil {
-		return nil, err
+// NewManagerFromLookup is like NewManager but reads every setting through env
+// instead of os.Getenv, so the variables can come from a configuration file.
+// KMS_HSM_TYPE "" or "file" selects the file-based key.
+func NewManagerFromLookup(env func(string) string) (Manager, error) {
+	switch hsmType := env("KMS_HSM_TYPE"); hsmType {
+	case "", "file":
+		fileMgr, err := NewManagerFromFile(envDefault(env, "KMS_MASTER_KEY_PATH", "master.key"))
+		if err != nil {
+			return nil, err
+		}
+		return fileMgr, nil
+	case "failover":
+		return newFailoverManager(env)
+	default:
+		factory, ok := providerFactories[hsmType]
+		if !ok {
+			return nil, errors.New("unsupported HSM type: " + hsmType)
+		}
+		return newManagerFromEnv(factory, env)
 	}
-	return fileMgr, nil
 }
 
 // NewHSMManagerFromEnv creates an HSM manager from environment variables.
@@ -44,6 +59,12 @@ func NewHSMManagerFromEnv() (Manager, error) {
 		return NewAWSKMSManagerFromEnv()
 	case "azure":
 		return NewAzureKeyVaultManagerFromEnv()
+	case "vault":
+		return NewVaultManagerFromEnv()
+	case "gcp":
+		return NewGCPKMSManagerFromEnv()
+	case "failover":
+		return NewFailoverManagerFromEnv()
 	default:
 		return nil, errors.New("unsupported HSM type: " + hsmType)
 	}
@@ -53,93 +74,290 @@ func NewHSMManagerFromEnv() (Manager, error) {
 // In internal/kms/manager.go
 
 func NewPKCS11ManagerFromEnv() (Manager, error) {
-	libPath := os.Getenv("KMS_PKCS11_LIB")
-	slotID := getenvUint("KMS_PKCS11_SLOT", 0)
-	pin := os.Getenv("KMS_PKCS11_PIN")
-	keyLabel := getenvDefault("KMS_PKCS11_KEY_LABEL", "kms-master-key")
+	return newManagerFromEnv(pkcs11ProviderFromEnv, os.Getenv)
+}
+
+// NewAWSKMSManagerFromEnv creates an AWS KMS manager from environment variables.
+func NewAWSKMSManagerFromEnv() (Manager, error) {
+	return newManagerFromEnv(awsProviderFromEnv, os.Getenv)
+}
+
+// NewAzureKeyVaultManagerFromEnv creates an Azure Key Vault manager from environment variables.
+func NewAzureKeyVaultManagerFromEnv() (Manager, error) {
+	return newManagerFromEnv(azureProviderFromEnv, os.Getenv)
+}
+
+// NewVaultManagerFromEnv creates a HashiCorp Vault Transit manager from environment variables.
+func NewVaultManagerFromEnv() (Manager, error) {
+	return newManagerFromEnv(vaultProviderFromEnv, os.Getenv)
+}
+
+// NewGCPKMSManagerFromEnv creates a Google Cloud KMS manager from environment variables.
+func NewGCPKMSManagerFromEnv() (Manager, error) {
+	return newManagerFromEnv(gcpProviderFromEnv, os.Getenv)
+}
+
+// NewFailoverManagerFromEnv creates a manager over several HSM backends with
+// failover. KMS_FAILOVER_BACKENDS lists the backends in priority order, e.g.
+// "pkcs11,pkcs11:B,gcp". A "type:SUFFIX" entry reads each of that type's
+// variables from NAME_SUFFIX first (KMS_PKCS11_LIB_B), then from NAME.
+func NewFailoverManagerFromEnv() (Manager, error) {
+	return newFailoverManager(os.Getenv)
+}
+
+func newFailoverManager(base func(string) string) (Manager, error) {
+	spec := base("KMS_FAILOVER_BACKENDS")
+	if spec == "" {
+		return nil, errors.New("KMS_FAILOVER_BACKENDS environment variable is required")
+	}
+
+	threshold, err := envUint(base, "KMS_FAILOVER_FAILURE_THRESHOLD", 3)
+	if err != nil {
+		return nil, err
+	}
+	openTimeout, err := envDuration(base, "KMS_FAILOVER_OPEN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	healthInterval, err := envDuration(base, "KMS_FAILOVER_HEALTH_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	cfg := FailoverConfig{
+		FailureThreshold:    int(threshold),
+		OpenTimeout:         openTimeout,
+		HealthCheckInterval: healthInterval,
+	}
+
+	var backends []FailoverBackend
+	closeAll := func() {
+		for _, b := range backends {
+			b.Provider.Close()
+		}
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		hsmType, suffix, _ := strings.Cut(entry, ":")
+		if hsmType == "failover" {
+			closeAll()
+			return nil, errors.New("failover backends cannot be nested")
+		}
+		factory, ok := providerFactories[hsmType]
+		if !ok {
+			closeAll()
+			return nil, errors.New("unsupported HSM type: " + hsmType)
+		}
+
+		env := base
+		if suffix != "" {
+			env = func(key string) string {
+				if v := base(key + "_" + suffix); v != "" {
+					return v
+				}
+				return base(key)
+			}
+		}
+		provider, keyID, err := factory(env)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failover backend %s: %w", entry, err)
+		}
+		backends = append(backends, FailoverBackend{Name: entry, Provider: provider, KeyID: keyID})
+	}
+
+	provider, err := NewFailoverProvider(backends, cfg)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	slog.Info("KMS failover: configured", "backends", len(backends), "primary", backends[0].Name)
+	return NewHSMManager(provider, backends[0].KeyID)
+}
 
-	// DEBUG: Print what we are trying to load
-	fmt.Printf("DEBUG: Initializing PKCS11...\n")
-	fmt.Printf("  Lib: %s\n", libPath)
-	fmt.Printf("  Slot: %d\n", slotID)
-	fmt.Printf("  Label: %s\n", keyLabel)
+// providerFromEnv builds a provider from environment variables read through
+// env and returns it with the key ID to use with it.
+type providerFromEnv func(env func(string) string) (HSMProvider, string, error)
+
+var providerFactories = map[string]providerFromEnv{
+	"pkcs11": pkcs11ProviderFromEnv,
+	"aws":    awsProviderFromEnv,
+	"azure":  azureProviderFromEnv,
+	"vault":  vaultProviderFromEnv,
+	"gcp":    gcpProviderFromEnv,
+}
+
+func newManagerFromEnv(factory providerFromEnv, env func(string) string) (Manager, error) {
+	provider, keyID, err := factory(env)
+	if err != nil {
+		return nil, err
+	}
+	return NewHSMManager(provider, keyID)
+}
+
+func pkcs11ProviderFromEnv(env func(string) string) (HSMProvider, string, error) {
+	libPath := env("KMS_PKCS11_LIB")
+	slotID, err := envUint(env, "KMS_PKCS11_SLOT", 0)
+	if err != nil {
+		return nil, "", err
+	}
+	pin := env("KMS_PKCS11_PIN")
+	keyLabel := envDefault(env, "KMS_PKCS11_KEY_LABEL", "kms-master-key")
+
+	slog.Debug("KMS pkcs11: initializing", "lib", libPath, "slot", slotID, "key_label", keyLabel)
 
 	if libPath == "" {
-		return nil, errors.New("KMS_PKCS11_LIB environment variable is required")
+		return nil, "", errors.New("KMS_PKCS11_LIB environment variable is required")
 	}
 
 	provider, err := NewPKCS11Provider(libPath, slotID, pin, keyLabel)
 	if err != nil {
-		return nil, fmt.Errorf("provider initialization failed: %w", err)
+		return nil, "", fmt.Errorf("provider initialization failed: %w", err)
 	}
 
 	// CRITICAL SAFETY CHECK
 	if provider == nil {
-		return nil, errors.New("provider initialization returned nil pointer without error (Check PKCS11 library path)")
+		return nil, "", errors.New("provider initialization returned nil pointer without error (Check PKCS11 library path)")
 	}
 
-	keyID := getenvDefault("KMS_KEY_ID", "default")
-	return NewHSMManager(provider, keyID)
+	keyID := envDefault(env, "KMS_KEY_ID", "default")
+	return provider, keyID, nil
 }
 
-// NewAWSKMSManagerFromEnv creates an AWS KMS manager from environment variables.
-func NewAWSKMSManagerFromEnv() (Manager, error) {
-	keyID := os.Getenv("KMS_AWS_KEY_ID")
-	region := getenvDefault("KMS_AWS_REGION", "us-east-1")
+func awsProviderFromEnv(env func(string) string) (HSMProvider, string, error) {
+	keyID := env("KMS_AWS_KEY_ID")
+	region := envDefault(env, "KMS_AWS_REGION", "us-east-1")
 
 	if keyID == "" {
-		return nil, errors.New("KMS_AWS_KEY_ID environment variable is required")
+		return nil, "", errors.New("KMS_AWS_KEY_ID environment variable is required")
 	}
 
 	provider, err := NewAWSKMSProvider(keyID, region)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return NewHSMManager(provider, keyID)
+	return provider, keyID, nil
 }
 
-// NewAzureKeyVaultManagerFromEnv creates an Azure Key Vault manager from environment variables.
-func NewAzureKeyVaultManagerFromEnv() (Manager, error) {
-	vaultURL := os.Getenv("KMS_AZURE_VAULT_URL")
-	keyName := os.Getenv("KMS_AZURE_KEY_NAME")
+func azureProviderFromEnv(env func(string) string) (HSMProvider, string, error) {
+	vaultURL := env("KMS_AZURE_VAULT_URL")
+	keyName := env("KMS_AZURE_KEY_NAME")
+	dekPath := env("KMS_AZURE_DEK_PATH")
 
 	if vaultURL == "" {
-		return nil, errors.New("KMS_AZURE_VAULT_URL environment variable is required")
+		return nil, "", errors.New("KMS_AZURE_VAULT_URL environment variable is required")
 	}
 	if keyName == "" {
-		return nil, errors.New("KMS_AZURE_KEY_NAME environment variable is required")
+		return nil, "", errors.New("KMS_AZURE_KEY_NAME environment variable is required")
+	}
+	if dekPath == "" {
+		return nil, "", errors.New("KMS_AZURE_DEK_PATH environment variable is required")
 	}
 
-	provider, err := NewAzureKeyVaultProvider(vaultURL, keyName)
+	provider, err := NewAzureKeyVaultProvider(vaultURL, keyName, dekPath, env("KMS_CREATE_DEK") == "true")
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	return provider, keyName, nil
+}
+
+func vaultProviderFromEnv(env func(string) string) (HSMProvider, string, error) {
+	cfg := VaultConfig{
+		Address:            envDefault(env, "KMS_VAULT_ADDR", env("VAULT_ADDR")),
+		Namespace:          envDefault(env, "KMS_VAULT_NAMESPACE", env("VAULT_NAMESPACE")),
+		TransitMount:       envDefault(env, "KMS_VAULT_TRANSIT_MOUNT", "transit"),
+		KeyName:            env("KMS_VAULT_KEY_NAME"),
+		DEKPath:            env("KMS_VAULT_DEK_PATH"),
+		CreateDEK:          env("KMS_CREATE_DEK") == "true",
+		Token:              envDefault(env, "KMS_VAULT_TOKEN", env("VAULT_TOKEN")),
+		RoleID:             env("KMS_VAULT_ROLE_ID"),
+		SecretID:           env("KMS_VAULT_SECRET_ID"),
+		AppRoleMount:       envDefault(env, "KMS_VAULT_APPROLE_MOUNT", "approle"),
+		CACert:             envDefault(env, "KMS_VAULT_CACERT", env("VAULT_CACERT")),
+		ClientCert:         env("KMS_VAULT_CLIENT_CERT"),
+		ClientKey:          env("KMS_VAULT_CLIENT_KEY"),
+		TLSServerName:      env("KMS_VAULT_TLS_SERVER_NAME"),
+		InsecureSkipVerify: env("KMS_VAULT_SKIP_VERIFY") == "true",
 	}
 
-	return NewHSMManager(provider, keyName)
+	if cfg.Address == "" {
+		return nil, "", errors.New("KMS_VAULT_ADDR environment variable is required")
+	}
+	if cfg.KeyName == "" {
+		return nil, "", errors.New("KMS_VAULT_KEY_NAME environment variable is required")
+	}
+	if cfg.DEKPath == "" {
+		return nil, "", errors.New("KMS_VAULT_DEK_PATH environment variable is required")
+	}
+
+	provider, err := NewVaultTransitProvider(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return provider, cfg.KeyName, nil
+}
+
+func gcpProviderFromEnv(env func(string) string) (HSMProvider, string, error) {
+	cfg := GCPConfig{
+		KeyName:         env("KMS_GCP_KEY_NAME"),
+		DEKPath:         env("KMS_GCP_DEK_PATH"),
+		CreateDEK:       env("KMS_CREATE_DEK") == "true",
+		AccessToken:     env("KMS_GCP_ACCESS_TOKEN"),
+		CredentialsFile: envDefault(env, "KMS_GCP_CREDENTIALS", env("GOOGLE_APPLICATION_CREDENTIALS")),
+		Endpoint:        env("KMS_GCP_ENDPOINT"),
+	}
+
+	if cfg.KeyName == "" {
+		return nil, "", errors.New("KMS_GCP_KEY_NAME environment variable is required")
+	}
+	if cfg.DEKPath == "" {
+		return nil, "", errors.New("KMS_GCP_DEK_PATH environment variable is required")
+	}
+
+	provider, err := NewGCPKMSProvider(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return provider, cfg.KeyName, nil
 }
 
 // Helper functions
-func getenvDefault(key, def string) string {
-	if v := os.Getenv(key); v != "" {
+func envDefault(env func(string) string, key, def string) string {
+	if v := env(key); v != "" {
 		return v
 	}
 	return def
 }
 
-func getenvUint(key string, def uint) uint {
-	v := os.Getenv(key)
+// envUint parses an unsigned integer variable. Unset means def; a value that
+// does not parse is an error rather than a silent fallback.
+func envUint(env func(string) string, key string, def uint) (uint, error) {
+	v := env(key)
 	if v == "" {
-		return def
-	}
-	// Simple parsing - in production use strconv.ParseUint
-	var result uint
-	for _, c := range v {
-		if c >= '0' && c <= '9' {
-			result = result*10 + uint(c-'0')
-		} else {
-			return def
-		}
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid unsigned integer %q", key, v)
+	}
+	return uint(n), nil
+}
+
+// envDuration parses a duration variable such as "30s", like envUint.
+func envDuration(env func(string) string, key string, def time.Duration) (time.Duration, error) {
+	v := env(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, v)
 	}
-	return result
+	return d, nil
 }
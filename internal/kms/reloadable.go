@@ -0,0 +1,112 @@
+package kms
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// ReloadableManager is a Manager whose underlying Manager can be replaced
+// while it serves, e.g. when kms-server reloads its configuration. Each call
+// runs entirely on the Manager that was current when it started; a replaced
+// Manager is closed once its last call has returned.
+type ReloadableManager struct {
+	cur atomic.Pointer[managerGen]
+}
+
+// managerGen counts the calls running on one Manager so it can be closed
+// when retired and idle.
+type managerGen struct {
+	Manager
+	mu      sync.Mutex
+	active  int
+	retired bool
+}
+
+// NewReloadableManager returns a ReloadableManager serving m.
+func NewReloadableManager(m Manager) *ReloadableManager {
+	r := &ReloadableManager{}
+	r.cur.Store(&managerGen{Manager: m})
+	return r
+}
+
+// acquire returns the current generation with a call registered on it.
+func (r *ReloadableManager) acquire() *managerGen {
+	for {
+		g := r.cur.Load()
+		g.mu.Lock()
+		if !g.retired {
+			g.active++
+			g.mu.Unlock()
+			return g
+		}
+		// Swapped between Load and Lock; the new generation is stored.
+		g.mu.Unlock()
+	}
+}
+
+func (g *managerGen) release() {
+	g.mu.Lock()
+	g.active--
+	idle := g.retired && g.active == 0
+	g.mu.Unlock()
+	if idle {
+		g.close()
+	}
+}
+
+func (g *managerGen) close() {
+	if err := g.Manager.Close(); err != nil {
+		slog.Error("KMS reload: closing previous key manager", "err", err)
+	}
+}
+
+func (r *ReloadableManager) Encrypt(plaintext []byte) (ciphertext, nonce []byte, err error) {
+	g := r.acquire()
+	defer g.release()
+	return g.Encrypt(plaintext)
+}
+
+func (r *ReloadableManager) Decrypt(ciphertext, nonce []byte) ([]byte, error) {
+	g := r.acquire()
+	defer g.release()
+	return g.Decrypt(ciphertext, nonce)
+}
+
+// Swap makes m the current Manager. New calls use m at once; the previous
+// Manager is closed after the calls still running on it return.
+func (r *ReloadableManager) Swap(m Manager) {
+	old := r.cur.Swap(&managerGen{Manager: m})
+	old.mu.Lock()
+	old.retired = true
+	idle := old.active == 0
+	old.mu.Unlock()
+	if idle {
+		old.close()
+	}
+}
+
+// Close closes the current Manager. Calls made afterwards fail with
+// ErrSealed or the Manager's own error.
+func (r *ReloadableManager) Close() error {
+	return r.cur.Load().Manager.Close()
+}
+
+// Seal closes the current Manager, as Swap does, and fails every later call
+// with ErrSealed until Swap installs a new Manager.
+func (r *ReloadableManager) Seal() {
+	r.Swap(sealedManager{})
+}
+
+// Sealed reports whether Seal was called since the last Swap.
+func (r *ReloadableManager) Sealed() bool {
+	_, sealed := r.cur.Load().Manager.(sealedManager)
+	return sealed
+}
+
+// sealedManager stands in for the Manager of a sealed ReloadableManager.
+type sealedManager struct{}
+
+func (sealedManager) Encrypt([]byte) ([]byte, []byte, error) { return nil, nil, ErrSealed }
+func (sealedManager) Decrypt([]byte, []byte) ([]byte, error) { return nil, ErrSealed }
+func (sealedManager) Close() error                           { return nil }
@@ -0,0 +1,8 @@
+package logging
+
+import "log/slog"
+
+// NewHandler returns h with every record redacted by r, as Setup installs it.
+func NewHandler(h slog.Handler, r *Redactor) slog.Handler {
+	return &redactHandler{Handler: h, r: r}
+}
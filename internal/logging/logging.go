@@ -0,0 +1,173 @@
+// Package logging sets up the structured log/slog logger of the KMS commands:
+// a level, a text or JSON format, an optional log file, and redaction of PINs,
+// tokens, key material and card numbers from every record. The commands log
+// with slog; lines that third-party packages write with the standard log
+// package go through the same logger.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Formats.
+const (
+	FormatText = "text" // key=value pairs, the default
+	FormatJSON = "json" // one JSON object per line
+)
+
+// Config selects the level, format and destination of the log.
+type Config struct {
+	// Level is "debug", "info" (the default), "warn" or "error".
+	Level string
+	// Format is FormatText (the default) or FormatJSON.
+	Format string
+	// File, if set, receives the log as well as stderr.
+	File string
+}
+
+// ConfigFromEnv reads KMS_LOG_LEVEL, KMS_LOG_FORMAT and KMS_LOG_FILE, for the
+// commands without a config file.
+func ConfigFromEnv() Config {
+	return Config{
+		Level:  os.Getenv("KMS_LOG_LEVEL"),
+		Format: os.Getenv("KMS_LOG_FORMAT"),
+		File:   os.Getenv("KMS_LOG_FILE"),
+	}
+}
+
+// Validate checks the level and format.
+func (c Config) Validate() error {
+	if _, err := ParseLevel(c.Level); err != nil {
+		return err
+	}
+	switch strings.ToLower(c.Format) {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("log format %q is not %q or %q", c.Format, FormatText, FormatJSON)
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error"; empty is info.
+func ParseLevel(s string) (slog.Level, error) {
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("log level %q is not debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// Logger is the installed default logger. Its level and the secrets it
+// redacts can be changed while it runs, e.g. on a config reload.
+type Logger struct {
+	*slog.Logger
+	level    slog.LevelVar
+	redactor *Redactor
+	file     *os.File
+}
+
+// Setup installs a logger per cfg as the slog default and routes the
+// standard log package through it. The values in secrets (configured PINs,
+// tokens, keys) are redacted wherever they appear, along with the patterns
+// Redactor always removes.
+func Setup(cfg Config, secrets ...string) (*Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l := &Logger{redactor: NewRedactor(secrets...)}
+	level, _ := ParseLevel(cfg.Level)
+	l.level.Set(level)
+
+	var w io.Writer = os.Stderr
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+		l.file = f
+		w = io.MultiWriter(os.Stderr, f)
+	}
+
+	opts := &slog.HandlerOptions{Level: &l.level}
+	var h slog.Handler
+	if strings.ToLower(cfg.Format) == FormatJSON {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	h = &redactHandler{Handler: h, r: l.redactor}
+	l.Logger = slog.New(h)
+
+	// Third-party packages still write with the log package. slog.SetDefault
+	// sends those lines at info level; logWriter picks the level from the
+	// line instead, so "WARNING: ..." stays visible at level warn.
+	slog.SetDefault(l.Logger)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(logWriter{h})
+	return l, nil
+}
+
+// Fatal logs msg and args at error level, like slog.Error, and exits with
+// status 1, like log.Fatal.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// SetLevel changes the level of the running logger.
+func (l *Logger) SetLevel(level slog.Level) { l.level.Set(level) }
+
+// SetSecrets replaces the secret values redacted from the log.
+func (l *Logger) SetSecrets(secrets ...string) { l.redactor.SetSecrets(secrets...) }
+
+// Close closes the log file, if any. Later records still go to stderr.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// logWriter turns the lines of the standard log package into records.
+type logWriter struct{ h slog.Handler }
+
+func (w logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := lineLevel(msg)
+	ctx := context.Background()
+	if !w.h.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	if err := w.h.Handle(ctx, slog.NewRecord(time.Now(), level, msg, 0)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineLevel is the level of a log package line: error for lines starting
+// with "ERROR", "FATAL" or "failed", warn for "WARNING", debug for "DEBUG"
+// and info otherwise.
+func lineLevel(msg string) slog.Level {
+	word := strings.ToUpper(msg[:min(len(msg), 8)])
+	switch {
+	case strings.HasPrefix(word, "ERROR"), strings.HasPrefix(word, "FATAL"), strings.HasPrefix(word, "FAILED"):
+		return slog.LevelError
+	case strings.HasPrefix(word, "WARN"):
+		return slog.LevelWarn
+	case strings.HasPrefix(word, "DEBUG"):
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
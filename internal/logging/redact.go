@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Redacted replaces what the Redactor removes.
+const Redacted = "[REDACTED]"
+
+// minSecretLen is the shortest configured secret redacted by value; shorter
+// ones would blank out common words and numbers.
+const minSecretLen = 4
+
+var (
+	// panPattern matches 13 to 19 digits, optionally grouped by single
+	// spaces or dashes: anything that could be a card number.
+	panPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// jwtPattern matches a JWT's base64url header, payload and signature.
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer)\s+[A-Za-z0-9._~+/=-]+`)
+	// assignPattern matches "pin=1234", "password: x", `secret="x"` and so on.
+	assignPattern = regexp.MustCompile(`(?i)\b(pin|password|passwd|pwd|secret|secret_?id|token|api_?key|hmac_?key|private_?key)(\s*[=:]\s*)("[^"]*"|[^\s,;&]+)`)
+)
+
+// sensitiveKeys are attribute keys whose values are always redacted,
+// compared lower-cased without "_" and "-" after the last ".".
+var sensitiveKeys = map[string]bool{
+	"pin": true, "password": true, "passwd": true, "pwd": true,
+	"secret": true, "secretid": true, "jwtsecret": true, "clientsecret": true,
+	"token": true, "accesstoken": true, "bearertoken": true, "authorization": true,
+	"apikey": true, "hmackey": true, "masterkey": true, "privatekey": true,
+	"keymaterial": true, "plaintext": true, "pan": true, "cardno": true, "cvv": true,
+}
+
+// Redactor removes secrets from log text: configured secret values, JWTs
+// and bearer tokens, "pin=..."-style assignments and card-number-like digit
+// runs. Attributes with a sensitive key (pin, token, plaintext, ...) and
+// byte slices, which may hold key material, are replaced whole. Integers
+// are redacted only when they are Luhn-valid card numbers, so timestamps
+// and counters of 13 to 19 digits stay readable.
+type Redactor struct {
+	secrets atomic.Pointer[[]string]
+}
+
+// NewRedactor returns a Redactor that also removes the given secret values.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.SetSecrets(secrets...)
+	return r
+}
+
+// SetSecrets replaces the secret values removed by value.
+func (r *Redactor) SetSecrets(secrets ...string) {
+	var keep []string
+	for _, s := range secrets {
+		if len(s) >= minSecretLen {
+			keep = append(keep, s)
+		}
+	}
+	r.secrets.Store(&keep)
+}
+
+// String returns s with every secret replaced by Redacted.
+func (r *Redactor) String(s string) string {
+	for _, secret := range *r.secrets.Load() {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = assignPattern.ReplaceAllString(s, "$1$2"+Redacted)
+	return panPattern.ReplaceAllString(s, Redacted)
+}
+
+// Attr returns a with its value redacted.
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.String(a.Value.String()))
+	case slog.KindInt64, slog.KindUint64:
+		if luhnPAN(strings.TrimPrefix(a.Value.String(), "-")) {
+			a.Value = slog.StringValue(Redacted)
+		}
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		out := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			out[i] = r.Attr(ga)
+		}
+		a.Value = slog.GroupValue(out...)
+	case slog.KindAny:
+		// Formatted values are checked as text; byte slices are not logged.
+		switch v := a.Value.Any().(type) {
+		case []byte:
+			a.Value = slog.StringValue(Redacted)
+		case error:
+			a.Value = slog.StringValue(r.String(v.Error()))
+		default:
+			a.Value = slog.StringValue(r.String(fmt.Sprint(v)))
+		}
+	}
+	return a
+}
+
+// luhnPAN reports whether digits is 13 to 19 digits passing the Luhn check.
+func luhnPAN(digits string) bool {
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range len(digits) {
+		d := int(digits[len(digits)-1-i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func sensitiveKey(key string) bool {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		key = key[i+1:]
+	}
+	key = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	return sensitiveKeys[key]
+}
+
+// redactHandler redacts every record's message and attributes before
+// passing it on.
+type redactHandler struct {
+	slog.Handler
+	r *Redactor
+}
+
+func (h *redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.String(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.Attr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = h.r.Attr(a)
+	}
+	return &redactHandler{Handler: h.Handler.WithAttrs(out), r: h.r}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{Handler: h.Handler.WithGroup(name), r: h.r}
+}
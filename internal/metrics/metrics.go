@@ -0,0 +1,205 @@
+// Package metrics defines the Prometheus metrics of kms-server and the HTTP
+// gateway and serves them for scraping. The gRPC interceptors that feed the
+// server metrics are in package server.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	kmslib "kms/internal/kms"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kms"
+
+// Label values of the backend and key metrics.
+const (
+	OpEncrypt = "encrypt"
+	OpDecrypt = "decrypt"
+
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+
+	// OtherKey labels key IDs that are not known to the server, so that
+	// callers cannot create a series per made-up key ID.
+	OtherKey = "other"
+)
+
+// latencyBuckets covers local AES (well under a millisecond) up to a slow
+// network HSM or cloud KMS.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewRegistry returns a registry with the Go runtime and process collectors,
+// for the metrics of one process.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// Handler serves the metrics in reg in the Prometheus text format.
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+// Server holds the metrics of kms-server.
+type Server struct {
+	Requests      *prometheus.CounterVec   // by method and gRPC code
+	Latency       *prometheus.HistogramVec // by method
+	KeyLatency    *prometheus.HistogramVec // by key ID and operation
+	ActiveStreams *prometheus.GaugeVec     // by method
+	AuthFailures  *prometheus.CounterVec   // by method and gRPC code
+	BatchItems    *prometheus.HistogramVec // by method
+	Backend       *prometheus.HistogramVec // by operation and outcome
+}
+
+// NewServer creates the kms-server metrics and registers them with reg.
+func NewServer(reg prometheus.Registerer) *Server {
+	m := &Server{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "gRPC calls handled, by method and status code.",
+		}, []string{"method", "code"}),
+		Latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Time to handle a gRPC call, or the lifetime of a stream.",
+			Buckets:   latencyBuckets,
+		}, []string{"method"}),
+		KeyLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "key_request_duration_seconds",
+			Help:      "Time to handle an Encrypt, Decrypt or batch call, by each key it used.",
+			Buckets:   latencyBuckets,
+		}, []string{"key_id", "op"}),
+		ActiveStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "grpc_active_streams",
+			Help:      "Streams currently open, by method.",
+		}, []string{"method"}),
+		AuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_failures_total",
+			Help:      "Calls refused for missing or invalid credentials (Unauthenticated) or a principal that is not allowed (PermissionDenied).",
+		}, []string{"method", "code"}),
+		BatchItems: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_items",
+			Help:      "Items per BatchEncrypt or BatchDecrypt call.",
+			Buckets:   []float64{1, 10, 50, 100, 250, 500, 1000},
+		}, []string{"method"}),
+		Backend: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_request_duration_seconds",
+			Help:      "Time the key backend (HSM, cloud KMS or key file) takes to encrypt or decrypt, including health checks and the unwrapping of named keys.",
+			Buckets:   latencyBuckets,
+		}, []string{"op", "outcome"}),
+	}
+	reg.MustRegister(m.Requests, m.Latency, m.KeyLatency, m.ActiveStreams, m.AuthFailures, m.BatchItems, m.Backend)
+	return m
+}
+
+// Manager returns mgr with its calls timed in m.Backend.
+func (m *Server) Manager(mgr kmslib.Manager) kmslib.Manager {
+	return &timedManager{Manager: mgr, m: m}
+}
+
+type timedManager struct {
+	kmslib.Manager
+	m *Server
+}
+
+func (t *timedManager) Encrypt(plaintext []byte) (ciphertext, nonce []byte, err error) {
+	defer t.observe(OpEncrypt, time.Now(), &err)
+	return t.Manager.Encrypt(plaintext)
+}
+
+func (t *timedManager) Decrypt(ciphertext, nonce []byte) (plaintext []byte, err error) {
+	defer t.observe(OpDecrypt, time.Now(), &err)
+	return t.Manager.Decrypt(ciphertext, nonce)
+}
+
+func (t *timedManager) observe(op string, start time.Time, err *error) {
+	outcome := OutcomeOK
+	if *err != nil {
+		outcome = OutcomeError
+	}
+	t.m.Backend.WithLabelValues(op, outcome).Observe(time.Since(start).Seconds())
+}
+
+// Gateway holds the metrics of the HTTP gateway.
+type Gateway struct {
+	Requests *prometheus.CounterVec   // by route, method and status code
+	Latency  *prometheus.HistogramVec // by route and method
+	InFlight prometheus.Gauge
+}
+
+// NewGateway creates the gateway metrics and registers them with reg.
+func NewGateway(reg prometheus.Registerer) *Gateway {
+	m := &Gateway{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		Latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to handle an HTTP request, including the gRPC call to kms-server.",
+			Buckets:   latencyBuckets,
+		}, []string{"route", "method"}),
+		InFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests being handled.",
+		}),
+	}
+	reg.MustRegister(m.Requests, m.Latency, m.InFlight)
+	return m
+}
+
+// Middleware records each request to a gorilla/mux route, labelled with the
+// route's path template; install it with Router.Use.
+func (m *Gateway) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tmpl, err := cr.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		m.InFlight.Inc()
+		defer m.InFlight.Dec()
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		m.Latency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.Requests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// statusWriter remembers the status code written.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
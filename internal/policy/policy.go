@@ -0,0 +1,113 @@
+// Package policy checks KMS requests against per-key size limits and content
+// validators before they reach a key, so that oversized input or data of the
+// wrong kind is never encrypted.
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the checks. Messages describe the violation without
+// echoing the data.
+var (
+	ErrPlaintextTooLarge  = errors.New("plaintext too large")
+	ErrCiphertextTooLarge = errors.New("ciphertext too large")
+	ErrInvalidPlaintext   = errors.New("invalid plaintext")
+)
+
+// DefaultKeyID names, in Policy.Keys, the key backend's own key used by
+// requests without a key_id. It is reserved and cannot be a named key.
+const DefaultKeyID = "default"
+
+// DefaultMaxPlaintextBytes is the plaintext limit of Default().
+const DefaultMaxPlaintextBytes = 64 << 10
+
+// ciphertextOverhead is added to the plaintext limit when no ciphertext
+// limit is set. It covers the named-key header, the GCM tag and the
+// encodings of the HSM providers.
+const ciphertextOverhead = 1024
+
+// Limits bound the requests for one key. Zero values inherit from
+// Policy.Default.
+type Limits struct {
+	MaxPlaintextBytes int
+	// MaxCiphertextBytes defaults to MaxPlaintextBytes plus a fixed
+	// allowance for the ciphertext format.
+	MaxCiphertextBytes int
+	// Validator names an entry of Validators that plaintexts must pass.
+	Validator string
+}
+
+// Policy holds the limits of every key.
+type Policy struct {
+	// Default applies to every key.
+	Default Limits
+	// Keys overrides Default per key_id; DefaultKeyID stands for requests
+	// without one.
+	Keys map[string]Limits
+}
+
+// Default returns the policy in force when none is configured.
+func Default() *Policy {
+	return &Policy{Default: Limits{MaxPlaintextBytes: DefaultMaxPlaintextBytes}}
+}
+
+// CheckPlaintext checks a plaintext to be encrypted with keyID ("" for the
+// key backend's key). Empty plaintexts are always rejected.
+func (p *Policy) CheckPlaintext(keyID string, plaintext []byte) error {
+	l := p.limits(keyID)
+	if len(plaintext) == 0 {
+		return fmt.Errorf("%w: plaintext is empty", ErrInvalidPlaintext)
+	}
+	if l.MaxPlaintextBytes > 0 && len(plaintext) > l.MaxPlaintextBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d for %s", ErrPlaintextTooLarge, len(plaintext), l.MaxPlaintextBytes, keyName(keyID))
+	}
+	if l.Validator != "" {
+		if err := Validators[l.Validator](plaintext); err != nil {
+			return fmt.Errorf("%w: not a valid %s for %s: %v", ErrInvalidPlaintext, l.Validator, keyName(keyID), err)
+		}
+	}
+	return nil
+}
+
+// CheckCiphertext checks a ciphertext to be decrypted with keyID.
+func (p *Policy) CheckCiphertext(keyID string, ciphertext []byte) error {
+	l := p.limits(keyID)
+	limit := l.MaxCiphertextBytes
+	if limit == 0 && l.MaxPlaintextBytes > 0 {
+		limit = l.MaxPlaintextBytes + ciphertextOverhead
+	}
+	if limit > 0 && len(ciphertext) > limit {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d for %s", ErrCiphertextTooLarge, len(ciphertext), limit, keyName(keyID))
+	}
+	return nil
+}
+
+func (p *Policy) limits(keyID string) Limits {
+	if keyID == "" {
+		keyID = DefaultKeyID
+	}
+	l := p.Default
+	if k, ok := p.Keys[keyID]; ok {
+		if k.MaxPlaintextBytes != 0 {
+			l.MaxPlaintextBytes = k.MaxPlaintextBytes
+			// The default ciphertext limit follows the key's plaintext limit.
+			l.MaxCiphertextBytes = 0
+		}
+		if k.MaxCiphertextBytes != 0 {
+			l.MaxCiphertextBytes = k.MaxCiphertextBytes
+		}
+		if k.Validator != "" {
+			l.Validator = k.Validator
+		}
+	}
+	return l
+}
+
+func keyName(keyID string) string {
+	if keyID == "" {
+		return "the default key"
+	}
+	return fmt.Sprintf("key %q", keyID)
+}
@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Validators check the content of a plaintext, by the name used in the
+// configuration. Their errors must not include the plaintext.
+var Validators = map[string]func([]byte) error{
+	"pan":    validatePAN,
+	"cvv":    validateCVV,
+	"digits": validateDigits,
+}
+
+// ValidatorNames returns the names of Validators, sorted.
+func ValidatorNames() []string {
+	return slices.Sorted(maps.Keys(Validators))
+}
+
+// validatePAN accepts a primary account number: 12 to 19 digits passing the
+// Luhn check.
+func validatePAN(b []byte) error {
+	if err := digitsBetween(b, 12, 19); err != nil {
+		return err
+	}
+	sum := 0
+	for i := range b {
+		d := int(b[len(b)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	if sum%10 != 0 {
+		return errors.New("fails the Luhn check")
+	}
+	return nil
+}
+
+// validateCVV accepts a card verification value of 3 or 4 digits.
+func validateCVV(b []byte) error {
+	return digitsBetween(b, 3, 4)
+}
+
+// validateDigits accepts any non-empty run of ASCII digits.
+func validateDigits(b []byte) error {
+	return digitsBetween(b, 1, len(b))
+}
+
+func digitsBetween(b []byte, lo, hi int) error {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return errors.New("contains characters other than digits")
+		}
+	}
+	if len(b) < lo || len(b) > hi {
+		return fmt.Errorf("must be %d to %d digits, got %d", lo, hi, len(b))
+	}
+	return nil
+}
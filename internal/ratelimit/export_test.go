@@ -0,0 +1,8 @@
+package ratelimit
+
+import "time"
+
+// SetClock replaces the clock l charges and refills its buckets by.
+func SetClock(l *Limiter, now func() time.Time) {
+	l.now = now
+}
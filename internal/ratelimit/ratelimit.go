@@ -0,0 +1,427 @@
+// Package ratelimit throttles KMS operations with token buckets per
+// principal, per principal and operation, and per key, and caps how many
+// items each principal may decrypt per day, so that one compromised client
+// cannot drain the vault at full speed.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Operations charged by the Limiter.
+const (
+	Encrypt = "encrypt"
+	Decrypt = "decrypt"
+)
+
+// MinDefaultBurst is the smallest burst a Rule without one gets, so a full
+// batch of items can pass.
+const MinDefaultBurst = 1000
+
+// Errors wrapped by a *LimitError.
+var (
+	ErrRateLimited   = errors.New("rate limit exceeded")
+	ErrQuotaExceeded = errors.New("daily decrypt quota exceeded")
+)
+
+// LimitError reports a refused call.
+type LimitError struct {
+	err error
+	msg string
+	// RetryAfter is how long until the call could pass; zero means retrying
+	// the same call does not help (it is larger than the burst).
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string { return e.err.Error() + ": " + e.msg }
+func (e *LimitError) Unwrap() error { return e.err }
+
+// Rule is a token bucket of Rate items per second holding up to Burst items.
+// A zero Rate means no limit; a zero Burst means one second of Rate, but at
+// least MinDefaultBurst.
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+func (r Rule) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return max(int(math.Ceil(r.Rate)), MinDefaultBurst)
+}
+
+// Config sets the limits. Every item of a call is charged to each bucket
+// that applies: the principal's, the principal's bucket for the operation,
+// the tenant's and the key's.
+type Config struct {
+	Principal Rule // each principal, encrypt and decrypt together
+	Encrypt   Rule // each principal's encryptions
+	Decrypt   Rule // each principal's decryptions
+	Key       Rule // each key, across all principals
+
+	// Keys overrides Key by key_id; "default" is the key backend's key. A
+	// tenant's keys are named with the tenant, "payments/pan".
+	Keys map[string]Rule
+
+	// DailyDecryptQuota caps the items each principal decrypts per UTC day;
+	// zero means no quota.
+	DailyDecryptQuota int
+
+	// Principals overrides the limits of principals by name, or by a name
+	// prefix ending in "*"; zero fields inherit.
+	Principals map[string]PrincipalConfig
+
+	// Tenants limits each tenant, all its principals together.
+	Tenants map[string]TenantConfig
+}
+
+// TenantConfig limits one tenant.
+type TenantConfig struct {
+	Rate Rule
+	// DailyDecryptQuota caps the items the tenant decrypts per UTC day.
+	DailyDecryptQuota int
+}
+
+// PrincipalConfig overrides the limits of matching principals.
+type PrincipalConfig struct {
+	Principal         Rule
+	Encrypt           Rule
+	Decrypt           Rule
+	DailyDecryptQuota int
+}
+
+// Enabled reports whether cfg limits anything.
+func (cfg Config) Enabled() bool {
+	if cfg.Principal.Rate > 0 || cfg.Encrypt.Rate > 0 || cfg.Decrypt.Rate > 0 || cfg.Key.Rate > 0 || cfg.DailyDecryptQuota > 0 {
+		return true
+	}
+	for _, r := range cfg.Keys {
+		if r.Rate > 0 {
+			return true
+		}
+	}
+	for _, p := range cfg.Principals {
+		if p.Principal.Rate > 0 || p.Encrypt.Rate > 0 || p.Decrypt.Rate > 0 || p.DailyDecryptQuota > 0 {
+			return true
+		}
+	}
+	for _, t := range cfg.Tenants {
+		if t.Rate.Rate > 0 || t.DailyDecryptQuota > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// principal returns the limits of the named principal.
+func (cfg Config) principal(name string) PrincipalConfig {
+	pc := PrincipalConfig{
+		Principal:         cfg.Principal,
+		Encrypt:           cfg.Encrypt,
+		Decrypt:           cfg.Decrypt,
+		DailyDecryptQuota: cfg.DailyDecryptQuota,
+	}
+	o, ok := cfg.Principals[name]
+	if !ok {
+		best := -1
+		for pattern, po := range cfg.Principals {
+			prefix, wildcard := strings.CutSuffix(pattern, "*")
+			if wildcard && strings.HasPrefix(name, prefix) && len(prefix) > best {
+				o, ok, best = po, true, len(prefix)
+			}
+		}
+	}
+	if !ok {
+		return pc
+	}
+	if o.Principal.Rate > 0 {
+		pc.Principal = o.Principal
+	}
+	if o.Encrypt.Rate > 0 {
+		pc.Encrypt = o.Encrypt
+	}
+	if o.Decrypt.Rate > 0 {
+		pc.Decrypt = o.Decrypt
+	}
+	if o.DailyDecryptQuota > 0 {
+		pc.DailyDecryptQuota = o.DailyDecryptQuota
+	}
+	return pc
+}
+
+// Limiter enforces a Config. It is safe for concurrent use.
+type Limiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	cfg       Config
+	buckets   map[bucketKey]*rate.Limiter
+	decrypted map[bucketKey]*dayCount // by principal or tenant
+	lastPrune time.Time
+}
+
+type bucketKey struct {
+	kind string // "principal", Encrypt, Decrypt, "tenant" or "key"
+	name string
+}
+
+type dayCount struct {
+	day string // UTC date
+	n   int
+}
+
+// pruneInterval is how often buckets that have refilled are dropped, so
+// clients that come and go do not accumulate.
+const pruneInterval = time.Minute
+
+// New returns a Limiter enforcing cfg.
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		now:       time.Now,
+		cfg:       cfg,
+		buckets:   map[bucketKey]*rate.Limiter{},
+		decrypted: map[bucketKey]*dayCount{},
+	}
+}
+
+// SetConfig replaces the limits. The token buckets start full again; the
+// day's decrypt counts are kept.
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	clear(l.buckets)
+}
+
+// Config returns the limits in force.
+func (l *Limiter) Config() Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// Allow charges a call of op by principal, a member of tenant ("" for none),
+// with the number of items per key ID, and returns a *LimitError if any
+// bucket lacks the tokens or a daily quota would be exceeded. A refused call
+// is not charged. Decrypt items that then fail are returned to the quota with
+// Refund.
+func (l *Limiter) Allow(tenant, principal, op string, items map[string]int) error {
+	_, _, err := l.take(tenant, principal, op, items, false)
+	return err
+}
+
+// Wait is like Allow for a single item but waits, up to the context's
+// deadline, for the buckets to refill; only the daily quota fails at once.
+// It is meant for streams, which are throttled rather than failed. An item
+// whose context ends while it waits is not charged.
+func (l *Limiter) Wait(ctx context.Context, tenant, principal, op, keyID string) error {
+	reserved, delay, err := l.take(tenant, principal, op, map[string]int{keyID: 1}, true)
+	if err != nil || delay == 0 {
+		return err
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		now := l.now()
+		for _, r := range reserved {
+			r.CancelAt(now)
+		}
+		l.mu.Unlock()
+		if op == Decrypt {
+			l.Refund(tenant, principal, 1)
+		}
+		return ctx.Err()
+	}
+}
+
+// Refund returns n decrypt items that failed to the daily quotas of
+// principal and tenant, so that the quotas count decrypted items only. It
+// credits today's counts, never below zero.
+func (l *Limiter) Refund(tenant, principal string, n int) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	day := l.now().UTC().Format(time.DateOnly)
+	for _, key := range []bucketKey{{"principal", principal}, {"tenant", tenant}} {
+		if dc := l.decrypted[key]; dc != nil && dc.day == day {
+			dc.n = max(dc.n-n, 0)
+		}
+	}
+}
+
+// take charges the buckets and the daily quotas. Without wait, a call that
+// would have to wait is refused and its reservations are cancelled; with
+// wait, the reservations stand and are returned, and the caller must wait
+// the returned delay.
+func (l *Limiter) take(tenant, principal, op string, items map[string]int, wait bool) ([]*rate.Reservation, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.pruneLocked(now)
+	pc := l.cfg.principal(principal)
+	tc := l.cfg.Tenants[tenant]
+
+	n := 0
+	for _, c := range items {
+		n += c
+	}
+
+	var counts []*dayCount
+	if op == Decrypt {
+		quotas := []struct {
+			key   bucketKey
+			quota int
+		}{
+			{bucketKey{"principal", principal}, pc.DailyDecryptQuota},
+			{bucketKey{"tenant", tenant}, tc.DailyDecryptQuota},
+		}
+		day := now.UTC().Format(time.DateOnly)
+		for _, q := range quotas {
+			if q.quota <= 0 || q.key.name == "" {
+				continue
+			}
+			dc := l.decrypted[q.key]
+			if dc == nil || dc.day != day {
+				dc = &dayCount{day: day}
+				l.decrypted[q.key] = dc
+			}
+			if dc.n+n > q.quota {
+				midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+				return nil, 0, &LimitError{
+					err:        ErrQuotaExceeded,
+					msg:        fmt.Sprintf("%s has decrypted %d of %d items today", q.key.describe(), dc.n, q.quota),
+					RetryAfter: midnight.Sub(now),
+				}
+			}
+			counts = append(counts, dc)
+		}
+	}
+
+	type charge struct {
+		key  bucketKey
+		rule Rule
+		n    int
+	}
+	charges := []charge{
+		{bucketKey{"principal", principal}, pc.Principal, n},
+	}
+	switch op {
+	case Encrypt:
+		charges = append(charges, charge{bucketKey{Encrypt, principal}, pc.Encrypt, n})
+	case Decrypt:
+		charges = append(charges, charge{bucketKey{Decrypt, principal}, pc.Decrypt, n})
+	}
+	if tenant != "" {
+		charges = append(charges, charge{bucketKey{"tenant", tenant}, tc.Rate, n})
+	}
+	for keyID, c := range items {
+		if keyID == "" {
+			keyID = "default"
+		}
+		rule := l.cfg.Key
+		if r, ok := l.cfg.Keys[keyID]; ok {
+			rule = r
+		}
+		charges = append(charges, charge{bucketKey{"key", keyID}, rule, c})
+	}
+
+	var (
+		reserved []*rate.Reservation
+		delay    time.Duration
+		limited  *charge
+	)
+	cancel := func() {
+		for _, r := range reserved {
+			r.CancelAt(now)
+		}
+	}
+	for i := range charges {
+		c := &charges[i]
+		if c.rule.Rate <= 0 {
+			continue
+		}
+		b := l.bucketLocked(c.key, c.rule)
+		r := b.ReserveN(now, c.n)
+		if !r.OK() {
+			cancel()
+			return nil, 0, &LimitError{
+				err: ErrRateLimited,
+				msg: fmt.Sprintf("%d items exceed the %s burst of %d; send smaller batches", c.n, c.key.describe(), b.Burst()),
+			}
+		}
+		reserved = append(reserved, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay, limited = d, c
+		}
+	}
+	if delay > 0 && !wait {
+		cancel()
+		return nil, 0, &LimitError{
+			err:        ErrRateLimited,
+			msg:        fmt.Sprintf("%s allows %g items/s", limited.key.describe(), limited.rule.Rate),
+			RetryAfter: delay,
+		}
+	}
+	for _, dc := range counts {
+		dc.n += n
+	}
+	return reserved, delay, nil
+}
+
+func (l *Limiter) bucketLocked(key bucketKey, rule Rule) *rate.Limiter {
+	b := l.buckets[key]
+	if b == nil {
+		b = rate.NewLimiter(rate.Limit(rule.Rate), rule.burst())
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// pruneLocked drops full buckets, which behave like new ones, and the
+// decrypt counts of past days.
+func (l *Limiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for k, b := range l.buckets {
+		if b.TokensAt(now) >= float64(b.Burst()) {
+			delete(l.buckets, k)
+		}
+	}
+	today := now.UTC().Format(time.DateOnly)
+	for k, dc := range l.decrypted {
+		if dc.day != today {
+			delete(l.decrypted, k)
+		}
+	}
+}
+
+func (k bucketKey) describe() string {
+	switch k.kind {
+	case "key":
+		return fmt.Sprintf("key %q", k.name)
+	case "principal":
+		return fmt.Sprintf("principal %q", k.name)
+	case "tenant":
+		return fmt.Sprintf("tenant %q", k.name)
+	default:
+		return fmt.Sprintf("%s limit of principal %q", k.kind, k.name)
+	}
+}
@@ -0,0 +1,195 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kms/internal/ratelimit"
+)
+
+// clock is a settable time source for a Limiter.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newLimiter(cfg ratelimit.Config) (*ratelimit.Limiter, *clock) {
+	c := &clock{t: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+	l := ratelimit.New(cfg)
+	ratelimit.SetClock(l, c.now)
+	return l, c
+}
+
+// step is one call in a table test: after advancing the clock by wait, op
+// with n items of key "pan" passes or fails with want.
+type step struct {
+	wait  time.Duration
+	op    string
+	n     int
+	want  error // sentinel, nil to pass
+	retry time.Duration
+}
+
+func run(t *testing.T, l *ratelimit.Limiter, c *clock, steps []step) {
+	t.Helper()
+	for i, s := range steps {
+		c.advance(s.wait)
+		err := l.Allow("payments", "pay-api", s.op, map[string]int{"pan": s.n})
+		if !errors.Is(err, s.want) {
+			t.Fatalf("step %d: %d %s items = %v, want %v", i+1, s.n, s.op, err, s.want)
+		}
+		var le *ratelimit.LimitError
+		if errors.As(err, &le) && le.RetryAfter != s.retry {
+			t.Errorf("step %d: retry after %v, want %v", i+1, le.RetryAfter, s.retry)
+		}
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cfg   ratelimit.Config
+		steps []step
+	}{
+		{
+			name: "burst then refill",
+			cfg:  ratelimit.Config{Principal: ratelimit.Rule{Rate: 2, Burst: 4}},
+			steps: []step{
+				{op: ratelimit.Encrypt, n: 4},
+				{op: ratelimit.Encrypt, n: 1, want: ratelimit.ErrRateLimited, retry: 500 * time.Millisecond},
+				{wait: 500 * time.Millisecond, op: ratelimit.Encrypt, n: 1},
+				{op: ratelimit.Decrypt, n: 1, want: ratelimit.ErrRateLimited, retry: 500 * time.Millisecond},
+				{wait: 2 * time.Second, op: ratelimit.Decrypt, n: 4},
+			},
+		},
+		{
+			name: "call larger than the burst",
+			cfg:  ratelimit.Config{Key: ratelimit.Rule{Rate: 10, Burst: 5}},
+			steps: []step{
+				{op: ratelimit.Encrypt, n: 6, want: ratelimit.ErrRateLimited},
+				{op: ratelimit.Encrypt, n: 5},
+			},
+		},
+		{
+			name: "default burst",
+			cfg:  ratelimit.Config{Encrypt: ratelimit.Rule{Rate: 1}},
+			steps: []step{
+				{op: ratelimit.Encrypt, n: ratelimit.MinDefaultBurst},
+				{op: ratelimit.Encrypt, n: 1, want: ratelimit.ErrRateLimited, retry: time.Second},
+				{op: ratelimit.Decrypt, n: 1},
+			},
+		},
+		{
+			name: "refused call is not charged",
+			cfg:  ratelimit.Config{Principal: ratelimit.Rule{Rate: 1, Burst: 2}, Key: ratelimit.Rule{Rate: 1, Burst: 1}},
+			steps: []step{
+				{op: ratelimit.Encrypt, n: 2, want: ratelimit.ErrRateLimited},
+				{op: ratelimit.Encrypt, n: 1},
+				{wait: time.Second, op: ratelimit.Encrypt, n: 1},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, c := newLimiter(tc.cfg)
+			run(t, l, c, tc.steps)
+		})
+	}
+}
+
+func TestDailyDecryptQuota(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cfg   ratelimit.Config
+		steps []step
+	}{
+		{
+			name: "principal",
+			cfg:  ratelimit.Config{DailyDecryptQuota: 3},
+			steps: []step{
+				{op: ratelimit.Decrypt, n: 2},
+				{op: ratelimit.Encrypt, n: 5},
+				{op: ratelimit.Decrypt, n: 2, want: ratelimit.ErrQuotaExceeded, retry: 12 * time.Hour},
+				{op: ratelimit.Decrypt, n: 1},
+				{wait: 11*time.Hour + 59*time.Minute, op: ratelimit.Decrypt, n: 1, want: ratelimit.ErrQuotaExceeded, retry: time.Minute},
+				{wait: time.Minute, op: ratelimit.Decrypt, n: 3},
+			},
+		},
+		{
+			name: "tenant",
+			cfg:  ratelimit.Config{Tenants: map[string]ratelimit.TenantConfig{"payments": {DailyDecryptQuota: 2}}},
+			steps: []step{
+				{op: ratelimit.Decrypt, n: 2},
+				{op: ratelimit.Decrypt, n: 1, want: ratelimit.ErrQuotaExceeded, retry: 12 * time.Hour},
+				{wait: 12 * time.Hour, op: ratelimit.Decrypt, n: 2},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, c := newLimiter(tc.cfg)
+			run(t, l, c, tc.steps)
+		})
+	}
+}
+
+func TestRefund(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		charged int
+		wait    time.Duration // between the charge and the refund
+		refund  int
+		allowed int // items that then fit in the quota of 4
+	}{
+		{name: "part of the charge", charged: 3, refund: 2, allowed: 3},
+		{name: "more than charged", charged: 2, refund: 10, allowed: 4},
+		{name: "negative", charged: 2, refund: -3, allowed: 2},
+		{name: "charge of the previous day", charged: 4, wait: 12 * time.Hour, refund: 4, allowed: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, c := newLimiter(ratelimit.Config{
+				DailyDecryptQuota: 4,
+				Tenants:           map[string]ratelimit.TenantConfig{"payments": {DailyDecryptQuota: 4}},
+			})
+			if err := l.Allow("payments", "pay-api", ratelimit.Decrypt, map[string]int{"pan": tc.charged}); err != nil {
+				t.Fatal(err)
+			}
+			c.advance(tc.wait)
+			l.Refund("payments", "pay-api", tc.refund)
+			if err := l.Allow("payments", "pay-api", ratelimit.Decrypt, map[string]int{"pan": tc.allowed}); err != nil {
+				t.Fatalf("%d items after the refund: %v", tc.allowed, err)
+			}
+			if err := l.Allow("payments", "pay-api", ratelimit.Decrypt, map[string]int{"pan": 1}); !errors.Is(err, ratelimit.ErrQuotaExceeded) {
+				t.Errorf("one more item = %v, want ErrQuotaExceeded", err)
+			}
+		})
+	}
+}
+
+// An item whose context ends while it waits gives back its tokens and its
+// share of the decrypt quota.
+func TestWaitCancel(t *testing.T) {
+	for _, op := range []string{ratelimit.Encrypt, ratelimit.Decrypt} {
+		t.Run(op, func(t *testing.T) {
+			l, c := newLimiter(ratelimit.Config{
+				Key:               ratelimit.Rule{Rate: 1, Burst: 1},
+				DailyDecryptQuota: 2,
+			})
+			if err := l.Wait(context.Background(), "", "etl", op, "pan"); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := l.Wait(ctx, "", "etl", op, "pan"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Wait on an empty bucket = %v, want DeadlineExceeded", err)
+			}
+
+			// The cancelled reservation no longer holds the token that
+			// refills in a second, and the quota has room for it.
+			c.advance(time.Second)
+			if err := l.Allow("", "etl", op, map[string]int{"pan": 1}); err != nil {
+				t.Errorf("Allow after the refill = %v, want the cancelled wait released", err)
+			}
+		})
+	}
+}
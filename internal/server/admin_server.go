@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"kms/internal/auth"
+	kmslib "kms/internal/kms"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ReasonConfigRejected is the ErrorInfo reason of a reload that was refused.
+const ReasonConfigRejected = "CONFIG_REJECTED"
+
+// ReloadResult describes an applied configuration reload.
+type ReloadResult struct {
+	// RestartRequired lists changed settings that are not applied until the
+	// server restarts, by config file key.
+	RestartRequired []string
+	// KeyReloaded reports whether the key backend was rebuilt.
+	KeyReloaded bool
+}
+
+// ReloadFunc re-reads the configuration and applies it atomically: on error
+// nothing has changed.
+type ReloadFunc func() (ReloadResult, error)
+
+// ServerInfo describes the running server for KMSAdmin/GetServerInfo.
+type ServerInfo struct {
+	Version     string
+	KeyBackend  string
+	ConfigFile  string
+	Sealed      bool
+	TLSEnabled  bool
+	AuthEnabled bool
+}
+
+// AdminHooks connect KMSAdmin to the process that owns the configuration and
+// the key backend. A nil hook makes its call return Unimplemented.
+type AdminHooks struct {
+	Reload ReloadFunc
+	// Seal releases the key material; Unseal reopens the key backend. Both
+	// do nothing when already in the requested state.
+	Seal   func() error
+	Unseal func() error
+	Info   func() ServerInfo
+}
+
+// AdminServer implements the KMSAdmin gRPC service. The auth interceptors
+// restrict it to JWTConfig.AdminPrincipals.
+type AdminServer struct {
+	kmsproto.UnimplementedKMSAdminServer
+	hooks   AdminHooks
+	keys    *kmslib.Keyring // nil without a key store
+	started time.Time
+}
+
+// NewAdminServer returns an AdminServer managing keys, which may be nil when
+// no key store is configured.
+func NewAdminServer(hooks AdminHooks, keys *kmslib.Keyring) *AdminServer {
+	return &AdminServer{hooks: hooks, keys: keys, started: time.Now()}
+}
+
+func (s *AdminServer) ReloadConfig(ctx context.Context, req *kmsproto.ReloadConfigRequest) (*kmsproto.ReloadConfigResponse, error) {
+	if s.hooks.Reload == nil {
+		return nil, status.Error(codes.Unimplemented, "configuration reload is not available")
+	}
+	logAdmin(ctx, "configuration reload")
+	res, err := s.hooks.Reload()
+	if err != nil {
+		return nil, newStatus(codes.FailedPrecondition, ReasonConfigRejected, err.Error())
+	}
+	return &kmsproto.ReloadConfigResponse{
+		RestartRequired: res.RestartRequired,
+		KeyReloaded:     res.KeyReloaded,
+	}, nil
+}
+
+func (s *AdminServer) CreateKey(ctx context.Context, req *kmsproto.CreateKeyRequest) (*kmsproto.CreateKeyResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	logAdmin(ctx, "create key", "key_id", req.GetKeyId())
+	key, err := s.keys.CreateKey(req.GetKeyId(), req.GetDescription())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.CreateKeyResponse{Key: keyMetadata(key)}, nil
+}
+
+func (s *AdminServer) ListKeys(ctx context.Context, req *kmsproto.ListKeysRequest) (*kmsproto.ListKeysResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	keys, err := s.keys.ListKeys()
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &kmsproto.ListKeysResponse{}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, keyMetadata(k))
+	}
+	return resp, nil
+}
+
+func (s *AdminServer) DescribeKey(ctx context.Context, req *kmsproto.DescribeKeyRequest) (*kmsproto.DescribeKeyResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	key, err := s.keys.DescribeKey(req.GetKeyId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.DescribeKeyResponse{Key: keyMetadata(key)}, nil
+}
+
+func (s *AdminServer) RotateKey(ctx context.Context, req *kmsproto.RotateKeyRequest) (*kmsproto.RotateKeyResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	logAdmin(ctx, "rotate key", "key_id", req.GetKeyId())
+	key, err := s.keys.RotateKey(req.GetKeyId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.RotateKeyResponse{Key: keyMetadata(key)}, nil
+}
+
+func (s *AdminServer) EnableKey(ctx context.Context, req *kmsproto.EnableKeyRequest) (*kmsproto.EnableKeyResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	logAdmin(ctx, "enable key", "key_id", req.GetKeyId())
+	key, err := s.keys.EnableKey(req.GetKeyId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.EnableKeyResponse{Key: keyMetadata(key)}, nil
+}
+
+func (s *AdminServer) DisableKey(ctx context.Context, req *kmsproto.DisableKeyRequest) (*kmsproto.DisableKeyResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	logAdmin(ctx, "disable key", "key_id", req.GetKeyId())
+	key, err := s.keys.DisableKey(req.GetKeyId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.DisableKeyResponse{Key: keyMetadata(key)}, nil
+}
+
+func (s *AdminServer) ScheduleKeyDeletion(ctx context.Context, req *kmsproto.ScheduleKeyDeletionRequest) (*kmsproto.ScheduleKeyDeletionResponse, error) {
+	if err := s.checkKeyStore(); err != nil {
+		return nil, err
+	}
+	window := kmslib.DefaultDeletionWindow
+	if days := req.GetPendingWindowDays(); days != 0 {
+		window = time.Duration(days) * 24 * time.Hour
+		if window < kmslib.MinDeletionWindow || window > kmslib.MaxDeletionWindow {
+			return nil, invalidRequest("pending_window_days must be between 7 and 30")
+		}
+	}
+	logAdmin(ctx, "schedule deletion of key", "key_id", req.GetKeyId())
+	key, err := s.keys.ScheduleKeyDeletion(req.GetKeyId(), window)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.ScheduleKeyDeletionResponse{Key: keyMetadata(key)}, nil
+}
+
+func (s *AdminServer) GetServerInfo(ctx context.Context, req *kmsproto.GetServerInfoRequest) (*kmsproto.GetServerInfoResponse, error) {
+	var info ServerInfo
+	if s.hooks.Info != nil {
+		info = s.hooks.Info()
+	}
+	resp := &kmsproto.GetServerInfoResponse{
+		Version:         info.Version,
+		GoVersion:       runtime.Version(),
+		StartTime:       timestamppb.New(s.started),
+		KeyBackend:      info.KeyBackend,
+		Sealed:          info.Sealed,
+		KeyStoreEnabled: s.keys != nil,
+		TlsEnabled:      info.TLSEnabled,
+		AuthEnabled:     info.AuthEnabled,
+		ConfigFile:      info.ConfigFile,
+	}
+	if s.keys != nil {
+		resp.KeyCount = int32(s.keys.Len())
+	}
+	return resp, nil
+}
+
+func (s *AdminServer) Seal(ctx context.Context, req *kmsproto.SealRequest) (*kmsproto.SealResponse, error) {
+	if s.hooks.Seal == nil {
+		return nil, status.Error(codes.Unimplemented, "sealing is not available")
+	}
+	logAdmin(ctx, "seal")
+	if err := s.hooks.Seal(); err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.SealResponse{}, nil
+}
+
+func (s *AdminServer) Unseal(ctx context.Context, req *kmsproto.UnsealRequest) (*kmsproto.UnsealResponse, error) {
+	if s.hooks.Unseal == nil {
+		return nil, status.Error(codes.Unimplemented, "unsealing is not available")
+	}
+	logAdmin(ctx, "unseal")
+	if err := s.hooks.Unseal(); err != nil {
+		return nil, statusError(err)
+	}
+	return &kmsproto.UnsealResponse{}, nil
+}
+
+func (s *AdminServer) checkKeyStore() error {
+	if s.keys == nil {
+		return newStatus(codes.FailedPrecondition, ReasonNoKeyStore, "no key store is configured (set key.store / KMS_KEY_STORE)")
+	}
+	return nil
+}
+
+// logAdmin records who requested a change, with args naming its target.
+func logAdmin(ctx context.Context, action string, args ...any) {
+	p, _ := auth.PrincipalFromContext(ctx)
+	slog.Info("KMS admin: "+action+" requested", append([]any{"principal", p.Name}, args...)...)
+}
+
+func keyMetadata(k kmslib.KeyMetadata) *kmsproto.KeyMetadata {
+	m := &kmsproto.KeyMetadata{
+		KeyId:          k.ID,
+		Description:    k.Description,
+		State:          keyState(k.State),
+		PrimaryVersion: k.PrimaryVersion(),
+		CreateTime:     timestamppb.New(k.CreateTime),
+	}
+	if !k.DeletionTime.IsZero() {
+		m.DeletionTime = timestamppb.New(k.DeletionTime)
+	}
+	for _, v := range k.Versions {
+		m.Versions = append(m.Versions, &kmsproto.KeyVersion{Version: v.Version, CreateTime: timestamppb.New(v.CreateTime)})
+	}
+	return m
+}
+
+func keyState(s kmslib.KeyState) kmsproto.KeyState {
+	switch s {
+	case kmslib.KeyEnabled:
+		return kmsproto.KeyState_KEY_STATE_ENABLED
+	case kmslib.KeyDisabled:
+		return kmsproto.KeyState_KEY_STATE_DISABLED
+	case kmslib.KeyPendingDeletion:
+		return kmsproto.KeyState_KEY_STATE_PENDING_DELETION
+	}
+	return kmsproto.KeyState_KEY_STATE_UNSPECIFIED
+}
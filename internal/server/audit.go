@@ -0,0 +1,360 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"kms/internal/audit"
+	"kms/internal/auth"
+	kmslib "kms/internal/kms"
+	"kms/internal/policy"
+	"kms/internal/tenant"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata read and written by the audit interceptors.
+const (
+	// RequestIDHeader carries the caller's request ID; when absent the server
+	// generates one. Either way it is returned in the response headers.
+	RequestIDHeader = "x-request-id"
+	// EncryptionContextHeader carries a caller-defined description of the
+	// data, e.g. "job=etl-2024-06,table=cards". Only its hash is recorded.
+	EncryptionContextHeader = "x-kms-encryption-context"
+)
+
+// auditedPrefix selects the methods that are audited: the KMS, Auth and
+// KMSAdmin services, but not health checks or reflection.
+const auditedPrefix = "/kms."
+
+// AuditUnaryInterceptor records every KMS, Auth and KMSAdmin call in l once
+// it returns. It must be the first interceptor, so that calls refused by
+// authentication or rate limits are recorded too, and be paired with
+// AuditPrincipalUnaryInterceptor after the auth interceptor.
+//
+// When l is fail-closed, a call is refused with Unavailable before it runs if
+// the audit log cannot take records, and its response is withheld if its
+// record cannot be written.
+func AuditUnaryInterceptor(l *audit.Log) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, auditedPrefix) {
+			return handler(ctx, req)
+		}
+		ev := newAuditEvent(ctx, info.FullMethod)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, ev.rec.RequestID))
+		ev.request(req)
+		if err := l.Check(); err != nil {
+			err = statusError(err)
+			ev.finish(l, err)
+			return nil, err
+		}
+		resp, err := handler(context.WithValue(ctx, auditEventKey{}, ev), req)
+		if err == nil {
+			ev.response(resp)
+		}
+		if aerr := ev.finish(l, err); aerr != nil && err == nil && l.FailClosed() {
+			return nil, statusError(aerr)
+		}
+		return resp, err
+	}
+}
+
+// AuditStreamInterceptor is the streaming counterpart of
+// AuditUnaryInterceptor. A stream is recorded once, when it ends, with its
+// item counts; a fail-closed log is checked when the stream opens.
+func AuditStreamInterceptor(l *audit.Log) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, auditedPrefix) {
+			return handler(srv, ss)
+		}
+		ev := newAuditEvent(ss.Context(), info.FullMethod)
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, ev.rec.RequestID))
+		if err := l.Check(); err != nil {
+			err = statusError(err)
+			ev.finish(l, err)
+			return err
+		}
+		err := handler(srv, &auditedStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), auditEventKey{}, ev), ev: ev})
+		ev.finish(l, err)
+		return err
+	}
+}
+
+// AuditPrincipalUnaryInterceptor records the authenticated principal of the
+// call; it must run right after the auth interceptor.
+func AuditPrincipalUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		setAuditPrincipal(ctx)
+		return handler(ctx, req)
+	}
+}
+
+// AuditPrincipalStreamInterceptor is the streaming counterpart of
+// AuditPrincipalUnaryInterceptor.
+func AuditPrincipalStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		setAuditPrincipal(ss.Context())
+		return handler(srv, ss)
+	}
+}
+
+type auditEventKey struct{}
+
+func setAuditPrincipal(ctx context.Context) {
+	ev, _ := ctx.Value(auditEventKey{}).(*auditEvent)
+	p, ok := auth.PrincipalFromContext(ctx)
+	if ev == nil || !ok {
+		return
+	}
+	ev.mu.Lock()
+	ev.rec.Principal, ev.rec.AuthMethod, ev.rec.Tenant = p.Name, p.Method, p.Tenant
+	ev.mu.Unlock()
+}
+
+// auditEvent collects the record of one call. Stream messages are received
+// and sent concurrently, hence the lock.
+type auditEvent struct {
+	mu    sync.Mutex
+	rec   audit.Record
+	keys  map[string]*audit.KeyUse
+	batch bool
+}
+
+func newAuditEvent(ctx context.Context, method string) *auditEvent {
+	ev := &auditEvent{
+		rec: audit.Record{
+			Time:       time.Now(),
+			Op:         strings.TrimPrefix(method, auditedPrefix),
+			RequestID:  incomingHeader(ctx, RequestIDHeader),
+			ClientAddr: clientAddr(ctx),
+		},
+		keys: map[string]*audit.KeyUse{},
+	}
+	if ev.rec.RequestID == "" {
+		ev.rec.RequestID = newRequestID()
+	}
+	if c := incomingHeader(ctx, EncryptionContextHeader); c != "" {
+		sum := sha256.Sum256([]byte(c))
+		ev.rec.ContextHash = hex.EncodeToString(sum[:])
+	}
+	return ev
+}
+
+// request records the keys and items of a request, taking key versions from
+// the ciphertexts of decrypt requests.
+func (ev *auditEvent) request(req interface{}) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	switch r := req.(type) {
+	case *kmsproto.EncryptRequest:
+		ev.item(r.GetKeyId(), nil)
+	case *kmsproto.DecryptRequest:
+		ev.item(r.GetKeyId(), r.GetCiphertext())
+	case *kmsproto.BatchEncryptRequest:
+		ev.batch = true
+		for _, it := range r.GetItems() {
+			ev.item(it.GetKeyId(), nil)
+		}
+	case *kmsproto.BatchDecryptRequest:
+		ev.batch = true
+		for _, it := range r.GetItems() {
+			ev.item(it.GetKeyId(), it.GetCiphertext())
+		}
+	case *kmsproto.EncryptStreamRequest:
+		ev.batch = true
+		ev.item(r.GetKeyId(), nil)
+	case *kmsproto.DecryptStreamRequest:
+		ev.batch = true
+		ev.item(r.GetKeyId(), r.GetCiphertext())
+	case *kmsproto.DescribeCiphertextRequest:
+		ev.version(r.GetCiphertext())
+	case *kmsproto.LoginRequest:
+		// Login is not authenticated; record who tried.
+		ev.rec.Principal = r.GetUsername()
+	case interface{ GetKeyId() string }:
+		// KMSAdmin key calls.
+		if id := r.GetKeyId(); id != "" {
+			ev.keys[id] = &audit.KeyUse{ID: id}
+		}
+	}
+}
+
+// response records failed items and the key versions of new ciphertexts and
+// of keys created or rotated through KMSAdmin.
+func (ev *auditEvent) response(resp interface{}) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	switch r := resp.(type) {
+	case *kmsproto.EncryptResponse:
+		ev.version(r.GetCiphertext())
+	case *kmsproto.BatchEncryptResponse:
+		for _, res := range r.GetResults() {
+			ev.itemResult(res.GetStatus())
+			ev.version(res.GetCiphertext())
+		}
+	case *kmsproto.BatchDecryptResponse:
+		for _, res := range r.GetResults() {
+			ev.itemResult(res.GetStatus())
+		}
+	case *kmsproto.EncryptStreamResponse:
+		ev.itemResult(r.GetStatus())
+		ev.version(r.GetCiphertext())
+	case *kmsproto.DecryptStreamResponse:
+		ev.itemResult(r.GetStatus())
+	case interface{ GetKey() *kmsproto.KeyMetadata }:
+		if k := r.GetKey(); k != nil {
+			if use := ev.keys[k.GetKeyId()]; use != nil && k.GetPrimaryVersion() != 0 {
+				use.Versions = []uint32{k.GetPrimaryVersion()}
+			}
+		}
+	}
+}
+
+func (ev *auditEvent) item(keyID string, ciphertext []byte) {
+	if keyID == "" {
+		keyID = policy.DefaultKeyID
+	}
+	use := ev.keys[keyID]
+	if use == nil {
+		use = &audit.KeyUse{ID: keyID}
+		ev.keys[keyID] = use
+	}
+	use.Items++
+	ev.rec.Items++
+	ev.version(ciphertext)
+}
+
+// version notes the key version named in a named-key ciphertext header.
+func (ev *auditEvent) version(ciphertext []byte) {
+	id, v, ok := kmslib.CiphertextKey(ciphertext)
+	if !ok {
+		return
+	}
+	use := ev.keys[id]
+	if use == nil {
+		use = &audit.KeyUse{ID: id}
+		ev.keys[id] = use
+	}
+	if !slices.Contains(use.Versions, v) {
+		use.Versions = append(use.Versions, v)
+	}
+}
+
+func (ev *auditEvent) itemResult(st *kmsproto.ItemStatus) {
+	if st != nil && codes.Code(st.GetCode()) != codes.OK {
+		ev.rec.Failed++
+	}
+}
+
+// finish completes the record with the call's outcome and appends it. A
+// record that cannot be written is logged and the error returned.
+func (ev *auditEvent) finish(l *audit.Log, err error) error {
+	ev.mu.Lock()
+	rec := ev.rec
+	keys := ev.scopedKeys()
+	for _, id := range slices.Sorted(maps.Keys(keys)) {
+		use := *keys[id]
+		slices.Sort(use.Versions)
+		rec.Keys = append(rec.Keys, use)
+	}
+	ev.mu.Unlock()
+
+	if !ev.batch {
+		rec.Items = 0
+	}
+	rec.Outcome = audit.OutcomeOK
+	if err != nil {
+		st := status.Convert(statusError(err))
+		rec.Outcome, rec.Code, rec.Reason = audit.OutcomeError, st.Code().String(), errorReason(st)
+	}
+	if err := l.Append(rec); err != nil {
+		slog.Error("KMS audit: appending record failed", "request_id", rec.RequestID, "op", rec.Op, "principal", rec.Principal, "err", err)
+		return err
+	}
+	return nil
+}
+
+// scopedKeys returns the keys of a tenant's KMS call by their qualified IDs,
+// as the server used them: the requested "pan" and the "payments/pan" of its
+// ciphertexts' headers are one key. Admin calls already name keys in full.
+func (ev *auditEvent) scopedKeys() map[string]*audit.KeyUse {
+	if ev.rec.Tenant == "" || !strings.HasPrefix(ev.rec.Op, "KMS/") {
+		return ev.keys
+	}
+	out := map[string]*audit.KeyUse{}
+	for id, use := range ev.keys {
+		if !strings.Contains(id, tenant.Separator) {
+			id = tenant.KeyID(ev.rec.Tenant, id)
+		}
+		merged := out[id]
+		if merged == nil {
+			merged = &audit.KeyUse{ID: id}
+			out[id] = merged
+		}
+		merged.Items += use.Items
+		for _, v := range use.Versions {
+			if !slices.Contains(merged.Versions, v) {
+				merged.Versions = append(merged.Versions, v)
+			}
+		}
+	}
+	return out
+}
+
+// auditedStream feeds the messages of a stream to its auditEvent.
+type auditedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	ev  *auditEvent
+}
+
+func (s *auditedStream) Context() context.Context { return s.ctx }
+
+func (s *auditedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.ev.request(m)
+	}
+	return err
+}
+
+func (s *auditedStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.ev.response(m)
+	}
+	return err
+}
+
+func incomingHeader(ctx context.Context, key string) string {
+	if vals := metadata.ValueFromIncomingContext(ctx, key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func clientAddr(ctx context.Context) string {
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		return pr.Addr.String()
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -17,13 +17,19 @@ import (
 // and issues a JWT using the shared JWTConfig.
 type AuthServer struct {
 	kmsproto.UnimplementedAuthServer
-	jwtCfg  auth.JWTConfig
+	authn   *auth.Authenticator
 	user    string
 	pass    string
 	tokenTTL time.Duration
 }
 
 func NewAuthServer(cfg auth.JWTConfig) *AuthServer {
+	return newAuthServer(auth.NewAuthenticator(cfg))
+}
+
+// newAuthServer issues tokens with the current config of authn, so a
+// reloaded secret is used at once.
+func newAuthServer(authn *auth.Authenticator) *AuthServer {
 	user := os.Getenv("KMS_DEMO_USER")
 	if user == "" {
 		user = "demo"
@@ -33,7 +39,7 @@ func NewAuthServer(cfg auth.JWTConfig) *AuthServer {
 		pass = "demo123"
 	}
 	return &AuthServer{
-		jwtCfg:  cfg,
+		authn:   authn,
 		user:    user,
 		pass:    pass,
 		tokenTTL: time.Hour,
@@ -45,7 +51,7 @@ func (s *AuthServer) Login(ctx context.Context, req *kmsproto.LoginRequest) (*km
 		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
 	}
 
-	token, err := auth.IssueToken(s.jwtCfg, req.GetUsername(), s.tokenTTL)
+	token, err := auth.IssueToken(s.authn.Config(), req.GetUsername(), s.tokenTTL)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to issue token: %v", err)
 	}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// MaxBatchItems is the largest number of items accepted by BatchEncrypt and
+// BatchDecrypt.
+const MaxBatchItems = 1000
+
+// BatchEncrypt encrypts every item against the Manager and returns results in
+// request order. A failing item gets its own status; the call itself only
+// fails for an invalid batch.
+func (s *KMSServer) BatchEncrypt(ctx context.Context, req *kmsproto.BatchEncryptRequest) (*kmsproto.BatchEncryptResponse, error) {
+	items := req.GetItems()
+	if err := s.checkBatchSize(len(items)); err != nil {
+		return nil, err
+	}
+
+	results := make([]*kmsproto.BatchEncryptResult, len(items))
+	runBatch(ctx, len(items), func(i int) {
+		ct, nonce, err := s.encryptOnce(ctx, items[i].GetIdempotencyKey(), items[i].GetKeyId(), items[i].GetPlaintext())
+		if err != nil {
+			results[i] = &kmsproto.BatchEncryptResult{Status: itemStatus(err)}
+			return
+		}
+		results[i] = &kmsproto.BatchEncryptResult{Ciphertext: ct, Nonce: nonce}
+	}, func(i int, err error) {
+		results[i] = &kmsproto.BatchEncryptResult{Status: itemStatus(err)}
+	})
+
+	return &kmsproto.BatchEncryptResponse{Results: results}, nil
+}
+
+// BatchDecrypt decrypts every item against the Manager and returns results in
+// request order, with per-item status as in BatchEncrypt.
+func (s *KMSServer) BatchDecrypt(ctx context.Context, req *kmsproto.BatchDecryptRequest) (*kmsproto.BatchDecryptResponse, error) {
+	items := req.GetItems()
+	if err := s.checkBatchSize(len(items)); err != nil {
+		return nil, err
+	}
+
+	results := make([]*kmsproto.BatchDecryptResult, len(items))
+	runBatch(ctx, len(items), func(i int) {
+		pt, err := s.decrypt(ctx, items[i].GetKeyId(), items[i].GetCiphertext(), items[i].GetNonce())
+		if err != nil {
+			results[i] = &kmsproto.BatchDecryptResult{Status: itemStatus(err)}
+			return
+		}
+		results[i] = &kmsproto.BatchDecryptResult{Plaintext: pt}
+	}, func(i int, err error) {
+		results[i] = &kmsproto.BatchDecryptResult{Status: itemStatus(err)}
+	})
+
+	return &kmsproto.BatchDecryptResponse{Results: results}, nil
+}
+
+func (s *KMSServer) checkBatchSize(n int) error {
+	if n == 0 {
+		return invalidRequest("items are required")
+	}
+	if limit := int(s.maxBatchItems.Load()); n > limit {
+		return invalidRequest(fmt.Sprintf("batch size %d exceeds the limit of %d items", n, limit))
+	}
+	return nil
+}
+
+// runBatch calls process for each index on a small worker pool, one worker per
+// CPU. The Manager is safe for concurrent use; HSM-backed managers serialize
+// internally, so more workers would only queue. Items not started before ctx
+// is done are passed to skipped with the context error instead.
+func runBatch(ctx context.Context, n int, process func(i int), skipped func(i int, err error)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					skipped(i, err)
+					continue
+				}
+				process(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// itemStatus converts an item error to its wire status: the code, message
+// and ErrorInfo reason a unary call would return for it (see statusError).
+func itemStatus(err error) *kmsproto.ItemStatus {
+	st := status.Convert(statusError(err))
+	return &kmsproto.ItemStatus{Code: int32(st.Code()), Message: st.Message(), Reason: errorReason(st)}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+
+	"kms/internal/auth"
+	kmslib "kms/internal/kms"
+	"kms/internal/tenant"
+	kmsproto "kms/proto"
+)
+
+// DescribeCiphertext reports what the bytes of a ciphertext say about it. No
+// key is used, so it works for disabled and deleted keys and on a sealed
+// server. A tenant's caller sees its own key names unqualified and no name
+// for another tenant's keys, as in Decrypt.
+func (s *KMSServer) DescribeCiphertext(ctx context.Context, req *kmsproto.DescribeCiphertextRequest) (*kmsproto.DescribeCiphertextResponse, error) {
+	info, err := kmslib.DescribeCiphertext(req.GetCiphertext())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &kmsproto.DescribeCiphertextResponse{
+		Format:              kmsproto.CiphertextFormat_CIPHERTEXT_FORMAT_LEGACY,
+		FormatVersion:       uint32(info.HeaderVersion),
+		Algorithm:           info.Algorithm,
+		NonceLength:         uint32(len(req.GetNonce())),
+		ExpectedNonceLength: kmslib.AESGCMNonceSize,
+		Aad:                 info.AAD,
+		PlaintextLength:     uint32(info.PlaintextBytes),
+	}
+	if !info.Named {
+		return resp, nil
+	}
+	resp.Format = kmsproto.CiphertextFormat_CIPHERTEXT_FORMAT_NAMED_KEY
+	p, _ := auth.PrincipalFromContext(ctx)
+	keyTenant, keyID := tenant.Split(info.KeyID)
+	switch {
+	case p.Tenant == "":
+		resp.KeyId, resp.KeyVersion = info.KeyID, info.KeyVersion
+	case keyTenant == p.Tenant:
+		resp.KeyId, resp.KeyVersion = keyID, info.KeyVersion
+	default:
+		resp.OtherTenant = true
+	}
+	return resp, nil
+}
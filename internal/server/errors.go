@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"kms/internal/audit"
+	"kms/internal/idempotency"
+	kmslib "kms/internal/kms"
+	"kms/internal/policy"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the google.rpc.ErrorInfo domain of errors raised by the KMS
+// service.
+const ErrorDomain = "kms"
+
+// Reasons carried in google.rpc.ErrorInfo and ItemStatus.reason.
+const (
+	ReasonSealed             = "KMS_SEALED"
+	ReasonBackendUnavailable = "BACKEND_UNAVAILABLE"
+	ReasonKeyNotFound        = "KEY_NOT_FOUND"
+	ReasonKeyDisabled        = "KEY_DISABLED"
+	ReasonKeyExists          = "KEY_EXISTS"
+	ReasonNoKeyStore         = "KEY_STORE_NOT_CONFIGURED"
+	ReasonPermissionDenied   = "PERMISSION_DENIED"
+	ReasonInvalidNonce       = "INVALID_NONCE"
+	ReasonInvalidCiphertext  = "INVALID_CIPHERTEXT"
+	ReasonInvalidRequest     = "INVALID_REQUEST"
+	ReasonInvalidPlaintext   = "INVALID_PLAINTEXT"
+	ReasonPlaintextTooLarge  = "PLAINTEXT_TOO_LARGE"
+	ReasonCiphertextTooLarge = "CIPHERTEXT_TOO_LARGE"
+	ReasonAuditUnavailable   = "AUDIT_UNAVAILABLE"
+	ReasonIdempotencyReused  = "IDEMPOTENCY_KEY_REUSED"
+	ReasonInternal           = "INTERNAL"
+)
+
+// errorClasses maps the kms, policy, idempotency and audit sentinel errors to status codes. Order matters
+// when an error matches several classes (a failover error wraps one error per
+// backend): a retryable cause is reported first.
+var errorClasses = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{kmslib.ErrSealed, codes.Unavailable, ReasonSealed},
+	{audit.ErrUnavailable, codes.Unavailable, ReasonAuditUnavailable},
+	{kmslib.ErrUnavailable, codes.Unavailable, ReasonBackendUnavailable},
+	{kmslib.ErrKeyNotFound, codes.NotFound, ReasonKeyNotFound},
+	{kmslib.ErrKeyDisabled, codes.FailedPrecondition, ReasonKeyDisabled},
+	{kmslib.ErrKeyExists, codes.AlreadyExists, ReasonKeyExists},
+	{kmslib.ErrInvalidKeyID, codes.InvalidArgument, ReasonInvalidRequest},
+	{kmslib.ErrPermissionDenied, codes.PermissionDenied, ReasonPermissionDenied},
+	{kmslib.ErrInvalidNonce, codes.InvalidArgument, ReasonInvalidNonce},
+	{kmslib.ErrInvalidCiphertext, codes.InvalidArgument, ReasonInvalidCiphertext},
+	{policy.ErrInvalidPlaintext, codes.InvalidArgument, ReasonInvalidPlaintext},
+	{policy.ErrPlaintextTooLarge, codes.InvalidArgument, ReasonPlaintextTooLarge},
+	{policy.ErrCiphertextTooLarge, codes.InvalidArgument, ReasonCiphertextTooLarge},
+	{idempotency.ErrKeyReused, codes.FailedPrecondition, ReasonIdempotencyReused},
+	{idempotency.ErrInvalidKey, codes.InvalidArgument, ReasonInvalidRequest},
+}
+
+// statusError converts a Manager error to a gRPC status error with a
+// google.rpc.ErrorInfo detail. Errors that already carry a status and context
+// errors are returned unchanged; unclassified errors become Internal.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	code, reason := codes.Internal, ReasonInternal
+	for _, c := range errorClasses {
+		if errors.Is(err, c.err) {
+			code, reason = c.code, c.reason
+			break
+		}
+	}
+	return newStatus(code, reason, err.Error())
+}
+
+// invalidRequest reports a request rejected before it reached the Manager.
+func invalidRequest(msg string) error {
+	return newStatus(codes.InvalidArgument, ReasonInvalidRequest, msg)
+}
+
+func newStatus(code codes.Code, reason, msg string) error {
+	st := status.New(code, msg)
+	if withInfo, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain}); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+// errorReason returns the ErrorInfo reason attached to st, if any.
+func errorReason(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kmslib "kms/internal/kms"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health check defaults. The interval can be changed with
+// KMS_HEALTH_CHECK_INTERVAL and the per-check timeout with
+// KMS_HEALTH_CHECK_TIMEOUT.
+const (
+	DefaultHealthCheckInterval = 15 * time.Second
+	DefaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// HealthChecker serves grpc.health.v1.Health. The KMS service (and the
+// overall "" service) is SERVING only while a periodic self-test of the
+// Manager succeeds: an encrypt/decrypt round trip of a fixed value, as
+// NewHSMManager does at startup. A Manager that has been closed (sealed),
+// an unreachable HSM or a self-test that hangs past the timeout all report
+// NOT_SERVING. The Auth service does not depend on the key and stays
+// SERVING.
+type HealthChecker struct {
+	server   *health.Server
+	mgr      kmslib.Manager
+	interval time.Duration
+	timeout  time.Duration
+
+	testing  atomic.Bool // a self-test is running, possibly hung
+	mu       sync.Mutex
+	lastErr  error
+	checked  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHealthChecker returns a checker for mgr. Statuses start as NOT_SERVING
+// until the first self-test; call Start to run it.
+func NewHealthChecker(mgr kmslib.Manager, interval, timeout time.Duration) *HealthChecker {
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	h := &HealthChecker{
+		server:   health.NewServer(),
+		mgr:      mgr,
+		interval: interval,
+		timeout:  timeout,
+		stop:     make(chan struct{}),
+	}
+	h.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	h.server.SetServingStatus(kmsproto.KMS_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	h.server.SetServingStatus(kmsproto.Auth_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return h
+}
+
+// Register adds the Health service to s.
+func (h *HealthChecker) Register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, h.server)
+}
+
+// Start runs a self-test now and then every interval until Stop.
+func (h *HealthChecker) Start() {
+	h.Check()
+	go func() {
+		t := time.NewTicker(h.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				h.Check()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic self-test and reports every service as
+// NOT_SERVING, e.g. while the server drains before shutdown.
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.server.Shutdown()
+}
+
+// Check runs one self-test, updates the served statuses and returns the
+// self-test error, if any.
+func (h *HealthChecker) Check() error {
+	err := h.selfTest()
+
+	h.mu.Lock()
+	changed := !h.checked || (err == nil) != (h.lastErr == nil)
+	h.lastErr = err
+	h.checked = true
+	h.mu.Unlock()
+
+	st := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	h.server.SetServingStatus("", st)
+	h.server.SetServingStatus(kmsproto.KMS_ServiceDesc.ServiceName, st)
+
+	if changed {
+		if err != nil {
+			slog.Error("KMS health: NOT_SERVING", "err", err)
+		} else {
+			slog.Info("KMS health: SERVING")
+		}
+	}
+	return err
+}
+
+// LastError returns the result of the most recent self-test.
+func (h *HealthChecker) LastError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastErr
+}
+
+var healthProbe = []byte("ping")
+
+// selfTest round-trips healthProbe through the Manager. HSM calls can block
+// for a long time when the device is unreachable, so the test is abandoned
+// after the timeout. A hung call keeps its goroutine until it returns, and no
+// new self-test starts meanwhile.
+func (h *HealthChecker) selfTest() error {
+	if !h.testing.CompareAndSwap(false, true) {
+		return errors.New("previous self-test has not finished")
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer h.testing.Store(false)
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("self-test panicked: %v", r)
+			}
+		}()
+		ct, nonce, err := h.mgr.Encrypt(healthProbe)
+		if err != nil {
+			done <- fmt.Errorf("self-test encrypt: %w", err)
+			return
+		}
+		pt, err := h.mgr.Decrypt(ct, nonce)
+		if err != nil {
+			done <- fmt.Errorf("self-test decrypt: %w", err)
+			return
+		}
+		if !bytes.Equal(pt, healthProbe) {
+			done <- errors.New("self-test round trip returned different plaintext")
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(h.timeout):
+		return fmt.Errorf("self-test did not finish within %s", h.timeout)
+	}
+}
+
+// getenvDuration parses a duration variable, returning 0 (the default) when
+// it is unset or invalid.
+func getenvDuration(key string) time.Duration {
+	d, _ := time.ParseDuration(os.Getenv(key))
+	return d
+}
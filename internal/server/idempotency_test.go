@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"kms/internal/idempotency"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// fromPeer returns an unauthenticated context of a client at ip, as with
+// auth disabled.
+func fromPeer(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+// Idempotency keys are scoped by caller, also with auth disabled, where the
+// caller is the client's address: one client's key does not replay or block
+// another's.
+func TestIdempotencyScope(t *testing.T) {
+	srv := newTenantServer(t)
+	srv.SetIdempotencyCache(idempotency.New(0, 0))
+	encrypt := func(ctx context.Context, plaintext string) (*kmsproto.EncryptResponse, error) {
+		return srv.Encrypt(ctx, &kmsproto.EncryptRequest{Plaintext: []byte(plaintext), IdempotencyKey: "row-1:pan"})
+	}
+
+	first, err := encrypt(fromPeer("10.0.0.1"), testPAN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := encrypt(fromPeer("10.0.0.1"), testPAN)
+	if err != nil || !bytes.Equal(again.Ciphertext, first.Ciphertext) {
+		t.Errorf("repeat from the same client = %v, want the first ciphertext", err)
+	}
+	if _, err := encrypt(fromPeer("10.0.0.1"), "5500000000000004"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("same client reusing the key = %v, want FailedPrecondition", err)
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"another client":    fromPeer("10.0.0.2"),
+		"another principal": as("", "ops"),
+		"another tenant":    as("payments", "pay-api"),
+	} {
+		other, err := encrypt(ctx, "5500000000000004")
+		if err != nil {
+			t.Errorf("%s using the key = %v, want its own result", name, err)
+		} else if bytes.Equal(other.Ciphertext, first.Ciphertext) {
+			t.Errorf("%s got the first client's ciphertext", name)
+		}
+	}
+}
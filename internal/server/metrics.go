@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	kmslib "kms/internal/kms"
+	"kms/internal/metrics"
+	"kms/internal/policy"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsUnaryInterceptor records every call in m: its code and latency,
+// batch sizes and authentication failures. It should be the first
+// interceptor, so that calls refused by auth or rate limits are counted too.
+// The latency per key is recorded by MetricsKeyUnaryInterceptor.
+func MetricsUnaryInterceptor(m *metrics.Server) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		method := metricsMethod(info.FullMethod)
+		recordCall(m, method, time.Since(start).Seconds(), err)
+		switch req.(type) {
+		case *kmsproto.BatchEncryptRequest, *kmsproto.BatchDecryptRequest:
+			n := 0
+			_, items := rateLimitItems(req)
+			for _, count := range items {
+				n += count
+			}
+			m.BatchItems.WithLabelValues(method).Observe(float64(n))
+		}
+		return resp, err
+	}
+}
+
+// MetricsKeyUnaryInterceptor records the latency per key of Encrypt, Decrypt
+// and the batch calls in m. It must run after the auth interceptor, so that a
+// tenant's key is labelled with the key_id the call resolves to, e.g.
+// "payments/pan", as in the audit log. Key IDs that keys does not hold (all
+// but the default key when keys is nil) are labelled metrics.OtherKey.
+func MetricsKeyUnaryInterceptor(m *metrics.Server, keys *kmslib.Keyring) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		elapsed := time.Since(start).Seconds()
+		// rateLimitItems' operations are metrics.OpEncrypt and OpDecrypt.
+		if op, items := rateLimitItems(req); op != "" {
+			for id := range items {
+				m.KeyLatency.WithLabelValues(metricsKey(ctx, keys, id), op).Observe(elapsed)
+			}
+		}
+		return resp, err
+	}
+}
+
+// MetricsStreamInterceptor is the streaming counterpart of
+// MetricsUnaryInterceptor: it counts open streams and records each stream's
+// code and lifetime when it ends.
+func MetricsStreamInterceptor(m *metrics.Server) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		method := metricsMethod(info.FullMethod)
+		active := m.ActiveStreams.WithLabelValues(method)
+		active.Inc()
+		start := time.Now()
+		err := handler(srv, ss)
+		active.Dec()
+		recordCall(m, method, time.Since(start).Seconds(), err)
+		return err
+	}
+}
+
+// metricsMethod is the method label: the full method without its leading
+// slash, e.g. "kms.KMS/Encrypt".
+func metricsMethod(fullMethod string) string {
+	return strings.TrimPrefix(fullMethod, "/")
+}
+
+func recordCall(m *metrics.Server, method string, seconds float64, err error) {
+	st := status.Convert(statusError(err))
+	m.Requests.WithLabelValues(method, st.Code().String()).Inc()
+	m.Latency.WithLabelValues(method).Observe(seconds)
+	// The auth interceptor refuses callers with Unauthenticated, or with
+	// PermissionDenied without an ErrorInfo; a key policy's PermissionDenied
+	// carries one.
+	if st.Code() == codes.Unauthenticated || (st.Code() == codes.PermissionDenied && errorReason(st) == "") {
+		m.AuthFailures.WithLabelValues(method, st.Code().String()).Inc()
+	}
+}
+
+// metricsKey returns the key_id label of a key ID as the caller named it,
+// bounded to the keys the server holds.
+func metricsKey(ctx context.Context, keys *kmslib.Keyring, id string) string {
+	id, err := scopedKeyID(ctx, id)
+	if err != nil {
+		return metrics.OtherKey
+	}
+	if id == "" || id == policy.DefaultKeyID {
+		return policy.DefaultKeyID
+	}
+	if keys != nil {
+		if _, err := keys.DescribeKey(id); err == nil {
+			return id
+		}
+	}
+	return metrics.OtherKey
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"kms/internal/auth"
+	"kms/internal/ratelimit"
+	"kms/internal/tenant"
+	kmsproto "kms/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Reasons of calls refused by the rate limiter.
+const (
+	ReasonRateLimited   = "RATE_LIMITED"
+	ReasonQuotaExceeded = "QUOTA_EXCEEDED"
+)
+
+// kmsServicePrefix selects the methods the rate limiter charges; Login,
+// health checks and KMSAdmin are not limited.
+const kmsServicePrefix = "/kms.KMS/"
+
+// RateLimitUnaryInterceptor charges each Encrypt, Decrypt and batch call to
+// l, one token per item, and refuses it with ResourceExhausted and a
+// google.rpc.RetryInfo when a limit is reached. It must run after the auth
+// interceptor, which identifies the principal and its tenant. A tenant's keys
+// are charged by their tenant-qualified IDs, "payments/pan". Items that fail
+// to decrypt are refunded to the daily quota.
+func RateLimitUnaryInterceptor(l *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		op, items := rateLimitItems(req)
+		if op == "" {
+			return handler(ctx, req)
+		}
+		t, principal := callerTenant(ctx), callerName(ctx)
+		scoped := make(map[string]int, len(items))
+		for id, n := range items {
+			scoped[tenant.KeyID(t, id)] += n
+		}
+		if err := l.Allow(t, principal, op, scoped); err != nil {
+			return nil, limitStatus(err)
+		}
+		resp, err := handler(ctx, req)
+		if op == ratelimit.Decrypt {
+			l.Refund(t, principal, failedDecrypts(resp, err, items))
+		}
+		return resp, err
+	}
+}
+
+// failedDecrypts counts the items of a Decrypt or BatchDecrypt call that
+// were not decrypted.
+func failedDecrypts(resp interface{}, err error, items map[string]int) int {
+	if batch, ok := resp.(*kmsproto.BatchDecryptResponse); ok && err == nil {
+		n := 0
+		for _, r := range batch.GetResults() {
+			if r.GetStatus().GetCode() != int32(codes.OK) {
+				n++
+			}
+		}
+		return n
+	}
+	if err == nil {
+		return 0
+	}
+	n := 0
+	for _, c := range items {
+		n += c
+	}
+	return n
+}
+
+// RateLimitStreamInterceptor throttles EncryptStream and DecryptStream: each
+// received item waits for its tokens, which slows the stream down through
+// flow control instead of failing items. A spent daily quota ends the stream.
+// Items that are not decrypted, including those the stream ends before, are
+// refunded to the daily quota when the stream ends.
+func RateLimitStreamInterceptor(l *ratelimit.Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, kmsServicePrefix) {
+			return handler(srv, ss)
+		}
+		ctx := ss.Context()
+		s := &limitedStream{ServerStream: ss, limiter: l, principal: callerName(ctx), tenant: callerTenant(ctx)}
+		err := handler(srv, s)
+		l.Refund(s.tenant, s.principal, int(s.decrypts.Load()-s.decrypted.Load()))
+		return err
+	}
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	limiter   *ratelimit.Limiter
+	principal string
+	tenant    string
+
+	// decrypts counts the items charged to the decrypt quota, decrypted
+	// those answered with a plaintext.
+	decrypts, decrypted atomic.Int64
+}
+
+func (s *limitedStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if resp, ok := m.(*kmsproto.DecryptStreamResponse); ok && err == nil && resp.GetStatus().GetCode() == int32(codes.OK) {
+		s.decrypted.Add(1)
+	}
+	return err
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	var op, keyID string
+	switch req := m.(type) {
+	case *kmsproto.EncryptStreamRequest:
+		op, keyID = ratelimit.Encrypt, req.GetKeyId()
+	case *kmsproto.DecryptStreamRequest:
+		op, keyID = ratelimit.Decrypt, req.GetKeyId()
+	default:
+		return nil
+	}
+	if err := s.limiter.Wait(s.Context(), s.tenant, s.principal, op, tenant.KeyID(s.tenant, keyID)); err != nil {
+		return limitStatus(err)
+	}
+	if op == ratelimit.Decrypt {
+		s.decrypts.Add(1)
+	}
+	return nil
+}
+
+// rateLimitItems returns the operation of a KMS request and its item count
+// per key ID, or "" for a request that is not charged.
+func rateLimitItems(req interface{}) (string, map[string]int) {
+	switch r := req.(type) {
+	case *kmsproto.EncryptRequest:
+		return ratelimit.Encrypt, map[string]int{r.GetKeyId(): 1}
+	case *kmsproto.DecryptRequest:
+		return ratelimit.Decrypt, map[string]int{r.GetKeyId(): 1}
+	case *kmsproto.BatchEncryptRequest:
+		items := map[string]int{}
+		for _, it := range r.GetItems() {
+			items[it.GetKeyId()]++
+		}
+		return ratelimit.Encrypt, items
+	case *kmsproto.BatchDecryptRequest:
+		items := map[string]int{}
+		for _, it := range r.GetItems() {
+			items[it.GetKeyId()]++
+		}
+		return ratelimit.Decrypt, items
+	}
+	return "", nil
+}
+
+// callerName identifies the caller for the rate limiter and the idempotency
+// cache: the authenticated principal or, with auth disabled, the client's IP
+// address.
+func callerName(ctx context.Context) string {
+	if p, ok := auth.PrincipalFromContext(ctx); ok && p.Name != "" {
+		return p.Name
+	}
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		addr := pr.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "peer:" + addr
+	}
+	return "peer:unknown"
+}
+
+// callerTenant is the tenant of the authenticated principal, if any.
+func callerTenant(ctx context.Context) string {
+	p, _ := auth.PrincipalFromContext(ctx)
+	return p.Tenant
+}
+
+// limitStatus converts a rate limiter error to ResourceExhausted with an
+// ErrorInfo and, when waiting helps, a RetryInfo.
+func limitStatus(err error) error {
+	var le *ratelimit.LimitError
+	if !errors.As(err, &le) {
+		return statusError(err)
+	}
+	reason := ReasonRateLimited
+	if errors.Is(err, ratelimit.ErrQuotaExceeded) {
+		reason = ReasonQuotaExceeded
+	}
+	st := status.New(codes.ResourceExhausted, err.Error())
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain}
+	if le.RetryAfter > 0 {
+		if withDetails, derr := st.WithDetails(info, &errdetails.RetryInfo{RetryDelay: durationpb.New(le.RetryAfter)}); derr == nil {
+			return withDetails.Err()
+		}
+	}
+	if withInfo, derr := st.WithDetails(info); derr == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
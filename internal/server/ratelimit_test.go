@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"kms/internal/ratelimit"
+	"kms/internal/server"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// quotaLeft returns how many more items principal pay-api of payments may
+// decrypt today, up to max, by charging them one at a time.
+func quotaLeft(l *ratelimit.Limiter, max int) int {
+	for n := 0; n < max; n++ {
+		if err := l.Allow("payments", "pay-api", ratelimit.Decrypt, map[string]int{"pan": 1}); err != nil {
+			return n
+		}
+	}
+	return max
+}
+
+func itemStatus(code codes.Code) *kmsproto.ItemStatus {
+	return &kmsproto.ItemStatus{Code: int32(code)}
+}
+
+// Decrypt items that fail are refunded to the daily quota; the items of a
+// call that fails as a whole all are.
+func TestRateLimitRefundsFailedDecrypts(t *testing.T) {
+	batch := &kmsproto.BatchDecryptRequest{Items: make([]*kmsproto.DecryptRequest, 4)}
+	for i := range batch.Items {
+		batch.Items[i] = &kmsproto.DecryptRequest{KeyId: "pan"}
+	}
+	for _, tc := range []struct {
+		name string
+		req  interface{}
+		resp interface{}
+		err  error
+		left int // of the quota of 5
+	}{
+		{name: "decrypt", req: &kmsproto.DecryptRequest{KeyId: "pan"}, resp: &kmsproto.DecryptResponse{}, left: 4},
+		{name: "failed decrypt", req: &kmsproto.DecryptRequest{KeyId: "pan"}, err: status.Error(codes.InvalidArgument, "bad ciphertext"), left: 5},
+		{name: "batch", req: batch, resp: &kmsproto.BatchDecryptResponse{Results: []*kmsproto.BatchDecryptResult{
+			{Status: itemStatus(codes.OK)}, {Status: itemStatus(codes.OK)}, {Status: itemStatus(codes.OK)}, {Status: itemStatus(codes.OK)},
+		}}, left: 1},
+		{name: "batch with failed items", req: batch, resp: &kmsproto.BatchDecryptResponse{Results: []*kmsproto.BatchDecryptResult{
+			{Status: itemStatus(codes.OK)}, {Status: itemStatus(codes.InvalidArgument)}, {Status: itemStatus(codes.OK)}, {Status: itemStatus(codes.NotFound)},
+		}}, left: 3},
+		{name: "failed batch", req: batch, err: status.Error(codes.Unavailable, "sealed"), left: 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := ratelimit.New(ratelimit.Config{DailyDecryptQuota: 5})
+			intercept := server.RateLimitUnaryInterceptor(l)
+			_, err := intercept(as("payments", "pay-api"), tc.req, &grpc.UnaryServerInfo{FullMethod: "/kms.KMS/Decrypt"}, func(context.Context, interface{}) (interface{}, error) {
+				return tc.resp, tc.err
+			})
+			if err != tc.err {
+				t.Fatalf("call = %v, want the handler's %v", err, tc.err)
+			}
+			if left := quotaLeft(l, 5); left != tc.left {
+				t.Errorf("%d items left of the quota, want %d", left, tc.left)
+			}
+		})
+	}
+}
+
+// fakeDecryptStream delivers reqs to the handler and fails SendMsg after
+// sendOK responses when sendOK >= 0.
+type fakeDecryptStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	reqs   []*kmsproto.DecryptStreamRequest
+	sendOK int
+	sent   int
+}
+
+func (s *fakeDecryptStream) Context() context.Context { return s.ctx }
+
+func (s *fakeDecryptStream) RecvMsg(m interface{}) error {
+	if len(s.reqs) == 0 {
+		return io.EOF
+	}
+	*m.(*kmsproto.DecryptStreamRequest) = kmsproto.DecryptStreamRequest{Id: s.reqs[0].Id, KeyId: s.reqs[0].KeyId}
+	s.reqs = s.reqs[1:]
+	return nil
+}
+
+func (s *fakeDecryptStream) SendMsg(m interface{}) error {
+	if s.sendOK >= 0 && s.sent == s.sendOK {
+		return status.Error(codes.Canceled, "client went away")
+	}
+	s.sent++
+	return nil
+}
+
+// Stream items charged to the quota but not answered with a plaintext are
+// refunded when the stream ends: failed items, items the handler ends the
+// stream before answering, and answers the client never received.
+func TestRateLimitStreamRefunds(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		received int          // items the handler reads
+		answers  []codes.Code // responses it then sends, stopping at the first send error
+		sendOK   int          // sends that succeed, -1 for all
+		left     int          // of the quota of 5
+	}{
+		{name: "all decrypted", received: 3, answers: []codes.Code{codes.OK, codes.OK, codes.OK}, sendOK: -1, left: 2},
+		{name: "failed item", received: 3, answers: []codes.Code{codes.OK, codes.InvalidArgument, codes.OK}, sendOK: -1, left: 3},
+		{name: "ended before answering", received: 4, answers: []codes.Code{codes.OK}, sendOK: -1, left: 4},
+		{name: "answer not delivered", received: 3, answers: []codes.Code{codes.OK, codes.OK, codes.OK}, sendOK: 1, left: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := ratelimit.New(ratelimit.Config{DailyDecryptQuota: 5})
+			intercept := server.RateLimitStreamInterceptor(l)
+			ss := &fakeDecryptStream{ctx: as("payments", "pay-api"), sendOK: tc.sendOK}
+			for i := 0; i < tc.received; i++ {
+				ss.reqs = append(ss.reqs, &kmsproto.DecryptStreamRequest{KeyId: "pan"})
+			}
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				for i := 0; i < tc.received; i++ {
+					if err := stream.RecvMsg(&kmsproto.DecryptStreamRequest{}); err != nil {
+						return err
+					}
+				}
+				for _, code := range tc.answers {
+					if err := stream.SendMsg(&kmsproto.DecryptStreamResponse{Status: itemStatus(code)}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			err := intercept(nil, ss, &grpc.StreamServerInfo{FullMethod: "/kms.KMS/DecryptStream"}, handler)
+			if err != nil && status.Code(err) != codes.Canceled {
+				t.Fatal(err)
+			}
+			if left := quotaLeft(l, 5); left != tc.left {
+				t.Errorf("%d items left of the quota, want %d", left, tc.left)
+			}
+		})
+	}
+}
@@ -2,31 +2,83 @@ package server
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"kms/internal/auth"
+	"kms/internal/idempotency"
 	kmslib "kms/internal/kms"
+	"kms/internal/policy"
+	"kms/internal/tenant"
 	kmsproto "kms/proto"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 // KMSServer implements the gRPC KMS service.
 type KMSServer struct {
 	kmsproto.UnimplementedKMSServer
-	manager kmslib.Manager
+	manager       kmslib.Manager
+	keys          *kmslib.Keyring // nil without a key store
+	maxBatchItems atomic.Int64
+	policy        atomic.Pointer[policy.Policy]
+	idempotency   atomic.Pointer[idempotency.Cache] // nil ignores idempotency keys
 }
 
 func NewKMSServer(mgr kmslib.Manager) *KMSServer {
-	return &KMSServer{manager: mgr}
+	return NewKMSServerWithKeyring(mgr, nil)
+}
+
+// NewKMSServerWithKeyring also serves the named keys of keys, selected by
+// the key_id of a request. Requests without key_id use mgr.
+func NewKMSServerWithKeyring(mgr kmslib.Manager, keys *kmslib.Keyring) *KMSServer {
+	s := &KMSServer{manager: mgr, keys: keys}
+	s.maxBatchItems.Store(MaxBatchItems)
+	s.policy.Store(policy.Default())
+	return s
+}
+
+// SetPolicy replaces the size limits and content validators checked before
+// every encryption and decryption; nil restores policy.Default(). It may be
+// called while the server runs.
+func (s *KMSServer) SetPolicy(p *policy.Policy) {
+	if p == nil {
+		p = policy.Default()
+	}
+	s.policy.Store(p)
+}
+
+// SetIdempotencyCache stores the results of Encrypt and BatchEncrypt items
+// that carry an idempotency key in c; nil ignores the keys. It may be called
+// while the server runs, which forgets the stored results.
+func (s *KMSServer) SetIdempotencyCache(c *idempotency.Cache) {
+	s.idempotency.Store(c)
+}
+
+// SetMaxBatchItems lowers the per-call item limit of BatchEncrypt and
+// BatchDecrypt; n outside 1..MaxBatchItems restores MaxBatchItems. It may be
+// called while the server runs.
+func (s *KMSServer) SetMaxBatchItems(n int) {
+	if n <= 0 || n > MaxBatchItems {
+		n = MaxBatchItems
+	}
+	s.maxBatchItems.Store(int64(n))
 }
 
 func (s *KMSServer) Encrypt(ctx context.Context, req *kmsproto.EncryptRequest) (*kmsproto.EncryptResponse, error) {
-	ct, nonce, err := s.manager.Encrypt(req.GetPlaintext())
+	ct, nonce, err := s.encryptOnce(ctx, req.GetIdempotencyKey(), req.GetKeyId(), req.GetPlaintext())
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &kmsproto.EncryptResponse{
 		Ciphertext: ct,
@@ -35,38 +87,270 @@ func (s *KMSServer) Encrypt(ctx context.Context, req *kmsproto.EncryptRequest) (
 }
 
 func (s *KMSServer) Decrypt(ctx context.Context, req *kmsproto.DecryptRequest) (*kmsproto.DecryptResponse, error) {
-	pt, err := s.manager.Decrypt(req.GetCiphertext(), req.GetNonce())
+	pt, err := s.decrypt(ctx, req.GetKeyId(), req.GetCiphertext(), req.GetNonce())
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &kmsproto.DecryptResponse{
 		Plaintext: pt,
 	}, nil
 }
 
+// encrypt uses the named key keyID, or the Manager's key when keyID is empty,
+// once the plaintext passes the key's policy. A tenant's caller uses its
+// tenant's keys only, see scopedKeyID. The key backend or keyring call
+// is traced as a child of ctx's span.
+func (s *KMSServer) encrypt(ctx context.Context, keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	if keyID, err = scopedKeyID(ctx, keyID); err != nil {
+		return nil, nil, err
+	}
+	if err := s.policy.Load().CheckPlaintext(keyID, plaintext); err != nil {
+		return nil, nil, err
+	}
+	if keyID == "" {
+		_, span := startKeySpan(ctx, "kms.Manager/Encrypt", keyID)
+		defer endKeySpan(span, &err)
+		return s.manager.Encrypt(plaintext)
+	}
+	if s.keys == nil {
+		return nil, nil, errNoKeyStore(keyID)
+	}
+	_, span := startKeySpan(ctx, "kms.Keyring/Encrypt", keyID)
+	defer endKeySpan(span, &err)
+	return s.keys.Encrypt(keyID, plaintext)
+}
+
+// encryptOnce is encrypt for a request with an idempotency key: a repeated
+// request of the same caller gets the result of the first. The caller is its
+// tenant and principal, or its IP address with auth disabled (see
+// callerName), so no one sees another's results.
+func (s *KMSServer) encryptOnce(ctx context.Context, idemKey, keyID string, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	cache := s.idempotency.Load()
+	if idemKey == "" || cache == nil {
+		return s.encrypt(ctx, keyID, plaintext)
+	}
+	scope := callerTenant(ctx) + "/" + callerName(ctx)
+	res, replayed, err := cache.Do(ctx, scope, idemKey, keyID, plaintext, func() (idempotency.Result, error) {
+		ct, nonce, err := s.encrypt(ctx, keyID, plaintext)
+		return idempotency.Result{Ciphertext: ct, Nonce: nonce}, err
+	})
+	if replayed {
+		trace.SpanFromContext(ctx).AddEvent("kms.idempotent_replay")
+	}
+	return res.Ciphertext, res.Nonce, err
+}
+
+func (s *KMSServer) decrypt(ctx context.Context, keyID string, ciphertext, nonce []byte) (plaintext []byte, err error) {
+	if keyID, err = scopedKeyID(ctx, keyID); err != nil {
+		return nil, err
+	}
+	if err := s.policy.Load().CheckCiphertext(keyID, ciphertext); err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		_, span := startKeySpan(ctx, "kms.Manager/Decrypt", keyID)
+		defer endKeySpan(span, &err)
+		return s.manager.Decrypt(ciphertext, nonce)
+	}
+	if s.keys == nil {
+		return nil, errNoKeyStore(keyID)
+	}
+	_, span := startKeySpan(ctx, "kms.Keyring/Decrypt", keyID)
+	defer endKeySpan(span, &err)
+	return s.keys.Decrypt(keyID, ciphertext, nonce)
+}
+
+// tracer names the spans of the key backend and keyring calls.
+var tracer = otel.Tracer("kms/internal/server")
+
+func startKeySpan(ctx context.Context, name, keyID string) (context.Context, trace.Span) {
+	if keyID == "" {
+		keyID = policy.DefaultKeyID
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String("kms.key_id", keyID)))
+}
+
+// endKeySpan records the outcome, with the error's reason but not its
+// message, which may quote the backend.
+func endKeySpan(span trace.Span, err *error) {
+	if *err != nil {
+		st := status.Convert(statusError(*err))
+		span.SetAttributes(attribute.String("kms.reason", errorReason(st)))
+		span.SetStatus(otelcodes.Error, st.Code().String())
+	}
+	span.End()
+}
+
+// scopedKeyID qualifies the key_id of a call by the caller's tenant, so that
+// "pan" is "payments/pan" and no key_id reaches another tenant's keys. A
+// caller outside any tenant may not name a tenant's key.
+func scopedKeyID(ctx context.Context, keyID string) (string, error) {
+	if strings.Contains(keyID, tenant.Separator) {
+		return "", fmt.Errorf("%w: %q (name keys without a tenant prefix)", kmslib.ErrInvalidKeyID, keyID)
+	}
+	p, _ := auth.PrincipalFromContext(ctx)
+	return tenant.KeyID(p.Tenant, keyID), nil
+}
+
+func errNoKeyStore(keyID string) error {
+	return fmt.Errorf("%w: %q (no key store is configured)", kmslib.ErrKeyNotFound, keyID)
+}
+
 // Run starts the gRPC server on the given address, e.g. ":50051".
 // You can supply optional unary interceptors (e.g., auth).
 // jwtCfg is used by the Auth service to issue tokens.
 func Run(addr string, mgr kmslib.Manager, jwtCfg auth.JWTConfig, interceptors ...grpc.UnaryServerInterceptor) error {
-	lis, err := net.Listen("tcp", addr)
+	return RunWithInterceptors(addr, mgr, jwtCfg, interceptors, nil)
+}
+
+// RunWithInterceptors is like Run, with stream interceptors for the streaming
+// RPCs (EncryptStream/DecryptStream) as well. Extra server options, such as
+// TLS credentials, are passed through to grpc.NewServer.
+func RunWithInterceptors(addr string, mgr kmslib.Manager, jwtCfg auth.JWTConfig, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor, extra ...grpc.ServerOption) error {
+	return Serve(context.Background(), Options{
+		Addr:               addr,
+		Manager:            mgr,
+		JWT:                jwtCfg,
+		UnaryInterceptors:  unary,
+		StreamInterceptors: stream,
+		ServerOptions:      extra,
+	})
+}
+
+// DefaultShutdownTimeout bounds how long Serve waits for in-flight RPCs to
+// finish after its context is cancelled.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Options configures Serve.
+type Options struct {
+	Addr    string // e.g. ":50051"
+	Manager kmslib.Manager
+	JWT     auth.JWTConfig // used by the Auth service to issue tokens
+
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+	ServerOptions      []grpc.ServerOption // e.g. TLS credentials
+
+	// DrainDelay is how long the server keeps serving after it reports
+	// NOT_SERVING, so load balancers stop sending new work before draining
+	// starts. Zero starts draining at once.
+	DrainDelay time.Duration
+
+	// ShutdownTimeout defaults to DefaultShutdownTimeout. RPCs still running
+	// when it expires, such as long streams, are cancelled.
+	ShutdownTimeout time.Duration
+
+	// HealthCheckInterval and HealthCheckTimeout configure the self-test;
+	// zero reads KMS_HEALTH_CHECK_INTERVAL / KMS_HEALTH_CHECK_TIMEOUT, then
+	// falls back to the defaults.
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// MaxBatchItems lowers the per-call item limit of BatchEncrypt and
+	// BatchDecrypt; zero or more than MaxBatchItems means MaxBatchItems.
+	MaxBatchItems int
+
+	// Policy sets the size limits and content validators; nil means
+	// policy.Default().
+	Policy *policy.Policy
+
+	// Keys, if set, serves named keys and is managed through KMSAdmin.
+	Keys *kmslib.Keyring
+
+	// The fields below let the caller change settings while the server
+	// runs. KMSServer, if set, is served instead of
+	// NewKMSServerWithKeyring(Manager, Keys) (MaxBatchItems and Policy are then
+	// ignored; use its setters). Authenticator, if set, issues Auth/Login
+	// tokens instead of JWT; the caller installs its interceptors. Admin
+	// backs the KMSAdmin calls that act on the whole server.
+	KMSServer     *KMSServer
+	Authenticator *auth.Authenticator
+	Admin         AdminHooks
+}
+
+// Serve runs the gRPC server until ctx is cancelled, then shuts it down
+// gracefully: health checks turn NOT_SERVING, new connections are refused
+// after DrainDelay, and in-flight RPCs get up to ShutdownTimeout to finish.
+// It returns nil after a graceful shutdown. Serve does not close the
+// Manager; the caller does that once Serve returns.
+func Serve(ctx context.Context, o Options) error {
+	lis, err := net.Listen("tcp", o.Addr)
 	if err != nil {
 		return err
 	}
 
-	opts := []grpc.ServerOption{}
-	if len(interceptors) > 0 {
-		opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
+	opts := append([]grpc.ServerOption{}, o.ServerOptions...)
+	if len(o.UnaryInterceptors) > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(o.UnaryInterceptors...))
+	}
+	if len(o.StreamInterceptors) > 0 {
+		opts = append(opts, grpc.ChainStreamInterceptor(o.StreamInterceptors...))
 	}
 
 	grpcServer := grpc.NewServer(opts...)
-	kmsproto.RegisterKMSServer(grpcServer, NewKMSServer(mgr))
-	kmsproto.RegisterAuthServer(grpcServer, NewAuthServer(jwtCfg))
-	
+	ks := o.KMSServer
+	if ks == nil {
+		ks = NewKMSServerWithKeyring(o.Manager, o.Keys)
+		ks.SetMaxBatchItems(o.MaxBatchItems)
+		ks.SetPolicy(o.Policy)
+	}
+	authn := o.Authenticator
+	if authn == nil {
+		authn = auth.NewAuthenticator(o.JWT)
+	}
+	kmsproto.RegisterKMSServer(grpcServer, ks)
+	kmsproto.RegisterAuthServer(grpcServer, newAuthServer(authn))
+	kmsproto.RegisterKMSAdminServer(grpcServer, NewAdminServer(o.Admin, o.Keys))
+
+	// grpc.health.v1.Health, driven by a periodic Manager self-test.
+	hcInterval, hcTimeout := o.HealthCheckInterval, o.HealthCheckTimeout
+	if hcInterval <= 0 {
+		hcInterval = getenvDuration("KMS_HEALTH_CHECK_INTERVAL")
+	}
+	if hcTimeout <= 0 {
+		hcTimeout = getenvDuration("KMS_HEALTH_CHECK_TIMEOUT")
+	}
+	hc := NewHealthChecker(o.Manager, hcInterval, hcTimeout)
+	hc.Register(grpcServer)
+	hc.Start()
+	defer hc.Stop()
+
 	// Enable gRPC reflection for tools like grpcurl
 	reflection.Register(grpcServer)
 
-	log.Printf("KMS gRPC server listening on %s", addr)
-	return grpcServer.Serve(lis)
-}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- grpcServer.Serve(lis) }()
+	slog.Info("KMS gRPC server listening", "addr", o.Addr)
 
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
 
+	timeout := o.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	slog.Info("KMS gRPC server: shutting down", "drain_delay", o.DrainDelay, "timeout", timeout)
+	hc.Stop()
+	if o.DrainDelay > 0 {
+		time.Sleep(o.DrainDelay)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		slog.Info("KMS gRPC server: all RPCs finished")
+	case <-time.After(timeout):
+		slog.Warn("KMS gRPC server: RPCs still running; closing connections", "timeout", timeout)
+		grpcServer.Stop()
+		<-stopped
+	}
+	<-serveErr // grpc.ErrServerStopped is expected here
+	return nil
+}
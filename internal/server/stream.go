@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+)
+
+// streamInFlight bounds how many received items may wait for a worker or for
+// their response to be sent. When it is reached the server stops reading, so
+// HTTP/2 flow control pushes back on a client that sends faster than the
+// Manager encrypts or than it reads responses.
+const streamInFlight = 256
+
+// EncryptStream encrypts each received item and streams back a response with
+// the same id. Responses may be reordered. A failed item is answered with its
+// status and the stream continues; the stream ends when the client closes its
+// side and all responses are sent.
+func (s *KMSServer) EncryptStream(stream grpc.BidiStreamingServer[kmsproto.EncryptStreamRequest, kmsproto.EncryptStreamResponse]) error {
+	return serveStream(stream, func(req *kmsproto.EncryptStreamRequest) *kmsproto.EncryptStreamResponse {
+		ct, nonce, err := s.encrypt(stream.Context(), req.GetKeyId(), req.GetPlaintext())
+		if err != nil {
+			return &kmsproto.EncryptStreamResponse{Id: req.GetId(), Status: itemStatus(err)}
+		}
+		return &kmsproto.EncryptStreamResponse{Id: req.GetId(), Ciphertext: ct, Nonce: nonce}
+	})
+}
+
+// DecryptStream decrypts each received item, with the same semantics as
+// EncryptStream.
+func (s *KMSServer) DecryptStream(stream grpc.BidiStreamingServer[kmsproto.DecryptStreamRequest, kmsproto.DecryptStreamResponse]) error {
+	return serveStream(stream, func(req *kmsproto.DecryptStreamRequest) *kmsproto.DecryptStreamResponse {
+		pt, err := s.decrypt(stream.Context(), req.GetKeyId(), req.GetCiphertext(), req.GetNonce())
+		if err != nil {
+			return &kmsproto.DecryptStreamResponse{Id: req.GetId(), Status: itemStatus(err)}
+		}
+		return &kmsproto.DecryptStreamResponse{Id: req.GetId(), Plaintext: pt}
+	})
+}
+
+// serveStream runs a receive -> process -> send pipeline over a bidi stream:
+// one reader, one worker per CPU and one sender, joined by bounded channels.
+func serveStream[Req, Resp any](stream grpc.BidiStreamingServer[Req, Resp], process func(*Req) *Resp) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	in := make(chan *Req, streamInFlight)
+	out := make(chan *Resp, streamInFlight)
+
+	var recvErr error
+	go func() {
+		defer close(in)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					recvErr = err
+				}
+				return
+			}
+			select {
+			case in <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range in {
+				select {
+				case out <- process(req):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	for resp := range out {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	// out is closed only after the reader has closed in, so recvErr is set.
+	return recvErr
+}
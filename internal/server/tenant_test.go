@@ -0,0 +1,172 @@
+package server_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kms/internal/auth"
+	kmslib "kms/internal/kms"
+	"kms/internal/policy"
+	"kms/internal/ratelimit"
+	"kms/internal/server"
+	kmsproto "kms/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTenantServer returns a server whose key store holds the keys "pan" and
+// "default" of tenant payments and "pan" of tenant cards.
+func newTenantServer(t *testing.T) *server.KMSServer {
+	t.Helper()
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyPath := filepath.Join(dir, "master.key")
+	if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := kmslib.NewManagerFromFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := kmslib.OpenKeyring(filepath.Join(dir, "keys.json"), mgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"payments/pan", "payments/default", "cards/pan"} {
+		if _, err := keys.CreateKey(id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return server.NewKMSServerWithKeyring(mgr, keys)
+}
+
+// as returns a context authenticated as principal name of tenant.
+func as(tenant, name string) context.Context {
+	return auth.WithPrincipal(context.Background(), auth.Principal{Name: name, Method: "jwt", Tenant: tenant})
+}
+
+const testPAN = "4111111111111111"
+
+func TestTenantKeyIDWithSeparator(t *testing.T) {
+	srv := newTenantServer(t)
+	ct, err := srv.Encrypt(as("cards", "cards-etl"), &kmsproto.EncryptRequest{Plaintext: []byte(testPAN), KeyId: "pan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		ctx   context.Context
+		keyID string
+	}{
+		{name: "another tenant's key", ctx: as("payments", "pay-api"), keyID: "cards/pan"},
+		{name: "own tenant's key, qualified", ctx: as("payments", "pay-api"), keyID: "payments/pan"},
+		{name: "path", ctx: as("payments", "pay-api"), keyID: "../cards/pan"},
+		{name: "caller outside any tenant", ctx: as("", "ops"), keyID: "cards/pan"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := srv.Encrypt(tc.ctx, &kmsproto.EncryptRequest{Plaintext: []byte(testPAN), KeyId: tc.keyID})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Encrypt with key_id %q = %v, want InvalidArgument", tc.keyID, err)
+			}
+			_, err = srv.Decrypt(tc.ctx, &kmsproto.DecryptRequest{Ciphertext: ct.Ciphertext, Nonce: ct.Nonce, KeyId: tc.keyID})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Decrypt with key_id %q = %v, want InvalidArgument", tc.keyID, err)
+			}
+		})
+	}
+}
+
+// Both tenants have a key "pan"; a ciphertext of one does not decrypt with
+// the other's, and the error does not name the other tenant's key.
+func TestTenantDecryptOtherTenant(t *testing.T) {
+	srv := newTenantServer(t)
+	ct, err := srv.Encrypt(as("cards", "cards-etl"), &kmsproto.EncryptRequest{Plaintext: []byte(testPAN), KeyId: "pan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyID := range []string{"pan", ""} {
+		_, err := srv.Decrypt(as("payments", "pay-api"), &kmsproto.DecryptRequest{Ciphertext: ct.Ciphertext, Nonce: ct.Nonce, KeyId: keyID})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Decrypt of cards' ciphertext by payments with key_id %q = %v, want InvalidArgument", keyID, err)
+		}
+		if strings.Contains(status.Convert(err).Message(), "cards") {
+			t.Errorf("error %q names the other tenant's key", status.Convert(err).Message())
+		}
+	}
+	pt, err := srv.Decrypt(as("cards", "cards-etl"), &kmsproto.DecryptRequest{Ciphertext: ct.Ciphertext, Nonce: ct.Nonce, KeyId: "pan"})
+	if err != nil || string(pt.Plaintext) != testPAN {
+		t.Errorf("Decrypt by cards = %q, %v", pt.GetPlaintext(), err)
+	}
+}
+
+// Per-key policies and rate limits are configured by qualified key ID, so
+// "payments/pan" does not apply to the key "pan" of cards.
+func TestTenantPolicyAndRateLimit(t *testing.T) {
+	srv := newTenantServer(t)
+	srv.SetPolicy(&policy.Policy{
+		Default: policy.Limits{MaxPlaintextBytes: policy.DefaultMaxPlaintextBytes},
+		Keys:    map[string]policy.Limits{"payments/pan": {Validator: "pan"}},
+	})
+	limiter := ratelimit.New(ratelimit.Config{Keys: map[string]ratelimit.Rule{"payments/pan": {Rate: 0.001, Burst: 1}}})
+	intercept := server.RateLimitUnaryInterceptor(limiter)
+	encrypt := func(ctx context.Context, plaintext string) error {
+		req := &kmsproto.EncryptRequest{Plaintext: []byte(plaintext), KeyId: "pan"}
+		_, err := intercept(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/kms.KMS/Encrypt"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.Encrypt(ctx, req.(*kmsproto.EncryptRequest))
+		})
+		return err
+	}
+
+	payments, cards := as("payments", "pay-api"), as("cards", "cards-etl")
+	if err := encrypt(payments, "not a pan"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("payments encrypting a non-PAN = %v, want the pan validator to refuse it", err)
+	}
+	if err := encrypt(payments, testPAN); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("payments exceeding its key's burst = %v, want ResourceExhausted", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := encrypt(cards, "not a pan"); err != nil {
+			t.Fatalf("cards call %d = %v, want neither payments' policy nor its bucket to apply", i+1, err)
+		}
+	}
+}
+
+func TestTenantDescribeCiphertext(t *testing.T) {
+	srv := newTenantServer(t)
+	ct, err := srv.Encrypt(as("cards", "cards-etl"), &kmsproto.EncryptRequest{Plaintext: []byte(testPAN), KeyId: "pan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name        string
+		ctx         context.Context
+		keyID       string
+		version     uint32
+		otherTenant bool
+	}{
+		{name: "own tenant", ctx: as("cards", "cards-etl"), keyID: "pan", version: 1},
+		{name: "other tenant", ctx: as("payments", "pay-api"), otherTenant: true},
+		{name: "outside any tenant", ctx: as("", "ops"), keyID: "cards/pan", version: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := srv.DescribeCiphertext(tc.ctx, &kmsproto.DescribeCiphertextRequest{Ciphertext: ct.Ciphertext, Nonce: ct.Nonce})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.KeyId != tc.keyID || resp.KeyVersion != tc.version || resp.OtherTenant != tc.otherTenant {
+				t.Errorf("key_id %q, version %d, other_tenant %v; want %q, %d, %v", resp.KeyId, resp.KeyVersion, resp.OtherTenant, tc.keyID, tc.version, tc.otherTenant)
+			}
+			if tc.otherTenant && strings.Contains(resp.String(), "cards") {
+				t.Errorf("response %v names the other tenant's key", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Package tenant scopes keys to tenants: business units served by one KMS.
+// A tenant's named keys live in the shared key store under IDs qualified by
+// the tenant name, "payments/pan", and its callers name them without the
+// prefix, so no request can reach another tenant's keys.
+package tenant
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Separator joins a tenant name and a key ID.
+const Separator = "/"
+
+// namePattern is the form of a tenant name, that of a key ID.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Tenant is a namespace of keys with the principals that belong to it.
+type Tenant struct {
+	// Principals are the JWT subjects and certificate principals of the
+	// tenant; a trailing "*" matches by prefix.
+	Principals []string
+}
+
+// Set is the configured tenants by name.
+type Set map[string]Tenant
+
+// CheckName reports whether name can name a tenant.
+func CheckName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("tenant name %q: use 1-64 letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// Has reports whether principal belongs to t.
+func (t Tenant) Has(principal string) bool {
+	_, ok := match(principal, t.Principals)
+	return ok
+}
+
+// Resolve returns the tenant whose Principals match principal. An exact
+// name beats a prefix and the longest prefix wins; among equal matches the
+// first tenant by name does.
+func (s Set) Resolve(principal string) (string, bool) {
+	best, found := "", -1
+	for name, t := range s {
+		n, ok := match(principal, t.Principals)
+		if ok && (n > found || n == found && name < best) {
+			best, found = name, n
+		}
+	}
+	return best, found >= 0
+}
+
+// match returns how specific the best pattern matching name is: the length
+// of a matching prefix, or more than any prefix for an exact name.
+func match(name string, patterns []string) (int, bool) {
+	best := -1
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) && len(prefix) > best {
+				best = len(prefix)
+			}
+		} else if name == p {
+			return len(name) + 1, true
+		}
+	}
+	return best, best >= 0
+}
+
+// KeyID qualifies the key_id of a tenant's request: "pan" becomes
+// "payments/pan" and an empty key_id the tenant's default key,
+// "payments/default". Without a tenant the key_id is returned as is.
+func KeyID(tenant, keyID string) string {
+	if tenant == "" {
+		return keyID
+	}
+	if keyID == "" {
+		keyID = DefaultKeyID
+	}
+	return tenant + Separator + keyID
+}
+
+// DefaultKeyID is the key a tenant's requests without a key_id use,
+// qualified by the tenant name. It is a named key, created like any other.
+const DefaultKeyID = "default"
+
+// Split returns the tenant and key ID of a qualified key ID; tenant is empty
+// for a key outside any tenant.
+func Split(qualified string) (tenant, keyID string) {
+	if t, id, ok := strings.Cut(qualified, Separator); ok {
+		return t, id
+	}
+	return "", qualified
+}
@@ -0,0 +1,363 @@
+// Package tlsconfig builds the TLS settings shared by the KMS gRPC server and
+// its clients (etl-worker, kms-http-server, test-client).
+//
+// Certificates, keys and CA bundles are read from PEM files and reloaded when
+// the files change, so certificates can be rotated without a restart.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultReloadInterval is how often, at most, the files are checked for
+// changes. Checks happen during handshakes, so an idle server does no work.
+const DefaultReloadInterval = 30 * time.Second
+
+// ClientAuth modes for ServerConfig.ClientAuth.
+const (
+	ClientAuthNone     = "none"     // no client certificate is requested
+	ClientAuthOptional = "optional" // a presented certificate must verify
+	ClientAuthRequire  = "require"  // mTLS: a verified certificate is required
+)
+
+// ServerConfig describes the server side of TLS. An empty CertFile disables
+// TLS.
+type ServerConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is a PEM bundle of CAs trusted to sign client
+	// certificates. Setting it enables mTLS.
+	ClientCAFile string
+
+	// ClientAuth is one of the ClientAuth* modes. It defaults to
+	// ClientAuthRequire when ClientCAFile is set, ClientAuthNone otherwise.
+	ClientAuth string
+
+	// ReloadInterval defaults to DefaultReloadInterval; negative disables
+	// reloading.
+	ReloadInterval time.Duration
+}
+
+// Enabled reports whether TLS is configured.
+func (c ServerConfig) Enabled() bool { return c.CertFile != "" }
+
+// ClientAuthMode returns ClientAuth with its default applied.
+func (c ServerConfig) ClientAuthMode() string {
+	switch {
+	case c.ClientAuth != "":
+		return c.ClientAuth
+	case c.ClientCAFile != "":
+		return ClientAuthRequire
+	default:
+		return ClientAuthNone
+	}
+}
+
+// ServerConfigFromEnv reads KMS_TLS_CERT_FILE, KMS_TLS_KEY_FILE,
+// KMS_TLS_CLIENT_CA_FILE, KMS_TLS_CLIENT_AUTH and KMS_TLS_RELOAD_INTERVAL.
+func ServerConfigFromEnv() ServerConfig {
+	cfg := ServerConfig{
+		CertFile:     os.Getenv("KMS_TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("KMS_TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("KMS_TLS_CLIENT_CA_FILE"),
+		ClientAuth:   strings.ToLower(os.Getenv("KMS_TLS_CLIENT_AUTH")),
+	}
+	if d, err := time.ParseDuration(os.Getenv("KMS_TLS_RELOAD_INTERVAL")); err == nil {
+		cfg.ReloadInterval = d
+	}
+	return cfg
+}
+
+// NewServerTLS returns a tls.Config for cfg that picks up rotated files, and
+// the Reloader behind it so callers can also force a reload.
+func NewServerTLS(cfg ServerConfig) (*tls.Config, *Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, nil, errors.New("TLS requires both a certificate and a key file")
+	}
+
+	mode := cfg.ClientAuthMode()
+	var clientAuth tls.ClientAuthType
+	switch mode {
+	case ClientAuthNone:
+		clientAuth = tls.NoClientCert
+	case ClientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, nil, fmt.Errorf("unknown client auth mode %q (want none, optional or require)", cfg.ClientAuth)
+	}
+	if clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, nil, fmt.Errorf("client auth mode %q requires a client CA file", mode)
+	}
+
+	r, err := NewReloader(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile, cfg.ReloadInterval)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			m := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*m.cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    m.pool,
+				NextProtos:   []string{"h2"},
+			}, nil
+		},
+	}
+	return tlsCfg, r, nil
+}
+
+// ServerOption returns the grpc.Creds option for cfg, or nil when TLS is not
+// configured.
+func ServerOption(cfg ServerConfig) (grpc.ServerOption, *Reloader, error) {
+	if !cfg.Enabled() {
+		return nil, nil, nil
+	}
+	tlsCfg, r, err := NewServerTLS(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return grpc.Creds(credentials.NewTLS(tlsCfg)), r, nil
+}
+
+// ClientConfig describes the client side of TLS. TLS is used when Enabled is
+// set or any file is given; otherwise clients connect in plaintext.
+type ClientConfig struct {
+	Enabled bool
+
+	// CAFile is a PEM bundle used to verify the server. Empty uses the
+	// system roots.
+	CAFile string
+
+	// CertFile and KeyFile are the client certificate for mTLS.
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the name checked against the server certificate.
+	ServerName string
+
+	// ReloadInterval applies to the client certificate, as in ServerConfig.
+	ReloadInterval time.Duration
+}
+
+func (c ClientConfig) enabled() bool {
+	return c.Enabled || c.CAFile != "" || c.CertFile != ""
+}
+
+// ClientConfigFromEnv reads KMS_TLS (true/false), KMS_TLS_CA_FILE,
+// KMS_TLS_CLIENT_CERT_FILE, KMS_TLS_CLIENT_KEY_FILE, KMS_TLS_SERVER_NAME and
+// KMS_TLS_RELOAD_INTERVAL.
+func ClientConfigFromEnv() ClientConfig {
+	v := strings.ToLower(os.Getenv("KMS_TLS"))
+	cfg := ClientConfig{
+		Enabled:    v == "1" || v == "true" || v == "yes",
+		CAFile:     os.Getenv("KMS_TLS_CA_FILE"),
+		CertFile:   os.Getenv("KMS_TLS_CLIENT_CERT_FILE"),
+		KeyFile:    os.Getenv("KMS_TLS_CLIENT_KEY_FILE"),
+		ServerName: os.Getenv("KMS_TLS_SERVER_NAME"),
+	}
+	if d, err := time.ParseDuration(os.Getenv("KMS_TLS_RELOAD_INTERVAL")); err == nil {
+		cfg.ReloadInterval = d
+	}
+	return cfg
+}
+
+// NewClientTLS returns a tls.Config for cfg. The client certificate, if any,
+// is reloaded when its files change; the CA bundle is read once.
+func NewClientTLS(cfg ClientConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("a client certificate requires both a certificate and a key file")
+		}
+		r, err := NewReloader(cfg.CertFile, cfg.KeyFile, "", cfg.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.current().cert, nil
+		}
+	}
+	return tlsCfg, nil
+}
+
+// DialOption returns the transport credentials for cfg: TLS when configured,
+// plaintext otherwise.
+func DialOption(cfg ClientConfig) (grpc.DialOption, error) {
+	if !cfg.enabled() {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil
+	}
+	tlsCfg, err := NewClientTLS(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg)), nil
+}
+
+// Reloader holds a certificate, its key and an optional CA bundle loaded from
+// files, and reloads them when a file's modification time or size changes.
+// A failed reload is logged and the previous material stays in use, so a
+// half-written rotation never takes the server down.
+type Reloader struct {
+	certFile, keyFile, caFile string
+	interval                  time.Duration
+
+	mu        sync.Mutex
+	material  *material
+	stamps    []fileStamp
+	lastCheck time.Time
+}
+
+type material struct {
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader loads the files once and fails if they are unusable. caFile may
+// be empty. A zero interval uses DefaultReloadInterval; a negative interval
+// disables automatic reloading but Reload still works.
+func NewReloader(certFile, keyFile, caFile string, interval time.Duration) (*Reloader, error) {
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: interval}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files now. On error the previous material is kept.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *Reloader) reloadLocked() error {
+	r.lastCheck = time.Now()
+	stamps := r.statFiles()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate %s: %w", r.certFile, err)
+	}
+	if len(cert.Certificate) > 0 {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			cert.Leaf = leaf
+		}
+	}
+	m := &material{cert: &cert}
+	if r.caFile != "" {
+		if m.pool, err = loadCertPool(r.caFile); err != nil {
+			return err
+		}
+	}
+
+	r.material = m
+	r.stamps = stamps
+	return nil
+}
+
+// current returns the loaded material, first reloading it if the interval
+// has passed and a file changed.
+func (r *Reloader) current() *material {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.interval > 0 && time.Since(r.lastCheck) >= r.interval {
+		r.lastCheck = time.Now()
+		if !stampsEqual(r.statFiles(), r.stamps) {
+			if err := r.reloadLocked(); err != nil {
+				slog.Error("TLS: keeping previous certificate", "err", err)
+			} else {
+				slog.Info("TLS: reloaded certificate", "file", r.certFile, "expires", r.notAfterLocked().Format(time.RFC3339))
+			}
+		}
+	}
+	return r.material
+}
+
+// NotAfter returns the expiry of the loaded certificate.
+func (r *Reloader) NotAfter() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.notAfterLocked()
+}
+
+func (r *Reloader) notAfterLocked() time.Time {
+	if r.material == nil || r.material.cert.Leaf == nil {
+		return time.Time{}
+	}
+	return r.material.cert.Leaf.NotAfter
+}
+
+func (r *Reloader) statFiles() []fileStamp {
+	var stamps []fileStamp
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		var s fileStamp
+		if name != "" {
+			if fi, err := os.Stat(name); err == nil {
+				s = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+			}
+		}
+		stamps = append(stamps, s)
+	}
+	return stamps
+}
+
+func stampsEqual(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
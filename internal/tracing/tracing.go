@@ -0,0 +1,189 @@
+// Package tracing sets up OpenTelemetry tracing for the KMS commands: an
+// exporter, a sampler and W3C trace context propagation, so that one trace
+// follows a request from the HTTP gateway or etl-worker through gRPC into
+// kms-server and its key backend.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"google.golang.org/grpc"
+)
+
+// Exporters.
+const (
+	// ExporterOTLP sends spans to an OpenTelemetry collector over OTLP/gRPC.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to stdout as indented JSON.
+	ExporterStdout = "stdout"
+	// ExporterFile appends spans to Config.File as JSON, one per line, for
+	// offline testing.
+	ExporterFile = "file"
+)
+
+// Exporters lists the valid Config.Exporter values besides "" (off).
+var Exporters = []string{ExporterOTLP, ExporterStdout, ExporterFile}
+
+// Config selects where spans go.
+type Config struct {
+	// Exporter is one of Exporters, or "" to disable tracing.
+	Exporter string
+	// Endpoint is the collector's host:port for ExporterOTLP; empty uses
+	// OTEL_EXPORTER_OTLP_ENDPOINT, or localhost:4317.
+	Endpoint string
+	// Insecure sends OTLP without TLS.
+	Insecure bool
+	// File receives the spans of ExporterFile.
+	File string
+	// SampleRatio is the share of new traces recorded, in (0, 1]; 0 means 1.
+	// A trace started by a caller follows the caller's decision.
+	SampleRatio float64
+}
+
+// Enabled reports whether spans are exported.
+func (c Config) Enabled() bool { return c.Exporter != "" }
+
+// ConfigFromEnv reads KMS_TRACING_EXPORTER, KMS_TRACING_ENDPOINT,
+// KMS_TRACING_INSECURE (true/false), KMS_TRACING_FILE and
+// KMS_TRACING_SAMPLE_RATIO, for the commands without a config file.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Exporter: strings.ToLower(os.Getenv("KMS_TRACING_EXPORTER")),
+		Endpoint: os.Getenv("KMS_TRACING_ENDPOINT"),
+		File:     os.Getenv("KMS_TRACING_FILE"),
+	}
+	if v := os.Getenv("KMS_TRACING_INSECURE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("KMS_TRACING_INSECURE: %w", err)
+		}
+		cfg.Insecure = b
+	}
+	if v := os.Getenv("KMS_TRACING_SAMPLE_RATIO"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("KMS_TRACING_SAMPLE_RATIO: %w", err)
+		}
+		cfg.SampleRatio = r
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the exporter and sample ratio.
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case "", ExporterOTLP, ExporterStdout:
+	case ExporterFile:
+		if c.File == "" {
+			return errors.New("tracing: the file exporter needs a file")
+		}
+	default:
+		return fmt.Errorf("tracing: exporter %q is not one of %s", c.Exporter, strings.Join(Exporters, ", "))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("tracing: sample ratio %g is not between 0 and 1", c.SampleRatio)
+	}
+	return nil
+}
+
+// Setup installs a global tracer provider exporting per cfg for the named
+// service, plus the W3C trace context and baggage propagators. The returned
+// function flushes the remaining spans and stops the exporter. With tracing
+// disabled it installs nothing and returns a no-op.
+func Setup(ctx context.Context, service string, cfg Config, attrs ...attribute.KeyValue) (shutdown func(context.Context) error, err error) {
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		exp    sdktrace.SpanExporter
+		closer io.Closer
+	)
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err = otlptracegrpc.New(ctx, opts...)
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		closer = f
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("tracing: %s exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(append([]attribute.KeyValue{semconv.ServiceName(service)}, attrs...)...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: resource: %w", err)
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// ServerOption traces incoming gRPC calls and continues the caller's trace
+// from the request metadata.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// DialOption traces outgoing gRPC calls and sends the trace context in the
+// request metadata.
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
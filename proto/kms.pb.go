@@ -9,6 +9,7 @@ package kmsproto
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -21,14 +22,125 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type CiphertextFormat int32
+
+const (
+	CiphertextFormat_CIPHERTEXT_FORMAT_UNSPECIFIED CiphertextFormat = 0
+	// Bare AES-GCM output of the key backend's key (requests without key_id).
+	CiphertextFormat_CIPHERTEXT_FORMAT_LEGACY CiphertextFormat = 1
+	// A "KMS" header naming the key and version, then AES-GCM output.
+	CiphertextFormat_CIPHERTEXT_FORMAT_NAMED_KEY CiphertextFormat = 2
+)
+
+// Enum value maps for CiphertextFormat.
+var (
+	CiphertextFormat_name = map[int32]string{
+		0: "CIPHERTEXT_FORMAT_UNSPECIFIED",
+		1: "CIPHERTEXT_FORMAT_LEGACY",
+		2: "CIPHERTEXT_FORMAT_NAMED_KEY",
+	}
+	CiphertextFormat_value = map[string]int32{
+		"CIPHERTEXT_FORMAT_UNSPECIFIED": 0,
+		"CIPHERTEXT_FORMAT_LEGACY":      1,
+		"CIPHERTEXT_FORMAT_NAMED_KEY":   2,
+	}
+)
+
+func (x CiphertextFormat) Enum() *CiphertextFormat {
+	p := new(CiphertextFormat)
+	*p = x
+	return p
+}
+
+func (x CiphertextFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CiphertextFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_kms_proto_enumTypes[0].Descriptor()
+}
+
+func (CiphertextFormat) Type() protoreflect.EnumType {
+	return &file_kms_proto_enumTypes[0]
+}
+
+func (x CiphertextFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CiphertextFormat.Descriptor instead.
+func (CiphertextFormat) EnumDescriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{0}
+}
+
+type KeyState int32
+
+const (
+	KeyState_KEY_STATE_UNSPECIFIED      KeyState = 0
+	KeyState_KEY_STATE_ENABLED          KeyState = 1
+	KeyState_KEY_STATE_DISABLED         KeyState = 2
+	KeyState_KEY_STATE_PENDING_DELETION KeyState = 3
+)
+
+// Enum value maps for KeyState.
+var (
+	KeyState_name = map[int32]string{
+		0: "KEY_STATE_UNSPECIFIED",
+		1: "KEY_STATE_ENABLED",
+		2: "KEY_STATE_DISABLED",
+		3: "KEY_STATE_PENDING_DELETION",
+	}
+	KeyState_value = map[string]int32{
+		"KEY_STATE_UNSPECIFIED":      0,
+		"KEY_STATE_ENABLED":          1,
+		"KEY_STATE_DISABLED":         2,
+		"KEY_STATE_PENDING_DELETION": 3,
+	}
+)
+
+func (x KeyState) Enum() *KeyState {
+	p := new(KeyState)
+	*p = x
+	return p
+}
+
+func (x KeyState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (KeyState) Descriptor() protoreflect.EnumDescriptor {
+	return file_kms_proto_enumTypes[1].Descriptor()
+}
+
+func (KeyState) Type() protoreflect.EnumType {
+	return &file_kms_proto_enumTypes[1]
+}
+
+func (x KeyState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use KeyState.Descriptor instead.
+func (KeyState) EnumDescriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{1}
+}
+
 type EncryptRequest struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Plaintext data to encrypt (e.g. card number, CVV).
 	Plaintext []byte `protobuf:"bytes,1,opt,name=plaintext,proto3" json:"plaintext,omitempty"`
-	// Optional logical key identifier if you plan to support multiple keys.
-	KeyId         string `protobuf:"bytes,2,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	// Named key from the key store (see KMSAdmin/CreateKey). Empty uses the
+	// key backend's own key, as before named keys existed.
+	KeyId string `protobuf:"bytes,2,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// Optional caller-chosen key, up to 128 characters, that makes a retry
+	// safe: within the server's idempotency TTL, a request with the same key,
+	// key_id and plaintext from the same caller gets the ciphertext and nonce
+	// of the first one. Reusing a key for other data fails with
+	// FailedPrecondition (IDEMPOTENCY_KEY_REUSED). In BatchEncrypt each item
+	// has its own key.
+	IdempotencyKey string `protobuf:"bytes,3,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *EncryptRequest) Reset() {
@@ -75,6 +187,13 @@ func (x *EncryptRequest) GetKeyId() string {
 	return ""
 }
 
+func (x *EncryptRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
 type EncryptResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Ciphertext bytes (AES-GCM).
@@ -133,7 +252,7 @@ type DecryptRequest struct {
 	state      protoimpl.MessageState `protogen:"open.v1"`
 	Ciphertext []byte                 `protobuf:"bytes,1,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
 	Nonce      []byte                 `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
-	// Optional logical key identifier.
+	// The key_id the data was encrypted with; empty for the key backend's key.
 	KeyId         string `protobuf:"bytes,3,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -234,28 +353,29 @@ func (x *DecryptResponse) GetPlaintext() []byte {
 	return nil
 }
 
-type LoginRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
-	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+type DescribeCiphertextRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Ciphertext []byte                 `protobuf:"bytes,1,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	// Optional; only its length is checked.
+	Nonce         []byte `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *LoginRequest) Reset() {
-	*x = LoginRequest{}
+func (x *DescribeCiphertextRequest) Reset() {
+	*x = DescribeCiphertextRequest{}
 	mi := &file_kms_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *LoginRequest) String() string {
+func (x *DescribeCiphertextRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LoginRequest) ProtoMessage() {}
+func (*DescribeCiphertextRequest) ProtoMessage() {}
 
-func (x *LoginRequest) ProtoReflect() protoreflect.Message {
+func (x *DescribeCiphertextRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_kms_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -267,47 +387,180 @@ func (x *LoginRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LoginRequest.ProtoReflect.Descriptor instead.
-func (*LoginRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use DescribeCiphertextRequest.ProtoReflect.Descriptor instead.
+func (*DescribeCiphertextRequest) Descriptor() ([]byte, []int) {
 	return file_kms_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *LoginRequest) GetUsername() string {
+func (x *DescribeCiphertextRequest) GetCiphertext() []byte {
 	if x != nil {
-		return x.Username
+		return x.Ciphertext
+	}
+	return nil
+}
+
+func (x *DescribeCiphertextRequest) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+type DescribeCiphertextResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Format CiphertextFormat       `protobuf:"varint,1,opt,name=format,proto3,enum=kms.CiphertextFormat" json:"format,omitempty"`
+	// Version of the named-key header; 0 for the legacy format.
+	FormatVersion uint32 `protobuf:"varint,2,opt,name=format_version,json=formatVersion,proto3" json:"format_version,omitempty"`
+	// The named key, without the caller's tenant prefix, and its version.
+	// Empty for the legacy format and for another tenant's key.
+	KeyId      string `protobuf:"bytes,3,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	KeyVersion uint32 `protobuf:"varint,4,opt,name=key_version,json=keyVersion,proto3" json:"key_version,omitempty"`
+	// The key belongs to another tenant than the caller's.
+	OtherTenant bool `protobuf:"varint,5,opt,name=other_tenant,json=otherTenant,proto3" json:"other_tenant,omitempty"`
+	// "AES-256-GCM" for named keys; "AES-GCM" for the key backend, whose key
+	// size depends on the backend.
+	Algorithm string `protobuf:"bytes,6,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	// Length of the nonce sent, 0 if none, and the length decryption needs.
+	NonceLength         uint32 `protobuf:"varint,7,opt,name=nonce_length,json=nonceLength,proto3" json:"nonce_length,omitempty"`
+	ExpectedNonceLength uint32 `protobuf:"varint,8,opt,name=expected_nonce_length,json=expectedNonceLength,proto3" json:"expected_nonce_length,omitempty"`
+	// Whether the ciphertext is bound to additional authenticated data (the
+	// header of a named-key ciphertext).
+	Aad bool `protobuf:"varint,9,opt,name=aad,proto3" json:"aad,omitempty"`
+	// Length of the plaintext: the sealed bytes less the 16-byte GCM tag.
+	PlaintextLength uint32 `protobuf:"varint,10,opt,name=plaintext_length,json=plaintextLength,proto3" json:"plaintext_length,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DescribeCiphertextResponse) Reset() {
+	*x = DescribeCiphertextResponse{}
+	mi := &file_kms_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DescribeCiphertextResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DescribeCiphertextResponse) ProtoMessage() {}
+
+func (x *DescribeCiphertextResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DescribeCiphertextResponse.ProtoReflect.Descriptor instead.
+func (*DescribeCiphertextResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DescribeCiphertextResponse) GetFormat() CiphertextFormat {
+	if x != nil {
+		return x.Format
+	}
+	return CiphertextFormat_CIPHERTEXT_FORMAT_UNSPECIFIED
+}
+
+func (x *DescribeCiphertextResponse) GetFormatVersion() uint32 {
+	if x != nil {
+		return x.FormatVersion
+	}
+	return 0
+}
+
+func (x *DescribeCiphertextResponse) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
 	}
 	return ""
 }
 
-func (x *LoginRequest) GetPassword() string {
+func (x *DescribeCiphertextResponse) GetKeyVersion() uint32 {
 	if x != nil {
-		return x.Password
+		return x.KeyVersion
+	}
+	return 0
+}
+
+func (x *DescribeCiphertextResponse) GetOtherTenant() bool {
+	if x != nil {
+		return x.OtherTenant
+	}
+	return false
+}
+
+func (x *DescribeCiphertextResponse) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
 	}
 	return ""
 }
 
-type LoginResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+func (x *DescribeCiphertextResponse) GetNonceLength() uint32 {
+	if x != nil {
+		return x.NonceLength
+	}
+	return 0
+}
+
+func (x *DescribeCiphertextResponse) GetExpectedNonceLength() uint32 {
+	if x != nil {
+		return x.ExpectedNonceLength
+	}
+	return 0
+}
+
+func (x *DescribeCiphertextResponse) GetAad() bool {
+	if x != nil {
+		return x.Aad
+	}
+	return false
+}
+
+func (x *DescribeCiphertextResponse) GetPlaintextLength() uint32 {
+	if x != nil {
+		return x.PlaintextLength
+	}
+	return 0
+}
+
+// ItemStatus is the outcome of one batch item. It is unset for items that
+// succeeded.
+type ItemStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// gRPC status code (see google.golang.org/grpc/codes).
+	Code    int32  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	// Machine-readable cause, the same value as the google.rpc.ErrorInfo
+	// reason of a unary call (e.g. "INVALID_CIPHERTEXT"). Empty on success.
+	Reason        string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *LoginResponse) Reset() {
-	*x = LoginResponse{}
-	mi := &file_kms_proto_msgTypes[5]
+func (x *ItemStatus) Reset() {
+	*x = ItemStatus{}
+	mi := &file_kms_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *LoginResponse) String() string {
+func (x *ItemStatus) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LoginResponse) ProtoMessage() {}
+func (*ItemStatus) ProtoMessage() {}
 
-func (x *LoginResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_kms_proto_msgTypes[5]
+func (x *ItemStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -318,49 +571,2035 @@ func (x *LoginResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LoginResponse.ProtoReflect.Descriptor instead.
-func (*LoginResponse) Descriptor() ([]byte, []int) {
-	return file_kms_proto_rawDescGZIP(), []int{5}
+// Deprecated: Use ItemStatus.ProtoReflect.Descriptor instead.
+func (*ItemStatus) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *LoginResponse) GetToken() string {
+func (x *ItemStatus) GetCode() int32 {
 	if x != nil {
-		return x.Token
+		return x.Code
+	}
+	return 0
+}
+
+func (x *ItemStatus) GetMessage() string {
+	if x != nil {
+		return x.Message
 	}
 	return ""
 }
 
-var File_kms_proto protoreflect.FileDescriptor
+func (x *ItemStatus) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
 
-const file_kms_proto_rawDesc = "" +
-	"\n" +
-	"\tkms.proto\x12\x03kms\"E\n" +
-	"\x0eEncryptRequest\x12\x1c\n" +
-	"\tplaintext\x18\x01 \x01(\fR\tplaintext\x12\x15\n" +
-	"\x06key_id\x18\x02 \x01(\tR\x05keyId\"G\n" +
-	"\x0fEncryptResponse\x12\x1e\n" +
-	"\n" +
-	"ciphertext\x18\x01 \x01(\fR\n" +
-	"ciphertext\x12\x14\n" +
-	"\x05nonce\x18\x02 \x01(\fR\x05nonce\"]\n" +
-	"\x0eDecryptRequest\x12\x1e\n" +
-	"\n" +
-	"ciphertext\x18\x01 \x01(\fR\n" +
-	"ciphertext\x12\x14\n" +
-	"\x05nonce\x18\x02 \x01(\fR\x05nonce\x12\x15\n" +
-	"\x06key_id\x18\x03 \x01(\tR\x05keyId\"/\n" +
-	"\x0fDecryptResponse\x12\x1c\n" +
-	"\tplaintext\x18\x01 \x01(\fR\tplaintext\"F\n" +
-	"\fLoginRequest\x12\x1a\n" +
-	"\busername\x18\x01 \x01(\tR\busername\x12\x1a\n" +
-	"\bpassword\x18\x02 \x01(\tR\bpassword\"%\n" +
-	"\rLoginResponse\x12\x14\n" +
-	"\x05token\x18\x01 \x01(\tR\x05token2u\n" +
-	"\x03KMS\x126\n" +
-	"\aEncrypt\x12\x13.kms.EncryptRequest\x1a\x14.kms.EncryptResponse\"\x00\x126\n" +
-	"\aDecrypt\x12\x13.kms.DecryptRequest\x1a\x14.kms.DecryptResponse\"\x0028\n" +
-	"\x04Auth\x120\n" +
-	"\x05Login\x12\x11.kms.LoginRequest\x1a\x12.kms.LoginResponse\"\x00B\x14Z\x12kms/proto;kmsprotob\x06proto3"
+type BatchEncryptRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Up to 1000 items.
+	Items         []*EncryptRequest `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchEncryptRequest) Reset() {
+	*x = BatchEncryptRequest{}
+	mi := &file_kms_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchEncryptRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchEncryptRequest) ProtoMessage() {}
+
+func (x *BatchEncryptRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchEncryptRequest.ProtoReflect.Descriptor instead.
+func (*BatchEncryptRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *BatchEncryptRequest) GetItems() []*EncryptRequest {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type BatchEncryptResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ciphertext    []byte                 `protobuf:"bytes,1,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	Nonce         []byte                 `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Status        *ItemStatus            `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchEncryptResult) Reset() {
+	*x = BatchEncryptResult{}
+	mi := &file_kms_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchEncryptResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchEncryptResult) ProtoMessage() {}
+
+func (x *BatchEncryptResult) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchEncryptResult.ProtoReflect.Descriptor instead.
+func (*BatchEncryptResult) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BatchEncryptResult) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
+func (x *BatchEncryptResult) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *BatchEncryptResult) GetStatus() *ItemStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type BatchEncryptResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One result per request item, in request order.
+	Results       []*BatchEncryptResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchEncryptResponse) Reset() {
+	*x = BatchEncryptResponse{}
+	mi := &file_kms_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchEncryptResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchEncryptResponse) ProtoMessage() {}
+
+func (x *BatchEncryptResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchEncryptResponse.ProtoReflect.Descriptor instead.
+func (*BatchEncryptResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BatchEncryptResponse) GetResults() []*BatchEncryptResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type BatchDecryptRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Up to 1000 items.
+	Items         []*DecryptRequest `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchDecryptRequest) Reset() {
+	*x = BatchDecryptRequest{}
+	mi := &file_kms_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchDecryptRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchDecryptRequest) ProtoMessage() {}
+
+func (x *BatchDecryptRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchDecryptRequest.ProtoReflect.Descriptor instead.
+func (*BatchDecryptRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *BatchDecryptRequest) GetItems() []*DecryptRequest {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type BatchDecryptResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Plaintext     []byte                 `protobuf:"bytes,1,opt,name=plaintext,proto3" json:"plaintext,omitempty"`
+	Status        *ItemStatus            `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchDecryptResult) Reset() {
+	*x = BatchDecryptResult{}
+	mi := &file_kms_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchDecryptResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchDecryptResult) ProtoMessage() {}
+
+func (x *BatchDecryptResult) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchDecryptResult.ProtoReflect.Descriptor instead.
+func (*BatchDecryptResult) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *BatchDecryptResult) GetPlaintext() []byte {
+	if x != nil {
+		return x.Plaintext
+	}
+	return nil
+}
+
+func (x *BatchDecryptResult) GetStatus() *ItemStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type BatchDecryptResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One result per request item, in request order.
+	Results       []*BatchDecryptResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchDecryptResponse) Reset() {
+	*x = BatchDecryptResponse{}
+	mi := &file_kms_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchDecryptResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchDecryptResponse) ProtoMessage() {}
+
+func (x *BatchDecryptResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchDecryptResponse.ProtoReflect.Descriptor instead.
+func (*BatchDecryptResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *BatchDecryptResponse) GetResults() []*BatchDecryptResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type EncryptStreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Caller-chosen correlation id, echoed in the response.
+	Id            string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Plaintext     []byte `protobuf:"bytes,2,opt,name=plaintext,proto3" json:"plaintext,omitempty"`
+	KeyId         string `protobuf:"bytes,3,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EncryptStreamRequest) Reset() {
+	*x = EncryptStreamRequest{}
+	mi := &file_kms_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncryptStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptStreamRequest) ProtoMessage() {}
+
+func (x *EncryptStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptStreamRequest.ProtoReflect.Descriptor instead.
+func (*EncryptStreamRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *EncryptStreamRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EncryptStreamRequest) GetPlaintext() []byte {
+	if x != nil {
+		return x.Plaintext
+	}
+	return nil
+}
+
+func (x *EncryptStreamRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type EncryptStreamResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Ciphertext    []byte                 `protobuf:"bytes,2,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	Nonce         []byte                 `protobuf:"bytes,3,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Status        *ItemStatus            `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EncryptStreamResponse) Reset() {
+	*x = EncryptStreamResponse{}
+	mi := &file_kms_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncryptStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncryptStreamResponse) ProtoMessage() {}
+
+func (x *EncryptStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncryptStreamResponse.ProtoReflect.Descriptor instead.
+func (*EncryptStreamResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *EncryptStreamResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EncryptStreamResponse) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
+func (x *EncryptStreamResponse) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *EncryptStreamResponse) GetStatus() *ItemStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type DecryptStreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Caller-chosen correlation id, echoed in the response.
+	Id            string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Ciphertext    []byte `protobuf:"bytes,2,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	Nonce         []byte `protobuf:"bytes,3,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	KeyId         string `protobuf:"bytes,4,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecryptStreamRequest) Reset() {
+	*x = DecryptStreamRequest{}
+	mi := &file_kms_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecryptStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecryptStreamRequest) ProtoMessage() {}
+
+func (x *DecryptStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecryptStreamRequest.ProtoReflect.Descriptor instead.
+func (*DecryptStreamRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *DecryptStreamRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DecryptStreamRequest) GetCiphertext() []byte {
+	if x != nil {
+		return x.Ciphertext
+	}
+	return nil
+}
+
+func (x *DecryptStreamRequest) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *DecryptStreamRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type DecryptStreamResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Plaintext     []byte                 `protobuf:"bytes,2,opt,name=plaintext,proto3" json:"plaintext,omitempty"`
+	Status        *ItemStatus            `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecryptStreamResponse) Reset() {
+	*x = DecryptStreamResponse{}
+	mi := &file_kms_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecryptStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecryptStreamResponse) ProtoMessage() {}
+
+func (x *DecryptStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecryptStreamResponse.ProtoReflect.Descriptor instead.
+func (*DecryptStreamResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *DecryptStreamResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DecryptStreamResponse) GetPlaintext() []byte {
+	if x != nil {
+		return x.Plaintext
+	}
+	return nil
+}
+
+func (x *DecryptStreamResponse) GetStatus() *ItemStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type LoginRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginRequest) Reset() {
+	*x = LoginRequest{}
+	mi := &file_kms_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginRequest) ProtoMessage() {}
+
+func (x *LoginRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginRequest.ProtoReflect.Descriptor instead.
+func (*LoginRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *LoginRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *LoginRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type LoginResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginResponse) Reset() {
+	*x = LoginResponse{}
+	mi := &file_kms_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginResponse) ProtoMessage() {}
+
+func (x *LoginResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginResponse.ProtoReflect.Descriptor instead.
+func (*LoginResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *LoginResponse) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type ReloadConfigRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReloadConfigRequest) Reset() {
+	*x = ReloadConfigRequest{}
+	mi := &file_kms_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReloadConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReloadConfigRequest) ProtoMessage() {}
+
+func (x *ReloadConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReloadConfigRequest.ProtoReflect.Descriptor instead.
+func (*ReloadConfigRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{19}
+}
+
+type ReloadConfigResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Settings that changed but only take effect after a restart, e.g.
+	// "server.addr".
+	RestartRequired []string `protobuf:"bytes,1,rep,name=restart_required,json=restartRequired,proto3" json:"restart_required,omitempty"`
+	// Whether the key backend was rebuilt.
+	KeyReloaded   bool `protobuf:"varint,2,opt,name=key_reloaded,json=keyReloaded,proto3" json:"key_reloaded,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReloadConfigResponse) Reset() {
+	*x = ReloadConfigResponse{}
+	mi := &file_kms_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReloadConfigResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReloadConfigResponse) ProtoMessage() {}
+
+func (x *ReloadConfigResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReloadConfigResponse.ProtoReflect.Descriptor instead.
+func (*ReloadConfigResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ReloadConfigResponse) GetRestartRequired() []string {
+	if x != nil {
+		return x.RestartRequired
+	}
+	return nil
+}
+
+func (x *ReloadConfigResponse) GetKeyReloaded() bool {
+	if x != nil {
+		return x.KeyReloaded
+	}
+	return false
+}
+
+type KeyVersion struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       uint32                 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	CreateTime    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KeyVersion) Reset() {
+	*x = KeyVersion{}
+	mi := &file_kms_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyVersion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyVersion) ProtoMessage() {}
+
+func (x *KeyVersion) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyVersion.ProtoReflect.Descriptor instead.
+func (*KeyVersion) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *KeyVersion) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *KeyVersion) GetCreateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreateTime
+	}
+	return nil
+}
+
+// KeyMetadata describes a named key. Key material is never returned.
+type KeyMetadata struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	KeyId       string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Description string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	State       KeyState               `protobuf:"varint,3,opt,name=state,proto3,enum=kms.KeyState" json:"state,omitempty"`
+	// The version new ciphertexts are encrypted with (the newest).
+	PrimaryVersion uint32                 `protobuf:"varint,4,opt,name=primary_version,json=primaryVersion,proto3" json:"primary_version,omitempty"`
+	CreateTime     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	// When the key will be deleted; set only in KEY_STATE_PENDING_DELETION.
+	DeletionTime *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=deletion_time,json=deletionTime,proto3" json:"deletion_time,omitempty"`
+	// Oldest first.
+	Versions      []*KeyVersion `protobuf:"bytes,7,rep,name=versions,proto3" json:"versions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KeyMetadata) Reset() {
+	*x = KeyMetadata{}
+	mi := &file_kms_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyMetadata) ProtoMessage() {}
+
+func (x *KeyMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyMetadata.ProtoReflect.Descriptor instead.
+func (*KeyMetadata) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *KeyMetadata) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *KeyMetadata) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *KeyMetadata) GetState() KeyState {
+	if x != nil {
+		return x.State
+	}
+	return KeyState_KEY_STATE_UNSPECIFIED
+}
+
+func (x *KeyMetadata) GetPrimaryVersion() uint32 {
+	if x != nil {
+		return x.PrimaryVersion
+	}
+	return 0
+}
+
+func (x *KeyMetadata) GetCreateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreateTime
+	}
+	return nil
+}
+
+func (x *KeyMetadata) GetDeletionTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DeletionTime
+	}
+	return nil
+}
+
+func (x *KeyMetadata) GetVersions() []*KeyVersion {
+	if x != nil {
+		return x.Versions
+	}
+	return nil
+}
+
+type CreateKeyRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// 1-64 characters: letters, digits, '.', '_' and '-', starting with a
+	// letter or digit. Used as key_id in Encrypt and Decrypt.
+	KeyId         string `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Description   string `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateKeyRequest) Reset() {
+	*x = CreateKeyRequest{}
+	mi := &file_kms_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateKeyRequest) ProtoMessage() {}
+
+func (x *CreateKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateKeyRequest.ProtoReflect.Descriptor instead.
+func (*CreateKeyRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *CreateKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *CreateKeyRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+type CreateKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *KeyMetadata           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateKeyResponse) Reset() {
+	*x = CreateKeyResponse{}
+	mi := &file_kms_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateKeyResponse) ProtoMessage() {}
+
+func (x *CreateKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateKeyResponse.ProtoReflect.Descriptor instead.
+func (*CreateKeyResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *CreateKeyResponse) GetKey() *KeyMetadata {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type ListKeysRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListKeysRequest) Reset() {
+	*x = ListKeysRequest{}
+	mi := &file_kms_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListKeysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListKeysRequest) ProtoMessage() {}
+
+func (x *ListKeysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListKeysRequest.ProtoReflect.Descriptor instead.
+func (*ListKeysRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{25}
+}
+
+type ListKeysResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sorted by key_id.
+	Keys          []*KeyMetadata `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListKeysResponse) Reset() {
+	*x = ListKeysResponse{}
+	mi := &file_kms_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListKeysResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListKeysResponse) ProtoMessage() {}
+
+func (x *ListKeysResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListKeysResponse.ProtoReflect.Descriptor instead.
+func (*ListKeysResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *ListKeysResponse) GetKeys() []*KeyMetadata {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type DescribeKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DescribeKeyRequest) Reset() {
+	*x = DescribeKeyRequest{}
+	mi := &file_kms_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DescribeKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DescribeKeyRequest) ProtoMessage() {}
+
+func (x *DescribeKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DescribeKeyRequest.ProtoReflect.Descriptor instead.
+func (*DescribeKeyRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *DescribeKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type DescribeKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *KeyMetadata           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DescribeKeyResponse) Reset() {
+	*x = DescribeKeyResponse{}
+	mi := &file_kms_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DescribeKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DescribeKeyResponse) ProtoMessage() {}
+
+func (x *DescribeKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DescribeKeyResponse.ProtoReflect.Descriptor instead.
+func (*DescribeKeyResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *DescribeKeyResponse) GetKey() *KeyMetadata {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type RotateKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RotateKeyRequest) Reset() {
+	*x = RotateKeyRequest{}
+	mi := &file_kms_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RotateKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RotateKeyRequest) ProtoMessage() {}
+
+func (x *RotateKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RotateKeyRequest.ProtoReflect.Descriptor instead.
+func (*RotateKeyRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *RotateKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type RotateKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *KeyMetadata           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RotateKeyResponse) Reset() {
+	*x = RotateKeyResponse{}
+	mi := &file_kms_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RotateKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RotateKeyResponse) ProtoMessage() {}
+
+func (x *RotateKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RotateKeyResponse.ProtoReflect.Descriptor instead.
+func (*RotateKeyResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *RotateKeyResponse) GetKey() *KeyMetadata {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type EnableKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnableKeyRequest) Reset() {
+	*x = EnableKeyRequest{}
+	mi := &file_kms_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnableKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnableKeyRequest) ProtoMessage() {}
+
+func (x *EnableKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnableKeyRequest.ProtoReflect.Descriptor instead.
+func (*EnableKeyRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *EnableKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type EnableKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *KeyMetadata           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnableKeyResponse) Reset() {
+	*x = EnableKeyResponse{}
+	mi := &file_kms_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnableKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnableKeyResponse) ProtoMessage() {}
+
+func (x *EnableKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnableKeyResponse.ProtoReflect.Descriptor instead.
+func (*EnableKeyResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *EnableKeyResponse) GetKey() *KeyMetadata {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type DisableKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisableKeyRequest) Reset() {
+	*x = DisableKeyRequest{}
+	mi := &file_kms_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisableKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisableKeyRequest) ProtoMessage() {}
+
+func (x *DisableKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisableKeyRequest.ProtoReflect.Descriptor instead.
+func (*DisableKeyRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *DisableKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type DisableKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *KeyMetadata           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisableKeyResponse) Reset() {
+	*x = DisableKeyResponse{}
+	mi := &file_kms_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisableKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisableKeyResponse) ProtoMessage() {}
+
+func (x *DisableKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisableKeyResponse.ProtoReflect.Descriptor instead.
+func (*DisableKeyResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *DisableKeyResponse) GetKey() *KeyMetadata {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type ScheduleKeyDeletionRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	KeyId string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// Days until deletion, 7 to 30; 0 means 30.
+	PendingWindowDays int32 `protobuf:"varint,2,opt,name=pending_window_days,json=pendingWindowDays,proto3" json:"pending_window_days,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ScheduleKeyDeletionRequest) Reset() {
+	*x = ScheduleKeyDeletionRequest{}
+	mi := &file_kms_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleKeyDeletionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleKeyDeletionRequest) ProtoMessage() {}
+
+func (x *ScheduleKeyDeletionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleKeyDeletionRequest.ProtoReflect.Descriptor instead.
+func (*ScheduleKeyDeletionRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *ScheduleKeyDeletionRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *ScheduleKeyDeletionRequest) GetPendingWindowDays() int32 {
+	if x != nil {
+		return x.PendingWindowDays
+	}
+	return 0
+}
+
+type ScheduleKeyDeletionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *KeyMetadata           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleKeyDeletionResponse) Reset() {
+	*x = ScheduleKeyDeletionResponse{}
+	mi := &file_kms_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleKeyDeletionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleKeyDeletionResponse) ProtoMessage() {}
+
+func (x *ScheduleKeyDeletionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleKeyDeletionResponse.ProtoReflect.Descriptor instead.
+func (*ScheduleKeyDeletionResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *ScheduleKeyDeletionResponse) GetKey() *KeyMetadata {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type GetServerInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetServerInfoRequest) Reset() {
+	*x = GetServerInfoRequest{}
+	mi := &file_kms_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetServerInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServerInfoRequest) ProtoMessage() {}
+
+func (x *GetServerInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServerInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetServerInfoRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{37}
+}
+
+type GetServerInfoResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Version string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	// Go runtime version, e.g. "go1.24.0".
+	GoVersion string                 `protobuf:"bytes,2,opt,name=go_version,json=goVersion,proto3" json:"go_version,omitempty"`
+	StartTime *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	// file, pkcs11, aws, azure, vault, gcp or failover.
+	KeyBackend string `protobuf:"bytes,4,opt,name=key_backend,json=keyBackend,proto3" json:"key_backend,omitempty"`
+	Sealed     bool   `protobuf:"varint,5,opt,name=sealed,proto3" json:"sealed,omitempty"`
+	// Whether named keys are available (key.store is set).
+	KeyStoreEnabled bool  `protobuf:"varint,6,opt,name=key_store_enabled,json=keyStoreEnabled,proto3" json:"key_store_enabled,omitempty"`
+	KeyCount        int32 `protobuf:"varint,7,opt,name=key_count,json=keyCount,proto3" json:"key_count,omitempty"`
+	TlsEnabled      bool  `protobuf:"varint,8,opt,name=tls_enabled,json=tlsEnabled,proto3" json:"tls_enabled,omitempty"`
+	AuthEnabled     bool  `protobuf:"varint,9,opt,name=auth_enabled,json=authEnabled,proto3" json:"auth_enabled,omitempty"`
+	// Empty when the server is configured by environment variables only.
+	ConfigFile    string `protobuf:"bytes,10,opt,name=config_file,json=configFile,proto3" json:"config_file,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetServerInfoResponse) Reset() {
+	*x = GetServerInfoResponse{}
+	mi := &file_kms_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetServerInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServerInfoResponse) ProtoMessage() {}
+
+func (x *GetServerInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServerInfoResponse.ProtoReflect.Descriptor instead.
+func (*GetServerInfoResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *GetServerInfoResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *GetServerInfoResponse) GetGoVersion() string {
+	if x != nil {
+		return x.GoVersion
+	}
+	return ""
+}
+
+func (x *GetServerInfoResponse) GetStartTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartTime
+	}
+	return nil
+}
+
+func (x *GetServerInfoResponse) GetKeyBackend() string {
+	if x != nil {
+		return x.KeyBackend
+	}
+	return ""
+}
+
+func (x *GetServerInfoResponse) GetSealed() bool {
+	if x != nil {
+		return x.Sealed
+	}
+	return false
+}
+
+func (x *GetServerInfoResponse) GetKeyStoreEnabled() bool {
+	if x != nil {
+		return x.KeyStoreEnabled
+	}
+	return false
+}
+
+func (x *GetServerInfoResponse) GetKeyCount() int32 {
+	if x != nil {
+		return x.KeyCount
+	}
+	return 0
+}
+
+func (x *GetServerInfoResponse) GetTlsEnabled() bool {
+	if x != nil {
+		return x.TlsEnabled
+	}
+	return false
+}
+
+func (x *GetServerInfoResponse) GetAuthEnabled() bool {
+	if x != nil {
+		return x.AuthEnabled
+	}
+	return false
+}
+
+func (x *GetServerInfoResponse) GetConfigFile() string {
+	if x != nil {
+		return x.ConfigFile
+	}
+	return ""
+}
+
+type SealRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SealRequest) Reset() {
+	*x = SealRequest{}
+	mi := &file_kms_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SealRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SealRequest) ProtoMessage() {}
+
+func (x *SealRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SealRequest.ProtoReflect.Descriptor instead.
+func (*SealRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{39}
+}
+
+type SealResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SealResponse) Reset() {
+	*x = SealResponse{}
+	mi := &file_kms_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SealResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SealResponse) ProtoMessage() {}
+
+func (x *SealResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SealResponse.ProtoReflect.Descriptor instead.
+func (*SealResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{40}
+}
+
+type UnsealRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnsealRequest) Reset() {
+	*x = UnsealRequest{}
+	mi := &file_kms_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnsealRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnsealRequest) ProtoMessage() {}
+
+func (x *UnsealRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnsealRequest.ProtoReflect.Descriptor instead.
+func (*UnsealRequest) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{41}
+}
+
+type UnsealResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnsealResponse) Reset() {
+	*x = UnsealResponse{}
+	mi := &file_kms_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnsealResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnsealResponse) ProtoMessage() {}
+
+func (x *UnsealResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kms_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnsealResponse.ProtoReflect.Descriptor instead.
+func (*UnsealResponse) Descriptor() ([]byte, []int) {
+	return file_kms_proto_rawDescGZIP(), []int{42}
+}
+
+var File_kms_proto protoreflect.FileDescriptor
+
+const file_kms_proto_rawDesc = "" +
+	"\n" +
+	"\tkms.proto\x12\x03kms\x1a\x1fgoogle/protobuf/timestamp.proto\"n\n" +
+	"\x0eEncryptRequest\x12\x1c\n" +
+	"\tplaintext\x18\x01 \x01(\fR\tplaintext\x12\x15\n" +
+	"\x06key_id\x18\x02 \x01(\tR\x05keyId\x12'\n" +
+	"\x0fidempotency_key\x18\x03 \x01(\tR\x0eidempotencyKey\"G\n" +
+	"\x0fEncryptResponse\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x01 \x01(\fR\n" +
+	"ciphertext\x12\x14\n" +
+	"\x05nonce\x18\x02 \x01(\fR\x05nonce\"]\n" +
+	"\x0eDecryptRequest\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x01 \x01(\fR\n" +
+	"ciphertext\x12\x14\n" +
+	"\x05nonce\x18\x02 \x01(\fR\x05nonce\x12\x15\n" +
+	"\x06key_id\x18\x03 \x01(\tR\x05keyId\"/\n" +
+	"\x0fDecryptResponse\x12\x1c\n" +
+	"\tplaintext\x18\x01 \x01(\fR\tplaintext\"Q\n" +
+	"\x19DescribeCiphertextRequest\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x01 \x01(\fR\n" +
+	"ciphertext\x12\x14\n" +
+	"\x05nonce\x18\x02 \x01(\fR\x05nonce\"\xff\x02\n" +
+	"\x1aDescribeCiphertextResponse\x12-\n" +
+	"\x06format\x18\x01 \x01(\x0e2\x15.kms.CiphertextFormatR\x06format\x12%\n" +
+	"\x0eformat_version\x18\x02 \x01(\rR\rformatVersion\x12\x15\n" +
+	"\x06key_id\x18\x03 \x01(\tR\x05keyId\x12\x1f\n" +
+	"\vkey_version\x18\x04 \x01(\rR\n" +
+	"keyVersion\x12!\n" +
+	"\fother_tenant\x18\x05 \x01(\bR\votherTenant\x12\x1c\n" +
+	"\talgorithm\x18\x06 \x01(\tR\talgorithm\x12!\n" +
+	"\fnonce_length\x18\a \x01(\rR\vnonceLength\x122\n" +
+	"\x15expected_nonce_length\x18\b \x01(\rR\x13expectedNonceLength\x12\x10\n" +
+	"\x03aad\x18\t \x01(\bR\x03aad\x12)\n" +
+	"\x10plaintext_length\x18\n" +
+	" \x01(\rR\x0fplaintextLength\"R\n" +
+	"\n" +
+	"ItemStatus\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"@\n" +
+	"\x13BatchEncryptRequest\x12)\n" +
+	"\x05items\x18\x01 \x03(\v2\x13.kms.EncryptRequestR\x05items\"s\n" +
+	"\x12BatchEncryptResult\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x01 \x01(\fR\n" +
+	"ciphertext\x12\x14\n" +
+	"\x05nonce\x18\x02 \x01(\fR\x05nonce\x12'\n" +
+	"\x06status\x18\x03 \x01(\v2\x0f.kms.ItemStatusR\x06status\"I\n" +
+	"\x14BatchEncryptResponse\x121\n" +
+	"\aresults\x18\x01 \x03(\v2\x17.kms.BatchEncryptResultR\aresults\"@\n" +
+	"\x13BatchDecryptRequest\x12)\n" +
+	"\x05items\x18\x01 \x03(\v2\x13.kms.DecryptRequestR\x05items\"[\n" +
+	"\x12BatchDecryptResult\x12\x1c\n" +
+	"\tplaintext\x18\x01 \x01(\fR\tplaintext\x12'\n" +
+	"\x06status\x18\x02 \x01(\v2\x0f.kms.ItemStatusR\x06status\"I\n" +
+	"\x14BatchDecryptResponse\x121\n" +
+	"\aresults\x18\x01 \x03(\v2\x17.kms.BatchDecryptResultR\aresults\"[\n" +
+	"\x14EncryptStreamRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\tplaintext\x18\x02 \x01(\fR\tplaintext\x12\x15\n" +
+	"\x06key_id\x18\x03 \x01(\tR\x05keyId\"\x86\x01\n" +
+	"\x15EncryptStreamResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x02 \x01(\fR\n" +
+	"ciphertext\x12\x14\n" +
+	"\x05nonce\x18\x03 \x01(\fR\x05nonce\x12'\n" +
+	"\x06status\x18\x04 \x01(\v2\x0f.kms.ItemStatusR\x06status\"s\n" +
+	"\x14DecryptStreamRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1e\n" +
+	"\n" +
+	"ciphertext\x18\x02 \x01(\fR\n" +
+	"ciphertext\x12\x14\n" +
+	"\x05nonce\x18\x03 \x01(\fR\x05nonce\x12\x15\n" +
+	"\x06key_id\x18\x04 \x01(\tR\x05keyId\"n\n" +
+	"\x15DecryptStreamResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\tplaintext\x18\x02 \x01(\fR\tplaintext\x12'\n" +
+	"\x06status\x18\x03 \x01(\v2\x0f.kms.ItemStatusR\x06status\"F\n" +
+	"\fLoginRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\"%\n" +
+	"\rLoginResponse\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\"\x15\n" +
+	"\x13ReloadConfigRequest\"d\n" +
+	"\x14ReloadConfigResponse\x12)\n" +
+	"\x10restart_required\x18\x01 \x03(\tR\x0frestartRequired\x12!\n" +
+	"\fkey_reloaded\x18\x02 \x01(\bR\vkeyReloaded\"c\n" +
+	"\n" +
+	"KeyVersion\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12;\n" +
+	"\vcreate_time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"createTime\"\xbf\x02\n" +
+	"\vKeyMetadata\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x12#\n" +
+	"\x05state\x18\x03 \x01(\x0e2\r.kms.KeyStateR\x05state\x12'\n" +
+	"\x0fprimary_version\x18\x04 \x01(\rR\x0eprimaryVersion\x12;\n" +
+	"\vcreate_time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"createTime\x12?\n" +
+	"\rdeletion_time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\fdeletionTime\x12+\n" +
+	"\bversions\x18\a \x03(\v2\x0f.kms.KeyVersionR\bversions\"K\n" +
+	"\x10CreateKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\"7\n" +
+	"\x11CreateKeyResponse\x12\"\n" +
+	"\x03key\x18\x01 \x01(\v2\x10.kms.KeyMetadataR\x03key\"\x11\n" +
+	"\x0fListKeysRequest\"8\n" +
+	"\x10ListKeysResponse\x12$\n" +
+	"\x04keys\x18\x01 \x03(\v2\x10.kms.KeyMetadataR\x04keys\"+\n" +
+	"\x12DescribeKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"9\n" +
+	"\x13DescribeKeyResponse\x12\"\n" +
+	"\x03key\x18\x01 \x01(\v2\x10.kms.KeyMetadataR\x03key\")\n" +
+	"\x10RotateKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"7\n" +
+	"\x11RotateKeyResponse\x12\"\n" +
+	"\x03key\x18\x01 \x01(\v2\x10.kms.KeyMetadataR\x03key\")\n" +
+	"\x10EnableKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"7\n" +
+	"\x11EnableKeyResponse\x12\"\n" +
+	"\x03key\x18\x01 \x01(\v2\x10.kms.KeyMetadataR\x03key\"*\n" +
+	"\x11DisableKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"8\n" +
+	"\x12DisableKeyResponse\x12\"\n" +
+	"\x03key\x18\x01 \x01(\v2\x10.kms.KeyMetadataR\x03key\"c\n" +
+	"\x1aScheduleKeyDeletionRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12.\n" +
+	"\x13pending_window_days\x18\x02 \x01(\x05R\x11pendingWindowDays\"A\n" +
+	"\x1bScheduleKeyDeletionResponse\x12\"\n" +
+	"\x03key\x18\x01 \x01(\v2\x10.kms.KeyMetadataR\x03key\"\x16\n" +
+	"\x14GetServerInfoRequest\"\xf2\x02\n" +
+	"\x15GetServerInfoResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1d\n" +
+	"\n" +
+	"go_version\x18\x02 \x01(\tR\tgoVersion\x129\n" +
+	"\n" +
+	"start_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tstartTime\x12\x1f\n" +
+	"\vkey_backend\x18\x04 \x01(\tR\n" +
+	"keyBackend\x12\x16\n" +
+	"\x06sealed\x18\x05 \x01(\bR\x06sealed\x12*\n" +
+	"\x11key_store_enabled\x18\x06 \x01(\bR\x0fkeyStoreEnabled\x12\x1b\n" +
+	"\tkey_count\x18\a \x01(\x05R\bkeyCount\x12\x1f\n" +
+	"\vtls_enabled\x18\b \x01(\bR\n" +
+	"tlsEnabled\x12!\n" +
+	"\fauth_enabled\x18\t \x01(\bR\vauthEnabled\x12\x1f\n" +
+	"\vconfig_file\x18\n" +
+	" \x01(\tR\n" +
+	"configFile\"\r\n" +
+	"\vSealRequest\"\x0e\n" +
+	"\fSealResponse\"\x0f\n" +
+	"\rUnsealRequest\"\x10\n" +
+	"\x0eUnsealResponse*t\n" +
+	"\x10CiphertextFormat\x12!\n" +
+	"\x1dCIPHERTEXT_FORMAT_UNSPECIFIED\x10\x00\x12\x1c\n" +
+	"\x18CIPHERTEXT_FORMAT_LEGACY\x10\x01\x12\x1f\n" +
+	"\x1bCIPHERTEXT_FORMAT_NAMED_KEY\x10\x02*t\n" +
+	"\bKeyState\x12\x19\n" +
+	"\x15KEY_STATE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11KEY_STATE_ENABLED\x10\x01\x12\x16\n" +
+	"\x12KEY_STATE_DISABLED\x10\x02\x12\x1e\n" +
+	"\x1aKEY_STATE_PENDING_DELETION\x10\x032\xf8\x03\n" +
+	"\x03KMS\x126\n" +
+	"\aEncrypt\x12\x13.kms.EncryptRequest\x1a\x14.kms.EncryptResponse\"\x00\x126\n" +
+	"\aDecrypt\x12\x13.kms.DecryptRequest\x1a\x14.kms.DecryptResponse\"\x00\x12E\n" +
+	"\fBatchEncrypt\x12\x18.kms.BatchEncryptRequest\x1a\x19.kms.BatchEncryptResponse\"\x00\x12E\n" +
+	"\fBatchDecrypt\x12\x18.kms.BatchDecryptRequest\x1a\x19.kms.BatchDecryptResponse\"\x00\x12L\n" +
+	"\rEncryptStream\x12\x19.kms.EncryptStreamRequest\x1a\x1a.kms.EncryptStreamResponse\"\x00(\x010\x01\x12L\n" +
+	"\rDecryptStream\x12\x19.kms.DecryptStreamRequest\x1a\x1a.kms.DecryptStreamResponse\"\x00(\x010\x01\x12W\n" +
+	"\x12DescribeCiphertext\x12\x1e.kms.DescribeCiphertextRequest\x1a\x1f.kms.DescribeCiphertextResponse\"\x0028\n" +
+	"\x04Auth\x120\n" +
+	"\x05Login\x12\x11.kms.LoginRequest\x1a\x12.kms.LoginResponse\"\x002\xd5\x05\n" +
+	"\bKMSAdmin\x12E\n" +
+	"\fReloadConfig\x12\x18.kms.ReloadConfigRequest\x1a\x19.kms.ReloadConfigResponse\"\x00\x12<\n" +
+	"\tCreateKey\x12\x15.kms.CreateKeyRequest\x1a\x16.kms.CreateKeyResponse\"\x00\x129\n" +
+	"\bListKeys\x12\x14.kms.ListKeysRequest\x1a\x15.kms.ListKeysResponse\"\x00\x12B\n" +
+	"\vDescribeKey\x12\x17.kms.DescribeKeyRequest\x1a\x18.kms.DescribeKeyResponse\"\x00\x12<\n" +
+	"\tRotateKey\x12\x15.kms.RotateKeyRequest\x1a\x16.kms.RotateKeyResponse\"\x00\x12<\n" +
+	"\tEnableKey\x12\x15.kms.EnableKeyRequest\x1a\x16.kms.EnableKeyResponse\"\x00\x12?\n" +
+	"\n" +
+	"DisableKey\x12\x16.kms.DisableKeyRequest\x1a\x17.kms.DisableKeyResponse\"\x00\x12Z\n" +
+	"\x13ScheduleKeyDeletion\x12\x1f.kms.ScheduleKeyDeletionRequest\x1a .kms.ScheduleKeyDeletionResponse\"\x00\x12H\n" +
+	"\rGetServerInfo\x12\x19.kms.GetServerInfoRequest\x1a\x1a.kms.GetServerInfoResponse\"\x00\x12-\n" +
+	"\x04Seal\x12\x10.kms.SealRequest\x1a\x11.kms.SealResponse\"\x00\x123\n" +
+	"\x06Unseal\x12\x12.kms.UnsealRequest\x1a\x13.kms.UnsealResponse\"\x00B\x14Z\x12kms/proto;kmsprotob\x06proto3"
 
 var (
 	file_kms_proto_rawDescOnce sync.Once
@@ -374,27 +2613,122 @@ func file_kms_proto_rawDescGZIP() []byte {
 	return file_kms_proto_rawDescData
 }
 
-var file_kms_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_kms_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_kms_proto_msgTypes = make([]protoimpl.MessageInfo, 43)
 var file_kms_proto_goTypes = []any{
-	(*EncryptRequest)(nil),  // 0: kms.EncryptRequest
-	(*EncryptResponse)(nil), // 1: kms.EncryptResponse
-	(*DecryptRequest)(nil),  // 2: kms.DecryptRequest
-	(*DecryptResponse)(nil), // 3: kms.DecryptResponse
-	(*LoginRequest)(nil),    // 4: kms.LoginRequest
-	(*LoginResponse)(nil),   // 5: kms.LoginResponse
+	(CiphertextFormat)(0),               // 0: kms.CiphertextFormat
+	(KeyState)(0),                       // 1: kms.KeyState
+	(*EncryptRequest)(nil),              // 2: kms.EncryptRequest
+	(*EncryptResponse)(nil),             // 3: kms.EncryptResponse
+	(*DecryptRequest)(nil),              // 4: kms.DecryptRequest
+	(*DecryptResponse)(nil),             // 5: kms.DecryptResponse
+	(*DescribeCiphertextRequest)(nil),   // 6: kms.DescribeCiphertextRequest
+	(*DescribeCiphertextResponse)(nil),  // 7: kms.DescribeCiphertextResponse
+	(*ItemStatus)(nil),                  // 8: kms.ItemStatus
+	(*BatchEncryptRequest)(nil),         // 9: kms.BatchEncryptRequest
+	(*BatchEncryptResult)(nil),          // 10: kms.BatchEncryptResult
+	(*BatchEncryptResponse)(nil),        // 11: kms.BatchEncryptResponse
+	(*BatchDecryptRequest)(nil),         // 12: kms.BatchDecryptRequest
+	(*BatchDecryptResult)(nil),          // 13: kms.BatchDecryptResult
+	(*BatchDecryptResponse)(nil),        // 14: kms.BatchDecryptResponse
+	(*EncryptStreamRequest)(nil),        // 15: kms.EncryptStreamRequest
+	(*EncryptStreamResponse)(nil),       // 16: kms.EncryptStreamResponse
+	(*DecryptStreamRequest)(nil),        // 17: kms.DecryptStreamRequest
+	(*DecryptStreamResponse)(nil),       // 18: kms.DecryptStreamResponse
+	(*LoginRequest)(nil),                // 19: kms.LoginRequest
+	(*LoginResponse)(nil),               // 20: kms.LoginResponse
+	(*ReloadConfigRequest)(nil),         // 21: kms.ReloadConfigRequest
+	(*ReloadConfigResponse)(nil),        // 22: kms.ReloadConfigResponse
+	(*KeyVersion)(nil),                  // 23: kms.KeyVersion
+	(*KeyMetadata)(nil),                 // 24: kms.KeyMetadata
+	(*CreateKeyRequest)(nil),            // 25: kms.CreateKeyRequest
+	(*CreateKeyResponse)(nil),           // 26: kms.CreateKeyResponse
+	(*ListKeysRequest)(nil),             // 27: kms.ListKeysRequest
+	(*ListKeysResponse)(nil),            // 28: kms.ListKeysResponse
+	(*DescribeKeyRequest)(nil),          // 29: kms.DescribeKeyRequest
+	(*DescribeKeyResponse)(nil),         // 30: kms.DescribeKeyResponse
+	(*RotateKeyRequest)(nil),            // 31: kms.RotateKeyRequest
+	(*RotateKeyResponse)(nil),           // 32: kms.RotateKeyResponse
+	(*EnableKeyRequest)(nil),            // 33: kms.EnableKeyRequest
+	(*EnableKeyResponse)(nil),           // 34: kms.EnableKeyResponse
+	(*DisableKeyRequest)(nil),           // 35: kms.DisableKeyRequest
+	(*DisableKeyResponse)(nil),          // 36: kms.DisableKeyResponse
+	(*ScheduleKeyDeletionRequest)(nil),  // 37: kms.ScheduleKeyDeletionRequest
+	(*ScheduleKeyDeletionResponse)(nil), // 38: kms.ScheduleKeyDeletionResponse
+	(*GetServerInfoRequest)(nil),        // 39: kms.GetServerInfoRequest
+	(*GetServerInfoResponse)(nil),       // 40: kms.GetServerInfoResponse
+	(*SealRequest)(nil),                 // 41: kms.SealRequest
+	(*SealResponse)(nil),                // 42: kms.SealResponse
+	(*UnsealRequest)(nil),               // 43: kms.UnsealRequest
+	(*UnsealResponse)(nil),              // 44: kms.UnsealResponse
+	(*timestamppb.Timestamp)(nil),       // 45: google.protobuf.Timestamp
 }
 var file_kms_proto_depIdxs = []int32{
-	0, // 0: kms.KMS.Encrypt:input_type -> kms.EncryptRequest
-	2, // 1: kms.KMS.Decrypt:input_type -> kms.DecryptRequest
-	4, // 2: kms.Auth.Login:input_type -> kms.LoginRequest
-	1, // 3: kms.KMS.Encrypt:output_type -> kms.EncryptResponse
-	3, // 4: kms.KMS.Decrypt:output_type -> kms.DecryptResponse
-	5, // 5: kms.Auth.Login:output_type -> kms.LoginResponse
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0,  // 0: kms.DescribeCiphertextResponse.format:type_name -> kms.CiphertextFormat
+	2,  // 1: kms.BatchEncryptRequest.items:type_name -> kms.EncryptRequest
+	8,  // 2: kms.BatchEncryptResult.status:type_name -> kms.ItemStatus
+	10, // 3: kms.BatchEncryptResponse.results:type_name -> kms.BatchEncryptResult
+	4,  // 4: kms.BatchDecryptRequest.items:type_name -> kms.DecryptRequest
+	8,  // 5: kms.BatchDecryptResult.status:type_name -> kms.ItemStatus
+	13, // 6: kms.BatchDecryptResponse.results:type_name -> kms.BatchDecryptResult
+	8,  // 7: kms.EncryptStreamResponse.status:type_name -> kms.ItemStatus
+	8,  // 8: kms.DecryptStreamResponse.status:type_name -> kms.ItemStatus
+	45, // 9: kms.KeyVersion.create_time:type_name -> google.protobuf.Timestamp
+	1,  // 10: kms.KeyMetadata.state:type_name -> kms.KeyState
+	45, // 11: kms.KeyMetadata.create_time:type_name -> google.protobuf.Timestamp
+	45, // 12: kms.KeyMetadata.deletion_time:type_name -> google.protobuf.Timestamp
+	23, // 13: kms.KeyMetadata.versions:type_name -> kms.KeyVersion
+	24, // 14: kms.CreateKeyResponse.key:type_name -> kms.KeyMetadata
+	24, // 15: kms.ListKeysResponse.keys:type_name -> kms.KeyMetadata
+	24, // 16: kms.DescribeKeyResponse.key:type_name -> kms.KeyMetadata
+	24, // 17: kms.RotateKeyResponse.key:type_name -> kms.KeyMetadata
+	24, // 18: kms.EnableKeyResponse.key:type_name -> kms.KeyMetadata
+	24, // 19: kms.DisableKeyResponse.key:type_name -> kms.KeyMetadata
+	24, // 20: kms.ScheduleKeyDeletionResponse.key:type_name -> kms.KeyMetadata
+	45, // 21: kms.GetServerInfoResponse.start_time:type_name -> google.protobuf.Timestamp
+	2,  // 22: kms.KMS.Encrypt:input_type -> kms.EncryptRequest
+	4,  // 23: kms.KMS.Decrypt:input_type -> kms.DecryptRequest
+	9,  // 24: kms.KMS.BatchEncrypt:input_type -> kms.BatchEncryptRequest
+	12, // 25: kms.KMS.BatchDecrypt:input_type -> kms.BatchDecryptRequest
+	15, // 26: kms.KMS.EncryptStream:input_type -> kms.EncryptStreamRequest
+	17, // 27: kms.KMS.DecryptStream:input_type -> kms.DecryptStreamRequest
+	6,  // 28: kms.KMS.DescribeCiphertext:input_type -> kms.DescribeCiphertextRequest
+	19, // 29: kms.Auth.Login:input_type -> kms.LoginRequest
+	21, // 30: kms.KMSAdmin.ReloadConfig:input_type -> kms.ReloadConfigRequest
+	25, // 31: kms.KMSAdmin.CreateKey:input_type -> kms.CreateKeyRequest
+	27, // 32: kms.KMSAdmin.ListKeys:input_type -> kms.ListKeysRequest
+	29, // 33: kms.KMSAdmin.DescribeKey:input_type -> kms.DescribeKeyRequest
+	31, // 34: kms.KMSAdmin.RotateKey:input_type -> kms.RotateKeyRequest
+	33, // 35: kms.KMSAdmin.EnableKey:input_type -> kms.EnableKeyRequest
+	35, // 36: kms.KMSAdmin.DisableKey:input_type -> kms.DisableKeyRequest
+	37, // 37: kms.KMSAdmin.ScheduleKeyDeletion:input_type -> kms.ScheduleKeyDeletionRequest
+	39, // 38: kms.KMSAdmin.GetServerInfo:input_type -> kms.GetServerInfoRequest
+	41, // 39: kms.KMSAdmin.Seal:input_type -> kms.SealRequest
+	43, // 40: kms.KMSAdmin.Unseal:input_type -> kms.UnsealRequest
+	3,  // 41: kms.KMS.Encrypt:output_type -> kms.EncryptResponse
+	5,  // 42: kms.KMS.Decrypt:output_type -> kms.DecryptResponse
+	11, // 43: kms.KMS.BatchEncrypt:output_type -> kms.BatchEncryptResponse
+	14, // 44: kms.KMS.BatchDecrypt:output_type -> kms.BatchDecryptResponse
+	16, // 45: kms.KMS.EncryptStream:output_type -> kms.EncryptStreamResponse
+	18, // 46: kms.KMS.DecryptStream:output_type -> kms.DecryptStreamResponse
+	7,  // 47: kms.KMS.DescribeCiphertext:output_type -> kms.DescribeCiphertextResponse
+	20, // 48: kms.Auth.Login:output_type -> kms.LoginResponse
+	22, // 49: kms.KMSAdmin.ReloadConfig:output_type -> kms.ReloadConfigResponse
+	26, // 50: kms.KMSAdmin.CreateKey:output_type -> kms.CreateKeyResponse
+	28, // 51: kms.KMSAdmin.ListKeys:output_type -> kms.ListKeysResponse
+	30, // 52: kms.KMSAdmin.DescribeKey:output_type -> kms.DescribeKeyResponse
+	32, // 53: kms.KMSAdmin.RotateKey:output_type -> kms.RotateKeyResponse
+	34, // 54: kms.KMSAdmin.EnableKey:output_type -> kms.EnableKeyResponse
+	36, // 55: kms.KMSAdmin.DisableKey:output_type -> kms.DisableKeyResponse
+	38, // 56: kms.KMSAdmin.ScheduleKeyDeletion:output_type -> kms.ScheduleKeyDeletionResponse
+	40, // 57: kms.KMSAdmin.GetServerInfo:output_type -> kms.GetServerInfoResponse
+	42, // 58: kms.KMSAdmin.Seal:output_type -> kms.SealResponse
+	44, // 59: kms.KMSAdmin.Unseal:output_type -> kms.UnsealResponse
+	41, // [41:60] is the sub-list for method output_type
+	22, // [22:41] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
 }
 
 func init() { file_kms_proto_init() }
@@ -407,13 +2741,14 @@ func file_kms_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_kms_proto_rawDesc), len(file_kms_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   6,
+			NumEnums:      2,
+			NumMessages:   43,
 			NumExtensions: 0,
-			NumServices:   2,
+			NumServices:   3,
 		},
 		GoTypes:           file_kms_proto_goTypes,
 		DependencyIndexes: file_kms_proto_depIdxs,
+		EnumInfos:         file_kms_proto_enumTypes,
 		MessageInfos:      file_kms_proto_msgTypes,
 	}.Build()
 	File_kms_proto = out.File
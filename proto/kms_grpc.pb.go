@@ -19,8 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	KMS_Encrypt_FullMethodName = "/kms.KMS/Encrypt"
-	KMS_Decrypt_FullMethodName = "/kms.KMS/Decrypt"
+	KMS_Encrypt_FullMethodName            = "/kms.KMS/Encrypt"
+	KMS_Decrypt_FullMethodName            = "/kms.KMS/Decrypt"
+	KMS_BatchEncrypt_FullMethodName       = "/kms.KMS/BatchEncrypt"
+	KMS_BatchDecrypt_FullMethodName       = "/kms.KMS/BatchDecrypt"
+	KMS_EncryptStream_FullMethodName      = "/kms.KMS/EncryptStream"
+	KMS_DecryptStream_FullMethodName      = "/kms.KMS/DecryptStream"
+	KMS_DescribeCiphertext_FullMethodName = "/kms.KMS/DescribeCiphertext"
 )
 
 // KMSClient is the client API for KMS service.
@@ -34,6 +39,23 @@ type KMSClient interface {
 	Encrypt(ctx context.Context, in *EncryptRequest, opts ...grpc.CallOption) (*EncryptResponse, error)
 	// Decrypt a single piece of data.
 	Decrypt(ctx context.Context, in *DecryptRequest, opts ...grpc.CallOption) (*DecryptResponse, error)
+	// Encrypt many items in one call. Results are returned in request order;
+	// a failed item carries its own status and does not fail the whole call.
+	BatchEncrypt(ctx context.Context, in *BatchEncryptRequest, opts ...grpc.CallOption) (*BatchEncryptResponse, error)
+	// Decrypt many items in one call, with per-item results as in BatchEncrypt.
+	BatchDecrypt(ctx context.Context, in *BatchDecryptRequest, opts ...grpc.CallOption) (*BatchDecryptResponse, error)
+	// Encrypt a stream of items for bulk pipelines. Responses carry the
+	// request id and may arrive out of order; a failed item gets a response
+	// with its status and the stream continues. Authentication is checked once
+	// when the stream opens.
+	EncryptStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EncryptStreamRequest, EncryptStreamResponse], error)
+	// Decrypt a stream of items, with the same semantics as EncryptStream.
+	DecryptStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DecryptStreamRequest, DecryptStreamResponse], error)
+	// Report the format, key and algorithm of a ciphertext from its bytes,
+	// without decrypting it or touching a key. Besides the callers of this
+	// service, administrators and the principals in auth.inspectPrincipals may
+	// call it. Not rate limited; audited like the other calls.
+	DescribeCiphertext(ctx context.Context, in *DescribeCiphertextRequest, opts ...grpc.CallOption) (*DescribeCiphertextResponse, error)
 }
 
 type kMSClient struct {
@@ -64,6 +86,62 @@ func (c *kMSClient) Decrypt(ctx context.Context, in *DecryptRequest, opts ...grp
 	return out, nil
 }
 
+func (c *kMSClient) BatchEncrypt(ctx context.Context, in *BatchEncryptRequest, opts ...grpc.CallOption) (*BatchEncryptResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchEncryptResponse)
+	err := c.cc.Invoke(ctx, KMS_BatchEncrypt_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSClient) BatchDecrypt(ctx context.Context, in *BatchDecryptRequest, opts ...grpc.CallOption) (*BatchDecryptResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchDecryptResponse)
+	err := c.cc.Invoke(ctx, KMS_BatchDecrypt_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSClient) EncryptStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EncryptStreamRequest, EncryptStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KMS_ServiceDesc.Streams[0], KMS_EncryptStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[EncryptStreamRequest, EncryptStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KMS_EncryptStreamClient = grpc.BidiStreamingClient[EncryptStreamRequest, EncryptStreamResponse]
+
+func (c *kMSClient) DecryptStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DecryptStreamRequest, DecryptStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KMS_ServiceDesc.Streams[1], KMS_DecryptStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[DecryptStreamRequest, DecryptStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KMS_DecryptStreamClient = grpc.BidiStreamingClient[DecryptStreamRequest, DecryptStreamResponse]
+
+func (c *kMSClient) DescribeCiphertext(ctx context.Context, in *DescribeCiphertextRequest, opts ...grpc.CallOption) (*DescribeCiphertextResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeCiphertextResponse)
+	err := c.cc.Invoke(ctx, KMS_DescribeCiphertext_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KMSServer is the server API for KMS service.
 // All implementations must embed UnimplementedKMSServer
 // for forward compatibility.
@@ -75,6 +153,23 @@ type KMSServer interface {
 	Encrypt(context.Context, *EncryptRequest) (*EncryptResponse, error)
 	// Decrypt a single piece of data.
 	Decrypt(context.Context, *DecryptRequest) (*DecryptResponse, error)
+	// Encrypt many items in one call. Results are returned in request order;
+	// a failed item carries its own status and does not fail the whole call.
+	BatchEncrypt(context.Context, *BatchEncryptRequest) (*BatchEncryptResponse, error)
+	// Decrypt many items in one call, with per-item results as in BatchEncrypt.
+	BatchDecrypt(context.Context, *BatchDecryptRequest) (*BatchDecryptResponse, error)
+	// Encrypt a stream of items for bulk pipelines. Responses carry the
+	// request id and may arrive out of order; a failed item gets a response
+	// with its status and the stream continues. Authentication is checked once
+	// when the stream opens.
+	EncryptStream(grpc.BidiStreamingServer[EncryptStreamRequest, EncryptStreamResponse]) error
+	// Decrypt a stream of items, with the same semantics as EncryptStream.
+	DecryptStream(grpc.BidiStreamingServer[DecryptStreamRequest, DecryptStreamResponse]) error
+	// Report the format, key and algorithm of a ciphertext from its bytes,
+	// without decrypting it or touching a key. Besides the callers of this
+	// service, administrators and the principals in auth.inspectPrincipals may
+	// call it. Not rate limited; audited like the other calls.
+	DescribeCiphertext(context.Context, *DescribeCiphertextRequest) (*DescribeCiphertextResponse, error)
 	mustEmbedUnimplementedKMSServer()
 }
 
@@ -91,6 +186,21 @@ func (UnimplementedKMSServer) Encrypt(context.Context, *EncryptRequest) (*Encryp
 func (UnimplementedKMSServer) Decrypt(context.Context, *DecryptRequest) (*DecryptResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method Decrypt not implemented")
 }
+func (UnimplementedKMSServer) BatchEncrypt(context.Context, *BatchEncryptRequest) (*BatchEncryptResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchEncrypt not implemented")
+}
+func (UnimplementedKMSServer) BatchDecrypt(context.Context, *BatchDecryptRequest) (*BatchDecryptResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchDecrypt not implemented")
+}
+func (UnimplementedKMSServer) EncryptStream(grpc.BidiStreamingServer[EncryptStreamRequest, EncryptStreamResponse]) error {
+	return status.Error(codes.Unimplemented, "method EncryptStream not implemented")
+}
+func (UnimplementedKMSServer) DecryptStream(grpc.BidiStreamingServer[DecryptStreamRequest, DecryptStreamResponse]) error {
+	return status.Error(codes.Unimplemented, "method DecryptStream not implemented")
+}
+func (UnimplementedKMSServer) DescribeCiphertext(context.Context, *DescribeCiphertextRequest) (*DescribeCiphertextResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DescribeCiphertext not implemented")
+}
 func (UnimplementedKMSServer) mustEmbedUnimplementedKMSServer() {}
 func (UnimplementedKMSServer) testEmbeddedByValue()             {}
 
@@ -148,6 +258,74 @@ func _KMS_Decrypt_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KMS_BatchEncrypt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchEncryptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSServer).BatchEncrypt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMS_BatchEncrypt_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSServer).BatchEncrypt(ctx, req.(*BatchEncryptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMS_BatchDecrypt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchDecryptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSServer).BatchDecrypt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMS_BatchDecrypt_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSServer).BatchDecrypt(ctx, req.(*BatchDecryptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMS_EncryptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KMSServer).EncryptStream(&grpc.GenericServerStream[EncryptStreamRequest, EncryptStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KMS_EncryptStreamServer = grpc.BidiStreamingServer[EncryptStreamRequest, EncryptStreamResponse]
+
+func _KMS_DecryptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KMSServer).DecryptStream(&grpc.GenericServerStream[DecryptStreamRequest, DecryptStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KMS_DecryptStreamServer = grpc.BidiStreamingServer[DecryptStreamRequest, DecryptStreamResponse]
+
+func _KMS_DescribeCiphertext_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeCiphertextRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSServer).DescribeCiphertext(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMS_DescribeCiphertext_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSServer).DescribeCiphertext(ctx, req.(*DescribeCiphertextRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // KMS_ServiceDesc is the grpc.ServiceDesc for KMS service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -163,8 +341,33 @@ var KMS_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Decrypt",
 			Handler:    _KMS_Decrypt_Handler,
 		},
+		{
+			MethodName: "BatchEncrypt",
+			Handler:    _KMS_BatchEncrypt_Handler,
+		},
+		{
+			MethodName: "BatchDecrypt",
+			Handler:    _KMS_BatchDecrypt_Handler,
+		},
+		{
+			MethodName: "DescribeCiphertext",
+			Handler:    _KMS_DescribeCiphertext_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "EncryptStream",
+			Handler:       _KMS_EncryptStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DecryptStream",
+			Handler:       _KMS_DecryptStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "kms.proto",
 }
 
@@ -277,3 +480,539 @@ var Auth_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "kms.proto",
 }
+
+const (
+	KMSAdmin_ReloadConfig_FullMethodName        = "/kms.KMSAdmin/ReloadConfig"
+	KMSAdmin_CreateKey_FullMethodName           = "/kms.KMSAdmin/CreateKey"
+	KMSAdmin_ListKeys_FullMethodName            = "/kms.KMSAdmin/ListKeys"
+	KMSAdmin_DescribeKey_FullMethodName         = "/kms.KMSAdmin/DescribeKey"
+	KMSAdmin_RotateKey_FullMethodName           = "/kms.KMSAdmin/RotateKey"
+	KMSAdmin_EnableKey_FullMethodName           = "/kms.KMSAdmin/EnableKey"
+	KMSAdmin_DisableKey_FullMethodName          = "/kms.KMSAdmin/DisableKey"
+	KMSAdmin_ScheduleKeyDeletion_FullMethodName = "/kms.KMSAdmin/ScheduleKeyDeletion"
+	KMSAdmin_GetServerInfo_FullMethodName       = "/kms.KMSAdmin/GetServerInfo"
+	KMSAdmin_Seal_FullMethodName                = "/kms.KMSAdmin/Seal"
+	KMSAdmin_Unseal_FullMethodName              = "/kms.KMSAdmin/Unseal"
+)
+
+// KMSAdminClient is the client API for KMSAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// KMSAdmin manages the running server. Only principals listed in
+// auth.adminPrincipals may call it, and it is refused when authentication is
+// disabled.
+type KMSAdminClient interface {
+	// Re-read the configuration file and key store and apply them without a
+	// restart, as SIGHUP does. An invalid configuration or a key backend that
+	// fails its self-test is rejected (FailedPrecondition) and the running
+	// configuration keeps serving.
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+	// Create a named key in the key store with a fresh version 1. Its key
+	// material is generated by the server and stored wrapped by the key
+	// backend. Fails with AlreadyExists if the key ID is taken.
+	CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error)
+	// List the named keys, including disabled keys and keys pending deletion.
+	ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*ListKeysResponse, error)
+	// Describe one named key and its versions.
+	DescribeKey(ctx context.Context, in *DescribeKeyRequest, opts ...grpc.CallOption) (*DescribeKeyResponse, error)
+	// Add a new version to an enabled key and make it primary. New ciphertexts
+	// use it; ciphertexts of older versions still decrypt.
+	RotateKey(ctx context.Context, in *RotateKeyRequest, opts ...grpc.CallOption) (*RotateKeyResponse, error)
+	// Enable a disabled key, or cancel the scheduled deletion of a key.
+	EnableKey(ctx context.Context, in *EnableKeyRequest, opts ...grpc.CallOption) (*EnableKeyResponse, error)
+	// Disable a key: Encrypt and Decrypt with it fail with KEY_DISABLED until
+	// it is enabled again.
+	DisableKey(ctx context.Context, in *DisableKeyRequest, opts ...grpc.CallOption) (*DisableKeyResponse, error)
+	// Disable a key now and delete it with all its versions once the pending
+	// window has passed. Data still encrypted under it can then no longer be
+	// decrypted; EnableKey cancels the deletion during the window.
+	ScheduleKeyDeletion(ctx context.Context, in *ScheduleKeyDeletionRequest, opts ...grpc.CallOption) (*ScheduleKeyDeletionResponse, error)
+	// Report the server version, key backend and seal state.
+	GetServerInfo(ctx context.Context, in *GetServerInfoRequest, opts ...grpc.CallOption) (*GetServerInfoResponse, error)
+	// Release all key material from memory and close the key backend. Every
+	// key operation then fails with KMS_SEALED and health checks report
+	// NOT_SERVING until Unseal. Sealing a sealed server does nothing.
+	Seal(ctx context.Context, in *SealRequest, opts ...grpc.CallOption) (*SealResponse, error)
+	// Reopen the key backend from the running configuration and resume
+	// serving. Unsealing an unsealed server does nothing.
+	Unseal(ctx context.Context, in *UnsealRequest, opts ...grpc.CallOption) (*UnsealResponse, error)
+}
+
+type kMSAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKMSAdminClient(cc grpc.ClientConnInterface) KMSAdminClient {
+	return &kMSAdminClient{cc}
+}
+
+func (c *kMSAdminClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_ReloadConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateKeyResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_CreateKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*ListKeysResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListKeysResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_ListKeys_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) DescribeKey(ctx context.Context, in *DescribeKeyRequest, opts ...grpc.CallOption) (*DescribeKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeKeyResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_DescribeKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) RotateKey(ctx context.Context, in *RotateKeyRequest, opts ...grpc.CallOption) (*RotateKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RotateKeyResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_RotateKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) EnableKey(ctx context.Context, in *EnableKeyRequest, opts ...grpc.CallOption) (*EnableKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnableKeyResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_EnableKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) DisableKey(ctx context.Context, in *DisableKeyRequest, opts ...grpc.CallOption) (*DisableKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DisableKeyResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_DisableKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) ScheduleKeyDeletion(ctx context.Context, in *ScheduleKeyDeletionRequest, opts ...grpc.CallOption) (*ScheduleKeyDeletionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScheduleKeyDeletionResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_ScheduleKeyDeletion_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) GetServerInfo(ctx context.Context, in *GetServerInfoRequest, opts ...grpc.CallOption) (*GetServerInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetServerInfoResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_GetServerInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) Seal(ctx context.Context, in *SealRequest, opts ...grpc.CallOption) (*SealResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SealResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_Seal_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kMSAdminClient) Unseal(ctx context.Context, in *UnsealRequest, opts ...grpc.CallOption) (*UnsealResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnsealResponse)
+	err := c.cc.Invoke(ctx, KMSAdmin_Unseal_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KMSAdminServer is the server API for KMSAdmin service.
+// All implementations must embed UnimplementedKMSAdminServer
+// for forward compatibility.
+//
+// KMSAdmin manages the running server. Only principals listed in
+// auth.adminPrincipals may call it, and it is refused when authentication is
+// disabled.
+type KMSAdminServer interface {
+	// Re-read the configuration file and key store and apply them without a
+	// restart, as SIGHUP does. An invalid configuration or a key backend that
+	// fails its self-test is rejected (FailedPrecondition) and the running
+	// configuration keeps serving.
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	// Create a named key in the key store with a fresh version 1. Its key
+	// material is generated by the server and stored wrapped by the key
+	// backend. Fails with AlreadyExists if the key ID is taken.
+	CreateKey(context.Context, *CreateKeyRequest) (*CreateKeyResponse, error)
+	// List the named keys, including disabled keys and keys pending deletion.
+	ListKeys(context.Context, *ListKeysRequest) (*ListKeysResponse, error)
+	// Describe one named key and its versions.
+	DescribeKey(context.Context, *DescribeKeyRequest) (*DescribeKeyResponse, error)
+	// Add a new version to an enabled key and make it primary. New ciphertexts
+	// use it; ciphertexts of older versions still decrypt.
+	RotateKey(context.Context, *RotateKeyRequest) (*RotateKeyResponse, error)
+	// Enable a disabled key, or cancel the scheduled deletion of a key.
+	EnableKey(context.Context, *EnableKeyRequest) (*EnableKeyResponse, error)
+	// Disable a key: Encrypt and Decrypt with it fail with KEY_DISABLED until
+	// it is enabled again.
+	DisableKey(context.Context, *DisableKeyRequest) (*DisableKeyResponse, error)
+	// Disable a key now and delete it with all its versions once the pending
+	// window has passed. Data still encrypted under it can then no longer be
+	// decrypted; EnableKey cancels the deletion during the window.
+	ScheduleKeyDeletion(context.Context, *ScheduleKeyDeletionRequest) (*ScheduleKeyDeletionResponse, error)
+	// Report the server version, key backend and seal state.
+	GetServerInfo(context.Context, *GetServerInfoRequest) (*GetServerInfoResponse, error)
+	// Release all key material from memory and close the key backend. Every
+	// key operation then fails with KMS_SEALED and health checks report
+	// NOT_SERVING until Unseal. Sealing a sealed server does nothing.
+	Seal(context.Context, *SealRequest) (*SealResponse, error)
+	// Reopen the key backend from the running configuration and resume
+	// serving. Unsealing an unsealed server does nothing.
+	Unseal(context.Context, *UnsealRequest) (*UnsealResponse, error)
+	mustEmbedUnimplementedKMSAdminServer()
+}
+
+// UnimplementedKMSAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedKMSAdminServer struct{}
+
+func (UnimplementedKMSAdminServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedKMSAdminServer) CreateKey(context.Context, *CreateKeyRequest) (*CreateKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateKey not implemented")
+}
+func (UnimplementedKMSAdminServer) ListKeys(context.Context, *ListKeysRequest) (*ListKeysResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListKeys not implemented")
+}
+func (UnimplementedKMSAdminServer) DescribeKey(context.Context, *DescribeKeyRequest) (*DescribeKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DescribeKey not implemented")
+}
+func (UnimplementedKMSAdminServer) RotateKey(context.Context, *RotateKeyRequest) (*RotateKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RotateKey not implemented")
+}
+func (UnimplementedKMSAdminServer) EnableKey(context.Context, *EnableKeyRequest) (*EnableKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method EnableKey not implemented")
+}
+func (UnimplementedKMSAdminServer) DisableKey(context.Context, *DisableKeyRequest) (*DisableKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DisableKey not implemented")
+}
+func (UnimplementedKMSAdminServer) ScheduleKeyDeletion(context.Context, *ScheduleKeyDeletionRequest) (*ScheduleKeyDeletionResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ScheduleKeyDeletion not implemented")
+}
+func (UnimplementedKMSAdminServer) GetServerInfo(context.Context, *GetServerInfoRequest) (*GetServerInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetServerInfo not implemented")
+}
+func (UnimplementedKMSAdminServer) Seal(context.Context, *SealRequest) (*SealResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Seal not implemented")
+}
+func (UnimplementedKMSAdminServer) Unseal(context.Context, *UnsealRequest) (*UnsealResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Unseal not implemented")
+}
+func (UnimplementedKMSAdminServer) mustEmbedUnimplementedKMSAdminServer() {}
+func (UnimplementedKMSAdminServer) testEmbeddedByValue()                  {}
+
+// UnsafeKMSAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KMSAdminServer will
+// result in compilation errors.
+type UnsafeKMSAdminServer interface {
+	mustEmbedUnimplementedKMSAdminServer()
+}
+
+func RegisterKMSAdminServer(s grpc.ServiceRegistrar, srv KMSAdminServer) {
+	// If the following call panics, it indicates UnimplementedKMSAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&KMSAdmin_ServiceDesc, srv)
+}
+
+func _KMSAdmin_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_ReloadConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).ReloadConfig(ctx, req.(*ReloadConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_CreateKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).CreateKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_CreateKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).CreateKey(ctx, req.(*CreateKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_ListKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).ListKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_ListKeys_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).ListKeys(ctx, req.(*ListKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_DescribeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).DescribeKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_DescribeKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).DescribeKey(ctx, req.(*DescribeKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_RotateKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).RotateKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_RotateKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).RotateKey(ctx, req.(*RotateKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_EnableKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnableKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).EnableKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_EnableKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).EnableKey(ctx, req.(*EnableKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_DisableKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).DisableKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_DisableKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).DisableKey(ctx, req.(*DisableKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_ScheduleKeyDeletion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleKeyDeletionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).ScheduleKeyDeletion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_ScheduleKeyDeletion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).ScheduleKeyDeletion(ctx, req.(*ScheduleKeyDeletionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_GetServerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).GetServerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_GetServerInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).GetServerInfo(ctx, req.(*GetServerInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_Seal_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SealRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).Seal(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_Seal_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).Seal(ctx, req.(*SealRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KMSAdmin_Unseal_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnsealRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KMSAdminServer).Unseal(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KMSAdmin_Unseal_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KMSAdminServer).Unseal(ctx, req.(*UnsealRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// KMSAdmin_ServiceDesc is the grpc.ServiceDesc for KMSAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KMSAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kms.KMSAdmin",
+	HandlerType: (*KMSAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _KMSAdmin_ReloadConfig_Handler,
+		},
+		{
+			MethodName: "CreateKey",
+			Handler:    _KMSAdmin_CreateKey_Handler,
+		},
+		{
+			MethodName: "ListKeys",
+			Handler:    _KMSAdmin_ListKeys_Handler,
+		},
+		{
+			MethodName: "DescribeKey",
+			Handler:    _KMSAdmin_DescribeKey_Handler,
+		},
+		{
+			MethodName: "RotateKey",
+			Handler:    _KMSAdmin_RotateKey_Handler,
+		},
+		{
+			MethodName: "EnableKey",
+			Handler:    _KMSAdmin_EnableKey_Handler,
+		},
+		{
+			MethodName: "DisableKey",
+			Handler:    _KMSAdmin_DisableKey_Handler,
+		},
+		{
+			MethodName: "ScheduleKeyDeletion",
+			Handler:    _KMSAdmin_ScheduleKeyDeletion_Handler,
+		},
+		{
+			MethodName: "GetServerInfo",
+			Handler:    _KMSAdmin_GetServerInfo_Handler,
+		},
+		{
+			MethodName: "Seal",
+			Handler:    _KMSAdmin_Seal_Handler,
+		},
+		{
+			MethodName: "Unseal",
+			Handler:    _KMSAdmin_Unseal_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "kms.proto",
+}